@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/textproto"
+)
+
+// APIKeyAuthenticator accepts keys sent in Header. Keys are only held as SHA-256 hashes, so looking one up does not
+// reveal how close a wrong key came to a right one.
+type APIKeyAuthenticator struct {
+	Header string
+	keys   map[[sha256.Size]byte]string
+}
+
+// NewAPIKeyAuthenticator accepts each of keys as the principal it maps to.
+func NewAPIKeyAuthenticator(header string, keys map[string]string) (APIKeyAuthenticator, error) {
+	if header == "" {
+		return APIKeyAuthenticator{}, errors.New("auth: API key header must not be empty")
+	}
+	ka := APIKeyAuthenticator{Header: textproto.CanonicalMIMEHeaderKey(header), keys: map[[sha256.Size]byte]string{}}
+	for key, principalID := range keys {
+		if key == "" || principalID == "" {
+			return APIKeyAuthenticator{}, errors.New("auth: API keys and their principals must not be empty")
+		}
+		ka.keys[sha256.Sum256([]byte(key))] = principalID
+	}
+	return ka, nil
+}
+
+func (ka APIKeyAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	key := r.Header.Get(ka.Header)
+	if key == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	principalID, ok := ka.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Principal{}, invalid("unknown API key")
+	}
+	return Principal{ID: principalID, Method: "api-key"}, nil
+}
+
+// Challenge is empty as there is no standard scheme for API keys.
+func (ka APIKeyAuthenticator) Challenge() string {
+	return ""
+}
@@ -0,0 +1,38 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/auth"
+)
+
+var _ = Describe("APIKeyAuthenticator", func() {
+	It("should map keys to principals", func() {
+		ka, err := auth.NewAPIKeyAuthenticator("x-api-key", map[string]string{"k1": "ci", "k2": "billing"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ka.Challenge()).To(BeEmpty())
+
+		request := httptest.NewRequest("GET", "/", nil)
+		_, err = ka.Authenticate(request)
+		Expect(err).To(Equal(auth.ErrNoCredentials))
+
+		request.Header.Set("X-Api-Key", "k2")
+		Expect(ka.Authenticate(request)).To(Equal(auth.Principal{ID: "billing", Method: "api-key"}))
+
+		request.Header.Set("X-Api-Key", "k3")
+		_, err = ka.Authenticate(request)
+		Expect(errors.Is(err, auth.ErrInvalidCredentials)).To(BeTrue())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := auth.NewAPIKeyAuthenticator("", nil)
+		Expect(err).To(HaveOccurred())
+		_, err = auth.NewAPIKeyAuthenticator("X-Api-Key", map[string]string{"": "ci"})
+		Expect(err).To(HaveOccurred())
+		_, err = auth.NewAPIKeyAuthenticator("X-Api-Key", map[string]string{"k1": ""})
+		Expect(err).To(HaveOccurred())
+	})
+})
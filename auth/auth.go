@@ -0,0 +1,163 @@
+// Package auth authenticates requests with Basic auth, API keys or JWTs, storing who made them in the request
+// context and in handler.RequestMetadata.User for the access log.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sahilm/handlers/handler"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request carries no credentials it understands.
+	ErrNoCredentials = errors.New("auth: no credentials")
+	// ErrInvalidCredentials is wrapped by the errors Authenticators return for credentials they reject.
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+)
+
+func invalid(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredentials, reason)
+}
+
+// Principal is who a request was authenticated as.
+type Principal struct {
+	ID string
+	// Method names the authenticator that vouched for the principal: basic, api-key or jwt.
+	Method string
+	// Claims are the claims of the JWT the principal presented, and nil for other methods.
+	Claims map[string]interface{}
+}
+
+type principalCtxKey struct{}
+
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, principal)
+}
+
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalCtxKey{}).(Principal)
+	return principal, ok
+}
+
+type Authenticator interface {
+	// Authenticate returns ErrNoCredentials when r has nothing for it to check, so that the next authenticator gets a
+	// turn, and an error wrapping ErrInvalidCredentials when it has something but it does not check out.
+	Authenticate(r *http.Request) (Principal, error)
+	// Challenge is the WWW-Authenticate value to send when authentication fails, or "" for none.
+	Challenge() string
+}
+
+// FailureFunc is called with the reason a request failed authentication.
+type FailureFunc func(r *http.Request, err error)
+
+// Handler tries each of Authenticators in turn and calls Next with the first principal one of them accepts. Requests
+// that none of them accept get a 401, unless Optional is set, in which case requests without any credentials are let
+// through anonymously. Invalid credentials are always refused.
+//
+// The principal's ID is recorded in RequestMetadata.User, so Handler must sit inside the RequestsHandler for it to be
+// logged.
+type Handler struct {
+	Authenticators []Authenticator
+	Optional       bool
+	Responder      handler.Responder
+	OnFailureFunc  FailureFunc
+	Next           http.Handler
+}
+
+type HandlerOption func(*Handler) error
+
+func NewHandler(next http.Handler, authenticators []Authenticator, opts ...HandlerOption) (Handler, error) {
+	if next == nil {
+		return Handler{}, handler.ErrNilNext
+	}
+	if len(authenticators) == 0 {
+		return Handler{}, errors.New("auth: at least one authenticator is required")
+	}
+	for _, authenticator := range authenticators {
+		if authenticator == nil {
+			return Handler{}, errors.New("auth: authenticator must not be nil")
+		}
+	}
+	h := Handler{Authenticators: authenticators, Responder: handler.NegotiatedResponder, Next: next}
+	for _, opt := range opts {
+		if err := opt(&h); err != nil {
+			return Handler{}, err
+		}
+	}
+	return h, nil
+}
+
+func WithOptional() HandlerOption {
+	return func(h *Handler) error {
+		h.Optional = true
+		return nil
+	}
+}
+
+func WithResponder(responder handler.Responder) HandlerOption {
+	return func(h *Handler) error {
+		if responder == nil {
+			return errors.New("auth: responder must not be nil")
+		}
+		h.Responder = responder
+		return nil
+	}
+}
+
+func WithFailureFunc(failureFunc FailureFunc) HandlerOption {
+	return func(h *Handler) error {
+		if failureFunc == nil {
+			return errors.New("auth: failure func must not be nil")
+		}
+		h.OnFailureFunc = failureFunc
+		return nil
+	}
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, authenticator := range h.Authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		handler.UpdateRequestMetadata(r, func(metadata *handler.RequestMetadata) {
+			metadata.User = principal.ID
+		})
+		h.Next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), principal)))
+		return
+	}
+	if h.Optional {
+		h.Next.ServeHTTP(w, r)
+		return
+	}
+	h.fail(w, r, ErrNoCredentials)
+}
+
+func (h Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if h.OnFailureFunc != nil {
+		h.OnFailureFunc(r, err)
+	}
+	for _, authenticator := range h.Authenticators {
+		if challenge := authenticator.Challenge(); challenge != "" {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	responder := h.Responder
+	if responder == nil {
+		responder = handler.NegotiatedResponder
+	}
+	responder(w, r, http.StatusUnauthorized, "authentication required")
+}
+
+func Middleware(authenticators []Authenticator, opts ...HandlerOption) (handler.Middleware, error) {
+	return handler.NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewHandler(next, authenticators, opts...)
+	})
+}
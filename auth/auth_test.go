@@ -0,0 +1,121 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/auth"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		recorder  *httptest.ResponseRecorder
+		request   *http.Request
+		principal auth.Principal
+		reached   bool
+		next      http.Handler
+		basic     auth.BasicAuthenticator
+		apiKey    auth.APIKeyAuthenticator
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("GET", "/", nil)
+		principal = auth.Principal{}
+		reached = false
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+			principal, _ = auth.FromContext(r.Context())
+		})
+		var err error
+		basic, err = auth.NewBasicAuthenticator("api", auth.StaticCredentials{"alice": "secret"})
+		Expect(err).ToNot(HaveOccurred())
+		apiKey, err = auth.NewAPIKeyAuthenticator("X-API-Key", map[string]string{"k1": "ci"})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should pass on the principal from the first authenticator with credentials", func() {
+		h, err := auth.NewHandler(next, []auth.Authenticator{basic, apiKey})
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("X-API-Key", "k1")
+		h.ServeHTTP(recorder, request)
+		Expect(reached).To(BeTrue())
+		Expect(principal).To(Equal(auth.Principal{ID: "ci", Method: "api-key"}))
+	})
+
+	It("should record the principal in the request metadata", func() {
+		var user string
+		ah, err := auth.NewHandler(next, []auth.Authenticator{basic})
+		Expect(err).ToNot(HaveOccurred())
+		rh, err := handler.NewRequestsHandlerWithOptions(ah, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, metadata handler.RequestMetadata) {
+				user = metadata.User
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		request.SetBasicAuth("alice", "secret")
+		rh.ServeHTTP(recorder, request)
+		Expect(user).To(Equal("alice"))
+	})
+
+	It("should challenge requests without credentials", func() {
+		var failure error
+		h, err := auth.NewHandler(next, []auth.Authenticator{apiKey, basic},
+			auth.WithFailureFunc(func(r *http.Request, err error) { failure = err }))
+		Expect(err).ToNot(HaveOccurred())
+		h.ServeHTTP(recorder, request)
+		Expect(reached).To(BeFalse())
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(recorder.Header()["Www-Authenticate"]).To(Equal([]string{`Basic realm="api", charset="UTF-8"`}))
+		Expect(recorder.Body.String()).To(Equal("401 Unauthorized: authentication required\n"))
+		Expect(failure).To(Equal(auth.ErrNoCredentials))
+	})
+
+	It("should refuse invalid credentials even when authentication is optional", func() {
+		var failure error
+		h, err := auth.NewHandler(next, []auth.Authenticator{basic, apiKey}, auth.WithOptional(),
+			auth.WithFailureFunc(func(r *http.Request, err error) { failure = err }))
+		Expect(err).ToNot(HaveOccurred())
+		request.SetBasicAuth("alice", "wrong")
+		request.Header.Set("X-API-Key", "k1")
+		h.ServeHTTP(recorder, request)
+		Expect(reached).To(BeFalse())
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(errors.Is(failure, auth.ErrInvalidCredentials)).To(BeTrue())
+	})
+
+	It("should let anonymous requests through when authentication is optional", func() {
+		h, err := auth.NewHandler(next, []auth.Authenticator{basic}, auth.WithOptional())
+		Expect(err).ToNot(HaveOccurred())
+		h.ServeHTTP(recorder, request)
+		Expect(reached).To(BeTrue())
+		Expect(principal).To(BeZero())
+	})
+
+	It("should use the configured responder", func() {
+		h, err := auth.NewHandler(next, []auth.Authenticator{basic},
+			auth.WithResponder(func(w http.ResponseWriter, r *http.Request, status int, message string) {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte("nope"))
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		h.ServeHTTP(recorder, request)
+		Expect(recorder.Body.String()).To(Equal("nope"))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := auth.NewHandler(nil, []auth.Authenticator{basic})
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = auth.NewHandler(next, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = auth.NewHandler(next, []auth.Authenticator{nil})
+		Expect(err).To(HaveOccurred())
+		_, err = auth.NewHandler(next, []auth.Authenticator{basic}, auth.WithResponder(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = auth.Middleware([]auth.Authenticator{basic}, auth.WithFailureFunc(nil))
+		Expect(err).To(HaveOccurred())
+	})
+})
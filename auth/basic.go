@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Credentials checks usernames and passwords.
+type Credentials interface {
+	Verify(username, password string) bool
+}
+
+// StaticCredentials maps usernames to plaintext passwords. Prefer an Htpasswd file for anything long lived.
+type StaticCredentials map[string]string
+
+// Verify takes the same time whether or not username exists, and however much of password is right.
+func (sc StaticCredentials) Verify(username, password string) bool {
+	expected, ok := sc[username]
+	return constantTimeEqual(expected, password) && ok
+}
+
+// constantTimeEqual compares hashes so that the time taken does not give away the length of either string.
+func constantTimeEqual(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+type BasicAuthenticator struct {
+	Realm       string
+	Credentials Credentials
+}
+
+func NewBasicAuthenticator(realm string, credentials Credentials) (BasicAuthenticator, error) {
+	if credentials == nil {
+		return BasicAuthenticator{}, errors.New("auth: credentials must not be nil")
+	}
+	return BasicAuthenticator{Realm: realm, Credentials: credentials}, nil
+}
+
+func (ba BasicAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		if hasScheme(r, "Basic") {
+			return Principal{}, invalid("malformed basic credentials")
+		}
+		return Principal{}, ErrNoCredentials
+	}
+	if !ba.Credentials.Verify(username, password) {
+		return Principal{}, invalid("wrong username or password for " + strconv.Quote(username))
+	}
+	return Principal{ID: username, Method: "basic"}, nil
+}
+
+func (ba BasicAuthenticator) Challenge() string {
+	return "Basic realm=" + strconv.Quote(ba.Realm) + `, charset="UTF-8"`
+}
+
+func hasScheme(r *http.Request, scheme string) bool {
+	authorization := r.Header.Get("Authorization")
+	return len(authorization) > len(scheme) && strings.EqualFold(authorization[:len(scheme)], scheme) &&
+		authorization[len(scheme)] == ' '
+}
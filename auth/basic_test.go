@@ -0,0 +1,68 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/auth"
+)
+
+var _ = Describe("BasicAuthenticator", func() {
+	var (
+		ba      auth.BasicAuthenticator
+		request *http.Request
+	)
+
+	BeforeEach(func() {
+		var err error
+		ba, err = auth.NewBasicAuthenticator(`say "hi"`, auth.StaticCredentials{"alice": "secret", "bob": ""})
+		Expect(err).ToNot(HaveOccurred())
+		request = httptest.NewRequest("GET", "/", nil)
+	})
+
+	It("should accept the right password", func() {
+		request.SetBasicAuth("alice", "secret")
+		Expect(ba.Authenticate(request)).To(Equal(auth.Principal{ID: "alice", Method: "basic"}))
+	})
+
+	for _, tc := range []struct {
+		description        string
+		username, password string
+	}{
+		{"a wrong password", "alice", "secre"},
+		{"an unknown user", "carol", "secret"},
+		{"an unknown user with an empty password", "carol", ""},
+	} {
+		tc := tc
+		It("should refuse "+tc.description, func() {
+			request.SetBasicAuth(tc.username, tc.password)
+			_, err := ba.Authenticate(request)
+			Expect(errors.Is(err, auth.ErrInvalidCredentials)).To(BeTrue())
+		})
+	}
+
+	It("should tell missing credentials from malformed ones", func() {
+		_, err := ba.Authenticate(request)
+		Expect(err).To(Equal(auth.ErrNoCredentials))
+
+		request.Header.Set("Authorization", "Bearer abc")
+		_, err = ba.Authenticate(request)
+		Expect(err).To(Equal(auth.ErrNoCredentials))
+
+		request.Header.Set("Authorization", "basic !!!")
+		_, err = ba.Authenticate(request)
+		Expect(errors.Is(err, auth.ErrInvalidCredentials)).To(BeTrue())
+	})
+
+	It("should quote the realm in its challenge", func() {
+		Expect(ba.Challenge()).To(Equal(`Basic realm="say \"hi\"", charset="UTF-8"`))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := auth.NewBasicAuthenticator("api", nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
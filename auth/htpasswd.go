@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd checks passwords against the entries of an Apache htpasswd file. Only bcrypt entries, as written by
+// htpasswd -B, are accepted; the other formats it supports are too weak to be worth trusting.
+type Htpasswd struct {
+	hashes map[string][]byte
+	// decoy is hashed against for unknown usernames, so that they take as long to refuse as wrong passwords.
+	decoy []byte
+}
+
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
+
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{hashes: map[string][]byte{}}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		i := strings.Index(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("auth: htpasswd line %d: expected username:hash", line)
+		}
+		username, hash := entry[:i], entry[i+1:]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("auth: htpasswd line %d: only bcrypt hashes are supported", line)
+		}
+		h.hashes[username] = []byte(hash)
+		if h.decoy == nil {
+			h.decoy = []byte(hash)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Htpasswd) Verify(username, password string) bool {
+	hash, ok := h.hashes[username]
+	if !ok {
+		if h.decoy != nil {
+			_ = bcrypt.CompareHashAndPassword(h.decoy, []byte(password))
+		}
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
@@ -0,0 +1,53 @@
+package auth_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ = Describe("Htpasswd", func() {
+	var hash string
+
+	BeforeEach(func() {
+		b, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		Expect(err).ToNot(HaveOccurred())
+		// htpasswd -B writes the $2y$ prefix
+		hash = strings.Replace(string(b), "$2a$", "$2y$", 1)
+	})
+
+	It("should check passwords against a file", func() {
+		f, err := ioutil.TempFile("", "htpasswd")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+		_, err = f.WriteString("# staff\n\nalice:" + hash + "\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		h, err := auth.LoadHtpasswd(f.Name())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.Verify("alice", "secret")).To(BeTrue())
+		Expect(h.Verify("alice", "wrong")).To(BeFalse())
+		Expect(h.Verify("bob", "secret")).To(BeFalse())
+	})
+
+	It("should refuse everyone when empty", func() {
+		h, err := auth.ParseHtpasswd(strings.NewReader(""))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.Verify("alice", "")).To(BeFalse())
+	})
+
+	It("should reject files it cannot use", func() {
+		_, err := auth.ParseHtpasswd(strings.NewReader("alice:$apr1$salt$hash\n"))
+		Expect(err).To(MatchError("auth: htpasswd line 1: only bcrypt hashes are supported"))
+		_, err = auth.ParseHtpasswd(strings.NewReader("alice:" + hash + "\n" + hash + "\n"))
+		Expect(err).To(MatchError("auth: htpasswd line 2: expected username:hash"))
+		_, err = auth.LoadHtpasswd("does-not-exist")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for HS256, RS256 and ES256
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for the 384 and 512 variants
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sahilm/handlers/handler"
+)
+
+// JWTAuthenticator accepts signed JWTs sent as bearer tokens. Tokens must carry sub, which becomes the principal's ID,
+// and exp, and are checked against nbf, and against Issuer and Audience when those are set. Leeway allows for clock
+// skew between the issuer and this server.
+type JWTAuthenticator struct {
+	Keys     KeySet
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+	clock    handler.Clock
+}
+
+type JWTAuthenticatorOption func(*JWTAuthenticator) error
+
+func NewJWTAuthenticator(keys KeySet, opts ...JWTAuthenticatorOption) (JWTAuthenticator, error) {
+	if len(keys) == 0 {
+		return JWTAuthenticator{}, errors.New("auth: at least one key is required")
+	}
+	ja := JWTAuthenticator{Keys: keys, clock: time.Now}
+	for _, opt := range opts {
+		if err := opt(&ja); err != nil {
+			return JWTAuthenticator{}, err
+		}
+	}
+	return ja, nil
+}
+
+func WithIssuer(issuer string) JWTAuthenticatorOption {
+	return func(ja *JWTAuthenticator) error {
+		ja.Issuer = issuer
+		return nil
+	}
+}
+
+func WithAudience(audience string) JWTAuthenticatorOption {
+	return func(ja *JWTAuthenticator) error {
+		ja.Audience = audience
+		return nil
+	}
+}
+
+func WithLeeway(leeway time.Duration) JWTAuthenticatorOption {
+	return func(ja *JWTAuthenticator) error {
+		if leeway < 0 {
+			return errors.New("auth: leeway must not be negative")
+		}
+		ja.Leeway = leeway
+		return nil
+	}
+}
+
+func WithJWTClock(clock handler.Clock) JWTAuthenticatorOption {
+	return func(ja *JWTAuthenticator) error {
+		if clock == nil {
+			return errors.New("auth: clock must not be nil")
+		}
+		ja.clock = clock
+		return nil
+	}
+}
+
+func (ja JWTAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	if !hasScheme(r, "Bearer") {
+		return Principal{}, ErrNoCredentials
+	}
+	claims, err := ja.verify(strings.TrimSpace(r.Header.Get("Authorization")[len("Bearer "):]))
+	if err != nil {
+		return Principal{}, err
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return Principal{}, invalid("token has no subject")
+	}
+	return Principal{ID: subject, Method: "jwt", Claims: claims}, nil
+}
+
+func (ja JWTAuthenticator) Challenge() string {
+	return "Bearer"
+}
+
+type jwtHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+func (ja JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeJSONSegment(parts[0], &header); err != nil {
+		return nil, invalid("malformed token header")
+	}
+	if len(header.Crit) > 0 {
+		return nil, invalid("unsupported critical header parameters")
+	}
+	key, err := ja.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("malformed token signature")
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err = decodeJSONSegment(parts[1], &claims); err != nil {
+		return nil, invalid("malformed token claims")
+	}
+	if err = ja.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (ja JWTAuthenticator) key(kid string) (interface{}, error) {
+	if kid == "" && len(ja.Keys) == 1 {
+		for _, key := range ja.Keys {
+			return key, nil
+		}
+	}
+	key, ok := ja.Keys[kid]
+	if !ok {
+		return nil, invalid("unknown key " + kid)
+	}
+	return key, nil
+}
+
+func decodeJSONSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+var jwtHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// verifySignature checks that alg suits the type of key as well as the signature itself, so that a token cannot, for
+// example, pass off an RSA public key as an HMAC secret.
+func verifySignature(alg string, key interface{}, input, signature []byte) error {
+	if len(alg) != 5 {
+		return invalid("unsupported algorithm " + alg)
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return invalid("unsupported algorithm " + alg)
+	}
+	var valid bool
+	switch alg[:2] {
+	case "HS":
+		valid = verifyHMAC(key, hash, input, signature)
+	case "RS":
+		valid = verifyRSA(key, hash, input, signature)
+	case "ES":
+		valid = verifyECDSA(key, hash, input, signature)
+	default:
+		return invalid("unsupported algorithm " + alg)
+	}
+	if !valid {
+		return invalid("bad " + alg + " signature")
+	}
+	return nil
+}
+
+func verifyHMAC(key interface{}, hash crypto.Hash, input, signature []byte) bool {
+	secret, ok := key.([]byte)
+	if !ok {
+		return false
+	}
+	mac := hmac.New(hash.New, secret)
+	_, _ = mac.Write(input)
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+func verifyRSA(key interface{}, hash crypto.Hash, input, signature []byte) bool {
+	public, ok := key.(*rsa.PublicKey)
+	return ok && rsa.VerifyPKCS1v15(public, hash, digest(hash, input), signature) == nil
+}
+
+func verifyECDSA(key interface{}, hash crypto.Hash, input, signature []byte) bool {
+	public, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	// each algorithm is tied to one curve: ES256 to P-256, ES384 to P-384 and ES512 to P-521
+	bits := public.Curve.Params().BitSize
+	if bits != hash.Size()*8 && !(bits == 521 && hash == crypto.SHA512) {
+		return false
+	}
+	size := (bits + 7) / 8
+	if len(signature) != 2*size {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	return ecdsa.Verify(public, digest(hash, input), r, s)
+}
+
+func digest(hash crypto.Hash, input []byte) []byte {
+	h := hash.New()
+	_, _ = h.Write(input)
+	return h.Sum(nil)
+}
+
+func (ja JWTAuthenticator) validate(claims map[string]interface{}) error {
+	now := ja.clock()
+	expiry, ok := timeClaim(claims, "exp")
+	if !ok {
+		return invalid("token has no expiry")
+	}
+	if now.After(expiry.Add(ja.Leeway)) {
+		return invalid("token expired")
+	}
+	if _, present := claims["nbf"]; present {
+		notBefore, valid := timeClaim(claims, "nbf")
+		if !valid || now.Add(ja.Leeway).Before(notBefore) {
+			return invalid("token not valid yet")
+		}
+	}
+	if ja.Issuer != "" && claims["iss"] != ja.Issuer {
+		return invalid("wrong issuer")
+	}
+	if ja.Audience != "" && !hasAudience(claims["aud"], ja.Audience) {
+		return invalid("wrong audience")
+	}
+	return nil
+}
+
+func timeClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	seconds, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)), true
+}
+
+// hasAudience accepts aud as a single string or an array of them, as RFC 7519 allows both.
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/auth"
+)
+
+var b64 = base64.RawURLEncoding
+
+func signJWT(alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, err := json.Marshal(header)
+	Expect(err).ToNot(HaveOccurred())
+	c, err := json.Marshal(claims)
+	Expect(err).ToNot(HaveOccurred())
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+	digest := hash.New()
+	digest.Write([]byte(input))
+	var signature []byte
+	switch alg[:2] {
+	case "HS":
+		mac := hmac.New(hash.New, key.([]byte))
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case "RS":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), hash, digest.Sum(nil))
+		Expect(err).ToNot(HaveOccurred())
+	case "ES":
+		private := key.(*ecdsa.PrivateKey)
+		r, s, signErr := ecdsa.Sign(rand.Reader, private, digest.Sum(nil))
+		Expect(signErr).ToNot(HaveOccurred())
+		size := (private.Curve.Params().BitSize + 7) / 8
+		signature = append(padded(r, size), padded(s, size)...)
+	}
+	return input + "." + b64.EncodeToString(signature)
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": key.Curve.Params().Name,
+		"x": b64.EncodeToString(padded(key.X, size)),
+		"y": b64.EncodeToString(padded(key.Y, size)),
+	}
+}
+
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+var _ = Describe("JWTAuthenticator", func() {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	secret := []byte("hmac secret")
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	var (
+		keys    auth.KeySet
+		ja      auth.JWTAuthenticator
+		claims  map[string]interface{}
+		request *http.Request
+	)
+
+	BeforeEach(func() {
+		jwks, marshalErr := json.Marshal(map[string]interface{}{"keys": []interface{}{
+			map[string]string{"kty": "oct", "kid": "hmac", "k": b64.EncodeToString(secret)},
+			map[string]string{
+				"kty": "RSA", "kid": "rsa", "use": "sig",
+				"n": b64.EncodeToString(rsaKey.N.Bytes()),
+				"e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			map[string]string{"kty": "RSA", "kid": "encryption", "use": "enc"},
+			ecJWK("p256", p256Key),
+			ecJWK("p384", p384Key),
+		}})
+		Expect(marshalErr).ToNot(HaveOccurred())
+		f, fileErr := ioutil.TempFile("", "jwks")
+		Expect(fileErr).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+		_, fileErr = f.Write(jwks)
+		Expect(fileErr).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		keys, err = auth.LoadJWKS(f.Name())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(HaveLen(4))
+		ja, err = auth.NewJWTAuthenticator(keys,
+			auth.WithIssuer("https://issuer.example"),
+			auth.WithAudience("api"),
+			auth.WithLeeway(time.Minute),
+			auth.WithJWTClock(func() time.Time { return now }))
+		Expect(err).ToNot(HaveOccurred())
+
+		claims = map[string]interface{}{
+			"sub": "alice",
+			"iss": "https://issuer.example",
+			"aud": []string{"web", "api"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		request = httptest.NewRequest("GET", "/", nil)
+	})
+
+	bearer := func(token string) *http.Request {
+		request.Header.Set("Authorization", "Bearer "+token)
+		return request
+	}
+
+	for _, tc := range []struct {
+		alg, kid string
+		key      func() interface{}
+	}{
+		{"HS256", "hmac", func() interface{} { return secret }},
+		{"HS512", "hmac", func() interface{} { return secret }},
+		{"RS256", "rsa", func() interface{} { return rsaKey }},
+		{"RS384", "rsa", func() interface{} { return rsaKey }},
+		{"ES256", "p256", func() interface{} { return p256Key }},
+		{"ES384", "p384", func() interface{} { return p384Key }},
+	} {
+		tc := tc
+		It("should accept "+tc.alg+" tokens", func() {
+			principal, authErr := ja.Authenticate(bearer(signJWT(tc.alg, tc.kid, tc.key(), claims)))
+			Expect(authErr).ToNot(HaveOccurred())
+			Expect(principal.ID).To(Equal("alice"))
+			Expect(principal.Method).To(Equal("jwt"))
+			Expect(principal.Claims["iss"]).To(Equal("https://issuer.example"))
+		})
+	}
+
+	It("should use the only key for tokens without a kid", func() {
+		single, newErr := auth.NewJWTAuthenticator(auth.KeySet{"hmac": secret},
+			auth.WithJWTClock(func() time.Time { return now }))
+		Expect(newErr).ToNot(HaveOccurred())
+		Expect(single.Authenticate(bearer(signJWT("HS256", "", secret, claims)))).To(
+			Equal(auth.Principal{ID: "alice", Method: "jwt", Claims: map[string]interface{}{
+				"sub": "alice",
+				"iss": "https://issuer.example",
+				"aud": []interface{}{"web", "api"},
+				"exp": float64(now.Add(time.Hour).Unix()),
+			}}))
+
+		_, authErr := ja.Authenticate(bearer(signJWT("HS256", "", secret, claims)))
+		Expect(authErr).To(MatchError("auth: invalid credentials: unknown key "))
+	})
+
+	It("should only look at bearer tokens", func() {
+		_, authErr := ja.Authenticate(request)
+		Expect(authErr).To(Equal(auth.ErrNoCredentials))
+		request.SetBasicAuth("alice", "secret")
+		_, authErr = ja.Authenticate(request)
+		Expect(authErr).To(Equal(auth.ErrNoCredentials))
+		Expect(ja.Challenge()).To(Equal("Bearer"))
+	})
+
+	for _, tc := range []struct {
+		description string
+		token       func() string
+		reason      string
+	}{
+		{"tokens whose signature does not match", func() string {
+			return signJWT("HS256", "hmac", []byte("other secret"), claims)
+		}, "bad HS256 signature"},
+		{"HMAC tokens keyed with an RSA public key", func() string {
+			return signJWT("HS256", "rsa", rsaKey.PublicKey.N.Bytes(), claims)
+		}, "bad HS256 signature"},
+		{"ES256 tokens verified with a P-384 key", func() string {
+			return signJWT("ES256", "p384", p256Key, claims)
+		}, "bad ES256 signature"},
+		{"unsigned tokens", func() string {
+			return b64.EncodeToString([]byte(`{"alg":"none","kid":"hmac"}`)) + ".e30."
+		}, "unsupported algorithm none"},
+		{"unsupported algorithms", func() string {
+			return b64.EncodeToString([]byte(`{"alg":"PS256","kid":"rsa"}`)) + ".e30.AA"
+		}, "unsupported algorithm PS256"},
+		{"tokens with malformed headers", func() string {
+			return signJWT("HS256", "hmac", secret, claims)[len("eyJ"):]
+		}, "malformed token header"},
+		{"tokens with critical extensions", func() string {
+			return b64.EncodeToString([]byte(`{"alg":"HS256","kid":"hmac","crit":["b64"]}`)) + ".e30.AA"
+		}, "unsupported critical header parameters"},
+		{"expired tokens", func() string {
+			claims["exp"] = now.Add(-2 * time.Minute).Unix()
+			return signJWT("HS256", "hmac", secret, claims)
+		}, "token expired"},
+		{"tokens without an expiry", func() string {
+			delete(claims, "exp")
+			return signJWT("HS256", "hmac", secret, claims)
+		}, "token has no expiry"},
+		{"tokens that are not valid yet", func() string {
+			claims["nbf"] = now.Add(2 * time.Minute).Unix()
+			return signJWT("HS256", "hmac", secret, claims)
+		}, "token not valid yet"},
+		{"tokens from another issuer", func() string {
+			claims["iss"] = "https://evil.example"
+			return signJWT("HS256", "hmac", secret, claims)
+		}, "wrong issuer"},
+		{"tokens for another audience", func() string {
+			claims["aud"] = "web"
+			return signJWT("HS256", "hmac", secret, claims)
+		}, "wrong audience"},
+		{"tokens without a subject", func() string {
+			delete(claims, "sub")
+			return signJWT("HS256", "hmac", secret, claims)
+		}, "token has no subject"},
+		{"malformed tokens", func() string {
+			return "abc.def"
+		}, "malformed token"},
+	} {
+		tc := tc
+		It("should refuse "+tc.description, func() {
+			_, authErr := ja.Authenticate(bearer(tc.token()))
+			Expect(errors.Is(authErr, auth.ErrInvalidCredentials)).To(BeTrue())
+			Expect(authErr).To(MatchError("auth: invalid credentials: " + tc.reason))
+		})
+	}
+
+	It("should allow for clock skew", func() {
+		claims["exp"] = now.Add(-30 * time.Second).Unix()
+		claims["nbf"] = now.Add(30 * time.Second).Unix()
+		_, authErr := ja.Authenticate(bearer(signJWT("HS256", "hmac", secret, claims)))
+		Expect(authErr).ToNot(HaveOccurred())
+	})
+
+	It("should reject key sets it cannot use", func() {
+		for _, jwks := range []string{
+			`{`,
+			`{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AA"}]}`,
+			`{"keys": [{"kty": "oct", "k": ""}]}`,
+			`{"keys": [{"kty": "oct", "kid": "a", "k": "AA"}, {"kty": "oct", "kid": "a", "k": "AA"}]}`,
+			`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+			`{"keys": [{"kty": "RSA", "n": "AQ", "e": "!"}]}`,
+		} {
+			_, parseErr := auth.ParseJWKS([]byte(jwks))
+			Expect(parseErr).To(HaveOccurred(), jwks)
+		}
+		_, loadErr := auth.LoadJWKS("does-not-exist")
+		Expect(loadErr).To(HaveOccurred())
+	})
+
+	It("should reject invalid setups", func() {
+		_, newErr := auth.NewJWTAuthenticator(nil)
+		Expect(newErr).To(HaveOccurred())
+		_, newErr = auth.NewJWTAuthenticator(keys, auth.WithLeeway(-time.Second))
+		Expect(newErr).To(HaveOccurred())
+		_, newErr = auth.NewJWTAuthenticator(keys, auth.WithJWTClock(nil))
+		Expect(newErr).To(HaveOccurred())
+	})
+})
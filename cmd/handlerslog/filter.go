@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filter picks the records a report covers. Zero values match everything.
+type filter struct {
+	since    time.Time
+	until    time.Time
+	statuses []statusMatcher
+	methods  []string
+}
+
+// statusMatcher matches a single status, or a class such as 5xx.
+type statusMatcher struct {
+	status int
+	class  int
+}
+
+func (sm statusMatcher) matches(status int) bool {
+	if sm.class > 0 {
+		return status/100 == sm.class
+	}
+	return status == sm.status
+}
+
+// parseStatuses reads a comma separated list of statuses and classes, such as "404,5xx".
+func parseStatuses(list string) ([]statusMatcher, error) {
+	var matchers []statusMatcher
+	for _, s := range splitList(list) {
+		if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") && s[0] >= '1' && s[0] <= '5' {
+			matchers = append(matchers, statusMatcher{class: int(s[0] - '0')})
+			continue
+		}
+		status, err := strconv.Atoi(s)
+		if err != nil || status < 100 || status > 999 {
+			return nil, fmt.Errorf("invalid status %q", s)
+		}
+		matchers = append(matchers, statusMatcher{status: status})
+	}
+	return matchers, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (f filter) matches(rec record) bool {
+	if !f.since.IsZero() && rec.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !rec.Time.Before(f.until) {
+		return false
+	}
+	if len(f.methods) > 0 && !containsFold(f.methods, rec.Method) {
+		return false
+	}
+	if len(f.statuses) == 0 {
+		return true
+	}
+	for _, sm := range f.statuses {
+		if sm.matches(rec.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
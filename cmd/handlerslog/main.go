@@ -0,0 +1,141 @@
+// Command handlerslog summarises the JSON access logs written by logrushandler.RequestsHandler: latency percentiles,
+// statuses, the busiest paths and clients, and the slowest requests by request ID.
+//
+//	handlerslog [flags] [file ...]
+//
+// It reads standard input when no files are given. Lines that are not access log entries, such as recovered panics,
+// are skipped and counted.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type options struct {
+	filter         filter
+	format         string
+	top            int
+	pathsByLatency bool
+	files          []string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, err := parseFlags(args, stderr)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "handlerslog:", err)
+		return 2
+	}
+	agg := newAggregator(opts.filter)
+	if err = read(opts.files, stdin, agg); err != nil {
+		fmt.Fprintln(stderr, "handlerslog:", err)
+		return 1
+	}
+	if err = write(stdout, opts.format, agg.report(opts.top, opts.pathsByLatency)); err != nil {
+		fmt.Fprintln(stderr, "handlerslog:", err)
+		return 1
+	}
+	return 0
+}
+
+func parseFlags(args []string, stderr io.Writer) (options, error) {
+	fs := flag.NewFlagSet("handlerslog", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		opts                          options
+		since, until, status, methods string
+	)
+	fs.StringVar(&since, "since", "", "only count requests that started at or after this RFC 3339 time")
+	fs.StringVar(&until, "until", "", "only count requests that started before this RFC 3339 time")
+	fs.StringVar(&status, "status", "", "only count these statuses or classes, e.g. 404,5xx")
+	fs.StringVar(&methods, "method", "", "only count these methods, e.g. GET,POST")
+	fs.StringVar(&opts.format, "format", "text", "output format: text, json or csv")
+	fs.IntVar(&opts.top, "top", 10, "how many paths, clients and slow requests to list")
+	fs.BoolVar(&opts.pathsByLatency, "paths-by-latency", false, "rank paths by p95 latency instead of request count")
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+	opts.files = fs.Args()
+	var err error
+	if opts.filter.since, err = parseFlagTime("since", since); err != nil {
+		return options{}, err
+	}
+	if opts.filter.until, err = parseFlagTime("until", until); err != nil {
+		return options{}, err
+	}
+	if opts.filter.statuses, err = parseStatuses(status); err != nil {
+		return options{}, err
+	}
+	opts.filter.methods = splitList(methods)
+	switch {
+	case opts.format != "text" && opts.format != "json" && opts.format != "csv":
+		return options{}, fmt.Errorf("unknown format %q", opts.format)
+	case opts.top <= 0:
+		return options{}, errors.New("-top must be positive")
+	}
+	return opts, nil
+}
+
+func parseFlagTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-%s: %w", name, err)
+	}
+	return t, nil
+}
+
+func read(files []string, stdin io.Reader, agg *aggregator) error {
+	if len(files) == 0 {
+		return readFrom(stdin, "standard input", agg)
+	}
+	for _, name := range files {
+		if err := readFile(name, agg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(name string, agg *aggregator) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readFrom(f, name, agg)
+}
+
+func readFrom(r io.Reader, name string, agg *aggregator) error {
+	skipped, err := scan(r, agg.add)
+	agg.skipped += skipped
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	return nil
+}
+
+func write(w io.Writer, format string, r report) error {
+	switch strings.ToLower(format) {
+	case "json":
+		return writeJSON(w, r)
+	case "csv":
+		return writeCSV(w, r)
+	default:
+		return writeText(w, r)
+	}
+}
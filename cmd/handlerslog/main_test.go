@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/handlertest"
+	"github.com/sahilm/handlers/logrushandler"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("handlerslog", func() {
+	start := time.Date(2019, 10, 13, 12, 0, 0, 0, time.UTC)
+
+	var logs bytes.Buffer
+
+	// logRequest writes the access log line logrushandler writes for a request taking runtime.
+	logRequest := func(at time.Time, method, target string, status int, runtime time.Duration, client, id string) {
+		logger := logrus.New()
+		logger.Out = &logs
+		logger.Formatter = &logrus.JSONFormatter{}
+		clock := handlertest.NewClock(at)
+		clock.Step(runtime)
+		rh, err := logrushandler.NewRequestsHandlerWithOptions(logrus.NewEntry(logger), handlertest.StatusHandler(status, "body"),
+			logrushandler.WithHandlerOptions(handler.WithClock(clock.Now)))
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest(method, target, nil)
+		request.RemoteAddr = client + ":1234"
+		if id != "" {
+			request.Header.Set(handler.RequestIDHeader, id)
+		}
+		rh.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	BeforeEach(func() {
+		logs.Reset()
+		logRequest(start, "GET", "/things?page=1", 200, 10*time.Millisecond, "10.0.0.1", "a")
+		logRequest(start.Add(time.Minute), "GET", "/things?page=2", 200, 30*time.Millisecond, "10.0.0.1", "b")
+		logRequest(start.Add(2*time.Minute), "POST", "/things", 201, 50*time.Millisecond, "10.0.0.2", "c")
+		logRequest(start.Add(3*time.Minute), "GET", "/slow", 503, 2*time.Second, "10.0.0.3", "d")
+		logRequest(start.Add(4*time.Minute), "GET", "/missing", 404, time.Millisecond, "10.0.0.1", "")
+		logs.WriteString(`{"level":"error","msg":"main.go:12 main()","panic":"boom"}` + "\n")
+		logs.WriteString("not json\n")
+	})
+
+	runJSON := func(args ...string) jsonReport {
+		var stdout, stderr bytes.Buffer
+		Expect(run(append([]string{"-format", "json"}, args...), bytes.NewReader(logs.Bytes()), &stdout, &stderr)).
+			To(Equal(0), stderr.String())
+		var r jsonReport
+		Expect(json.Unmarshal(stdout.Bytes(), &r)).To(Succeed())
+		return r
+	}
+
+	It("should summarise the access log", func() {
+		r := runJSON()
+		Expect(r.Requests).To(Equal(5))
+		Expect(r.Skipped).To(Equal(2))
+		Expect(r.Latency).To(Equal(jsonLatency{P50: 30, P90: 2000, P95: 2000, P99: 2000, Max: 2000}))
+		Expect(r.StatusClasses).To(Equal([]jsonCount{{"2xx", 3}, {"4xx", 1}, {"5xx", 1}}))
+		Expect(r.Statuses).To(Equal([]jsonCount{{"200", 2}, {"201", 1}, {"404", 1}, {"503", 1}}))
+		Expect(r.TopPaths[0]).To(Equal(jsonPath{Path: "/things", Count: 3,
+			Latency: jsonLatency{P50: 30, P90: 50, P95: 50, P99: 50, Max: 50}}))
+		Expect(r.TopClients[0]).To(Equal(jsonCount{"10.0.0.1", 3}))
+		Expect(r.Slowest[0]).To(Equal(jsonRequest{RequestID: "d", Method: "GET", Path: "/slow", Status: 503,
+			RuntimeMs: 2000, Time: start.Add(3 * time.Minute)}))
+	})
+
+	It("should filter by time, status and method", func() {
+		r := runJSON("-since", start.Add(time.Minute).Format(time.RFC3339),
+			"-until", start.Add(4*time.Minute).Format(time.RFC3339))
+		Expect(r.Requests).To(Equal(3))
+
+		r = runJSON("-status", "5xx,404")
+		Expect(r.Statuses).To(Equal([]jsonCount{{"404", 1}, {"503", 1}}))
+
+		r = runJSON("-method", "post")
+		Expect(r.Requests).To(Equal(1))
+		Expect(r.TopPaths[0].Path).To(Equal("/things"))
+	})
+
+	It("should rank paths by latency when asked", func() {
+		r := runJSON("-paths-by-latency", "-top", "2")
+		Expect(r.TopPaths).To(HaveLen(2))
+		Expect(r.TopPaths[0].Path).To(Equal("/slow"))
+		Expect(r.Slowest).To(HaveLen(2))
+	})
+
+	It("should write text and CSV reports", func() {
+		var stdout bytes.Buffer
+		Expect(run(nil, bytes.NewReader(logs.Bytes()), &stdout, ioutil.Discard)).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("requests       5"))
+		Expect(stdout.String()).To(MatchRegexp(`d\s+2s\s+503\s+GET /slow`))
+
+		stdout.Reset()
+		Expect(run([]string{"-format", "csv"}, bytes.NewReader(logs.Bytes()), &stdout, ioutil.Discard)).To(Equal(0))
+		rows, err := csv.NewReader(&stdout).ReadAll()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rows[0][0]).To(Equal("section"))
+		Expect(rows).To(ContainElement([]string{"status", "503", "1", "", "", "", "", "", ""}))
+		Expect(rows).To(ContainElement([]string{"slowest", "d", "", "", "", "", "", "2000",
+			"GET /slow 503 2019-10-13T12:03:00Z"}))
+	})
+
+	It("should read files", func() {
+		dir, err := ioutil.TempDir("", "handlerslog")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		name := filepath.Join(dir, "access.log")
+		Expect(ioutil.WriteFile(name, logs.Bytes(), 0600)).To(Succeed())
+
+		var stdout bytes.Buffer
+		Expect(run([]string{"-format", "json", name, name}, strings.NewReader(""), &stdout, ioutil.Discard)).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring(`"requests": 10`))
+
+		var stderr bytes.Buffer
+		Expect(run([]string{filepath.Join(dir, "missing.log")}, nil, ioutil.Discard, &stderr)).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("missing.log"))
+	})
+
+	It("should reject bad flags", func() {
+		for _, args := range [][]string{
+			{"-format", "xml"},
+			{"-top", "0"},
+			{"-status", "9xx"},
+			{"-since", "yesterday"},
+		} {
+			Expect(run(args, nil, ioutil.Discard, ioutil.Discard)).To(Equal(2))
+		}
+	})
+
+	It("should accept runtimes written as duration strings", func() {
+		rec, err := parseLine([]byte(`{"msg":"GET /x","method":"GET","status":200,"runtime":"1.5s",` +
+			`"remoteAddr":"10.0.0.9","time":"2019-10-13T12:00:00Z"}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rec.Runtime).To(Equal(1500 * time.Millisecond))
+		Expect(rec.Time).To(Equal(start))
+		Expect(rec.Client).To(Equal("10.0.0.9"))
+		Expect(http.StatusText(rec.Status)).To(Equal("OK"))
+	})
+})
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+func writeText(w io.Writer, r report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "requests\t%d\n", r.Requests)
+	if r.Skipped > 0 {
+		fmt.Fprintf(tw, "skipped lines\t%d\n", r.Skipped)
+	}
+	fmt.Fprintf(tw, "\nLATENCY\tp50\tp90\tp95\tp99\tmax\n")
+	fmt.Fprintf(tw, "all\t%s\n", latencyColumns(r.Latency, "\t"))
+	fmt.Fprintf(tw, "\nSTATUS\tCOUNT\n")
+	for _, c := range r.StatusClasses {
+		fmt.Fprintf(tw, "%s\t%d\n", c.Key, c.Count)
+	}
+	for _, c := range r.Statuses {
+		fmt.Fprintf(tw, "%s\t%d\n", c.Key, c.Count)
+	}
+	fmt.Fprintf(tw, "\nPATH\tCOUNT\tp50\tp90\tp95\tp99\tmax\n")
+	for _, p := range r.TopPaths {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", p.Path, p.Count, latencyColumns(p.Latency, "\t"))
+	}
+	fmt.Fprintf(tw, "\nCLIENT\tCOUNT\n")
+	for _, c := range r.TopClients {
+		fmt.Fprintf(tw, "%s\t%d\n", c.Key, c.Count)
+	}
+	fmt.Fprintf(tw, "\nSLOWEST\tRUNTIME\tSTATUS\tREQUEST\tTIME\n")
+	for _, rec := range r.Slowest {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s %s\t%s\n", requestID(rec), rec.Runtime, rec.Status, rec.Method, rec.Path,
+			formatTime(rec.Time))
+	}
+	return tw.Flush()
+}
+
+func latencyColumns(l latency, sep string) string {
+	return l.P50.String() + sep + l.P90.String() + sep + l.P95.String() + sep + l.P99.String() + sep + l.Max.String()
+}
+
+func requestID(rec record) string {
+	if rec.RequestID == "" {
+		return "-"
+	}
+	return rec.RequestID
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// jsonLatency gives latencies in milliseconds.
+type jsonLatency struct {
+	P50 float64 `json:"p50Ms"`
+	P90 float64 `json:"p90Ms"`
+	P95 float64 `json:"p95Ms"`
+	P99 float64 `json:"p99Ms"`
+	Max float64 `json:"maxMs"`
+}
+
+type jsonCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type jsonPath struct {
+	Path    string      `json:"path"`
+	Count   int         `json:"count"`
+	Latency jsonLatency `json:"latency"`
+}
+
+type jsonRequest struct {
+	RequestID string    `json:"requestId,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	RuntimeMs float64   `json:"runtimeMs"`
+	Time      time.Time `json:"time"`
+}
+
+type jsonReport struct {
+	Requests      int           `json:"requests"`
+	Skipped       int           `json:"skipped"`
+	Latency       jsonLatency   `json:"latency"`
+	Statuses      []jsonCount   `json:"statuses"`
+	StatusClasses []jsonCount   `json:"statusClasses"`
+	TopPaths      []jsonPath    `json:"topPaths"`
+	TopClients    []jsonCount   `json:"topClients"`
+	Slowest       []jsonRequest `json:"slowest"`
+}
+
+func writeJSON(w io.Writer, r report) error {
+	out := jsonReport{
+		Requests:      r.Requests,
+		Skipped:       r.Skipped,
+		Latency:       toJSONLatency(r.Latency),
+		Statuses:      toJSONCounts(r.Statuses),
+		StatusClasses: toJSONCounts(r.StatusClasses),
+		TopPaths:      []jsonPath{},
+		TopClients:    toJSONCounts(r.TopClients),
+		Slowest:       []jsonRequest{},
+	}
+	for _, p := range r.TopPaths {
+		out.TopPaths = append(out.TopPaths, jsonPath{Path: p.Path, Count: p.Count, Latency: toJSONLatency(p.Latency)})
+	}
+	for _, rec := range r.Slowest {
+		out.Slowest = append(out.Slowest, jsonRequest{
+			RequestID: rec.RequestID,
+			Method:    rec.Method,
+			Path:      rec.Path,
+			Status:    rec.Status,
+			RuntimeMs: ms(rec.Runtime),
+			Time:      rec.Time,
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func toJSONLatency(l latency) jsonLatency {
+	return jsonLatency{P50: ms(l.P50), P90: ms(l.P90), P95: ms(l.P95), P99: ms(l.P99), Max: ms(l.Max)}
+}
+
+func toJSONCounts(counts []count) []jsonCount {
+	out := make([]jsonCount, len(counts))
+	for i, c := range counts {
+		out[i] = jsonCount{Key: c.Key, Count: c.Count}
+	}
+	return out
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// writeCSV puts every section of the report in one table, telling rows apart by their first column. Latencies are in
+// milliseconds.
+func writeCSV(w io.Writer, r report) error {
+	cw := csv.NewWriter(w)
+	rows := [][]string{
+		{"section", "key", "count", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "max_ms", "detail"},
+		append([]string{"latency", "all", strconv.Itoa(r.Requests)}, csvLatency(r.Latency)...),
+	}
+	for _, c := range r.StatusClasses {
+		rows = append(rows, csvCount("status_class", c))
+	}
+	for _, c := range r.Statuses {
+		rows = append(rows, csvCount("status", c))
+	}
+	for _, p := range r.TopPaths {
+		rows = append(rows, append([]string{"path", p.Path, strconv.Itoa(p.Count)}, csvLatency(p.Latency)...))
+	}
+	for _, c := range r.TopClients {
+		rows = append(rows, csvCount("client", c))
+	}
+	for _, rec := range r.Slowest {
+		runtime := strconv.FormatFloat(ms(rec.Runtime), 'f', -1, 64)
+		rows = append(rows, []string{"slowest", requestID(rec), "", "", "", "", "", runtime,
+			fmt.Sprintf("%s %s %d %s", rec.Method, rec.Path, rec.Status, formatTime(rec.Time))})
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func csvLatency(l latency) []string {
+	columns := make([]string, 0, 6)
+	for _, d := range []time.Duration{l.P50, l.P90, l.P95, l.P99, l.Max} {
+		columns = append(columns, strconv.FormatFloat(ms(d), 'f', -1, 64))
+	}
+	return append(columns, "")
+}
+
+func csvCount(section string, c count) []string {
+	return []string{section, c.Key, strconv.Itoa(c.Count), "", "", "", "", "", ""}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/logrushandler"
+)
+
+// record is one access log line written by logrushandler.RequestsHandler.
+type record struct {
+	Time      time.Time
+	Method    string
+	Path      string
+	Status    int
+	Runtime   time.Duration
+	Bytes     int64
+	Client    string
+	RequestID string
+}
+
+// logLine holds the fields of a logrus JSON line that the analysis uses.
+type logLine struct {
+	Msg            string          `json:"msg"`
+	Time           string          `json:"time"`
+	StartTimestamp string          `json:"startTimestamp"`
+	Method         string          `json:"method"`
+	Status         *int            `json:"status"`
+	Runtime        json.RawMessage `json:"runtime"`
+	Bytes          int64           `json:"bytes"`
+	RemoteAddr     string          `json:"remoteAddr"`
+}
+
+var errNotAccessLog = errors.New("not an access log line")
+
+// scan reads lines from r, calling fn with each access log record and returning how many lines it skipped, either
+// because they were not JSON or because they were some other kind of entry, such as a recovered panic.
+func scan(r io.Reader, fn func(record)) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	skipped := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		rec, err := parseLine([]byte(line))
+		if err != nil {
+			skipped++
+			continue
+		}
+		fn(rec)
+	}
+	return skipped, scanner.Err()
+}
+
+func parseLine(line []byte) (record, error) {
+	var l logLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		return record{}, err
+	}
+	if l.Method == "" || l.Status == nil {
+		return record{}, errNotAccessLog
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return record{}, err
+	}
+	requestID, _ := fields[handler.RequestIDLogField].(string)
+	runtime, err := parseRuntime(l.Runtime)
+	if err != nil {
+		return record{}, err
+	}
+	return record{
+		Time:      parseTime(l.StartTimestamp, l.Time),
+		Method:    l.Method,
+		Path:      path(l.Msg, l.Method),
+		Status:    *l.Status,
+		Runtime:   runtime,
+		Bytes:     l.Bytes,
+		Client:    client(l.RemoteAddr),
+		RequestID: requestID,
+	}, nil
+}
+
+// parseRuntime reads the runtime field, which logrus's JSONFormatter writes as nanoseconds, though a duration string
+// is accepted too.
+func parseRuntime(raw json.RawMessage) (time.Duration, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	var ns int64
+	if err := json.Unmarshal(raw, &ns); err == nil {
+		return time.Duration(ns), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(s)
+}
+
+func parseTime(startTimestamp, logTime string) time.Time {
+	if t, err := time.Parse(logrushandler.ISO8601Format, startTimestamp); err == nil {
+		return t
+	}
+	t, _ := time.Parse(time.RFC3339, logTime)
+	return t
+}
+
+// path takes the path from a message of the form "GET /things?page=2", dropping the query so that requests for the
+// same route are counted together.
+func path(msg, method string) string {
+	uri := strings.TrimPrefix(msg, method+" ")
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		uri = uri[:i]
+	}
+	return uri
+}
+
+func client(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// latency summarises a set of runtimes.
+type latency struct {
+	P50 time.Duration
+	P90 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+type count struct {
+	Key   string
+	Count int
+}
+
+type pathStats struct {
+	Path    string
+	Count   int
+	Latency latency
+}
+
+type report struct {
+	Requests      int
+	Skipped       int
+	Latency       latency
+	Statuses      []count
+	StatusClasses []count
+	TopPaths      []pathStats
+	TopClients    []count
+	Slowest       []record
+}
+
+// aggregator collects the records a report is built from.
+type aggregator struct {
+	filter   filter
+	skipped  int
+	runtimes []time.Duration
+	paths    map[string][]time.Duration
+	statuses map[int]int
+	clients  map[string]int
+	records  []record
+}
+
+func newAggregator(f filter) *aggregator {
+	return &aggregator{
+		filter:   f,
+		paths:    make(map[string][]time.Duration),
+		statuses: make(map[int]int),
+		clients:  make(map[string]int),
+	}
+}
+
+func (a *aggregator) add(rec record) {
+	if !a.filter.matches(rec) {
+		return
+	}
+	a.runtimes = append(a.runtimes, rec.Runtime)
+	a.paths[rec.Path] = append(a.paths[rec.Path], rec.Runtime)
+	a.statuses[rec.Status]++
+	if rec.Client != "" {
+		a.clients[rec.Client]++
+	}
+	a.records = append(a.records, rec)
+}
+
+// report builds the report, keeping the top n of each ranking and ordering paths by request count or, with
+// pathsByLatency, by p95 latency.
+func (a *aggregator) report(n int, pathsByLatency bool) report {
+	r := report{
+		Requests:   len(a.runtimes),
+		Skipped:    a.skipped,
+		Latency:    summarise(a.runtimes),
+		TopPaths:   a.topPaths(n, pathsByLatency),
+		TopClients: top(a.clients, n),
+		Slowest:    a.slowest(n),
+	}
+	classes := make(map[string]int)
+	for status, c := range a.statuses {
+		r.Statuses = append(r.Statuses, count{Key: strconv.Itoa(status), Count: c})
+		classes[strconv.Itoa(status/100)+"xx"] += c
+	}
+	sort.Slice(r.Statuses, func(i, j int) bool { return r.Statuses[i].Key < r.Statuses[j].Key })
+	for class, c := range classes {
+		r.StatusClasses = append(r.StatusClasses, count{Key: class, Count: c})
+	}
+	sort.Slice(r.StatusClasses, func(i, j int) bool { return r.StatusClasses[i].Key < r.StatusClasses[j].Key })
+	return r
+}
+
+func (a *aggregator) topPaths(n int, byLatency bool) []pathStats {
+	stats := make([]pathStats, 0, len(a.paths))
+	for p, runtimes := range a.paths {
+		stats = append(stats, pathStats{Path: p, Count: len(runtimes), Latency: summarise(runtimes)})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if byLatency && stats[i].Latency.P95 != stats[j].Latency.P95 {
+			return stats[i].Latency.P95 > stats[j].Latency.P95
+		}
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Path < stats[j].Path
+	})
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+func (a *aggregator) slowest(n int) []record {
+	slowest := append([]record(nil), a.records...)
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].Runtime > slowest[j].Runtime })
+	if len(slowest) > n {
+		slowest = slowest[:n]
+	}
+	return slowest
+}
+
+func top(counts map[string]int, n int) []count {
+	ranked := make([]count, 0, len(counts))
+	for k, c := range counts {
+		ranked = append(ranked, count{Key: k, Count: c})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Key < ranked[j].Key
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+func summarise(runtimes []time.Duration) latency {
+	if len(runtimes) == 0 {
+		return latency{}
+	}
+	sorted := append([]time.Duration(nil), runtimes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return latency{
+		P50: percentile(sorted, 50),
+		P90: percentile(sorted, 90),
+		P95: percentile(sorted, 95),
+		P99: percentile(sorted, 99),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// percentile picks the nearest-rank pth percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
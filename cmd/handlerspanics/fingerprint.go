@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/sahilm/handlers/logrushandler"
+)
+
+// handlersModule is left out of fingerprints by default: its middleware frames are on every stack.
+const handlersModule = "github.com/sahilm/handlers"
+
+// closureSuffix matches the numbering of anonymous functions, which shifts as unrelated closures are added.
+var closureSuffix = regexp.MustCompile(`\.func\d+(\.\d+)*`)
+
+// fingerprinter groups panics by the frames of the code being triaged. Line numbers and closure numbering are ignored
+// so that a panic keeps its fingerprint across deploys.
+type fingerprinter struct {
+	// module is the import path prefix of the code being triaged. Empty means any code outside the standard library
+	// and this module.
+	module string
+	// depth is how many frames, counting from the panic, make up a fingerprint. Zero means all of them.
+	depth int
+}
+
+// fingerprint returns the fingerprint of a panic and the normalized frames it was computed from. A panic without any
+// frames of interest is fingerprinted by its runtime frames, or failing that, by its message.
+func (fp fingerprinter) fingerprint(e panicEntry) (string, []string) {
+	frames := fp.frames(e.Frames, fp.inModule)
+	if len(frames) == 0 {
+		frames = fp.frames(e.Frames, func(pkg string) bool { return !isRuntime(pkg) })
+	}
+	key := strings.Join(frames, "\n")
+	if len(frames) == 0 {
+		key = e.Panic
+	}
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:])[:12], frames
+}
+
+func (fp fingerprinter) frames(stack []logrushandler.StackFrame, keep func(pkg string) bool) []string {
+	var frames []string
+	for _, f := range stack {
+		if !keep(packagePath(f.Func)) {
+			continue
+		}
+		name := closureSuffix.ReplaceAllString(f.Func, ".func")
+		// collapse recursion, which would otherwise fingerprint each depth separately
+		if len(frames) > 0 && frames[len(frames)-1] == name {
+			continue
+		}
+		frames = append(frames, name)
+		if fp.depth > 0 && len(frames) == fp.depth {
+			break
+		}
+	}
+	return frames
+}
+
+func (fp fingerprinter) inModule(pkg string) bool {
+	if fp.module != "" {
+		return withinPath(pkg, fp.module)
+	}
+	return !isStandard(pkg) && !withinPath(pkg, handlersModule)
+}
+
+// packagePath takes the import path from a function name such as
+// "github.com/acme/app/things.(*Store).Get.func1".
+func packagePath(funcName string) string {
+	slash := strings.LastIndexByte(funcName, '/')
+	dot := strings.IndexByte(funcName[slash+1:], '.')
+	if dot < 0 {
+		return funcName
+	}
+	return funcName[:slash+1+dot]
+}
+
+func withinPath(pkg, prefix string) bool {
+	return pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+}
+
+func isStandard(pkg string) bool {
+	first := pkg
+	if i := strings.IndexByte(pkg, '/'); i >= 0 {
+		first = pkg[:i]
+	}
+	return !strings.Contains(first, ".") && pkg != "main"
+}
+
+func isRuntime(pkg string) bool {
+	return withinPath(pkg, "runtime")
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHandlerspanics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Handlerspanics Suite")
+}
@@ -0,0 +1,135 @@
+// Command handlerspanics groups the panics logged by logrushandler.RecoveryHandler by where they happened, so that a
+// crash loop shows up as one large group rather than as hundreds of stack traces.
+//
+//	handlerspanics [flags] [file ...]
+//
+// It reads JSON log lines from the files, or from standard input when no files are given, and understands both the
+// default entries, whose message is the stack trace, and those logged with logrushandler.WithStructuredStack. Each
+// group is fingerprinted by the function names of its frames within -module, without line numbers, and listed with
+// its count, when it was first and last seen, and a sample request ID.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+type options struct {
+	fingerprinter fingerprinter
+	since         time.Time
+	until         time.Time
+	format        string
+	top           int
+	files         []string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, err := parseFlags(args, stderr)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "handlerspanics:", err)
+		return 2
+	}
+	agg := newAggregator(opts.fingerprinter, opts.since, opts.until)
+	if err = read(opts.files, stdin, agg); err != nil {
+		fmt.Fprintln(stderr, "handlerspanics:", err)
+		return 1
+	}
+	write := writeText
+	if opts.format == "json" {
+		write = writeJSON
+	}
+	if err = write(stdout, agg.report(opts.top)); err != nil {
+		fmt.Fprintln(stderr, "handlerspanics:", err)
+		return 1
+	}
+	return 0
+}
+
+func parseFlags(args []string, stderr io.Writer) (options, error) {
+	fs := flag.NewFlagSet("handlerspanics", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		opts         options
+		since, until string
+	)
+	fs.StringVar(&opts.fingerprinter.module, "module", "",
+		"import path of the code to fingerprint by, e.g. github.com/acme/app; by default any code outside the "+
+			"standard library and github.com/sahilm/handlers")
+	fs.IntVar(&opts.fingerprinter.depth, "depth", 0, "how many frames from the panic make up a fingerprint; 0 for all")
+	fs.StringVar(&since, "since", "", "only count panics logged at or after this RFC 3339 time")
+	fs.StringVar(&until, "until", "", "only count panics logged before this RFC 3339 time")
+	fs.StringVar(&opts.format, "format", "text", "output format: text or json")
+	fs.IntVar(&opts.top, "top", 20, "how many groups to list")
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+	opts.files = fs.Args()
+	var err error
+	if opts.since, err = parseFlagTime("since", since); err != nil {
+		return options{}, err
+	}
+	if opts.until, err = parseFlagTime("until", until); err != nil {
+		return options{}, err
+	}
+	switch {
+	case opts.format != "text" && opts.format != "json":
+		return options{}, fmt.Errorf("unknown format %q", opts.format)
+	case opts.top <= 0:
+		return options{}, errors.New("-top must be positive")
+	case opts.fingerprinter.depth < 0:
+		return options{}, errors.New("-depth must not be negative")
+	}
+	return opts, nil
+}
+
+func parseFlagTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-%s: %w", name, err)
+	}
+	return t, nil
+}
+
+func read(files []string, stdin io.Reader, agg *aggregator) error {
+	if len(files) == 0 {
+		return readFrom(stdin, "standard input", agg)
+	}
+	for _, name := range files {
+		if err := readFile(name, agg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(name string, agg *aggregator) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readFrom(f, name, agg)
+}
+
+func readFrom(r io.Reader, name string, agg *aggregator) error {
+	skipped, err := scan(r, agg.add)
+	agg.skipped += skipped
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/logrushandler"
+	"github.com/sirupsen/logrus"
+)
+
+const module = "github.com/sahilm/handlers/cmd/handlerspanics"
+
+// clockHook stamps entries with the time it is set to, since logrus stamps them with the wall clock.
+type clockHook struct {
+	now time.Time
+}
+
+func (h *clockHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *clockHook) Fire(entry *logrus.Entry) error {
+	entry.Time = h.now
+	return nil
+}
+
+func loadThing(w http.ResponseWriter, r *http.Request) {
+	var things []string
+	_, _ = w.Write([]byte(things[len(r.URL.Path)]))
+}
+
+func saveThing(w http.ResponseWriter, r *http.Request) {
+	func() {
+		panic("save failed for " + r.URL.Path)
+	}()
+}
+
+var _ = Describe("handlerspanics", func() {
+	start := time.Date(2019, 10, 13, 12, 0, 0, 0, time.UTC)
+
+	var (
+		logs bytes.Buffer
+		hook *clockHook
+	)
+
+	logPanic := func(at time.Time, next http.HandlerFunc, target, id string, opts ...logrushandler.RecoveryHandlerOption) {
+		logger := logrus.New()
+		logger.Out = &logs
+		logger.Formatter = &logrus.JSONFormatter{}
+		hook.now = at
+		logger.AddHook(hook)
+		rh, err := logrushandler.NewRecoveryHandlerWithOptions(logrus.NewEntry(logger), next, opts...)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("GET", target, nil)
+		if id != "" {
+			request.Header.Set(handler.RequestIDHeader, id)
+		}
+		rh.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	BeforeEach(func() {
+		logs.Reset()
+		hook = &clockHook{}
+		logPanic(start, loadThing, "/a", "a")
+		logPanic(start.Add(time.Minute), saveThing, "/b", "b")
+		logPanic(start.Add(2*time.Minute), loadThing, "/bb", "", logrushandler.WithStructuredStack())
+		logPanic(start.Add(3*time.Minute), loadThing, "/ccc", "c", logrushandler.WithStructuredStack())
+		logs.WriteString(`{"level":"info","method":"GET","msg":"GET /a","status":500}` + "\n")
+		logs.WriteString("not json\n")
+	})
+
+	runJSON := func(args ...string) jsonReport {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{"-format", "json", "-module", module}, args...)
+		Expect(run(args, bytes.NewReader(logs.Bytes()), &stdout, &stderr)).To(Equal(0), stderr.String())
+		var r jsonReport
+		Expect(json.Unmarshal(stdout.Bytes(), &r)).To(Succeed())
+		return r
+	}
+
+	It("should group text and structured stack traces by fingerprint", func() {
+		r := runJSON()
+		Expect(r.Panics).To(Equal(4))
+		Expect(r.Skipped).To(Equal(2))
+		Expect(r.Groups).To(HaveLen(2))
+
+		load := r.Groups[0]
+		Expect(load.Count).To(Equal(3))
+		Expect(*load.FirstSeen).To(Equal(start))
+		Expect(*load.LastSeen).To(Equal(start.Add(3 * time.Minute)))
+		Expect(load.RequestID).To(Equal("c"))
+		Expect(load.Panic).To(ContainSubstring("index out of range"))
+		Expect(load.Frames[0]).To(Equal(module + ".loadThing"))
+
+		save := r.Groups[1]
+		Expect(save.Count).To(Equal(1))
+		Expect(save.RequestID).To(Equal("b"))
+		Expect(save.Panic).To(Equal("save failed for /b"))
+		Expect(save.Frames[:2]).To(Equal([]string{module + ".saveThing.func", module + ".saveThing"}))
+	})
+
+	It("should limit fingerprints to the innermost frames", func() {
+		r := runJSON("-depth", "1")
+		Expect(r.Groups[1].Frames).To(Equal([]string{module + ".saveThing.func"}))
+	})
+
+	It("should filter by time and limit the groups listed", func() {
+		r := runJSON("-since", start.Add(time.Minute).Format(time.RFC3339),
+			"-until", start.Add(3*time.Minute).Format(time.RFC3339))
+		Expect(r.Panics).To(Equal(2))
+		Expect(r.Groups[0].Count).To(Equal(1))
+		Expect(r.Groups[0].RequestID).To(BeEmpty())
+
+		r = runJSON("-top", "1")
+		Expect(r.Groups).To(HaveLen(1))
+		Expect(r.Groups[0].Count).To(Equal(3))
+	})
+
+	It("should leave out the standard library and this module by default", func() {
+		var stdout bytes.Buffer
+		Expect(run([]string{"-format", "json"}, bytes.NewReader(logs.Bytes()), &stdout, ioutil.Discard)).To(Equal(0))
+		var r jsonReport
+		Expect(json.Unmarshal(stdout.Bytes(), &r)).To(Succeed())
+		for _, g := range r.Groups {
+			for _, f := range g.Frames {
+				Expect(f).ToNot(HavePrefix("runtime."))
+			}
+		}
+	})
+
+	It("should write a text report", func() {
+		var stdout bytes.Buffer
+		Expect(run([]string{"-module", module}, bytes.NewReader(logs.Bytes()), &stdout, ioutil.Discard)).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("panics         4"))
+		Expect(stdout.String()).To(MatchRegexp(`[0-9a-f]{12}  count 3  first 2019-10-13T12:00:00Z  ` +
+			`last 2019-10-13T12:03:00Z  request c\n    panic: .*index out of range.*\n    ` + module + `.loadThing\n`))
+	})
+
+	It("should read files", func() {
+		dir, err := ioutil.TempDir("", "handlerspanics")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		name := filepath.Join(dir, "app.log")
+		Expect(ioutil.WriteFile(name, logs.Bytes(), 0600)).To(Succeed())
+
+		var stdout bytes.Buffer
+		Expect(run([]string{"-format", "json", name, name}, strings.NewReader(""), &stdout, ioutil.Discard)).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring(`"panics": 8`))
+
+		var stderr bytes.Buffer
+		Expect(run([]string{filepath.Join(dir, "missing.log")}, nil, ioutil.Discard, &stderr)).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("missing.log"))
+	})
+
+	It("should reject bad flags", func() {
+		for _, args := range [][]string{
+			{"-format", "csv"},
+			{"-top", "0"},
+			{"-depth", "-1"},
+			{"-until", "tomorrow"},
+		} {
+			Expect(run(args, nil, ioutil.Discard, ioutil.Discard)).To(Equal(2))
+		}
+	})
+
+	It("should collapse recursion and closure numbering", func() {
+		fp := fingerprinter{module: "example.com/app"}
+		a, frames := fp.fingerprint(panicEntry{Frames: []logrushandler.StackFrame{
+			{Func: "runtime.gopanic"},
+			{Func: "example.com/app/tree.walk.func3", Line: 10},
+			{Func: "example.com/app/tree.walk", Line: 12},
+			{Func: "example.com/app/tree.walk", Line: 12},
+			{Func: "net/http.HandlerFunc.ServeHTTP"},
+		}})
+		Expect(frames).To(Equal([]string{"example.com/app/tree.walk.func", "example.com/app/tree.walk"}))
+		b, _ := fp.fingerprint(panicEntry{Frames: []logrushandler.StackFrame{
+			{Func: "example.com/app/tree.walk.func1", Line: 20},
+			{Func: "example.com/app/tree.walk", Line: 22},
+		}})
+		Expect(b).To(Equal(a))
+	})
+})
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func writeText(w io.Writer, r report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "panics\t%d\n", r.Panics)
+	fmt.Fprintf(tw, "groups\t%d\n", len(r.Groups))
+	if r.Skipped > 0 {
+		fmt.Fprintf(tw, "skipped lines\t%d\n", r.Skipped)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, g := range r.Groups {
+		fmt.Fprintf(w, "\n%s  count %d  first %s  last %s  request %s\n", g.Fingerprint, g.Count,
+			formatTime(g.FirstSeen), formatTime(g.LastSeen), orDash(g.RequestID))
+		fmt.Fprintf(w, "    panic: %s\n", firstLine(g.Panic))
+		for _, f := range g.Frames {
+			fmt.Fprintf(w, "    %s\n", f)
+		}
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i] + " ..."
+	}
+	return s
+}
+
+type jsonGroup struct {
+	Fingerprint string     `json:"fingerprint"`
+	Count       int        `json:"count"`
+	FirstSeen   *time.Time `json:"firstSeen,omitempty"`
+	LastSeen    *time.Time `json:"lastSeen,omitempty"`
+	RequestID   string     `json:"requestId,omitempty"`
+	Panic       string     `json:"panic"`
+	Frames      []string   `json:"frames"`
+}
+
+type jsonReport struct {
+	Panics  int         `json:"panics"`
+	Skipped int         `json:"skipped"`
+	Groups  []jsonGroup `json:"groups"`
+}
+
+func writeJSON(w io.Writer, r report) error {
+	out := jsonReport{Panics: r.Panics, Skipped: r.Skipped, Groups: []jsonGroup{}}
+	for _, g := range r.Groups {
+		out.Groups = append(out.Groups, jsonGroup{
+			Fingerprint: g.Fingerprint,
+			Count:       g.Count,
+			FirstSeen:   timeOrNil(g.FirstSeen),
+			LastSeen:    timeOrNil(g.LastSeen),
+			RequestID:   g.RequestID,
+			Panic:       g.Panic,
+			Frames:      append([]string{}, g.Frames...),
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
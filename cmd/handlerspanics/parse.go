@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/logrushandler"
+)
+
+// panicEntry is one panic logged by logrushandler.RecoveryHandler.
+type panicEntry struct {
+	Time      time.Time
+	Panic     string
+	RequestID string
+	Frames    []logrushandler.StackFrame
+}
+
+// logLine holds the fields of a logrus JSON line that the analysis uses.
+type logLine struct {
+	Msg   string                     `json:"msg"`
+	Time  string                     `json:"time"`
+	Panic interface{}                `json:"panic"`
+	Stack []logrushandler.StackFrame `json:"stack"`
+}
+
+var (
+	errNotPanic = errors.New("not a panic log line")
+	// frameLine matches a line of the stack trace RecoveryHandler logs as its message by default.
+	frameLine = regexp.MustCompile(`^(.+):(\d+) (\S+)\(\)$`)
+)
+
+// scan reads lines from r, calling fn with each panic and returning how many lines it skipped, either because they
+// were not JSON or because they were some other kind of entry, such as an access log line.
+func scan(r io.Reader, fn func(panicEntry)) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	skipped := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		e, err := parseLine([]byte(line))
+		if err != nil {
+			skipped++
+			continue
+		}
+		fn(e)
+	}
+	return skipped, scanner.Err()
+}
+
+// parseLine reads a panic logged either with the stack trace as the message or, with
+// logrushandler.WithStructuredStack, as a stack field.
+func parseLine(line []byte) (panicEntry, error) {
+	var l logLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		return panicEntry{}, err
+	}
+	if l.Panic == nil {
+		return panicEntry{}, errNotPanic
+	}
+	frames := l.Stack
+	if len(frames) == 0 {
+		frames = parseFrames(l.Msg)
+	}
+	if len(frames) == 0 {
+		return panicEntry{}, errNotPanic
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return panicEntry{}, err
+	}
+	requestID, _ := fields[handler.RequestIDLogField].(string)
+	t, _ := time.Parse(time.RFC3339, l.Time)
+	return panicEntry{
+		Time:      t,
+		Panic:     panicMessage(l.Panic),
+		RequestID: requestID,
+		Frames:    frames,
+	}, nil
+}
+
+func parseFrames(msg string) []logrushandler.StackFrame {
+	var frames []logrushandler.StackFrame
+	for _, line := range strings.Split(msg, "\n") {
+		m := frameLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		frames = append(frames, logrushandler.StackFrame{Func: m[3], File: m[1], Line: n})
+	}
+	return frames
+}
+
+func panicMessage(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
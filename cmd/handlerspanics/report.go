@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// group is the panics sharing a fingerprint.
+type group struct {
+	Fingerprint string
+	Frames      []string
+	Count       int
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	// Panic and RequestID come from the most recent panic in the group, RequestID from the most recent one that
+	// had a request ID.
+	Panic         string
+	RequestID     string
+	requestIDSeen time.Time
+}
+
+type report struct {
+	Panics  int
+	Skipped int
+	Groups  []*group
+}
+
+// aggregator groups panics by fingerprint.
+type aggregator struct {
+	fingerprinter fingerprinter
+	since         time.Time
+	until         time.Time
+	skipped       int
+	panics        int
+	groups        map[string]*group
+}
+
+func newAggregator(fp fingerprinter, since, until time.Time) *aggregator {
+	return &aggregator{fingerprinter: fp, since: since, until: until, groups: make(map[string]*group)}
+}
+
+func (a *aggregator) add(e panicEntry) {
+	if !a.since.IsZero() && e.Time.Before(a.since) || !a.until.IsZero() && !e.Time.Before(a.until) {
+		return
+	}
+	a.panics++
+	fingerprint, frames := a.fingerprinter.fingerprint(e)
+	g, ok := a.groups[fingerprint]
+	if !ok {
+		g = &group{Fingerprint: fingerprint, Frames: frames, FirstSeen: e.Time, LastSeen: e.Time, Panic: e.Panic}
+		a.groups[fingerprint] = g
+	}
+	g.Count++
+	if e.Time.Before(g.FirstSeen) {
+		g.FirstSeen = e.Time
+	}
+	if !e.Time.Before(g.LastSeen) {
+		g.LastSeen = e.Time
+		g.Panic = e.Panic
+	}
+	if e.RequestID != "" && (g.RequestID == "" || !e.Time.Before(g.requestIDSeen)) {
+		g.RequestID = e.RequestID
+		g.requestIDSeen = e.Time
+	}
+}
+
+// report lists the n largest groups, most recently seen first among groups of the same size.
+func (a *aggregator) report(n int) report {
+	groups := make([]*group, 0, len(a.groups))
+	for _, g := range a.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		if !groups[i].LastSeen.Equal(groups[j].LastSeen) {
+			return groups[i].LastSeen.After(groups[j].LastSeen)
+		}
+		return groups[i].Fingerprint < groups[j].Fingerprint
+	})
+	if len(groups) > n {
+		groups = groups[:n]
+	}
+	return report{Panics: a.panics, Skipped: a.skipped, Groups: groups}
+}
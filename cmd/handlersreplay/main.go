@@ -0,0 +1,179 @@
+// Command handlersreplay replays the requests in HAR files, such as those handler.RecordHandler archives, against a
+// server and reports the responses that differ from the recorded ones.
+//
+//	handlersreplay -target http://localhost:8080 [flags] file.har ...
+//
+// Requests go to the target in the order they were recorded, keeping their paths and queries. Headers that were
+// redacted when recorded are left out, so use -H to put back credentials. Redacted query values and request bodies
+// cannot be put back: they are sent with the redaction marker in place of the original value. It exits with status 1
+// if any response differed.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sahilm/handlers/handler"
+)
+
+type options struct {
+	target   *url.URL
+	replayer handler.Replayer
+	timeout  time.Duration
+	verbose  bool
+	files    []string
+}
+
+// headerFlag collects repeated -H "Name: value" flags.
+type headerFlag http.Header
+
+func (hf headerFlag) String() string {
+	return ""
+}
+
+func (hf headerFlag) Set(value string) error {
+	colon := strings.IndexByte(value, ':')
+	if colon <= 0 {
+		return fmt.Errorf("header %q is not of the form Name: value", value)
+	}
+	http.Header(hf).Add(strings.TrimSpace(value[:colon]), strings.TrimSpace(value[colon+1:]))
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	opts, err := parseFlags(args, stderr)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(stderr, "handlersreplay:", err)
+		return 2
+	}
+	target := proxy(opts.target, opts.timeout)
+	replayed, differed := 0, 0
+	for _, name := range opts.files {
+		har, readErr := handler.ReadHARFile(name)
+		if readErr != nil {
+			fmt.Fprintln(stderr, "handlersreplay:", readErr)
+			return 1
+		}
+		results, replayErr := opts.replayer.Replay(har, target)
+		for _, result := range results {
+			replayed++
+			if !result.Matched() {
+				differed++
+			}
+			report(stdout, result, opts.verbose)
+		}
+		if replayErr != nil {
+			fmt.Fprintf(stderr, "handlersreplay: %s: %v\n", name, replayErr)
+			return 1
+		}
+	}
+	fmt.Fprintf(stdout, "%d replayed, %d differed\n", replayed, differed)
+	if differed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func parseFlags(args []string, stderr io.Writer) (options, error) {
+	fs := flag.NewFlagSet("handlersreplay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		opts           options
+		target, ignore string
+	)
+	opts.replayer.Header = make(http.Header)
+	fs.StringVar(&target, "target", "", "base URL of the server to replay against, e.g. http://localhost:8080")
+	fs.Var(headerFlag(opts.replayer.Header), "H", `header to set on every request, e.g. "Authorization: Bearer x"; `+
+		"may be repeated")
+	fs.StringVar(&ignore, "ignore-header", "", "comma separated response headers not to compare")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "how long to wait for each response")
+	fs.BoolVar(&opts.verbose, "v", false, "list matching responses too")
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+	opts.files = fs.Args()
+	for _, name := range strings.Split(ignore, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.replayer.IgnoreHeaders = append(opts.replayer.IgnoreHeaders, name)
+		}
+	}
+	var err error
+	if opts.target, err = parseTarget(target); err != nil {
+		return options{}, err
+	}
+	switch {
+	case opts.timeout <= 0:
+		return options{}, errors.New("-timeout must be positive")
+	case len(opts.files) == 0:
+		return options{}, errors.New("no HAR files given")
+	}
+	return opts, nil
+}
+
+func parseTarget(target string) (*url.URL, error) {
+	if target == "" {
+		return nil, errors.New("-target is required")
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("-target: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("-target %q is not an http or https URL", target)
+	}
+	return u, nil
+}
+
+// proxy forwards requests to target, answering with a 502 carrying the error if it cannot be reached.
+func proxy(target *url.URL, timeout time.Duration) http.Handler {
+	rp := httputil.NewSingleHostReverseProxy(target)
+	director := rp.Director
+	rp.Director = func(r *http.Request) {
+		director(r)
+		r.Host = target.Host
+	}
+	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = io.WriteString(w, err.Error())
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		rp.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func report(w io.Writer, result handler.ReplayResult, verbose bool) {
+	if result.Matched() && !verbose {
+		return
+	}
+	verdict := "ok  "
+	if !result.Matched() {
+		verdict = "DIFF"
+	}
+	request := result.Entry.Request
+	fmt.Fprintf(w, "%s %s %s -> %d", verdict, request.Method, request.URL, result.Status)
+	if result.Entry.RequestID != "" {
+		fmt.Fprintf(w, " (request %s)", result.Entry.RequestID)
+	}
+	fmt.Fprintln(w)
+	for _, difference := range result.Differences {
+		fmt.Fprintf(w, "    %s\n", difference)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("handlersreplay", func() {
+	var (
+		dir      string
+		files    []string
+		greeting string
+		server   *httptest.Server
+	)
+
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer letmein" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(greeting + " " + r.URL.Path))
+	})
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "handlersreplay")
+		Expect(err).ToNot(HaveOccurred())
+		greeting = "hello"
+
+		archive, err := handler.NewHARArchive(dir)
+		Expect(err).ToNot(HaveOccurred())
+		rh, err := handler.NewRecordHandler(app, archive)
+		Expect(err).ToNot(HaveOccurred())
+		for _, path := range []string{"/a", "/b"} {
+			request := httptest.NewRequest("GET", path, nil)
+			request.Header.Set("Authorization", "Bearer letmein")
+			request.Header.Set(handler.RequestIDHeader, "req"+path)
+			rh.ServeHTTP(httptest.NewRecorder(), request)
+		}
+		Expect(archive.Close()).To(Succeed())
+		files, err = archive.Files()
+		Expect(err).ToNot(HaveOccurred())
+
+		server = httptest.NewServer(app)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	replay := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(append(args, files...), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	It("should report matching responses", func() {
+		code, stdout, stderr := replay("-target", server.URL, "-H", "Authorization: Bearer letmein", "-v")
+		Expect(code).To(Equal(0), stderr)
+		Expect(stdout).To(Equal("ok   GET http://example.com/a -> 200 (request req/a)\n" +
+			"ok   GET http://example.com/b -> 200 (request req/b)\n" +
+			"2 replayed, 0 differed\n"))
+	})
+
+	It("should report differing responses and fail", func() {
+		greeting = "bye"
+		code, stdout, _ := replay("-target", server.URL, "-H", "Authorization: Bearer letmein")
+		Expect(code).To(Equal(1))
+		Expect(stdout).To(ContainSubstring("DIFF GET http://example.com/a -> 200 (request req/a)\n" +
+			`    body differs at byte 0: recorded "hello /a", replayed "bye /a"` + "\n"))
+		Expect(stdout).To(HaveSuffix("2 replayed, 2 differed\n"))
+
+		code, stdout, _ = replay("-target", server.URL)
+		Expect(code).To(Equal(1))
+		Expect(stdout).To(ContainSubstring("status: recorded 200, replayed 401"))
+	})
+
+	It("should report servers it cannot reach as bad gateways", func() {
+		server.Close()
+		code, stdout, _ := replay("-target", server.URL, "-timeout", "1s")
+		Expect(code).To(Equal(1))
+		Expect(stdout).To(ContainSubstring("status: recorded 200, replayed 502"))
+	})
+
+	It("should fail on files it cannot read", func() {
+		var stderr bytes.Buffer
+		missing := filepath.Join(dir, "missing.har")
+		Expect(run([]string{"-target", server.URL, missing}, ioutil.Discard, &stderr)).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("missing.har"))
+	})
+
+	It("should reject bad flags", func() {
+		for _, args := range [][]string{
+			{},
+			{"-target", "localhost:8080"},
+			{"-target", "ftp://example.com"},
+			{"-target", "http://example.com", "-H", "nocolon"},
+			{"-target", "http://example.com", "-timeout", "0s"},
+		} {
+			Expect(run(append(args, files...), ioutil.Discard, ioutil.Discard)).To(Equal(2), "%v", args)
+		}
+		Expect(run([]string{"-target", "http://example.com"}, ioutil.Discard, ioutil.Discard)).To(Equal(2))
+	})
+})
@@ -0,0 +1,192 @@
+// Package conformance checks that a logging integration built on handler.RequestsHandler and handler.RecoveryHandler
+// logs the way logrushandler does. Run drives an Adapter through a set of scenarios, from panics to client
+// disconnects, and compares what it logged to golden files.
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/handlertest"
+)
+
+// Entry is one structured log entry. Fields are keyed by the names logrushandler uses.
+type Entry struct {
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields"`
+}
+
+// Adapter is the logging integration under test.
+type Adapter interface {
+	// Wrap puts the adapter's request logging and panic recovery around next, reading the time from clock.
+	Wrap(next http.Handler, clock handler.Clock) (http.Handler, error)
+	// Entries returns what the adapter has logged since it was last called, oldest first.
+	Entries() []Entry
+}
+
+// Suite compares the entries an Adapter logs to the golden files in GoldenDir, or to those this package ships,
+// recorded from logrushandler, if it is empty. With Update set, it writes the golden files instead.
+//
+// The shipped files are found through the path this package was compiled from, which builds with -trimpath, or from a
+// vendor directory that leaves testdata out, do not have. Those must copy the files from the module and set
+// GoldenDir.
+type Suite struct {
+	GoldenDir string
+	Update    bool
+}
+
+// Run runs the Suite's default, comparing adapter with logrushandler.
+func Run(t *testing.T, adapter Adapter) {
+	Suite{}.Run(t, adapter)
+}
+
+// Run drives adapter through every scenario as a subtest of t.
+func (s Suite) Run(t *testing.T, adapter Adapter) {
+	dir := s.GoldenDir
+	if dir == "" {
+		dir = shippedGoldenDir()
+	}
+	if _, err := os.Stat(dir); err != nil && !s.Update {
+		t.Fatalf("conformance: no golden files in %s, set Suite.GoldenDir to a copy of this package's testdata: %v",
+			dir, err)
+	}
+	for _, sc := range scenarios {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			got, err := s.serve(sc, adapter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, sc.name+".golden.json")
+			if s.Update {
+				if writeErr := writeGolden(path, got); writeErr != nil {
+					t.Fatal(writeErr)
+				}
+				return
+			}
+			compare(t, path, got)
+		})
+	}
+}
+
+func (s Suite) serve(sc scenario, adapter Adapter) ([]Entry, error) {
+	clock := handlertest.NewClock(time.Date(2019, 10, 13, 20, 53, 20, 0, time.UTC))
+	// timestamps are logged to the second, so anything less would not show whether start and end are the right way round
+	clock.Step(1500 * time.Millisecond)
+	h, err := adapter.Wrap(sc.handler, clock.Now)
+	if err != nil {
+		return nil, err
+	}
+	adapter.Entries()
+	w, done := sc.writer()
+	defer done()
+	serveRecovering(h, w, sc.request())
+	return normalize(adapter.Entries())
+}
+
+// serveRecovering serves r, swallowing any panic the adapter let through so that the entries it did log can still
+// be compared.
+func serveRecovering(h http.Handler, w http.ResponseWriter, r *http.Request) {
+	defer func() { _ = recover() }()
+	h.ServeHTTP(w, r)
+}
+
+func compare(t *testing.T, path string, got []Entry) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	var want []Entry
+	if err = json.Unmarshal(data, &want); err != nil {
+		t.Fatalf("parsing golden file %s: %v", path, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.MarshalIndent(got, "", "  ")
+		t.Errorf("logged entries differ from %s\ngot:\n%s\nwant:\n%s", path, gotJSON, data)
+	}
+}
+
+func writeGolden(path string, entries []Entry) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(entries); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func shippedGoldenDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata")
+}
+
+var stackTracePattern = regexp.MustCompile(`\.go:\d+`)
+
+// normalize puts entries in the form they are compared in: durations and times as strings, stack traces, which
+// change with every build, replaced by a placeholder, and every other value as it comes back from JSON.
+func normalize(entries []Entry) ([]Entry, error) {
+	normalized := make([]Entry, len(entries))
+	for i, entry := range entries {
+		fields := make(map[string]interface{}, len(entry.Fields))
+		for k, v := range entry.Fields {
+			fields[k] = normalizeValue(v)
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("conformance: fields of entry %d: %w", i, err)
+		}
+		fields = nil
+		if err = json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		normalized[i] = Entry{Level: entry.Level, Message: normalizeValue(entry.Message).(string), Fields: fields}
+	}
+	return normalized, nil
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case error:
+		return v.Error()
+	case string:
+		if stackTracePattern.MatchString(v) {
+			return "<stack trace>"
+		}
+		return v
+	}
+	return v
+}
+
+// hijackableRecorder is an httptest.ResponseRecorder whose connection can be taken over.
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (hr *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hr.conn, bufio.NewReadWriter(bufio.NewReader(hr.conn), bufio.NewWriter(hr.conn)), nil
+}
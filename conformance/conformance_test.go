@@ -0,0 +1,14 @@
+package conformance_test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/sahilm/handlers/conformance"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from logrushandler")
+
+func TestLogrusAdapter(t *testing.T) {
+	conformance.Suite{Update: *update}.Run(t, conformance.NewLogrusAdapter())
+}
@@ -0,0 +1,45 @@
+package conformance
+
+import (
+	"net/http"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/handlertest"
+	"github.com/sahilm/handlers/logrushandler"
+)
+
+// LogrusAdapter is logrushandler's RequestsHandler around its RecoveryHandler, the integration the shipped golden
+// files were recorded from. It doubles as an example of an Adapter.
+type LogrusAdapter struct {
+	capture *handlertest.LogCapture
+	seen    int
+}
+
+func NewLogrusAdapter() *LogrusAdapter {
+	return &LogrusAdapter{}
+}
+
+func (la *LogrusAdapter) Wrap(next http.Handler, clock handler.Clock) (http.Handler, error) {
+	entry, capture := handlertest.NewLogCapture()
+	la.capture, la.seen = capture, 0
+	rh, err := logrushandler.NewRecoveryHandlerWithOptions(entry, next)
+	if err != nil {
+		return nil, err
+	}
+	return logrushandler.NewRequestsHandlerWithOptions(entry, rh,
+		logrushandler.WithHandlerOptions(handler.WithClock(clock)))
+}
+
+func (la *LogrusAdapter) Entries() []Entry {
+	if la.capture == nil {
+		return nil
+	}
+	all := la.capture.Entries()
+	logged := all[la.seen:]
+	la.seen = len(all)
+	entries := make([]Entry, len(logged))
+	for i, e := range logged {
+		entries[i] = Entry{Level: e.Level.String(), Message: e.Message, Fields: e.Fields}
+	}
+	return entries
+}
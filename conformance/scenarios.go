@@ -0,0 +1,116 @@
+package conformance
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/handlertest"
+)
+
+const scenarioRequestID = "5f2b8c1e-conformance"
+
+type scenario struct {
+	name    string
+	handler http.Handler
+	request func() *http.Request
+	writer  func() (http.ResponseWriter, func())
+}
+
+var scenarios = []scenario{
+	{
+		name:    "ok",
+		handler: handlertest.StatusHandler(http.StatusCreated, "created"),
+		request: func() *http.Request { return newRequest("POST", "/things?page=1") },
+		writer:  newRecorder,
+	},
+	{
+		name:    "missing-request-id",
+		handler: handlertest.StatusHandler(http.StatusOK, "ok"),
+		request: func() *http.Request {
+			r := newRequest("GET", "/things")
+			r.Header.Del(handler.RequestIDHeader)
+			return r
+		},
+		writer: newRecorder,
+	},
+	{
+		name:    "panic-before-headers",
+		handler: handlertest.PanickingHandler("boom"),
+		request: func() *http.Request { return newRequest("GET", "/panic") },
+		writer:  newRecorder,
+	},
+	{
+		name:    "panic-after-headers",
+		handler: handlertest.PanicAfterWriteHandler(http.StatusAccepted, "partial", "boom"),
+		request: func() *http.Request { return newRequest("GET", "/panic") },
+		writer:  newRecorder,
+	},
+	{
+		name:    "streaming",
+		handler: handlertest.StreamingHandler("one ", "two ", "three"),
+		request: func() *http.Request { return newRequest("GET", "/stream") },
+		writer:  newRecorder,
+	},
+	{
+		name:    "hijack",
+		handler: http.HandlerFunc(hijack),
+		request: func() *http.Request { return newRequest("GET", "/socket") },
+		writer:  newHijackableRecorder,
+	},
+	{
+		name:    "client-disconnect",
+		handler: http.HandlerFunc(waitForDisconnect),
+		request: func() *http.Request {
+			r := newRequest("GET", "/slow")
+			ctx, cancel := context.WithCancel(r.Context())
+			cancel()
+			return r.WithContext(ctx)
+		},
+		writer: newRecorder,
+	},
+}
+
+func newRequest(method, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set(handler.RequestIDHeader, scenarioRequestID)
+	r.Header.Set("User-Agent", "conformance")
+	return r
+}
+
+func newRecorder() (http.ResponseWriter, func()) {
+	return httptest.NewRecorder(), func() {}
+}
+
+func newHijackableRecorder() (http.ResponseWriter, func()) {
+	server, client := net.Pipe()
+	go func() { _, _ = io.Copy(ioutil.Discard, client) }()
+	return &hijackableRecorder{ResponseRecorder: httptest.NewRecorder(), conn: server}, func() {
+		_ = server.Close()
+		_ = client.Close()
+	}
+}
+
+func hijack(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+	_ = rw.Flush()
+	_ = conn.Close()
+}
+
+func waitForDisconnect(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+}
@@ -3,6 +3,7 @@ module github.com/sahilm/handlers
 go 1.13
 
 require (
+	github.com/andybalholm/brotli v1.0.0
 	github.com/antonfisher/nested-logrus-formatter v1.0.2
 	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/google/uuid v1.1.1
@@ -12,6 +13,7 @@ require (
 	github.com/onsi/gomega v1.7.0
 	github.com/sirupsen/logrus v1.4.2
 	github.com/stretchr/testify v1.4.0 // indirect
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 	golang.org/x/net v0.0.0-20191003171128-d98b1b443823 // indirect
 	golang.org/x/sys v0.0.0-20191005200804-aed5e4c7ecf9 // indirect
 	golang.org/x/text v0.3.2 // indirect
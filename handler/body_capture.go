@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type CapturedBody struct {
+	Data        []byte
+	ContentType string
+	// Size is the full number of bytes that passed through, including anything beyond the capture limit.
+	Size      int64
+	Truncated bool
+	// Binary bodies are counted but their Data is dropped.
+	Binary bool
+}
+
+// BodyCaptureConfig controls which request and response bodies RequestsHandler tees into RequestMetadata.
+// Capture happens as the body streams through, so nothing is buffered beyond MaxBytes.
+type BodyCaptureConfig struct {
+	MaxBytes int
+	// ContentTypes is an allow list of media types. Entries ending in "/" match a whole type ("text/"), entries
+	// starting with "+" match a suffix ("+json"). An empty list allows everything.
+	ContentTypes []string
+	// Enabled selects the requests (typically by route) whose bodies are captured. Nil disables route based capture.
+	Enabled func(r *http.Request) bool
+	// DebugHeader enables capture for a single request when it carries DebugToken. An empty DebugToken disables it.
+	DebugHeader string
+	DebugToken  string
+	Redact      func(contentType string, body []byte) []byte
+}
+
+func (cb *CapturedBody) String() string {
+	if cb.Binary {
+		return fmt.Sprintf("[binary body, %d bytes]", cb.Size)
+	}
+	if cb.Truncated {
+		return fmt.Sprintf("%s...[truncated %d bytes]", cb.Data, cb.Size-int64(len(cb.Data)))
+	}
+	return string(cb.Data)
+}
+
+func (bc *BodyCaptureConfig) enabledFor(r *http.Request) bool {
+	if bc == nil || bc.MaxBytes <= 0 {
+		return false
+	}
+	if bc.Enabled != nil && bc.Enabled(r) {
+		return true
+	}
+	if bc.DebugHeader == "" || bc.DebugToken == "" {
+		return false
+	}
+	token := r.Header.Get(bc.DebugHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(bc.DebugToken)) == 1
+}
+
+func (bc *BodyCaptureConfig) allows(contentType string) bool {
+	if len(bc.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range bc.ContentTypes {
+		switch {
+		case strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed),
+			strings.HasPrefix(allowed, "+") && strings.HasSuffix(mediaType, allowed),
+			mediaType == allowed:
+			return true
+		}
+	}
+	return false
+}
+
+// bodyCapturer is locked because a handler abandoned by TimeoutHandler can still be reading the body when the capture
+// is reported.
+type bodyCapturer struct {
+	config      *BodyCaptureConfig
+	contentType string
+	mu          sync.Mutex
+	buf         bytes.Buffer
+	size        int64
+}
+
+func (c *bodyCapturer) write(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size += int64(len(p))
+	if remaining := c.config.MaxBytes - c.buf.Len(); remaining > 0 {
+		if len(p) > remaining {
+			p = p[:remaining]
+		}
+		c.buf.Write(p)
+	}
+}
+
+func (c *bodyCapturer) captured() *CapturedBody {
+	c.mu.Lock()
+	data := append([]byte(nil), c.buf.Bytes()...)
+	size := c.size
+	c.mu.Unlock()
+	cb := &CapturedBody{
+		ContentType: c.contentType,
+		Size:        size,
+		Truncated:   size > int64(len(data)),
+	}
+	if isBinary(data) {
+		cb.Binary = true
+		return cb
+	}
+	cb.Data = data
+	if c.config.Redact != nil {
+		cb.Data = c.config.Redact(c.contentType, cb.Data)
+	}
+	return cb
+}
+
+func isBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) != -1 {
+		return true
+	}
+	// a truncated capture may end part way through a multi-byte rune
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				data = data[:i]
+			}
+			break
+		}
+	}
+	return !utf8.Valid(data)
+}
+
+type capturingReadCloser struct {
+	io.ReadCloser
+	capturer *bodyCapturer
+}
+
+func (cr *capturingReadCloser) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.capturer.write(p[:n])
+	return n, err
+}
@@ -0,0 +1,133 @@
+package handler_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("Body capture", func() {
+	var (
+		captured    handler.RequestMetadata
+		nextHandler http.Handler
+		config      *handler.BodyCaptureConfig
+		recorder    *httptest.ResponseRecorder
+		serve       func(r *http.Request)
+	)
+
+	BeforeEach(func() {
+		captured = handler.RequestMetadata{}
+		nextHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			w.Header().Set("Content-Type", "application/json")
+			_, err = w.Write([]byte(`{"echo":` + string(body) + `}`))
+			Expect(err).ToNot(HaveOccurred())
+		})
+		config = &handler.BodyCaptureConfig{
+			MaxBytes: 1024,
+			Enabled:  func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/debug") },
+		}
+		recorder = httptest.NewRecorder()
+		serve = func(r *http.Request) {
+			rh, err := handler.NewRequestsHandlerWithOptions(nextHandler,
+				handler.WithEndFunc(func(w http.ResponseWriter, r *http.Request, metadata handler.RequestMetadata) {
+					captured = metadata
+				}),
+				handler.WithBodyCapture(config),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			rh.ServeHTTP(recorder, r)
+		}
+	})
+
+	It("should capture request and response bodies on enabled routes", func() {
+		request := httptest.NewRequest("POST", "/debug/echo", strings.NewReader(`"hello"`))
+		request.Header.Set("Content-Type", "application/json")
+		serve(request)
+
+		Expect(recorder.Body.String()).To(Equal(`{"echo":"hello"}`))
+		Expect(captured.RequestBody).ToNot(BeNil())
+		Expect(captured.RequestBody.String()).To(Equal(`"hello"`))
+		Expect(captured.RequestBody.ContentType).To(Equal("application/json"))
+		Expect(captured.ResponseBody).ToNot(BeNil())
+		Expect(captured.ResponseBody.String()).To(Equal(`{"echo":"hello"}`))
+		Expect(captured.ResponseBody.Size).To(BeNumerically("==", 16))
+	})
+
+	It("should not capture other routes", func() {
+		serve(httptest.NewRequest("POST", "/echo", strings.NewReader(`"hello"`)))
+		Expect(captured.RequestBody).To(BeNil())
+		Expect(captured.ResponseBody).To(BeNil())
+	})
+
+	It("should truncate bodies beyond the limit", func() {
+		config.MaxBytes = 4
+		serve(httptest.NewRequest("POST", "/debug", strings.NewReader(`"hello"`)))
+		Expect(captured.ResponseBody.Truncated).To(BeTrue())
+		Expect(captured.ResponseBody.String()).To(Equal(`{"ec...[truncated 12 bytes]`))
+		Expect(recorder.Body.String()).To(Equal(`{"echo":"hello"}`))
+	})
+
+	It("should only capture allowed content types", func() {
+		config.ContentTypes = []string{"text/"}
+		request := httptest.NewRequest("POST", "/debug", strings.NewReader(`"hello"`))
+		request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		serve(request)
+		Expect(captured.RequestBody).ToNot(BeNil())
+		Expect(captured.ResponseBody).To(BeNil())
+	})
+
+	It("should flag binary bodies without keeping their data", func() {
+		request := httptest.NewRequest("POST", "/debug", strings.NewReader("\x00\x01\x02"))
+		serve(request)
+		Expect(captured.RequestBody.Binary).To(BeTrue())
+		Expect(captured.RequestBody.Data).To(BeNil())
+		Expect(captured.RequestBody.Size).To(BeNumerically("==", 3))
+	})
+
+	It("should apply the redaction hook", func() {
+		config.Redact = handler.NewDefaultRedactor().Body
+		request := httptest.NewRequest("POST", "/debug", strings.NewReader("user=bob&password=hunter2"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		serve(request)
+		Expect(captured.RequestBody.String()).To(Equal("user=bob&password=[REDACTED]"))
+	})
+
+	When("a debug header is configured", func() {
+		BeforeEach(func() {
+			config.Enabled = nil
+			config.DebugHeader = "X-Debug-Capture"
+			config.DebugToken = "s3cret"
+		})
+
+		It("should capture requests that carry the token", func() {
+			request := httptest.NewRequest("POST", "/echo", strings.NewReader(`1`))
+			request.Header.Set("X-Debug-Capture", "s3cret")
+			serve(request)
+			Expect(captured.RequestBody).ToNot(BeNil())
+		})
+
+		It("should ignore requests with the wrong token", func() {
+			request := httptest.NewRequest("POST", "/echo", strings.NewReader(`1`))
+			request.Header.Set("X-Debug-Capture", "guess")
+			serve(request)
+			Expect(captured.RequestBody).To(BeNil())
+		})
+	})
+
+	It("should keep the ResponseWriter flushable", func() {
+		nextHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, ok := w.(http.Flusher)
+			Expect(ok).To(BeTrue())
+			w.(http.Flusher).Flush()
+		})
+		serve(httptest.NewRequest("GET", "/debug", nil))
+		Expect(recorder.Flushed).To(BeTrue())
+	})
+})
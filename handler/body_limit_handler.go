@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBodyTooLarge = errors.New("handler: request body too large")
+	ErrBodyTooSlow  = errors.New("handler: request body arriving too slowly")
+	// ErrBodyLengthMismatch is returned when a body carries more bytes than its Content-Length declared.
+	ErrBodyLengthMismatch = errors.New("handler: request body longer than its Content-Length")
+)
+
+// BodyLimitFunc picks the maximum body size for a request, which lets upload routes allow more than the rest of the
+// application. Returning zero or less lifts the limit.
+type BodyLimitFunc func(r *http.Request) int64
+
+// BodyLimitHandler bounds request bodies. The limit is enforced on the bytes actually read rather than the declared
+// Content-Length, so it holds for chunked bodies too, although a Content-Length over the limit is rejected before
+// Next runs. Reads past the limit, or reads that fall below MinBytesPerSecond once GracePeriod has passed, fail with
+// ErrBodyTooLarge or ErrBodyTooSlow and the Responder replaces whatever Next tries to respond with.
+//
+// Throughput is checked as each read returns, which a client that stops sending altogether never lets happen. To cut
+// those off too, set the server's ConnContext to ConnContext: BodyLimitHandler then sets a read deadline on HTTP/1
+// connections while the body is read. It takes the connection's read deadline over, so Server.ReadTimeout no longer
+// bounds the body.
+type BodyLimitHandler struct {
+	MaxBytes          int64
+	LimitFunc         BodyLimitFunc
+	MinBytesPerSecond int64
+	GracePeriod       time.Duration
+	Responder         Responder
+	Next              http.Handler
+}
+
+type BodyLimitHandlerOption func(*BodyLimitHandler) error
+
+func NewBodyLimitHandler(next http.Handler, maxBytes int64, opts ...BodyLimitHandlerOption) (BodyLimitHandler, error) {
+	if next == nil {
+		return BodyLimitHandler{}, ErrNilNext
+	}
+	if maxBytes <= 0 {
+		return BodyLimitHandler{}, errors.New("handler: body limit must be positive")
+	}
+	bh := BodyLimitHandler{MaxBytes: maxBytes, Responder: NegotiatedResponder, Next: next}
+	for _, opt := range opts {
+		if err := opt(&bh); err != nil {
+			return BodyLimitHandler{}, err
+		}
+	}
+	return bh, nil
+}
+
+// WithBodyLimitFunc overrides the handler's limit per request.
+func WithBodyLimitFunc(limitFunc BodyLimitFunc) BodyLimitHandlerOption {
+	return func(bh *BodyLimitHandler) error {
+		if limitFunc == nil {
+			return errors.New("handler: body limit func must not be nil")
+		}
+		bh.LimitFunc = limitFunc
+		return nil
+	}
+}
+
+// WithMinThroughput fails body reads that average fewer than bytesPerSecond once gracePeriod has passed, which stops
+// slowloris style uploads from tying up a handler.
+func WithMinThroughput(bytesPerSecond int64, gracePeriod time.Duration) BodyLimitHandlerOption {
+	return func(bh *BodyLimitHandler) error {
+		if bytesPerSecond <= 0 {
+			return errors.New("handler: minimum throughput must be positive")
+		}
+		if gracePeriod < 0 {
+			return errors.New("handler: grace period must not be negative")
+		}
+		bh.MinBytesPerSecond = bytesPerSecond
+		bh.GracePeriod = gracePeriod
+		return nil
+	}
+}
+
+func WithBodyLimitResponder(responder Responder) BodyLimitHandlerOption {
+	return func(bh *BodyLimitHandler) error {
+		if responder == nil {
+			return errors.New("handler: responder must not be nil")
+		}
+		bh.Responder = responder
+		return nil
+	}
+}
+
+func (bh BodyLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody {
+		bh.Next.ServeHTTP(w, r)
+		return
+	}
+	limit := bh.MaxBytes
+	if bh.LimitFunc != nil {
+		limit = bh.LimitFunc(r)
+	}
+	state := &bodyLimitState{}
+	defer func() {
+		read, err := state.result()
+		UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+			metadata.BodyBytesRead = read
+			metadata.BodyLimitExceeded = err == ErrBodyTooLarge
+			metadata.BodyTooSlow = err == ErrBodyTooSlow
+		})
+	}()
+
+	if limit > 0 && r.ContentLength > limit {
+		state.fail(ErrBodyTooLarge)
+		bh.respond(w, r, ErrBodyTooLarge, limit)
+		return
+	}
+
+	br := &limitedBody{
+		body:     r.Body,
+		limit:    limit,
+		declared: r.ContentLength,
+		minRate:  bh.MinBytesPerSecond,
+		grace:    bh.GracePeriod,
+		start:    time.Now(),
+		conn:     deadlineConn(r),
+		state:    state,
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Body = br
+	bw := &bodyLimitWriter{ResponseWriter: w, request: r, state: state, handler: bh, limit: limit}
+	bh.Next.ServeHTTP(bw, r2)
+	if !bw.wroteHeader {
+		if _, err := state.result(); err != nil {
+			bh.respond(w, r, err, limit)
+		}
+	}
+}
+
+func (bh BodyLimitHandler) respond(w http.ResponseWriter, r *http.Request, err error, limit int64) {
+	responder := bh.Responder
+	if responder == nil {
+		responder = NegotiatedResponder
+	}
+	// the rest of the body is never going to be read, so the connection cannot be reused
+	w.Header().Set("Connection", "close")
+	switch err {
+	case ErrBodyTooLarge:
+		responder(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit))
+	case ErrBodyTooSlow:
+		responder(w, r, http.StatusRequestTimeout, "request body arrived too slowly")
+	default:
+		responder(w, r, http.StatusBadRequest, "request body does not match its Content-Length")
+	}
+}
+
+type bodyLimitState struct {
+	mu   sync.Mutex
+	read int64
+	err  error
+}
+
+func (s *bodyLimitState) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.read += int64(n)
+}
+
+func (s *bodyLimitState) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *bodyLimitState) result() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read, s.err
+}
+
+type connCtxKey struct{}
+
+// ConnContext is an http.Server ConnContext that lets BodyLimitHandler set read deadlines on the connection.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connCtxKey{}, c)
+}
+
+// deadlineConn is the connection a request arrived on, if ConnContext recorded it and it carries only this request.
+func deadlineConn(r *http.Request) net.Conn {
+	if r.ProtoMajor != 1 {
+		// HTTP/2 multiplexes requests over the connection, so its deadline is not this request's to set
+		return nil
+	}
+	c, _ := r.Context().Value(connCtxKey{}).(net.Conn)
+	return c
+}
+
+type limitedBody struct {
+	body     io.ReadCloser
+	limit    int64
+	declared int64
+	minRate  int64
+	grace    time.Duration
+	start    time.Time
+	conn     net.Conn
+	read     int64
+	err      error
+	state    *bodyLimitState
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.err != nil {
+		return 0, lb.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// read one byte more than allowed so that overruns are noticed rather than looking like EOF
+	if max := lb.maxRead(); max >= 0 && int64(len(p)) > max+1 {
+		p = p[:max+1]
+	}
+
+	n, err := lb.readWithDeadline(p)
+	if max := lb.maxRead(); max >= 0 && int64(n) > max {
+		n, err = int(max), lb.overrunError()
+	}
+	lb.read += int64(n)
+	lb.state.add(n)
+	if err == ErrBodyTooLarge || err == ErrBodyTooSlow || err == ErrBodyLengthMismatch {
+		lb.err = err
+		lb.state.fail(err)
+	}
+	return n, err
+}
+
+// overrunError explains a read past maxRead. A Content-Length over the limit is rejected up front, so when one was
+// declared it is the bound that was crossed.
+func (lb *limitedBody) overrunError() error {
+	if lb.declared >= 0 {
+		return ErrBodyLengthMismatch
+	}
+	return ErrBodyTooLarge
+}
+
+// maxRead is how many more bytes may be read, or -1 when neither a limit nor a Content-Length applies.
+func (lb *limitedBody) maxRead() int64 {
+	max := int64(-1)
+	if lb.limit > 0 {
+		max = lb.limit - lb.read
+	}
+	if lb.declared >= 0 && (max < 0 || lb.declared-lb.read < max) {
+		max = lb.declared - lb.read
+	}
+	return max
+}
+
+// readWithDeadline fails reads that return after the body has fallen below the minimum throughput, and when it has
+// the connection, sets a read deadline so that they return by then.
+func (lb *limitedBody) readWithDeadline(p []byte) (int, error) {
+	if lb.minRate <= 0 {
+		return lb.body.Read(p)
+	}
+	due := lb.start.Add(lb.grace + time.Duration(float64(lb.read+1)/float64(lb.minRate)*float64(time.Second)))
+	if lb.conn != nil {
+		_ = lb.conn.SetReadDeadline(due)
+		defer func() { _ = lb.conn.SetReadDeadline(time.Time{}) }()
+	}
+	n, err := lb.body.Read(p)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return n, ErrBodyTooSlow
+	}
+	if err == nil && time.Now().After(due) {
+		return n, ErrBodyTooSlow
+	}
+	return n, err
+}
+
+func (lb *limitedBody) Close() error {
+	return lb.body.Close()
+}
+
+type bodyLimitWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	state       *bodyLimitState
+	handler     BodyLimitHandler
+	limit       int64
+	wroteHeader bool
+	replaced    error
+}
+
+func (bw *bodyLimitWriter) WriteHeader(statusCode int) {
+	if bw.wroteHeader {
+		return
+	}
+	bw.wroteHeader = true
+	if _, err := bw.state.result(); err != nil {
+		bw.replaced = err
+		bw.handler.respond(bw.ResponseWriter, bw.request, err, bw.limit)
+		return
+	}
+	bw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (bw *bodyLimitWriter) Write(b []byte) (int, error) {
+	if !bw.wroteHeader {
+		bw.WriteHeader(http.StatusOK)
+	}
+	if bw.replaced != nil {
+		return 0, bw.replaced
+	}
+	return bw.ResponseWriter.Write(b)
+}
+
+func (bw *bodyLimitWriter) Flush() {
+	if !bw.wroteHeader {
+		bw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := bw.ResponseWriter.(http.Flusher); ok && bw.replaced == nil {
+		f.Flush()
+	}
+}
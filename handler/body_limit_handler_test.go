@@ -0,0 +1,206 @@
+package handler_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+type trickleReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (tr *trickleReader) Read(p []byte) (int, error) {
+	if len(tr.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(tr.delay)
+	p[0] = tr.data[0]
+	tr.data = tr.data[1:]
+	return 1, nil
+}
+
+var _ = Describe("BodyLimitHandler", func() {
+	var (
+		recorder *httptest.ResponseRecorder
+		metadata handler.RequestMetadata
+		readErr  error
+		echo     http.Handler
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		metadata = handler.RequestMetadata{}
+		readErr = nil
+		echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body []byte
+			body, readErr = ioutil.ReadAll(r.Body)
+			if readErr != nil {
+				http.Error(w, readErr.Error(), http.StatusBadRequest)
+				return
+			}
+			_, err := w.Write(body)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	serve := func(bh http.Handler, r *http.Request) {
+		rh, err := handler.NewRequestsHandlerWithOptions(bh, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, m handler.RequestMetadata) {
+				metadata = m
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		rh.ServeHTTP(recorder, r)
+	}
+
+	It("should pass bodies within the limit through", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 10)
+		Expect(err).ToNot(HaveOccurred())
+		serve(bh, httptest.NewRequest("POST", "/", strings.NewReader("0123456789")))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("0123456789"))
+		Expect(metadata.BodyBytesRead).To(Equal(int64(10)))
+		Expect(metadata.BodyLimitExceeded).To(BeFalse())
+	})
+
+	It("should reject a declared Content-Length over the limit without calling next", func() {
+		bh, err := handler.NewBodyLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Fail("next should not be called")
+		}), 5)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("POST", "/", strings.NewReader("0123456789"))
+		request.Header.Set("Accept", "application/json")
+		serve(bh, request)
+
+		Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(recorder.Header().Get("Connection")).To(Equal("close"))
+		var body handler.ErrorResponse
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(Succeed())
+		Expect(body.Message).To(Equal("request body exceeds 5 bytes"))
+		Expect(metadata.BodyLimitExceeded).To(BeTrue())
+		Expect(metadata.Status).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("should enforce the limit on chunked bodies and replace next's response", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 5)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader("0123456789")))
+		request.ContentLength = -1
+		serve(bh, request)
+
+		Expect(readErr).To(Equal(handler.ErrBodyTooLarge))
+		Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(metadata.BodyBytesRead).To(Equal(int64(5)))
+		Expect(metadata.BodyLimitExceeded).To(BeTrue())
+	})
+
+	It("should accept chunked bodies of exactly the limit", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 10)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader("0123456789")))
+		request.ContentLength = -1
+		serve(bh, request)
+		Expect(readErr).ToNot(HaveOccurred())
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not trust an understated Content-Length", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 100)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("POST", "/", bytes.NewReader([]byte("0123456789")))
+		request.ContentLength = 4
+		serve(bh, request)
+
+		Expect(readErr).To(Equal(handler.ErrBodyLengthMismatch))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(metadata.BodyBytesRead).To(Equal(int64(4)))
+		Expect(metadata.BodyLimitExceeded).To(BeFalse())
+	})
+
+	It("should apply per-route limits", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 5, handler.WithBodyLimitFunc(func(r *http.Request) int64 {
+			if r.URL.Path == "/upload" {
+				return 0
+			}
+			return 5
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		serve(bh, httptest.NewRequest("POST", "/upload", strings.NewReader("0123456789")))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(metadata.BodyBytesRead).To(Equal(int64(10)))
+	})
+
+	It("should cut off bodies that arrive too slowly", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 100, handler.WithMinThroughput(1000, 0))
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("POST", "/", &trickleReader{[]byte("0123456789"), 20 * time.Millisecond})
+		request.ContentLength = -1
+		serve(bh, request)
+
+		Expect(readErr).To(Equal(handler.ErrBodyTooSlow))
+		Expect(recorder.Code).To(Equal(http.StatusRequestTimeout))
+		Expect(metadata.BodyTooSlow).To(BeTrue())
+	})
+
+	It("should cut off clients that stop sending when it has their connection", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 100, handler.WithMinThroughput(1000, 50*time.Millisecond))
+		Expect(err).ToNot(HaveOccurred())
+		server := httptest.NewUnstartedServer(bh)
+		server.Config.ConnContext = handler.ConnContext
+		server.Start()
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 10\r\n\r\n01")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusRequestTimeout))
+		Expect(response.Close).To(BeTrue())
+	})
+
+	It("should allow slow starts within the grace period", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 100, handler.WithMinThroughput(1000, time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("POST", "/", &trickleReader{[]byte("0123"), time.Millisecond})
+		serve(bh, request)
+		Expect(readErr).ToNot(HaveOccurred())
+		Expect(recorder.Body.String()).To(Equal("0123"))
+	})
+
+	It("should leave requests without bodies alone", func() {
+		bh, err := handler.NewBodyLimitHandler(echo, 5)
+		Expect(err).ToNot(HaveOccurred())
+		serve(bh, httptest.NewRequest("GET", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(metadata.BodyBytesRead).To(BeZero())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewBodyLimitHandler(nil, 5)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewBodyLimitHandler(echo, 0)
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewBodyLimitHandler(echo, 5, handler.WithMinThroughput(0, time.Second))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewBodyLimitHandler(echo, 5, handler.WithBodyLimitFunc(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewBodyLimitHandler(echo, 5, handler.WithBodyLimitResponder(nil))
+		Expect(err).To(HaveOccurred())
+	})
+})
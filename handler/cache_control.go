@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheableStatuses are the statuses a shared cache may store given explicit freshness.
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// cacheControl maps the lower-cased directives of a Cache-Control header to their unquoted values.
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds reads a delta-seconds directive, reporting false when it is missing or malformed.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	arg, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cachePolicy is how long a response may be served from the cache.
+type cachePolicy struct {
+	lifetime             time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	mustRevalidate       bool
+}
+
+// responseCachePolicy works out whether a shared cache may store the response to r, and for how long. Only responses
+// with explicit freshness are stored; there is no heuristic caching.
+func responseCachePolicy(r *http.Request, status int, header http.Header, now time.Time) (cachePolicy, bool) {
+	cc := parseCacheControl(header)
+	if !cacheableStatuses[status] || !storable(r, cc, header) {
+		return cachePolicy{}, false
+	}
+	lifetime, ok := freshnessLifetime(cc, header, now)
+	if !ok || lifetime <= 0 {
+		return cachePolicy{}, false
+	}
+	policy := cachePolicy{
+		lifetime: lifetime,
+		// s-maxage implies proxy-revalidate
+		mustRevalidate: cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage"),
+	}
+	policy.staleWhileRevalidate, _ = cc.seconds("stale-while-revalidate")
+	policy.staleIfError, _ = cc.seconds("stale-if-error")
+	return policy, true
+}
+
+func storable(r *http.Request, cc cacheControl, header http.Header) bool {
+	if cc.has("no-store") || cc.has("private") || cc.has("no-cache") {
+		return false
+	}
+	// responses setting cookies are almost always meant for one client
+	if len(header["Set-Cookie"]) > 0 || containsFold(varyNames(header), "*") {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		return cc.has("public") || cc.has("s-maxage") || cc.has("must-revalidate")
+	}
+	return true
+}
+
+func freshnessLifetime(cc cacheControl, header http.Header, now time.Time) (time.Duration, bool) {
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime, true
+	}
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime, true
+	}
+	expires, err := http.ParseTime(header.Get("Expires"))
+	if err != nil {
+		// an invalid Expires, such as 0, means already expired
+		return 0, header.Get("Expires") != ""
+	}
+	if date, dateErr := http.ParseTime(header.Get("Date")); dateErr == nil {
+		now = date
+	}
+	return expires.Sub(now), true
+}
+
+// varyNames lists the canonical names of the request headers a response varies on.
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, vary := range header["Vary"] {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,377 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheHeader tells clients whether a CacheHandler answered from the cache, with CacheHit, CacheMiss or CacheStale.
+const CacheHeader = "X-Cache"
+
+const (
+	CacheHit   = "HIT"
+	CacheMiss  = "MISS"
+	CacheStale = "STALE"
+)
+
+// CacheKeyFunc names the resource a request is for. Requests with the same key share cached responses, subject to
+// Vary.
+type CacheKeyFunc func(r *http.Request) string
+
+// DefaultCacheKey keys responses by host and request URI.
+func DefaultCacheKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+// CacheHandler is a shared cache for the responses Next gives to GET requests, which it also uses to answer HEAD
+// requests. It stores responses whose Cache-Control s-maxage or max-age, or Expires, gives them a freshness lifetime,
+// unless they are no-store, no-cache or private, set cookies, vary on everything, or answer requests with an
+// Authorization header without being marked public. Responses vary on the request headers named in their Vary.
+//
+// Once a response goes stale it can still be served for its stale-while-revalidate while a fresh one is fetched in
+// the background, and for its stale-if-error in place of a 5xx, unless it is must-revalidate or has an s-maxage.
+// Concurrent misses for the same key wait for one request to Next and share its response. Request Cache-Control
+// directives are ignored, so clients cannot force misses.
+//
+// Responses are kept in an LRU bounded by size. Unsafe requests, such as POST, evict the responses stored under
+// their key. Requests to upgrade the connection, such as WebSocket handshakes, go straight to Next.
+type CacheHandler struct {
+	MaxEntryBytes int64
+	KeyFunc       CacheKeyFunc
+	Next          http.Handler
+	cache         *responseCache
+	clock         Clock
+	mu            sync.Mutex
+	flights       map[string]*cacheFlight
+}
+
+// cacheFlight is a request to Next that concurrent misses wait on.
+type cacheFlight struct {
+	done     chan struct{}
+	response *cachedResponse
+	status   string
+}
+
+type CacheHandlerOption func(*CacheHandler) error
+
+// NewCacheHandler keeps up to 64MiB of responses of up to 1MiB each unless told otherwise.
+func NewCacheHandler(next http.Handler, opts ...CacheHandlerOption) (*CacheHandler, error) {
+	if next == nil {
+		return nil, ErrNilNext
+	}
+	ch := &CacheHandler{
+		MaxEntryBytes: 1 << 20,
+		KeyFunc:       DefaultCacheKey,
+		Next:          next,
+		cache:         newResponseCache(64 << 20),
+		clock:         time.Now,
+		flights:       make(map[string]*cacheFlight),
+	}
+	for _, opt := range opts {
+		if err := opt(ch); err != nil {
+			return nil, err
+		}
+	}
+	return ch, nil
+}
+
+// WithCacheSize bounds the size of the cache and of each response in it. Headers count towards both.
+func WithCacheSize(maxBytes, maxEntryBytes int64) CacheHandlerOption {
+	return func(ch *CacheHandler) error {
+		if maxEntryBytes <= 0 || maxBytes < maxEntryBytes {
+			return errors.New("handler: cache sizes must be positive, and the cache no smaller than an entry")
+		}
+		ch.cache = newResponseCache(maxBytes)
+		ch.MaxEntryBytes = maxEntryBytes
+		return nil
+	}
+}
+
+func WithCacheKey(keyFunc CacheKeyFunc) CacheHandlerOption {
+	return func(ch *CacheHandler) error {
+		if keyFunc == nil {
+			return errors.New("handler: cache key func must not be nil")
+		}
+		ch.KeyFunc = keyFunc
+		return nil
+	}
+}
+
+func WithCacheClock(clock Clock) CacheHandlerOption {
+	return func(ch *CacheHandler) error {
+		if clock == nil {
+			return errors.New("handler: clock must not be nil")
+		}
+		ch.clock = clock
+		return nil
+	}
+}
+
+// Len is how many entries the cache holds. Responses that vary take an extra entry per key.
+func (ch *CacheHandler) Len() int {
+	return ch.cache.len()
+}
+
+func (ch *CacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isUpgrade(r) {
+		ch.Next.ServeHTTP(w, r)
+		return
+	}
+	key := ch.KeyFunc(r)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		ch.lookup(w, r, key)
+	case http.MethodOptions, http.MethodTrace:
+		ch.Next.ServeHTTP(w, r)
+	default:
+		ch.Next.ServeHTTP(w, r)
+		ch.cache.remove(key)
+	}
+}
+
+func (ch *CacheHandler) lookup(w http.ResponseWriter, r *http.Request, key string) {
+	now := ch.now()
+	cr := ch.cache.get(key, r)
+	switch {
+	case cr == nil || !cr.usable(now):
+		ch.fetch(w, r, key, nil)
+	case cr.fresh(now):
+		ch.serve(w, r, cr, CacheHit)
+	case cr.staleWithin(now, cr.policy.staleWhileRevalidate):
+		ch.serve(w, r, cr, CacheStale)
+		ch.revalidate(r, key)
+	default:
+		ch.fetch(w, r, key, cr)
+	}
+}
+
+// fetch answers a miss from Next, or from the response of a concurrent miss for the same key. A stale response is
+// served instead should Next fail.
+func (ch *CacheHandler) fetch(w http.ResponseWriter, r *http.Request, key string, stale *cachedResponse) {
+	flightKey := r.Method + " " + key
+	flight, leader := ch.join(flightKey)
+	if !leader {
+		ch.follow(w, r, key, flight)
+		return
+	}
+	defer ch.land(flightKey, flight)
+	if stale == nil {
+		flight.response, flight.status = ch.record(w, r, key), CacheHit
+		return
+	}
+	setCacheStatus(w, r, CacheMiss)
+	bw := newBufferedWriter(w, ch.MaxEntryBytes)
+	ch.Next.ServeHTTP(bw, r)
+	switch {
+	case bw.status >= http.StatusInternalServerError:
+		flight.response, flight.status = stale, CacheStale
+		ch.serve(w, r, stale, CacheStale)
+	case !bw.overflowed:
+		flight.response, flight.status = ch.store(r, key, bw.status, bw.header, bw.body.Bytes()), CacheHit
+		bw.writeTo(w)
+	}
+}
+
+// follow waits for a concurrent miss and serves its response, provided it is for the same variant, or goes to Next
+// itself.
+func (ch *CacheHandler) follow(w http.ResponseWriter, r *http.Request, key string, flight *cacheFlight) {
+	select {
+	case <-flight.done:
+	case <-r.Context().Done():
+		return
+	}
+	if cr := flight.response; cr != nil && varyKey(cr.varyNames, r) == cr.varyKey {
+		ch.serve(w, r, cr, flight.status)
+		return
+	}
+	ch.record(w, r, key)
+}
+
+// record passes the response from Next through, storing it if it can be cached.
+func (ch *CacheHandler) record(w http.ResponseWriter, r *http.Request, key string) *cachedResponse {
+	setCacheStatus(w, r, CacheMiss)
+	rw := newRecordingWriter(w, ch.MaxEntryBytes)
+	ch.Next.ServeHTTP(rw, r)
+	rw.finish()
+	if rw.overflowed {
+		return nil
+	}
+	return ch.store(r, key, rw.status, rw.sent, rw.body.Bytes())
+}
+
+// revalidate fetches a fresh response for key in the background, unless that is already happening.
+func (ch *CacheHandler) revalidate(r *http.Request, key string) {
+	flightKey := http.MethodGet + " " + key
+	flight, leader := ch.join(flightKey)
+	if !leader {
+		return
+	}
+	req := r.Clone(context.Background())
+	req.Method = http.MethodGet
+	req.Body = http.NoBody
+	go func() {
+		defer ch.land(flightKey, flight)
+		// there is no one left to answer, so a panic only loses the refresh
+		defer func() { _ = recover() }()
+		bw := newBufferedWriter(nil, ch.MaxEntryBytes)
+		ch.Next.ServeHTTP(bw, req)
+		if bw.status < http.StatusInternalServerError && !bw.overflowed {
+			flight.response, flight.status = ch.store(req, key, bw.status, bw.header, bw.body.Bytes()), CacheHit
+		}
+	}()
+}
+
+// store caches the response to r if it may be, returning what it stored.
+func (ch *CacheHandler) store(r *http.Request, key string, status int, header http.Header,
+	body []byte) *cachedResponse {
+
+	if r.Method != http.MethodGet {
+		return nil
+	}
+	now := ch.now()
+	policy, ok := responseCachePolicy(r, status, header, now)
+	if !ok {
+		return nil
+	}
+	header = header.Clone()
+	header.Del(CacheHeader)
+	size := responseSize(header, body)
+	if size > ch.MaxEntryBytes {
+		return nil
+	}
+	names := varyNames(header)
+	cr := &cachedResponse{
+		status:    status,
+		header:    header,
+		body:      body,
+		varyNames: names,
+		varyKey:   varyKey(names, r),
+		stored:    now,
+		policy:    policy,
+		byteSize:  size,
+	}
+	ch.cache.put(key, names, cr)
+	return cr
+}
+
+func (ch *CacheHandler) serve(w http.ResponseWriter, r *http.Request, cr *cachedResponse, status string) {
+	h := w.Header()
+	mergeHeader(h, cr.header, false)
+	h.Set("Age", strconv.FormatInt(int64(cr.age(ch.now())/time.Second), 10))
+	setCacheStatus(w, r, status)
+	w.WriteHeader(cr.status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(cr.body)
+	}
+}
+
+func (ch *CacheHandler) join(flightKey string) (*cacheFlight, bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.flights == nil {
+		ch.flights = make(map[string]*cacheFlight)
+	}
+	if flight, ok := ch.flights[flightKey]; ok {
+		return flight, false
+	}
+	flight := &cacheFlight{done: make(chan struct{})}
+	ch.flights[flightKey] = flight
+	return flight, true
+}
+
+func (ch *CacheHandler) land(flightKey string, flight *cacheFlight) {
+	ch.mu.Lock()
+	delete(ch.flights, flightKey)
+	ch.mu.Unlock()
+	close(flight.done)
+}
+
+func (ch *CacheHandler) now() time.Time {
+	if ch.clock == nil {
+		return time.Now()
+	}
+	return ch.clock()
+}
+
+func setCacheStatus(w http.ResponseWriter, r *http.Request, status string) {
+	w.Header().Set(CacheHeader, status)
+	UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+		metadata.Cache = status
+	})
+}
+
+// isUpgrade reports whether r asks to switch protocols, which a cache has no business in.
+func isUpgrade(r *http.Request) bool {
+	for _, value := range r.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds a response back so that it can be inspected before it is sent, if at all. Once the body grows
+// past maxBytes it gives up holding it: the rest of a successful response is sent on to w, if there is one, as it
+// comes, and anything else is dropped.
+type bufferedWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	maxBytes    int64
+	overflowed  bool
+	wroteHeader bool
+}
+
+func newBufferedWriter(w http.ResponseWriter, maxBytes int64) *bufferedWriter {
+	return &bufferedWriter{w: w, header: make(http.Header), status: http.StatusOK, maxBytes: maxBytes}
+}
+
+func (bw *bufferedWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferedWriter) WriteHeader(statusCode int) {
+	if bw.wroteHeader || statusCode < http.StatusOK {
+		return
+	}
+	bw.wroteHeader = true
+	bw.status = statusCode
+}
+
+func (bw *bufferedWriter) Write(p []byte) (int, error) {
+	bw.WriteHeader(http.StatusOK)
+	if !bw.overflowed && int64(bw.body.Len()+len(p)) > bw.maxBytes {
+		bw.overflowed = true
+		if bw.passesThrough() {
+			bw.writeTo(bw.w)
+		}
+		bw.body = bytes.Buffer{}
+	}
+	switch {
+	case !bw.overflowed:
+		return bw.body.Write(p)
+	case bw.passesThrough():
+		return bw.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// passesThrough reports whether a response too large to hold goes on to w rather than being dropped.
+func (bw *bufferedWriter) passesThrough() bool {
+	return bw.w != nil && bw.status < http.StatusInternalServerError
+}
+
+func (bw *bufferedWriter) writeTo(w http.ResponseWriter) {
+	mergeHeader(w.Header(), bw.header, true)
+	w.WriteHeader(bw.status)
+	_, _ = w.Write(bw.body.Bytes())
+}
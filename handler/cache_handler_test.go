@@ -0,0 +1,351 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("CacheHandler", func() {
+	var (
+		mu           sync.Mutex
+		now          time.Time
+		calls        int
+		status       int
+		cacheControl string
+		header       http.Header
+		next         http.Handler
+		ch           *handler.CacheHandler
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1571000000, 0)
+		calls = 0
+		status = http.StatusOK
+		cacheControl = "max-age=60"
+		header = http.Header{}
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			calls++
+			body := "response " + strconv.Itoa(calls)
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Cache-Control", cacheControl)
+			code := status
+			mu.Unlock()
+			w.WriteHeader(code)
+			_, _ = w.Write([]byte(body))
+		})
+		var err error
+		ch, err = handler.NewCacheHandler(next, handler.WithCacheClock(func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+
+	callCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+
+	It("should answer repeat requests from the cache while the response is fresh", func() {
+		first := serveRequest(ch, "GET", "/things")
+		Expect(first.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheMiss))
+		Expect(first.Body.String()).To(Equal("response 1"))
+
+		advance(10 * time.Second)
+		hit := serveRequest(ch, "GET", "/things")
+		Expect(hit.Code).To(Equal(http.StatusOK))
+		Expect(hit.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheHit))
+		Expect(hit.Header().Get("Age")).To(Equal("10"))
+		Expect(hit.Header().Get("Cache-Control")).To(Equal("max-age=60"))
+		Expect(hit.Body.String()).To(Equal("response 1"))
+
+		advance(50 * time.Second)
+		Expect(serveRequest(ch, "GET", "/things").Body.String()).To(Equal("response 2"))
+		Expect(calls).To(Equal(2))
+	})
+
+	It("should keep the headers handlers further out set for each request", func() {
+		header.Set("Vary", "Accept-Encoding")
+		outer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Set-Cookie", "session="+r.Header.Get("X-Session"))
+			w.Header().Set("Vary", "Origin")
+			ch.ServeHTTP(w, r)
+		})
+		first := serveRequest(outer, "GET", "/things", "X-Session", "first")
+		Expect(first.Header().Get("Set-Cookie")).To(Equal("session=first"))
+		Expect(first.Header()["Vary"]).To(Equal([]string{"Origin", "Accept-Encoding"}))
+
+		hit := serveRequest(outer, "GET", "/things", "X-Session", "second")
+		Expect(hit.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheHit))
+		Expect(hit.Header().Get("Set-Cookie")).To(Equal("session=second"))
+		Expect(hit.Header()["Vary"]).To(Equal([]string{"Origin", "Accept-Encoding"}))
+		Expect(hit.Header().Get("Cache-Control")).To(Equal("max-age=60"))
+	})
+
+	It("should answer HEAD requests from cached GET responses", func() {
+		serveRequest(ch, "HEAD", "/things")
+		serveRequest(ch, "GET", "/things")
+		head := serveRequest(ch, "HEAD", "/things")
+		Expect(head.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheHit))
+		Expect(head.Body.String()).To(BeEmpty())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("should record the outcome in the request metadata", func() {
+		var outcomes []string
+		rh, err := handler.NewRequestsHandlerWithOptions(ch, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, metadata handler.RequestMetadata) {
+				outcomes = append(outcomes, metadata.Cache)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		serveRequest(rh, "GET", "/things")
+		serveRequest(rh, "GET", "/things")
+		serveRequest(rh, "POST", "/things")
+		Expect(outcomes).To(Equal([]string{handler.CacheMiss, handler.CacheHit, ""}))
+	})
+
+	for _, tc := range []struct {
+		description  string
+		cacheControl string
+		status       int
+		header       []string
+	}{
+		{"no-store responses", "no-store, max-age=60", http.StatusOK, nil},
+		{"no-cache responses", "no-cache, max-age=60", http.StatusOK, nil},
+		{"private responses", "private, max-age=60", http.StatusOK, nil},
+		{"responses without explicit freshness", "", http.StatusOK, nil},
+		{"responses that are already stale", "max-age=0", http.StatusOK, nil},
+		{"server errors", "max-age=60", http.StatusInternalServerError, nil},
+		{"responses setting cookies", "max-age=60", http.StatusOK, []string{"Set-Cookie", "session=1"}},
+		{"responses that vary on everything", "max-age=60", http.StatusOK, []string{"Vary", "*"}},
+		{"responses with an invalid Expires", "", http.StatusOK, []string{"Expires", "0"}},
+	} {
+		tc := tc
+		It("should not store "+tc.description, func() {
+			cacheControl, status = tc.cacheControl, tc.status
+			if tc.header != nil {
+				header.Set(tc.header[0], tc.header[1])
+			}
+			serveRequest(ch, "GET", "/things")
+			Expect(serveRequest(ch, "GET", "/things").Header().Get(handler.CacheHeader)).To(Equal(handler.CacheMiss))
+			Expect(calls).To(Equal(2))
+		})
+	}
+
+	It("should only store responses to authorized requests that are marked public", func() {
+		serveRequest(ch, "GET", "/things", "Authorization", "Bearer t")
+		serveRequest(ch, "GET", "/things", "Authorization", "Bearer t")
+		Expect(calls).To(Equal(2))
+
+		cacheControl = "public, max-age=60"
+		serveRequest(ch, "GET", "/things", "Authorization", "Bearer t")
+		Expect(serveRequest(ch, "GET", "/things", "Authorization", "Bearer t").Header().Get(handler.CacheHeader)).
+			To(Equal(handler.CacheHit))
+	})
+
+	It("should prefer s-maxage to max-age", func() {
+		cacheControl = "max-age=600, s-maxage=5"
+		serveRequest(ch, "GET", "/things")
+		advance(5 * time.Second)
+		Expect(serveRequest(ch, "GET", "/things").Header().Get(handler.CacheHeader)).To(Equal(handler.CacheMiss))
+	})
+
+	It("should honour Expires relative to Date", func() {
+		cacheControl = ""
+		header.Set("Date", now.Add(-time.Hour).Format(http.TimeFormat))
+		header.Set("Expires", now.Add(-time.Hour+30*time.Second).Format(http.TimeFormat))
+		serveRequest(ch, "GET", "/things")
+		advance(29 * time.Second)
+		Expect(serveRequest(ch, "GET", "/things").Header().Get(handler.CacheHeader)).To(Equal(handler.CacheHit))
+		advance(time.Second)
+		Expect(serveRequest(ch, "GET", "/things").Header().Get(handler.CacheHeader)).To(Equal(handler.CacheMiss))
+	})
+
+	It("should keep a variant for each value of the headers a response varies on", func() {
+		header.Set("Vary", "accept-encoding")
+		Expect(serveRequest(ch, "GET", "/things", "Accept-Encoding", "gzip").Body.String()).To(Equal("response 1"))
+		Expect(serveRequest(ch, "GET", "/things", "Accept-Encoding", "br").Body.String()).To(Equal("response 2"))
+		Expect(serveRequest(ch, "GET", "/things", "Accept-Encoding", "gzip").Body.String()).To(Equal("response 1"))
+		Expect(serveRequest(ch, "GET", "/things", "Accept-Encoding", "br").Body.String()).To(Equal("response 2"))
+		Expect(serveRequest(ch, "GET", "/things").Body.String()).To(Equal("response 3"))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("should serve stale responses while revalidating in the background", func() {
+		cacheControl = "max-age=60, stale-while-revalidate=30"
+		serveRequest(ch, "GET", "/things")
+		advance(70 * time.Second)
+		stale := serveRequest(ch, "GET", "/things")
+		Expect(stale.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheStale))
+		Expect(stale.Body.String()).To(Equal("response 1"))
+
+		Eventually(func() string { return serveRequest(ch, "GET", "/things").Body.String() }).Should(Equal("response 2"))
+		Expect(callCount()).To(Equal(2))
+		Expect(serveRequest(ch, "GET", "/things").Header().Get(handler.CacheHeader)).To(Equal(handler.CacheHit))
+	})
+
+	It("should serve stale responses in place of server errors", func() {
+		cacheControl = "max-age=60, stale-if-error=30"
+		serveRequest(ch, "GET", "/things")
+		advance(70 * time.Second)
+		status = http.StatusBadGateway
+		stale := serveRequest(ch, "GET", "/things")
+		Expect(stale.Code).To(Equal(http.StatusOK))
+		Expect(stale.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheStale))
+		Expect(stale.Body.String()).To(Equal("response 1"))
+
+		advance(30 * time.Second)
+		Expect(serveRequest(ch, "GET", "/things").Code).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should replace stale responses when revalidation succeeds", func() {
+		cacheControl = "max-age=60, stale-if-error=30"
+		serveRequest(ch, "GET", "/things")
+		advance(70 * time.Second)
+		fresh := serveRequest(ch, "GET", "/things")
+		Expect(fresh.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheMiss))
+		Expect(fresh.Body.String()).To(Equal("response 2"))
+		Expect(serveRequest(ch, "GET", "/things").Body.String()).To(Equal("response 2"))
+	})
+
+	It("should not serve stale responses that must be revalidated", func() {
+		cacheControl = "max-age=60, must-revalidate, stale-while-revalidate=30, stale-if-error=30"
+		serveRequest(ch, "GET", "/things")
+		advance(70 * time.Second)
+		status = http.StatusBadGateway
+		Expect(serveRequest(ch, "GET", "/things").Code).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should collapse concurrent misses into one request", func() {
+		started, release := make(chan struct{}), make(chan struct{})
+		var once sync.Once
+		blocking, err := handler.NewCacheHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			once.Do(func() { close(started) })
+			<-release
+			next.ServeHTTP(w, r)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		responses := make([]*httptest.ResponseRecorder, 5)
+		var wg sync.WaitGroup
+		for i := range responses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				responses[i] = serveRequest(blocking, "GET", "/things")
+			}(i)
+			if i == 0 {
+				<-started
+			}
+		}
+		// give the others time to join the first
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		Expect(callCount()).To(Equal(1))
+		for _, response := range responses {
+			Expect(response.Body.String()).To(Equal("response 1"))
+		}
+	})
+
+	It("should evict responses to unsafe requests", func() {
+		serveRequest(ch, "GET", "/things")
+		serveRequest(ch, "OPTIONS", "/things")
+		Expect(serveRequest(ch, "GET", "/things").Header().Get(handler.CacheHeader)).To(Equal(handler.CacheHit))
+		serveRequest(ch, "DELETE", "/things")
+		Expect(serveRequest(ch, "GET", "/things").Header().Get(handler.CacheHeader)).To(Equal(handler.CacheMiss))
+	})
+
+	It("should evict every variant of responses to unsafe requests", func() {
+		header.Set("Vary", "accept-encoding")
+		serveRequest(ch, "GET", "/things", "Accept-Encoding", "gzip")
+		serveRequest(ch, "GET", "/things", "Accept-Encoding", "br")
+		Expect(ch.Len()).To(Equal(3))
+		serveRequest(ch, "DELETE", "/things")
+		Expect(ch.Len()).To(BeZero())
+		Expect(serveRequest(ch, "GET", "/things", "Accept-Encoding", "br").Header().Get(handler.CacheHeader)).
+			To(Equal(handler.CacheMiss))
+	})
+
+	It("should pass requests to upgrade the connection straight through", func() {
+		serveRequest(ch, "GET", "/things", "Connection", "keep-alive, Upgrade", "Upgrade", "websocket")
+		upgrade := serveRequest(ch, "GET", "/things", "Connection", "keep-alive, Upgrade", "Upgrade", "websocket")
+		Expect(upgrade.Body.String()).To(Equal("response 2"))
+		Expect(upgrade.Header().Get(handler.CacheHeader)).To(BeEmpty())
+		Expect(ch.Len()).To(BeZero())
+	})
+
+	It("should not hold back more than an entry's worth of a response when it has a stale one", func() {
+		body := "small"
+		big, err := handler.NewCacheHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60, stale-if-error=30")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+			_, _ = w.Write([]byte(body))
+		}), handler.WithCacheSize(1000, 100), handler.WithCacheClock(func() time.Time { return now }))
+		Expect(err).ToNot(HaveOccurred())
+		serveRequest(big, "GET", "/things")
+		advance(70 * time.Second)
+
+		body = strings.Repeat("x", 60)
+		status = http.StatusBadGateway
+		stale := serveRequest(big, "GET", "/things")
+		Expect(stale.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheStale))
+		Expect(stale.Body.String()).To(Equal("smallsmall"))
+
+		status = http.StatusOK
+		passed := serveRequest(big, "GET", "/things")
+		Expect(passed.Header().Get(handler.CacheHeader)).To(Equal(handler.CacheMiss))
+		Expect(passed.Header().Get("Cache-Control")).To(Equal("max-age=60, stale-if-error=30"))
+		Expect(passed.Body.String()).To(Equal(body + body))
+		Expect(serveRequest(big, "GET", "/things").Header().Get(handler.CacheHeader)).To(Equal(handler.CacheMiss))
+	})
+
+	It("should keep within its size limits", func() {
+		small, err := handler.NewCacheHandler(next, handler.WithCacheSize(70, 50),
+			handler.WithCacheKey(func(r *http.Request) string { return r.URL.Query().Get("id") }))
+		Expect(err).ToNot(HaveOccurred())
+		for _, id := range []string{"1", "2", "3", "4"} {
+			small.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/things?id="+id, nil))
+		}
+		Expect(small.Len()).To(Equal(2))
+
+		header.Set("X-Padding", strings.Repeat("x", 50))
+		small.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/things?id=5", nil))
+		Expect(small.Len()).To(Equal(2))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewCacheHandler(nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		for _, opt := range []handler.CacheHandlerOption{
+			handler.WithCacheSize(100, 0),
+			handler.WithCacheSize(10, 100),
+			handler.WithCacheKey(nil),
+			handler.WithCacheClock(nil),
+		} {
+			_, err = handler.CacheMiddleware(opt)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes middleware so that the first one added is the outermost.
+type Chain struct {
+	middlewares []Middleware
+}
+
+func NewChain(middlewares ...Middleware) Chain {
+	return Chain{middlewares: append([]Middleware(nil), middlewares...)}
+}
+
+// Use adds middlewares to the end of the chain in place.
+func (c *Chain) Use(middlewares ...Middleware) {
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// Append returns a new chain with middlewares added to the end, leaving c untouched. Use it for per-route additions
+// to a shared base chain.
+func (c Chain) Append(middlewares ...Middleware) Chain {
+	combined := make([]Middleware, 0, len(c.middlewares)+len(middlewares))
+	combined = append(combined, c.middlewares...)
+	return Chain{middlewares: append(combined, middlewares...)}
+}
+
+// Extend returns a new chain with other's middlewares added to the end of c's.
+func (c Chain) Extend(other Chain) Chain {
+	return c.Append(other.middlewares...)
+}
+
+// Then wraps h in the chain. A nil h means http.DefaultServeMux.
+func (c Chain) Then(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i](h)
+	}
+	return h
+}
+
+func (c Chain) ThenFunc(fn http.HandlerFunc) http.Handler {
+	if fn == nil {
+		return c.Then(nil)
+	}
+	return c.Then(fn)
+}
+
+// NewMiddleware turns a handler constructor into a Middleware. It calls build once up front, around a placeholder
+// handler, so that a misconfigured chain fails where it is built rather than when it is first wrapped around a
+// handler. The middleware variants below are all made this way. The Middleware panics if build fails once options
+// have been validated, which means it was passed a nil handler.
+func NewMiddleware(build func(next http.Handler) (http.Handler, error)) (Middleware, error) {
+	if _, err := build(http.NotFoundHandler()); err != nil {
+		return nil, err
+	}
+	return func(next http.Handler) http.Handler {
+		h, err := build(next)
+		if err != nil {
+			panic(err)
+		}
+		return h
+	}, nil
+}
+
+func RequestIDMiddleware(opts ...RequestIDHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewRequestIDHandler(next, opts...)
+	})
+}
+
+func UUIDRequestIDMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return NewUUIDRequestIDHandler(next)
+	}
+}
+
+func RequestsMiddleware(opts ...RequestsHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewRequestsHandlerWithOptions(next, opts...)
+	})
+}
+
+func RecoveryMiddleware(opts ...RecoveryHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewRecoveryHandler(next, opts...)
+	})
+}
+
+func BodyLimitMiddleware(maxBytes int64, opts ...BodyLimitHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewBodyLimitHandler(next, maxBytes, opts...)
+	})
+}
+
+// RateLimitMiddleware gives each handler it wraps its own store unless one is passed with WithRateLimitStore.
+func RateLimitMiddleware(algorithm RateLimitAlgorithm, opts ...RateLimitHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewRateLimitHandler(next, algorithm, opts...)
+	})
+}
+
+// LoadShedMiddleware gives each handler it wraps its own limiter from newLimiter, and so its own limit.
+func LoadShedMiddleware(newLimiter func() ConcurrencyLimiter, opts ...LoadShedHandlerOption) (Middleware, error) {
+	if newLimiter == nil {
+		return nil, errors.New("handler: limiter constructor must not be nil")
+	}
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewLoadShedHandler(next, newLimiter(), opts...)
+	})
+}
+
+func CompressMiddleware(opts ...CompressHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewCompressHandler(next, opts...)
+	})
+}
+
+func CORSMiddleware(opts ...CORSHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewCORSHandler(next, opts...)
+	})
+}
+
+func SecurityHeadersMiddleware(opts ...SecurityHeadersHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewSecurityHeadersHandler(next, opts...)
+	})
+}
+
+func WebhookSignatureMiddleware(scheme WebhookScheme, secret []byte,
+	opts ...WebhookSignatureHandlerOption) (Middleware, error) {
+
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewWebhookSignatureHandler(next, scheme, secret, opts...)
+	})
+}
+
+// IdempotencyMiddleware gives each handler it wraps its own store unless one is passed with WithIdempotencyStore.
+func IdempotencyMiddleware(opts ...IdempotencyHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewIdempotencyHandler(next, opts...)
+	})
+}
+
+// CacheMiddleware gives each handler it wraps its own cache.
+func CacheMiddleware(opts ...CacheHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewCacheHandler(next, opts...)
+	})
+}
+
+func ETagMiddleware(opts ...ETagHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewETagHandler(next, opts...)
+	})
+}
+
+// RecordMiddleware records the requests of every handler it wraps to the same writer.
+func RecordMiddleware(writer HARWriter, opts ...RecordHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewRecordHandler(next, writer, opts...)
+	})
+}
+
+func TimeoutMiddleware(timeout time.Duration, opts ...TimeoutHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewTimeoutHandler(next, timeout, opts...)
+	})
+}
+
+func SlowRequestMiddleware(threshold time.Duration, opts ...SlowRequestHandlerOption) (Middleware, error) {
+	return NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewSlowRequestHandler(next, threshold, opts...)
+	})
+}
@@ -0,0 +1,112 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("Chain", func() {
+	var (
+		calls       []string
+		tracing     func(name string) handler.Middleware
+		nextHandler http.Handler
+		serve       func(h http.Handler)
+	)
+
+	BeforeEach(func() {
+		calls = nil
+		tracing = func(name string) handler.Middleware {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls = append(calls, name)
+					next.ServeHTTP(w, r)
+				})
+			}
+		}
+		nextHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "handler")
+		})
+		serve = func(h http.Handler) {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+	})
+
+	It("should run middlewares in the order they were added", func() {
+		chain := handler.NewChain(tracing("a"))
+		chain.Use(tracing("b"), tracing("c"))
+		serve(chain.Then(nextHandler))
+		Expect(calls).To(Equal([]string{"a", "b", "c", "handler"}))
+	})
+
+	It("should not modify the base chain when appending", func() {
+		base := handler.NewChain(tracing("a"))
+		route := base.Append(tracing("b"))
+		serve(base.Then(nextHandler))
+		Expect(calls).To(Equal([]string{"a", "handler"}))
+
+		calls = nil
+		serve(route.Then(nextHandler))
+		Expect(calls).To(Equal([]string{"a", "b", "handler"}))
+	})
+
+	It("should extend a chain with another chain", func() {
+		chain := handler.NewChain(tracing("a")).Extend(handler.NewChain(tracing("b"), tracing("c")))
+		serve(chain.Then(nextHandler))
+		Expect(calls).To(Equal([]string{"a", "b", "c", "handler"}))
+	})
+
+	It("should accept plain handler funcs", func() {
+		chain := handler.NewChain(tracing("a"))
+		serve(chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "func")
+		}))
+		Expect(calls).To(Equal([]string{"a", "func"}))
+	})
+
+	It("should fail to build middleware from invalid options", func() {
+		_, err := handler.RequestsMiddleware(handler.WithClock(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.TimeoutMiddleware(0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should panic rather than return a broken handler when wrapped around nil", func() {
+		middleware, err := handler.RequestsMiddleware()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(func() { middleware(nil) }).To(Panic())
+	})
+
+	It("should compose the handlers in this package", func() {
+		var loggedID string
+		var status int
+		requests, err := handler.RequestsMiddleware(
+			handler.WithEndFunc(func(w http.ResponseWriter, r *http.Request, metadata handler.RequestMetadata) {
+				loggedID = r.Header.Get(handler.RequestIDHeader)
+				status = metadata.Status
+			}),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		recovery, err := handler.RecoveryMiddleware(handler.WithRecoveryFunc(
+			func(w http.ResponseWriter, r *http.Request, panicMessage interface{}, stackTrace []handler.Stack) {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, writeErr := fmt.Fprint(w, panicMessage)
+				Expect(writeErr).ToNot(HaveOccurred())
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		chain := handler.NewChain(handler.UUIDRequestIDMiddleware(), requests, recovery)
+		recorder := httptest.NewRecorder()
+		chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(recorder.Body.String()).To(Equal("boom"))
+		Expect(loggedID).ToNot(BeEmpty())
+		Expect(status).To(Equal(http.StatusInternalServerError))
+	})
+})
@@ -0,0 +1,357 @@
+package handler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultSkippedContentTypes are media types that are already compressed. Entries ending in "/" match every subtype,
+// except that +xml types such as image/svg+xml are always compressed.
+var DefaultSkippedContentTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+}
+
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var compressorPools = map[string]*sync.Pool{
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	"gzip": {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+	"deflate": {New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}},
+}
+
+// CompressHandler compresses responses with whichever of Encodings the client's Accept-Encoding prefers, breaking
+// ties in the order Encodings lists them. Responses are buffered until MinSize bytes have been written, so that small
+// ones can go out uncompressed, unless the handler flushes first, in which case it is assumed to be streaming and is
+// compressed straight away.
+type CompressHandler struct {
+	Encodings           []string
+	MinSize             int
+	SkippedContentTypes []string
+	Next                http.Handler
+}
+
+type CompressHandlerOption func(*CompressHandler) error
+
+func NewCompressHandler(next http.Handler, opts ...CompressHandlerOption) (CompressHandler, error) {
+	if next == nil {
+		return CompressHandler{}, ErrNilNext
+	}
+	ch := CompressHandler{
+		Encodings:           []string{"br", "gzip", "deflate"},
+		MinSize:             1024,
+		SkippedContentTypes: DefaultSkippedContentTypes,
+		Next:                next,
+	}
+	for _, opt := range opts {
+		if err := opt(&ch); err != nil {
+			return CompressHandler{}, err
+		}
+	}
+	return ch, nil
+}
+
+// WithEncodings picks from br, gzip and deflate, in order of preference.
+func WithEncodings(encodings ...string) CompressHandlerOption {
+	return func(ch *CompressHandler) error {
+		if len(encodings) == 0 {
+			return errors.New("handler: at least one encoding is required")
+		}
+		for _, encoding := range encodings {
+			if _, ok := compressorPools[encoding]; !ok {
+				return errors.New("handler: unsupported encoding " + encoding)
+			}
+		}
+		ch.Encodings = encodings
+		return nil
+	}
+}
+
+func WithMinSize(minSize int) CompressHandlerOption {
+	return func(ch *CompressHandler) error {
+		if minSize < 0 {
+			return errors.New("handler: minimum size must not be negative")
+		}
+		ch.MinSize = minSize
+		return nil
+	}
+}
+
+func WithSkippedContentTypes(contentTypes ...string) CompressHandlerOption {
+	return func(ch *CompressHandler) error {
+		ch.SkippedContentTypes = contentTypes
+		return nil
+	}
+}
+
+func (ch CompressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	addVary(w.Header(), "Accept-Encoding")
+	encoding := ch.negotiate(r.Header.Get("Accept-Encoding"))
+	// a HEAD response has no body to compress
+	if encoding == "" || r.Method == http.MethodHead {
+		ch.Next.ServeHTTP(w, r)
+		return
+	}
+	cw := &compressWriter{ResponseWriter: w, handler: ch, encoding: encoding}
+	defer func() {
+		if p := recover(); p != nil {
+			// leave the response uncommitted so that a RecoveryHandler further out can still send its 500
+			cw.release()
+			panic(p)
+		}
+		cw.close()
+		if cw.compressed {
+			UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+				metadata.UncompressedBytes = cw.written
+			})
+		}
+	}()
+	ch.Next.ServeHTTP(cw, r)
+}
+
+func (ch CompressHandler) negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	accepted := parseQualityList(acceptEncoding)
+	best, bestQuality := "", 0.0
+	for _, encoding := range ch.Encodings {
+		quality := encodingQuality(accepted, encoding)
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+func encodingQuality(accepted []qualityValue, encoding string) float64 {
+	wildcard := 0.0
+	for _, value := range accepted {
+		switch value.value {
+		case encoding:
+			return value.quality
+		case "*":
+			wildcard = value.quality
+		}
+	}
+	return wildcard
+}
+
+func (ch CompressHandler) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	for _, skipped := range ch.SkippedContentTypes {
+		if mediaType == skipped || (strings.HasSuffix(skipped, "/") && strings.HasPrefix(mediaType, skipped)) {
+			return false
+		}
+	}
+	return true
+}
+
+func addVary(header http.Header, value string) {
+	for _, vary := range header["Vary"] {
+		for _, v := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	handler     CompressHandler
+	encoding    string
+	statusCode  int
+	wroteHeader bool
+	decided     bool
+	compressed  bool
+	hijacked    bool
+	buf         []byte
+	compressor  compressor
+	written     int64
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	if statusCode < http.StatusOK {
+		// informational responses such as 103 Early Hints go straight through
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	cw.wroteHeader = true
+	cw.statusCode = statusCode
+	if !compressibleStatus(statusCode) {
+		_, _ = cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.written += int64(len(p))
+	if cw.decided {
+		return cw.write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) == 0 || len(cw.buf) < cw.handler.MinSize {
+		return len(p), nil
+	}
+	if _, err := cw.decide(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.compressor != nil {
+		return cw.compressor.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide writes the header, compressed or not, and whatever has been buffered so far.
+func (cw *compressWriter) decide(streaming bool) (int, error) {
+	cw.decided = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if cw.shouldCompress(streaming) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// the compressed bytes are not the representation the strong validator was computed from
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.compressed = true
+		cw.compressor = compressorPools[cw.encoding].Get().(compressor)
+		cw.compressor.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	return cw.write(buf)
+}
+
+func (cw *compressWriter) shouldCompress(streaming bool) bool {
+	h := cw.Header()
+	if !compressibleStatus(cw.statusCode) || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if !cw.handler.compressible(h.Get("Content-Type")) {
+		return false
+	}
+	if streaming {
+		return true
+	}
+	if len(cw.buf) == 0 {
+		// the response is over without a body, which compressing would only add to
+		return false
+	}
+	if len(cw.buf) >= cw.handler.MinSize {
+		return true
+	}
+	contentLength, err := strconv.Atoi(h.Get("Content-Length"))
+	return err == nil && contentLength >= cw.handler.MinSize
+}
+
+// compressibleStatus rules out responses without bodies and partial content, whose ranges refer to the uncompressed
+// representation.
+func compressibleStatus(statusCode int) bool {
+	return statusCode != http.StatusNoContent &&
+		statusCode != http.StatusNotModified &&
+		statusCode != http.StatusPartialContent
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		_, _ = cw.decide(true)
+	}
+	if cw.compressor != nil {
+		_ = cw.compressor.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("handler: ResponseWriter does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (cw *compressWriter) close() {
+	if cw.hijacked || !cw.wroteHeader {
+		return
+	}
+	if !cw.decided {
+		_, _ = cw.decide(false)
+	}
+	if cw.compressor != nil {
+		_ = cw.compressor.Close()
+	}
+	cw.release()
+}
+
+func (cw *compressWriter) release() {
+	if cw.compressor != nil {
+		cw.compressor.Reset(nil)
+		compressorPools[cw.encoding].Put(cw.compressor)
+		cw.compressor = nil
+	}
+}
@@ -0,0 +1,269 @@
+package handler_test
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (hr *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hr.hijacked = true
+	return nil, nil, nil
+}
+
+func decompress(encoding string, body io.Reader) string {
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		Expect(err).ToNot(HaveOccurred())
+		reader = gz
+	case "deflate":
+		reader = flate.NewReader(body)
+	case "br":
+		reader = brotli.NewReader(body)
+	default:
+		reader = body
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	Expect(err).ToNot(HaveOccurred())
+	return string(decompressed)
+}
+
+var _ = Describe("CompressHandler", func() {
+	var (
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+		payload  string
+		text     http.Handler
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("GET", "/", nil)
+		payload = strings.Repeat("compress me please ", 100)
+		text = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Content-Length", "1900")
+			_, err := io.WriteString(w, payload)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("negotiating Accept-Encoding", func() {
+		for _, tc := range []struct {
+			description, acceptEncoding, expected string
+		}{
+			{"should prefer the server's order on ties", "gzip, deflate, br", "br"},
+			{"should honour q-values", "br;q=0.5, gzip", "gzip"},
+			{"should support deflate", "deflate", "deflate"},
+			{"should treat * as any encoding", "*", "br"},
+			{"should skip refused encodings", "br;q=0, *;q=0.1", "gzip"},
+			{"should leave identity alone", "identity", ""},
+			{"should ignore unknown encodings", "compress", ""},
+		} {
+			tc := tc
+			It(tc.description, func() {
+				ch, err := handler.NewCompressHandler(text)
+				Expect(err).ToNot(HaveOccurred())
+				request.Header.Set("Accept-Encoding", tc.acceptEncoding)
+				ch.ServeHTTP(recorder, request)
+
+				Expect(recorder.Header().Get("Content-Encoding")).To(Equal(tc.expected))
+				Expect(recorder.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+				Expect(decompress(tc.expected, recorder.Body)).To(Equal(payload))
+				if tc.expected != "" {
+					Expect(recorder.Header().Get("Content-Length")).To(BeEmpty())
+				}
+			})
+		}
+	})
+
+	It("should not compress small bodies", func() {
+		ch, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, writeErr := io.WriteString(w, "<p>tiny</p>")
+			Expect(writeErr).ToNot(HaveOccurred())
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", "gzip")
+		ch.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+		Expect(recorder.Body.String()).To(Equal("<p>tiny</p>"))
+	})
+
+	It("should not compress empty bodies or HEAD responses, whatever the minimum size", func() {
+		empty, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, writeErr := w.Write(nil)
+			Expect(writeErr).ToNot(HaveOccurred())
+		}), handler.WithMinSize(0))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", "gzip")
+		empty.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+		Expect(recorder.Body.Len()).To(BeZero())
+
+		recorder = httptest.NewRecorder()
+		head, err := handler.NewCompressHandler(text, handler.WithMinSize(0))
+		Expect(err).ToNot(HaveOccurred())
+		request = httptest.NewRequest("HEAD", "/", nil)
+		request.Header.Set("Accept-Encoding", "gzip")
+		head.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+		Expect(recorder.Header().Get("Content-Length")).To(Equal("1900"))
+		Expect(recorder.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+	})
+
+	It("should skip content types that are already compressed", func() {
+		ch, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, writeErr := io.WriteString(w, payload)
+			Expect(writeErr).ToNot(HaveOccurred())
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", "gzip")
+		ch.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+		Expect(recorder.Body.String()).To(Equal(payload))
+	})
+
+	It("should leave responses that are already encoded, partial or empty alone", func() {
+		for _, status := range []int{http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent} {
+			recorder = httptest.NewRecorder()
+			ch, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(status)
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			request.Header.Set("Accept-Encoding", "gzip")
+			ch.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(status))
+			Expect(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+		}
+
+		recorder = httptest.NewRecorder()
+		ch, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, writeErr := io.WriteString(w, payload)
+			Expect(writeErr).ToNot(HaveOccurred())
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		ch.ServeHTTP(recorder, request)
+		Expect(recorder.Body.String()).To(Equal(payload))
+	})
+
+	It("should weaken strong ETags on compressed responses", func() {
+		ch, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			text.ServeHTTP(w, r)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", "gzip")
+		ch.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("ETag")).To(Equal(`W/"v1"`))
+	})
+
+	It("should compress streamed responses as they are flushed", func() {
+		ch, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, writeErr := io.WriteString(w, "data: 1\n\n")
+			Expect(writeErr).ToNot(HaveOccurred())
+			w.(http.Flusher).Flush()
+			Expect(recorder.Flushed).To(BeTrue())
+			Expect(recorder.Header().Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(recorder.Body.Len()).To(BeNumerically(">", 0))
+			_, writeErr = io.WriteString(w, "data: 2\n\n")
+			Expect(writeErr).ToNot(HaveOccurred())
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", "gzip")
+		ch.ServeHTTP(recorder, request)
+		Expect(decompress("gzip", recorder.Body)).To(Equal("data: 1\n\ndata: 2\n\n"))
+	})
+
+	It("should pass hijacking through", func() {
+		hijackable := &hijackableRecorder{ResponseRecorder: recorder}
+		ch, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _, hijackErr := w.(http.Hijacker).Hijack()
+			Expect(hijackErr).ToNot(HaveOccurred())
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", "gzip")
+		ch.ServeHTTP(hijackable, request)
+		Expect(hijackable.hijacked).To(BeTrue())
+	})
+
+	It("should report compressed and uncompressed sizes to RequestMetadata", func() {
+		ch, err := handler.NewCompressHandler(text)
+		Expect(err).ToNot(HaveOccurred())
+		var metadata handler.RequestMetadata
+		rh, err := handler.NewRequestsHandlerWithOptions(ch, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, m handler.RequestMetadata) {
+				metadata = m
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", "gzip")
+		rh.ServeHTTP(recorder, request)
+
+		Expect(metadata.UncompressedBytes).To(Equal(int64(len(payload))))
+		Expect(metadata.BytesWritten).To(Equal(int64(recorder.Body.Len())))
+		Expect(metadata.BytesWritten).To(BeNumerically("<", metadata.UncompressedBytes))
+
+		png, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, payload)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		rh.Next = png
+		rh.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(metadata.BytesWritten).To(Equal(int64(len(payload))))
+		Expect(metadata.UncompressedBytes).To(BeZero())
+	})
+
+	It("should leave the response uncommitted when the handler panics", func() {
+		ch, err := handler.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, writeErr := io.WriteString(w, "partial")
+			Expect(writeErr).ToNot(HaveOccurred())
+			panic("boom")
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		rh, err := handler.NewRecoveryHandler(ch, handler.WithRecoveryFunc(
+			func(w http.ResponseWriter, r *http.Request, panicMessage interface{}, _ []handler.Stack) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", "gzip")
+		rh.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(recorder.Body.String()).To(BeEmpty())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewCompressHandler(nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewCompressHandler(text, handler.WithEncodings("compress"))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewCompressHandler(text, handler.WithEncodings())
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewCompressHandler(text, handler.WithMinSize(-1))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimiter decides how many requests a LoadShedHandler lets run at once. Observe is called with the
+// metadata of every request it let through, along with how many requests were in flight when it finished, which is
+// what lets adaptive limiters follow the latency the service is actually delivering.
+type ConcurrencyLimiter interface {
+	Limit() int
+	Observe(metadata RequestMetadata, inFlight int)
+}
+
+// FixedLimit never changes.
+type FixedLimit int
+
+func (fl FixedLimit) Limit() int {
+	return int(fl)
+}
+
+func (fl FixedLimit) Observe(RequestMetadata, int) {}
+
+// overloaded treats requests that took longer than threshold, timed out or were turned away downstream as a sign that
+// the limit is too high.
+func overloaded(metadata RequestMetadata, threshold time.Duration) bool {
+	return metadata.ExecutionTime > threshold ||
+		metadata.TimedOut ||
+		metadata.DeadlineExceeded ||
+		metadata.Status == http.StatusServiceUnavailable ||
+		metadata.Status == http.StatusGatewayTimeout
+}
+
+// AIMDLimit grows the limit by one while requests are fast and the limit is being used, and cuts it by BackoffRatio
+// whenever a request is slower than LatencyThreshold or otherwise overloaded.
+type AIMDLimit struct {
+	MinLimit         int
+	MaxLimit         int
+	LatencyThreshold time.Duration
+	BackoffRatio     float64
+	mu               sync.Mutex
+	limit            float64
+}
+
+func NewAIMDLimit(initial, minLimit, maxLimit int, latencyThreshold time.Duration) (*AIMDLimit, error) {
+	if err := validateLimits(initial, minLimit, maxLimit); err != nil {
+		return nil, err
+	}
+	if latencyThreshold <= 0 {
+		return nil, errors.New("handler: latency threshold must be positive")
+	}
+	return &AIMDLimit{
+		MinLimit:         minLimit,
+		MaxLimit:         maxLimit,
+		LatencyThreshold: latencyThreshold,
+		BackoffRatio:     0.9,
+		limit:            float64(initial),
+	}, nil
+}
+
+func (al *AIMDLimit) Limit() int {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return int(al.limit)
+}
+
+func (al *AIMDLimit) Observe(metadata RequestMetadata, inFlight int) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	switch {
+	case overloaded(metadata, al.LatencyThreshold):
+		al.limit = math.Max(float64(al.MinLimit), math.Floor(al.limit*al.BackoffRatio))
+	case float64(inFlight*2) >= al.limit:
+		// only grow a limit that is actually being used, or idle periods would push it to MaxLimit
+		al.limit = math.Min(float64(al.MaxLimit), al.limit+1)
+	}
+}
+
+// GradientLimit adjusts the limit by the ratio between the long term average latency and the latest one, so that it
+// shrinks as queueing pushes latency up and grows back as it recovers, in the manner of Netflix's Gradient2 limit.
+type GradientLimit struct {
+	MinLimit int
+	MaxLimit int
+	// Tolerance is how much worse than the long term average latency may get before the limit shrinks.
+	Tolerance float64
+	// Smoothing weighs each new limit against the current one.
+	Smoothing float64
+	// LongWindow is the number of samples the long term average latency spans.
+	LongWindow int
+	mu         sync.Mutex
+	limit      float64
+	longRTT    float64
+}
+
+func NewGradientLimit(initial, minLimit, maxLimit int) (*GradientLimit, error) {
+	if err := validateLimits(initial, minLimit, maxLimit); err != nil {
+		return nil, err
+	}
+	return &GradientLimit{
+		MinLimit:   minLimit,
+		MaxLimit:   maxLimit,
+		Tolerance:  1.5,
+		Smoothing:  0.2,
+		LongWindow: 600,
+		limit:      float64(initial),
+	}, nil
+}
+
+func (gl *GradientLimit) Limit() int {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	return int(gl.limit)
+}
+
+func (gl *GradientLimit) Observe(metadata RequestMetadata, inFlight int) {
+	rtt := float64(metadata.ExecutionTime)
+	if rtt <= 0 {
+		return
+	}
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if gl.longRTT == 0 {
+		gl.longRTT = rtt
+	} else {
+		gl.longRTT += (rtt - gl.longRTT) / float64(gl.LongWindow)
+	}
+	if gl.longRTT/rtt > 2 {
+		// latency has recovered well below the average, so let the average catch up quickly
+		gl.longRTT *= 0.95
+	}
+	if float64(inFlight) < gl.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, gl.Tolerance*gl.longRTT/rtt))
+	target := gl.limit*gradient + math.Sqrt(gl.limit)
+	limit := gl.limit*(1-gl.Smoothing) + target*gl.Smoothing
+	gl.limit = math.Max(float64(gl.MinLimit), math.Min(float64(gl.MaxLimit), limit))
+}
+
+func validateLimits(initial, minLimit, maxLimit int) error {
+	if minLimit <= 0 || minLimit > initial || initial > maxLimit {
+		return errors.New("handler: limits must satisfy 0 < min <= initial <= max")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package handler_test
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("concurrency limiters", func() {
+	fast := handler.RequestMetadata{ExecutionTime: 10 * time.Millisecond, Status: http.StatusOK}
+	slow := handler.RequestMetadata{ExecutionTime: time.Second, Status: http.StatusOK}
+
+	Describe("AIMDLimit", func() {
+		It("should grow while busy and fast and back off when slow", func() {
+			limit, err := handler.NewAIMDLimit(10, 5, 12, 100*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+
+			limit.Observe(fast, 2)
+			Expect(limit.Limit()).To(Equal(10))
+			limit.Observe(fast, 5)
+			Expect(limit.Limit()).To(Equal(11))
+			limit.Observe(fast, 10)
+			limit.Observe(fast, 10)
+			Expect(limit.Limit()).To(Equal(12))
+
+			limit.Observe(slow, 10)
+			Expect(limit.Limit()).To(Equal(10))
+			limit.Observe(handler.RequestMetadata{Status: http.StatusServiceUnavailable}, 10)
+			limit.Observe(handler.RequestMetadata{TimedOut: true}, 10)
+			Expect(limit.Limit()).To(Equal(8))
+			for i := 0; i < 10; i++ {
+				limit.Observe(slow, 10)
+			}
+			Expect(limit.Limit()).To(Equal(5))
+		})
+	})
+
+	Describe("GradientLimit", func() {
+		It("should shrink when latency rises above the long term average and recover after", func() {
+			limit, err := handler.NewGradientLimit(20, 2, 40)
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 50; i++ {
+				limit.Observe(fast, 20)
+			}
+			Expect(limit.Limit()).To(Equal(40))
+
+			for i := 0; i < 10; i++ {
+				limit.Observe(slow, 40)
+			}
+			shrunk := limit.Limit()
+			Expect(shrunk).To(BeNumerically("<", 40))
+
+			for i := 0; i < 10; i++ {
+				limit.Observe(fast, 40)
+			}
+			Expect(limit.Limit()).To(BeNumerically(">", shrunk))
+		})
+
+		It("should ignore samples while the limit is mostly unused", func() {
+			limit, err := handler.NewGradientLimit(20, 2, 40)
+			Expect(err).ToNot(HaveOccurred())
+			limit.Observe(fast, 1)
+			Expect(limit.Limit()).To(Equal(20))
+		})
+	})
+
+	It("should validate limits", func() {
+		_, err := handler.NewAIMDLimit(1, 2, 3, time.Second)
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewAIMDLimit(2, 1, 3, 0)
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewGradientLimit(4, 1, 3)
+		Expect(err).To(HaveOccurred())
+		Expect(handler.FixedLimit(3).Limit()).To(Equal(3))
+	})
+})
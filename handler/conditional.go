@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Validators are what conditional requests are checked against: the current ETag and Last-Modified of a resource,
+// either of which may be missing, and whether it exists at all.
+type Validators struct {
+	ETag         string
+	LastModified time.Time
+	Exists       bool
+}
+
+func validatorsFrom(status int, header http.Header) Validators {
+	lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+	return Validators{
+		ETag:         header.Get("ETag"),
+		LastModified: lastModified,
+		Exists:       status >= http.StatusOK && status < http.StatusMultipleChoices,
+	}
+}
+
+// notModified reports whether a GET or HEAD request's If-None-Match, or failing that its If-Modified-Since, shows the
+// client already has the representation described by v.
+func notModified(r *http.Request, v Validators) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, v, false)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !v.LastModified.IsZero() && !v.LastModified.After(ims)
+}
+
+// preconditionFailed reports whether an unsafe request's If-Match, If-Unmodified-Since or If-None-Match rule out
+// changing the resource described by v.
+func preconditionFailed(r *http.Request, v Validators) bool {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagListMatches(im, v, true) {
+			return true
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil {
+		if !v.Exists || v.LastModified.IsZero() || v.LastModified.After(ius) {
+			return true
+		}
+	}
+	inm := r.Header.Get("If-None-Match")
+	return inm != "" && etagListMatches(inm, v, false)
+}
+
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != "" ||
+		r.Header.Get("If-None-Match") != ""
+}
+
+// etagListMatches compares the entity tags in an If-Match or If-None-Match list to v's. Weak tags never match under
+// strong comparison.
+func etagListMatches(list string, v Validators, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return v.Exists
+	}
+	if v.ETag == "" || (strong && isWeakETag(v.ETag)) {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && isWeakETag(candidate) {
+			continue
+		}
+		if opaqueTag(candidate) == opaqueTag(v.ETag) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
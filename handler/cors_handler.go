@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSRejectedFunc is called for preflight requests the CORSHandler refuses, with the reason it refused them.
+type CORSRejectedFunc func(r *http.Request, reason string)
+
+// CORSHandler answers preflight requests itself and adds CORS headers to the responses of allowed origins. Origins
+// are allowed by exact match, by a wildcard subdomain rule such as "https://*.example.com", which does not match
+// example.com itself, or by regular expressions matched against the whole origin. "*" allows every origin.
+type CORSHandler struct {
+	AllowedOrigins        []string
+	AllowedOriginPatterns []*regexp.Regexp
+	AllowedMethods        []string
+	AllowedHeaders        []string
+	ExposedHeaders        []string
+	AllowCredentials      bool
+	MaxAge                time.Duration
+	OnRejectedFunc        CORSRejectedFunc
+	Next                  http.Handler
+}
+
+type CORSHandlerOption func(*CORSHandler) error
+
+func NewCORSHandler(next http.Handler, opts ...CORSHandlerOption) (CORSHandler, error) {
+	if next == nil {
+		return CORSHandler{}, ErrNilNext
+	}
+	ch := CORSHandler{
+		AllowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost},
+		AllowedHeaders: []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"},
+		Next:           next,
+	}
+	for _, opt := range opts {
+		if err := opt(&ch); err != nil {
+			return CORSHandler{}, err
+		}
+	}
+	if ch.AllowCredentials && ch.allowsAnyOrigin() {
+		return CORSHandler{}, errors.New("handler: credentials cannot be allowed for every origin")
+	}
+	return ch, nil
+}
+
+func WithAllowedOrigins(origins ...string) CORSHandlerOption {
+	return func(ch *CORSHandler) error {
+		for _, origin := range origins {
+			if strings.Count(origin, "*") > 1 || (strings.Contains(origin, "*") && origin != "*" &&
+				!strings.Contains(origin, "://*.")) {
+				return errors.New("handler: wildcards are only allowed as a leading subdomain: " + origin)
+			}
+		}
+		ch.AllowedOrigins = append(ch.AllowedOrigins, origins...)
+		return nil
+	}
+}
+
+// WithOriginPatterns allows origins matching any of patterns, which are anchored at both ends.
+func WithOriginPatterns(patterns ...string) CORSHandlerOption {
+	return func(ch *CORSHandler) error {
+		for _, pattern := range patterns {
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return err
+			}
+			ch.AllowedOriginPatterns = append(ch.AllowedOriginPatterns, re)
+		}
+		return nil
+	}
+}
+
+func WithAllowedMethods(methods ...string) CORSHandlerOption {
+	return func(ch *CORSHandler) error {
+		ch.AllowedMethods = methods
+		return nil
+	}
+}
+
+// WithAllowedHeaders replaces the default CORS-safelisted request headers. "*" allows any header.
+func WithAllowedHeaders(headers ...string) CORSHandlerOption {
+	return func(ch *CORSHandler) error {
+		ch.AllowedHeaders = headers
+		return nil
+	}
+}
+
+func WithExposedHeaders(headers ...string) CORSHandlerOption {
+	return func(ch *CORSHandler) error {
+		ch.ExposedHeaders = headers
+		return nil
+	}
+}
+
+func WithCredentials() CORSHandlerOption {
+	return func(ch *CORSHandler) error {
+		ch.AllowCredentials = true
+		return nil
+	}
+}
+
+// WithMaxAge lets browsers cache preflight responses, saving a round trip per request.
+func WithMaxAge(maxAge time.Duration) CORSHandlerOption {
+	return func(ch *CORSHandler) error {
+		if maxAge < 0 {
+			return errors.New("handler: max age must not be negative")
+		}
+		ch.MaxAge = maxAge
+		return nil
+	}
+}
+
+func WithCORSRejectedFunc(rejectedFunc CORSRejectedFunc) CORSHandlerOption {
+	return func(ch *CORSHandler) error {
+		ch.OnRejectedFunc = rejectedFunc
+		return nil
+	}
+}
+
+func (ch CORSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		ch.preflight(w, r)
+		return
+	}
+	h := w.Header()
+	if !ch.allowsAnyOrigin() {
+		addVary(h, "Origin")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && ch.originAllowed(origin) {
+		ch.setOrigin(h, origin)
+		if len(ch.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(ch.ExposedHeaders, ", "))
+		}
+	}
+	ch.Next.ServeHTTP(w, r)
+}
+
+func (ch CORSHandler) preflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	addVary(h, "Origin")
+	addVary(h, "Access-Control-Request-Method")
+	addVary(h, "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	headers := requestedHeaders(r)
+	if reason := ch.rejectPreflight(origin, method, headers); reason != "" {
+		if ch.OnRejectedFunc != nil {
+			ch.OnRejectedFunc(r, reason)
+		}
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ch.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", method)
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if ch.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(ch.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectPreflight explains why a preflight must be refused, or returns "" to allow it.
+func (ch CORSHandler) rejectPreflight(origin, method string, headers []string) string {
+	if origin == "" || !ch.originAllowed(origin) {
+		return "origin " + strconv.Quote(origin) + " not allowed"
+	}
+	if !containsFold(ch.AllowedMethods, method) {
+		return "method " + method + " not allowed"
+	}
+	if containsFold(ch.AllowedHeaders, "*") {
+		return ""
+	}
+	for _, header := range headers {
+		if !containsFold(ch.AllowedHeaders, header) {
+			return "header " + header + " not allowed"
+		}
+	}
+	return ""
+}
+
+func (ch CORSHandler) setOrigin(h http.Header, origin string) {
+	if ch.allowsAnyOrigin() {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if ch.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (ch CORSHandler) allowsAnyOrigin() bool {
+	for _, allowed := range ch.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch CORSHandler) originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range ch.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if i := strings.Index(allowed, "://*."); i != -1 {
+			scheme, domain := strings.ToLower(allowed[:i+3]), strings.ToLower(allowed[i+4:])
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) &&
+				len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+		}
+	}
+	for _, pattern := range ch.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, value := range r.Header["Access-Control-Request-Headers"] {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, textproto.CanonicalMIMEHeaderKey(header))
+			}
+		}
+	}
+	return headers
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("CORSHandler", func() {
+	var (
+		recorder *httptest.ResponseRecorder
+		reached  bool
+		next     http.Handler
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		reached = false
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+			w.Header().Set("X-Total-Count", "3")
+		})
+	})
+
+	preflight := func(origin, method, headers string) *http.Request {
+		r := httptest.NewRequest("OPTIONS", "/things", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			r.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		return r
+	}
+
+	crossOrigin := func(origin string) *http.Request {
+		r := httptest.NewRequest("GET", "/things", nil)
+		r.Header.Set("Origin", origin)
+		return r
+	}
+
+	Describe("origin rules", func() {
+		var ch handler.CORSHandler
+
+		BeforeEach(func() {
+			var err error
+			ch, err = handler.NewCORSHandler(next,
+				handler.WithAllowedOrigins("https://app.example.org", "https://*.example.com"),
+				handler.WithOriginPatterns(`https://pr-\d+\.preview\.example\.net`))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		for _, tc := range []struct {
+			origin  string
+			allowed bool
+		}{
+			{"https://app.example.org", true},
+			{"https://APP.example.org", true},
+			{"http://app.example.org", false},
+			{"https://a.example.com", true},
+			{"https://a.b.example.com", true},
+			{"https://example.com", false},
+			{"https://example.com.evil.org", false},
+			{"https://pr-42.preview.example.net", true},
+			{"https://pr-42.preview.example.net.evil.org", false},
+		} {
+			tc := tc
+			It("should decide whether "+tc.origin+" is allowed", func() {
+				ch.ServeHTTP(recorder, crossOrigin(tc.origin))
+				Expect(reached).To(BeTrue())
+				if tc.allowed {
+					Expect(recorder.Header().Get("Access-Control-Allow-Origin")).To(Equal(tc.origin))
+				} else {
+					Expect(recorder.Header()).ToNot(HaveKey("Access-Control-Allow-Origin"))
+				}
+				Expect(recorder.Header().Get("Vary")).To(Equal("Origin"))
+			})
+		}
+	})
+
+	It("should allow credentials and expose headers", func() {
+		ch, err := handler.NewCORSHandler(next, handler.WithAllowedOrigins("https://app.example.org"),
+			handler.WithCredentials(), handler.WithExposedHeaders("X-Total-Count", "Link"))
+		Expect(err).ToNot(HaveOccurred())
+		ch.ServeHTTP(recorder, crossOrigin("https://app.example.org"))
+		Expect(recorder.Header().Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+		Expect(recorder.Header().Get("Access-Control-Expose-Headers")).To(Equal("X-Total-Count, Link"))
+	})
+
+	It("should send a constant header for any origin", func() {
+		ch, err := handler.NewCORSHandler(next, handler.WithAllowedOrigins("*"))
+		Expect(err).ToNot(HaveOccurred())
+		ch.ServeHTTP(recorder, crossOrigin("https://anywhere.example"))
+		Expect(recorder.Header().Get("Access-Control-Allow-Origin")).To(Equal("*"))
+		Expect(recorder.Header()).ToNot(HaveKey("Vary"))
+	})
+
+	It("should answer allowed preflights without calling next", func() {
+		ch, err := handler.NewCORSHandler(next, handler.WithAllowedOrigins("https://app.example.org"),
+			handler.WithAllowedMethods("GET", "PUT"), handler.WithAllowedHeaders("Content-Type", "X-Api-Key"),
+			handler.WithMaxAge(10*time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		ch.ServeHTTP(recorder, preflight("https://app.example.org", "PUT", "content-type, x-api-key"))
+
+		Expect(reached).To(BeFalse())
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Expect(recorder.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.org"))
+		Expect(recorder.Header().Get("Access-Control-Allow-Methods")).To(Equal("PUT"))
+		Expect(recorder.Header().Get("Access-Control-Allow-Headers")).To(Equal("Content-Type, X-Api-Key"))
+		Expect(recorder.Header().Get("Access-Control-Max-Age")).To(Equal("600"))
+		Expect(recorder.Header()["Vary"]).To(Equal([]string{
+			"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers",
+		}))
+	})
+
+	It("should allow any requested header with *", func() {
+		ch, err := handler.NewCORSHandler(next, handler.WithAllowedOrigins("https://app.example.org"),
+			handler.WithAllowedHeaders("*"))
+		Expect(err).ToNot(HaveOccurred())
+		ch.ServeHTTP(recorder, preflight("https://app.example.org", "POST", "X-Anything"))
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Expect(recorder.Header().Get("Access-Control-Allow-Headers")).To(Equal("X-Anything"))
+	})
+
+	It("should reject preflights that break the rules and say why", func() {
+		var reasons []string
+		ch, err := handler.NewCORSHandler(next, handler.WithAllowedOrigins("https://app.example.org"),
+			handler.WithCORSRejectedFunc(func(r *http.Request, reason string) {
+				reasons = append(reasons, reason)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, r := range []*http.Request{
+			preflight("https://evil.example", "GET", ""),
+			preflight("https://app.example.org", "DELETE", ""),
+			preflight("https://app.example.org", "POST", "X-Secret"),
+		} {
+			recorder = httptest.NewRecorder()
+			ch.ServeHTTP(recorder, r)
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+			Expect(recorder.Header()).ToNot(HaveKey("Access-Control-Allow-Origin"))
+		}
+		Expect(reached).To(BeFalse())
+		Expect(reasons).To(Equal([]string{
+			`origin "https://evil.example" not allowed`,
+			"method DELETE not allowed",
+			"header X-Secret not allowed",
+		}))
+	})
+
+	It("should pass plain OPTIONS requests through", func() {
+		ch, err := handler.NewCORSHandler(next, handler.WithAllowedOrigins("*"))
+		Expect(err).ToNot(HaveOccurred())
+		ch.ServeHTTP(recorder, httptest.NewRequest("OPTIONS", "/", nil))
+		Expect(reached).To(BeTrue())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewCORSHandler(nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewCORSHandler(next, handler.WithAllowedOrigins("https://a.*.com"))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewCORSHandler(next, handler.WithOriginPatterns("("))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewCORSHandler(next, handler.WithMaxAge(-time.Second))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewCORSHandler(next, handler.WithAllowedOrigins("*"), handler.WithCredentials())
+		Expect(err).To(HaveOccurred())
+	})
+})
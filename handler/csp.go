@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CSP source expressions. SourceNonce stands in for the per-request nonce, which SecurityHeadersHandler generates and
+// CSPNonce returns for use in templates.
+const (
+	SourceSelf          = "'self'"
+	SourceNone          = "'none'"
+	SourceUnsafeInline  = "'unsafe-inline'"
+	SourceUnsafeEval    = "'unsafe-eval'"
+	SourceStrictDynamic = "'strict-dynamic'"
+	SourceNonce         = "'nonce'"
+	SourceData          = "data:"
+	SourceBlob          = "blob:"
+	SourceHTTPS         = "https:"
+)
+
+// CSPReportIDParam is the query parameter added to the report-uri so that violation reports can be tied back to the
+// request that served the page.
+const CSPReportIDParam = "request-id"
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// CSP builds a Content-Security-Policy. Directives keep the order they were added in and adding one again replaces
+// it.
+type CSP struct {
+	directives []cspDirective
+	reportURI  string
+}
+
+func NewCSP() *CSP {
+	return &CSP{}
+}
+
+func (c *CSP) Directive(name string, sources ...string) *CSP {
+	for i := range c.directives {
+		if c.directives[i].name == name {
+			c.directives[i].sources = sources
+			return c
+		}
+	}
+	c.directives = append(c.directives, cspDirective{name, sources})
+	return c
+}
+
+func (c *CSP) DefaultSrc(sources ...string) *CSP {
+	return c.Directive("default-src", sources...)
+}
+
+func (c *CSP) ScriptSrc(sources ...string) *CSP {
+	return c.Directive("script-src", sources...)
+}
+
+func (c *CSP) StyleSrc(sources ...string) *CSP {
+	return c.Directive("style-src", sources...)
+}
+
+func (c *CSP) ImgSrc(sources ...string) *CSP {
+	return c.Directive("img-src", sources...)
+}
+
+func (c *CSP) ConnectSrc(sources ...string) *CSP {
+	return c.Directive("connect-src", sources...)
+}
+
+func (c *CSP) FontSrc(sources ...string) *CSP {
+	return c.Directive("font-src", sources...)
+}
+
+func (c *CSP) ObjectSrc(sources ...string) *CSP {
+	return c.Directive("object-src", sources...)
+}
+
+func (c *CSP) MediaSrc(sources ...string) *CSP {
+	return c.Directive("media-src", sources...)
+}
+
+func (c *CSP) FrameSrc(sources ...string) *CSP {
+	return c.Directive("frame-src", sources...)
+}
+
+func (c *CSP) WorkerSrc(sources ...string) *CSP {
+	return c.Directive("worker-src", sources...)
+}
+
+func (c *CSP) FrameAncestors(sources ...string) *CSP {
+	return c.Directive("frame-ancestors", sources...)
+}
+
+func (c *CSP) BaseURI(sources ...string) *CSP {
+	return c.Directive("base-uri", sources...)
+}
+
+func (c *CSP) FormAction(sources ...string) *CSP {
+	return c.Directive("form-action", sources...)
+}
+
+func (c *CSP) UpgradeInsecureRequests() *CSP {
+	return c.Directive("upgrade-insecure-requests")
+}
+
+// ReportURI sends violation reports to uri, typically where a CSPReportHandler is mounted.
+func (c *CSP) ReportURI(uri string) *CSP {
+	c.reportURI = uri
+	return c
+}
+
+func (c *CSP) usesNonce() bool {
+	for _, d := range c.directives {
+		for _, source := range d.sources {
+			if source == SourceNonce {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String renders the policy for a request that was given nonce and requestID. Either may be empty.
+func (c *CSP) String(nonce, requestID string) string {
+	var parts []string
+	for _, d := range c.directives {
+		values := []string{d.name}
+		for _, source := range d.sources {
+			if source == SourceNonce {
+				if nonce == "" {
+					continue
+				}
+				source = "'nonce-" + nonce + "'"
+			}
+			values = append(values, source)
+		}
+		parts = append(parts, strings.Join(values, " "))
+	}
+	if c.reportURI != "" {
+		parts = append(parts, "report-uri "+reportURI(c.reportURI, requestID))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func reportURI(uri, requestID string) string {
+	if requestID == "" {
+		return uri
+	}
+	separator := "?"
+	if strings.Contains(uri, "?") {
+		separator = "&"
+	}
+	return uri + separator + CSPReportIDParam + "=" + url.QueryEscape(requestID)
+}
+
+type cspNonceCtxKey struct{}
+
+// CSPNonce returns the nonce SecurityHeadersHandler generated for r, or "" when the policy does not use one.
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceCtxKey{}).(string)
+	return nonce
+}
+
+func withCSPNonce(r *http.Request) (*http.Request, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return r, "", err
+	}
+	nonce := base64.StdEncoding.EncodeToString(b)
+	return r.WithContext(context.WithValue(r.Context(), cspNonceCtxKey{}, nonce)), nonce, nil
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// CSPReport is a violation report, from either the report-uri or the Reporting API format.
+type CSPReport struct {
+	DocumentURI        string
+	Referrer           string
+	BlockedURI         string
+	ViolatedDirective  string
+	EffectiveDirective string
+	OriginalPolicy     string
+	Disposition        string
+	SourceFile         string
+	LineNumber         int
+	ColumnNumber       int
+	StatusCode         int
+	Sample             string
+}
+
+// CSPReportFunc receives each report along with the ID of the request that served the violating page, as recorded in
+// the report-uri by SecurityHeadersHandler, or "" if it is unknown.
+type CSPReportFunc func(r *http.Request, report CSPReport, pageRequestID string)
+
+// CSPReportHandler accepts violation reports POSTed by browsers, as application/csp-report or
+// application/reports+json, and answers 204 No Content.
+type CSPReportHandler struct {
+	MaxBytes     int64
+	OnReportFunc CSPReportFunc
+}
+
+type CSPReportHandlerOption func(*CSPReportHandler) error
+
+// NewCSPReportHandler accepts bodies of up to 64KiB unless told otherwise.
+func NewCSPReportHandler(onReport CSPReportFunc, opts ...CSPReportHandlerOption) (CSPReportHandler, error) {
+	if onReport == nil {
+		return CSPReportHandler{}, errors.New("handler: report func must not be nil")
+	}
+	rh := CSPReportHandler{MaxBytes: 64 << 10, OnReportFunc: onReport}
+	for _, opt := range opts {
+		if err := opt(&rh); err != nil {
+			return CSPReportHandler{}, err
+		}
+	}
+	return rh, nil
+}
+
+func WithMaxReportBytes(maxBytes int64) CSPReportHandlerOption {
+	return func(rh *CSPReportHandler) error {
+		if maxBytes <= 0 {
+			return errors.New("handler: max report bytes must be positive")
+		}
+		rh.MaxBytes = maxBytes
+		return nil
+	}
+}
+
+func (rh CSPReportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, rh.MaxBytes+1))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > rh.MaxBytes {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	reports, err := parseCSPReports(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	pageRequestID := r.URL.Query().Get(CSPReportIDParam)
+	for _, report := range reports {
+		rh.OnReportFunc(r, report, pageRequestID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		StatusCode         int    `json:"status-code"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		StatusCode         int    `json:"statusCode"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+var errUnsupportedReportType = errors.New("handler: unsupported report content type")
+
+func parseCSPReports(contentType string, body []byte) ([]CSPReport, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case "application/csp-report", "application/json":
+		return parseLegacyCSPReport(body)
+	case "application/reports+json":
+		return parseReportingAPIReports(body)
+	}
+	return nil, errUnsupportedReportType
+}
+
+func parseLegacyCSPReport(body []byte) ([]CSPReport, error) {
+	var legacy legacyCSPReport
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+	lr := legacy.Report
+	return []CSPReport{{
+		DocumentURI:        lr.DocumentURI,
+		Referrer:           lr.Referrer,
+		BlockedURI:         lr.BlockedURI,
+		ViolatedDirective:  lr.ViolatedDirective,
+		EffectiveDirective: lr.EffectiveDirective,
+		OriginalPolicy:     lr.OriginalPolicy,
+		Disposition:        lr.Disposition,
+		SourceFile:         lr.SourceFile,
+		LineNumber:         lr.LineNumber,
+		ColumnNumber:       lr.ColumnNumber,
+		StatusCode:         lr.StatusCode,
+		Sample:             lr.ScriptSample,
+	}}, nil
+}
+
+// parseReportingAPIReports keeps only csp-violation reports, as browsers batch other report types into the same
+// request.
+func parseReportingAPIReports(body []byte) ([]CSPReport, error) {
+	var batch []reportingAPIReport
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, err
+	}
+	var reports []CSPReport
+	for _, report := range batch {
+		if report.Type != "csp-violation" {
+			continue
+		}
+		b := report.Body
+		reports = append(reports, CSPReport{
+			DocumentURI:        b.DocumentURL,
+			Referrer:           b.Referrer,
+			BlockedURI:         b.BlockedURL,
+			ViolatedDirective:  b.EffectiveDirective,
+			EffectiveDirective: b.EffectiveDirective,
+			OriginalPolicy:     b.OriginalPolicy,
+			Disposition:        b.Disposition,
+			SourceFile:         b.SourceFile,
+			LineNumber:         b.LineNumber,
+			ColumnNumber:       b.ColumnNumber,
+			StatusCode:         b.StatusCode,
+			Sample:             b.Sample,
+		})
+	}
+	return reports, nil
+}
@@ -0,0 +1,116 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("CSPReportHandler", func() {
+	var (
+		recorder      *httptest.ResponseRecorder
+		reports       []handler.CSPReport
+		pageRequestID string
+		rh            handler.CSPReportHandler
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		reports = nil
+		pageRequestID = ""
+		var err error
+		rh, err = handler.NewCSPReportHandler(func(r *http.Request, report handler.CSPReport, id string) {
+			reports = append(reports, report)
+			pageRequestID = id
+		}, handler.WithMaxReportBytes(1024))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	post := func(contentType, body string) *http.Request {
+		r := httptest.NewRequest("POST", "/csp?request-id=page-1", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		return r
+	}
+
+	It("should accept report-uri reports", func() {
+		rh.ServeHTTP(recorder, post("application/csp-report", `{"csp-report": {
+			"document-uri": "https://example.com/page",
+			"blocked-uri": "https://evil.example/x.js",
+			"violated-directive": "script-src-elem",
+			"effective-directive": "script-src-elem",
+			"original-policy": "script-src 'self'",
+			"disposition": "enforce",
+			"source-file": "https://example.com/page",
+			"line-number": 12,
+			"column-number": 3,
+			"status-code": 200,
+			"script-sample": "alert(1)"
+		}}`))
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Expect(pageRequestID).To(Equal("page-1"))
+		Expect(reports).To(Equal([]handler.CSPReport{{
+			DocumentURI:        "https://example.com/page",
+			BlockedURI:         "https://evil.example/x.js",
+			ViolatedDirective:  "script-src-elem",
+			EffectiveDirective: "script-src-elem",
+			OriginalPolicy:     "script-src 'self'",
+			Disposition:        "enforce",
+			SourceFile:         "https://example.com/page",
+			LineNumber:         12,
+			ColumnNumber:       3,
+			StatusCode:         200,
+			Sample:             "alert(1)",
+		}}))
+	})
+
+	It("should accept Reporting API batches, ignoring other report types", func() {
+		rh.ServeHTTP(recorder, post("application/reports+json", `[
+			{"type": "deprecation", "body": {}},
+			{"type": "csp-violation", "body": {
+				"documentURL": "https://example.com/page",
+				"blockedURL": "inline",
+				"effectiveDirective": "style-src-attr",
+				"disposition": "report"
+			}}
+		]`))
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Expect(reports).To(HaveLen(1))
+		Expect(reports[0].BlockedURI).To(Equal("inline"))
+		Expect(reports[0].ViolatedDirective).To(Equal("style-src-attr"))
+		Expect(reports[0].Disposition).To(Equal("report"))
+	})
+
+	It("should reject bad requests", func() {
+		rh.ServeHTTP(recorder, httptest.NewRequest("GET", "/csp", nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(recorder.Header().Get("Allow")).To(Equal("POST"))
+
+		for _, r := range []*http.Request{
+			post("application/csp-report", "{"),
+			post("text/plain", "{}"),
+			post("", "{}"),
+		} {
+			recorder = httptest.NewRecorder()
+			rh.ServeHTTP(recorder, r)
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		}
+
+		recorder = httptest.NewRecorder()
+		rh.ServeHTTP(recorder, post("application/csp-report", `{"csp-report": {"blocked-uri": "`+
+			strings.Repeat("a", 1024)+`"}}`))
+		Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(reports).To(BeEmpty())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewCSPReportHandler(nil)
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewCSPReportHandler(func(*http.Request, handler.CSPReport, string) {},
+			handler.WithMaxReportBytes(0))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,40 @@
+package handler_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("CSP", func() {
+	It("should render directives in the order they were added", func() {
+		csp := handler.NewCSP().
+			DefaultSrc(handler.SourceSelf).
+			ImgSrc(handler.SourceSelf, handler.SourceData, "https://cdn.example.com").
+			ObjectSrc(handler.SourceNone).
+			UpgradeInsecureRequests()
+		Expect(csp.String("", "")).To(Equal(
+			"default-src 'self'; img-src 'self' data: https://cdn.example.com; object-src 'none'; upgrade-insecure-requests"))
+	})
+
+	It("should replace a directive that is added again", func() {
+		csp := handler.NewCSP().ScriptSrc(handler.SourceSelf).FrameAncestors(handler.SourceNone).
+			ScriptSrc(handler.SourceStrictDynamic)
+		Expect(csp.String("", "")).To(Equal("script-src 'strict-dynamic'; frame-ancestors 'none'"))
+	})
+
+	It("should substitute the nonce, dropping it when there is none", func() {
+		csp := handler.NewCSP().ScriptSrc(handler.SourceNonce, handler.SourceStrictDynamic).StyleSrc(handler.SourceNonce)
+		Expect(csp.String("abc", "")).To(Equal("script-src 'nonce-abc' 'strict-dynamic'; style-src 'nonce-abc'"))
+		Expect(csp.String("", "")).To(Equal("script-src 'strict-dynamic'; style-src"))
+	})
+
+	It("should tag the report URI with the request ID", func() {
+		csp := handler.NewCSP().DefaultSrc(handler.SourceSelf).ReportURI("/csp-reports")
+		Expect(csp.String("", "a b")).To(Equal("default-src 'self'; report-uri /csp-reports?request-id=a+b"))
+		Expect(csp.String("", "")).To(Equal("default-src 'self'; report-uri /csp-reports"))
+
+		csp.ReportURI("/csp-reports?app=web")
+		Expect(csp.String("", "42")).To(HaveSuffix("report-uri /csp-reports?app=web&request-id=42"))
+	})
+})
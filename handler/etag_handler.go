@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ValidatorFunc looks up the current Validators of the resource an unsafe request would change.
+type ValidatorFunc func(r *http.Request) Validators
+
+// ETagHandler answers conditional requests. It gives 200 responses to GET and HEAD requests an ETag hashed from their
+// body, unless Next set an ETag or Last-Modified of its own, and answers If-None-Match and If-Modified-Since with a
+// 304 when the client's copy is current. Unsafe requests whose If-Match, If-Unmodified-Since or If-None-Match rule out
+// changing the resource get a 412 without Next running.
+//
+// Bodies are buffered to be hashed, so responses larger than MaxBytes, and ones Next flushes as it goes, are passed
+// through without an ETag. The current validators for unsafe requests come from ValidatorFunc, or else from a GET of
+// the same URI through the handler, which costs a run of Next. Put it outside a CacheHandler so that cache hits can be
+// answered with 304s.
+type ETagHandler struct {
+	Weak          bool
+	MaxBytes      int64
+	ValidatorFunc ValidatorFunc
+	Responder     Responder
+	Next          http.Handler
+}
+
+type ETagHandlerOption func(*ETagHandler) error
+
+// NewETagHandler hashes bodies of up to 1MiB into strong ETags unless told otherwise.
+func NewETagHandler(next http.Handler, opts ...ETagHandlerOption) (ETagHandler, error) {
+	if next == nil {
+		return ETagHandler{}, ErrNilNext
+	}
+	eh := ETagHandler{
+		MaxBytes:  1 << 20,
+		Responder: NegotiatedResponder,
+		Next:      next,
+	}
+	for _, opt := range opts {
+		if err := opt(&eh); err != nil {
+			return ETagHandler{}, err
+		}
+	}
+	return eh, nil
+}
+
+// WithWeakETags marks generated ETags weak, for responses whose bytes may change without their meaning changing.
+func WithWeakETags() ETagHandlerOption {
+	return func(eh *ETagHandler) error {
+		eh.Weak = true
+		return nil
+	}
+}
+
+// WithMaxETagBytes limits the bodies that are buffered to be hashed.
+func WithMaxETagBytes(maxBytes int64) ETagHandlerOption {
+	return func(eh *ETagHandler) error {
+		if maxBytes <= 0 {
+			return errors.New("handler: max ETag bytes must be positive")
+		}
+		eh.MaxBytes = maxBytes
+		return nil
+	}
+}
+
+func WithValidatorFunc(validatorFunc ValidatorFunc) ETagHandlerOption {
+	return func(eh *ETagHandler) error {
+		if validatorFunc == nil {
+			return errors.New("handler: validator func must not be nil")
+		}
+		eh.ValidatorFunc = validatorFunc
+		return nil
+	}
+}
+
+func WithETagResponder(responder Responder) ETagHandlerOption {
+	return func(eh *ETagHandler) error {
+		if responder == nil {
+			return errors.New("handler: responder must not be nil")
+		}
+		eh.Responder = responder
+		return nil
+	}
+}
+
+func (eh ETagHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		eh.serveSafe(w, r)
+	case http.MethodOptions, http.MethodTrace:
+		eh.Next.ServeHTTP(w, r)
+	default:
+		if hasPreconditions(r) && preconditionFailed(r, eh.current(r)) {
+			eh.respond(w, r, http.StatusPreconditionFailed, "precondition failed")
+			return
+		}
+		eh.Next.ServeHTTP(w, r)
+	}
+}
+
+func (eh ETagHandler) serveSafe(w http.ResponseWriter, r *http.Request) {
+	ew := &etagWriter{ResponseWriter: w, handler: eh, request: r}
+	eh.Next.ServeHTTP(ew, r)
+	// not deferred, so that should Next panic the response is left uncommitted for a RecoveryHandler further out
+	ew.finish()
+}
+
+// current works out the validators of the resource r would change.
+func (eh ETagHandler) current(r *http.Request) Validators {
+	if eh.ValidatorFunc != nil {
+		return eh.ValidatorFunc(r)
+	}
+	get := r.Clone(r.Context())
+	get.Method = http.MethodGet
+	get.Body = http.NoBody
+	get.ContentLength = 0
+	for _, name := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		get.Header.Del(name)
+	}
+	// only the validators are wanted, so none of the body is kept
+	bw := newBufferedWriter(nil, 0)
+	eh.serveSafe(bw, get)
+	return validatorsFrom(bw.status, bw.header)
+}
+
+func (eh ETagHandler) etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if eh.Weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+func (eh ETagHandler) respond(w http.ResponseWriter, r *http.Request, status int, message string) {
+	responder := eh.Responder
+	if responder == nil {
+		responder = NegotiatedResponder
+	}
+	responder(w, r, status, message)
+}
+
+type etagMode int
+
+const (
+	etagBuffering etagMode = iota
+	etagPassing
+	etagDiscarding
+)
+
+// etagWriter buffers a 200 response until it can be hashed, passing anything else through, and swallows the body of
+// responses it has turned into 304s.
+type etagWriter struct {
+	http.ResponseWriter
+	handler     ETagHandler
+	request     *http.Request
+	status      int
+	wroteHeader bool
+	hijacked    bool
+	mode        etagMode
+	buf         bytes.Buffer
+	saved       int64
+}
+
+func (ew *etagWriter) WriteHeader(statusCode int) {
+	if ew.wroteHeader {
+		return
+	}
+	if statusCode < http.StatusOK {
+		ew.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	ew.wroteHeader = true
+	ew.status = statusCode
+	h := ew.Header()
+	switch {
+	case statusCode != http.StatusOK:
+		ew.pass()
+	case h.Get("ETag") != "" || h.Get("Last-Modified") != "":
+		// Next's own validators are used as they are, so there is no need to see the body first
+		if notModified(ew.request, validatorsFrom(statusCode, h)) {
+			ew.notModified()
+		} else {
+			ew.pass()
+		}
+	}
+}
+
+func (ew *etagWriter) Write(p []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	switch ew.mode {
+	case etagDiscarding:
+		ew.saved += int64(len(p))
+		return len(p), nil
+	case etagBuffering:
+		if int64(ew.buf.Len()+len(p)) <= ew.handler.MaxBytes {
+			return ew.buf.Write(p)
+		}
+		if _, err := ew.release(); err != nil {
+			return 0, err
+		}
+	}
+	return ew.ResponseWriter.Write(p)
+}
+
+// pass sends the header as it is and everything after it straight through.
+func (ew *etagWriter) pass() {
+	ew.mode = etagPassing
+	ew.ResponseWriter.WriteHeader(ew.status)
+}
+
+// release gives up on hashing, sending what has been buffered so far without an ETag.
+func (ew *etagWriter) release() (int, error) {
+	ew.pass()
+	buf := ew.buf.Bytes()
+	ew.buf = bytes.Buffer{}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	return ew.ResponseWriter.Write(buf)
+}
+
+func (ew *etagWriter) notModified() {
+	ew.mode = etagDiscarding
+	h := ew.Header()
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+		h.Del(name)
+	}
+	ew.ResponseWriter.WriteHeader(http.StatusNotModified)
+}
+
+// finish hashes a buffered response and sends it, or a 304 in its place.
+func (ew *etagWriter) finish() {
+	if ew.hijacked {
+		return
+	}
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case ew.mode != etagBuffering:
+	case ew.buf.Len() == 0 && ew.request.Method == http.MethodHead:
+		// handlers rarely write bodies for HEAD, so an empty one says nothing about the representation
+		_, _ = ew.release()
+	default:
+		ew.Header().Set("ETag", ew.handler.etag(ew.buf.Bytes()))
+		if notModified(ew.request, validatorsFrom(ew.status, ew.Header())) {
+			ew.saved = int64(ew.buf.Len())
+			ew.notModified()
+		} else {
+			_, _ = ew.release()
+		}
+	}
+	if ew.saved > 0 {
+		UpdateRequestMetadata(ew.request, func(metadata *RequestMetadata) {
+			metadata.BytesSaved = ew.saved
+		})
+	}
+}
+
+func (ew *etagWriter) Flush() {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.mode == etagBuffering {
+		_, _ = ew.release()
+	}
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok && ew.mode == etagPassing {
+		f.Flush()
+	}
+}
+
+func (ew *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := ew.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("handler: ResponseWriter does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		ew.hijacked = true
+	}
+	return conn, rw, err
+}
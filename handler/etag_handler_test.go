@@ -0,0 +1,221 @@
+package handler_test
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("ETagHandler", func() {
+	const body = "hello, world"
+	lastModified := time.Unix(1571000000, 0).UTC()
+
+	var (
+		calls int
+		next  http.Handler
+		eh    handler.ETagHandler
+	)
+
+	BeforeEach(func() {
+		calls = 0
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(body))
+		})
+		var err error
+		eh, err = handler.NewETagHandler(next)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should tag responses with a strong ETag of their body", func() {
+		recorder := serveRequest(eh, "GET", "/things/1")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal(body))
+		Expect(recorder.Header().Get("ETag")).To(MatchRegexp(`^"[0-9a-f]{32}"$`))
+		Expect(serveRequest(eh, "GET", "/things/1").Header().Get("ETag")).To(Equal(recorder.Header().Get("ETag")))
+	})
+
+	It("should answer requests for the current representation with a 304", func() {
+		etag := serveRequest(eh, "GET", "/things/1").Header().Get("ETag")
+		for _, inm := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+			recorder := serveRequest(eh, "GET", "/things/1", "If-None-Match", inm)
+			Expect(recorder.Code).To(Equal(http.StatusNotModified))
+			Expect(recorder.Body.String()).To(BeEmpty())
+			Expect(recorder.Header().Get("ETag")).To(Equal(etag))
+			Expect(recorder.Header()).ToNot(HaveKey("Content-Type"))
+		}
+		Expect(serveRequest(eh, "GET", "/things/1", "If-None-Match", `"other"`).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should record how much a 304 saved", func() {
+		etag := serveRequest(eh, "GET", "/things/1").Header().Get("ETag")
+		var metadata handler.RequestMetadata
+		rh, err := handler.NewRequestsHandlerWithOptions(eh, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, m handler.RequestMetadata) {
+				metadata = m
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		serveRequest(rh, "GET", "/things/1", "If-None-Match", etag)
+		Expect(metadata.Status).To(Equal(http.StatusNotModified))
+		Expect(metadata.BytesWritten).To(BeZero())
+		Expect(metadata.BytesSaved).To(Equal(int64(len(body))))
+	})
+
+	It("should make weak ETags when asked", func() {
+		weak, err := handler.NewETagHandler(next, handler.WithWeakETags())
+		Expect(err).ToNot(HaveOccurred())
+		etag := serveRequest(weak, "GET", "/things/1").Header().Get("ETag")
+		Expect(etag).To(HavePrefix(`W/"`))
+		Expect(serveRequest(weak, "GET", "/things/1", "If-None-Match", etag).Code).To(Equal(http.StatusNotModified))
+	})
+
+	It("should use the validators Next sets without buffering", func() {
+		own, err := handler.NewETagHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+			_, _ = w.Write([]byte(body))
+			_, _ = w.Write([]byte(body))
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serveRequest(own, "GET", "/things/1").Header().Get("ETag")).To(Equal(`"v1"`))
+		Expect(serveRequest(own, "GET", "/things/1", "If-None-Match", `"v1"`).Code).To(Equal(http.StatusNotModified))
+		Expect(serveRequest(own, "GET", "/things/1", "If-None-Match", `"v0"`).Code).To(Equal(http.StatusOK))
+
+		since := lastModified.Add(time.Second).Format(http.TimeFormat)
+		Expect(serveRequest(own, "GET", "/things/1", "If-Modified-Since", since).Code).To(Equal(http.StatusNotModified))
+		before := lastModified.Add(-time.Second).Format(http.TimeFormat)
+		Expect(serveRequest(own, "GET", "/things/1", "If-Modified-Since", before).Code).To(Equal(http.StatusOK))
+		// If-None-Match takes precedence
+		Expect(serveRequest(own, "GET", "/things/1", "If-None-Match", `"v0"`, "If-Modified-Since", since).Code).
+			To(Equal(http.StatusOK))
+	})
+
+	It("should leave other statuses alone", func() {
+		missing, err := handler.NewETagHandler(http.NotFoundHandler())
+		Expect(err).ToNot(HaveOccurred())
+		recorder := serveRequest(missing, "GET", "/things/1", "If-None-Match", "*")
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		Expect(recorder.Header()).ToNot(HaveKey("ETag"))
+	})
+
+	It("should pass streaming and large responses through without an ETag", func() {
+		streaming, err := handler.NewETagHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("first"))
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte("second"))
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		recorder := serveRequest(streaming, "GET", "/things/1")
+		Expect(recorder.Body.String()).To(Equal("firstsecond"))
+		Expect(recorder.Flushed).To(BeTrue())
+		Expect(recorder.Header()).ToNot(HaveKey("ETag"))
+
+		small, err := handler.NewETagHandler(next, handler.WithMaxETagBytes(4))
+		Expect(err).ToNot(HaveOccurred())
+		recorder = serveRequest(small, "GET", "/things/1")
+		Expect(recorder.Body.String()).To(Equal(body))
+		Expect(recorder.Header()).ToNot(HaveKey("ETag"))
+	})
+
+	It("should only tag HEAD responses Next wrote a body for", func() {
+		etag := serveRequest(eh, "GET", "/things/1").Header().Get("ETag")
+		Expect(serveRequest(eh, "HEAD", "/things/1").Header().Get("ETag")).To(Equal(etag))
+
+		empty, err := handler.NewETagHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serveRequest(empty, "HEAD", "/things/1").Header()).ToNot(HaveKey("ETag"))
+	})
+
+	Describe("unsafe requests", func() {
+		var etag string
+
+		BeforeEach(func() {
+			etag = serveRequest(eh, "GET", "/things/1").Header().Get("ETag")
+			calls = 0
+		})
+
+		for _, tc := range []struct {
+			description string
+			headers     func(etag string) []string
+			status      int
+		}{
+			{"a matching If-Match", func(etag string) []string { return []string{"If-Match", etag} },
+				http.StatusNoContent},
+			{"If-Match: *", func(string) []string { return []string{"If-Match", "*"} }, http.StatusNoContent},
+			{"a stale If-Match", func(string) []string { return []string{"If-Match", `"stale"`} },
+				http.StatusPreconditionFailed},
+			{"a weak If-Match", func(etag string) []string { return []string{"If-Match", "W/" + etag} },
+				http.StatusPreconditionFailed},
+			{"If-None-Match: *", func(string) []string { return []string{"If-None-Match", "*"} },
+				http.StatusPreconditionFailed},
+			{"If-Unmodified-Since without a Last-Modified", func(string) []string {
+				return []string{"If-Unmodified-Since", time.Now().UTC().Format(http.TimeFormat)}
+			}, http.StatusPreconditionFailed},
+		} {
+			tc := tc
+			It("should check "+tc.description+" against a GET of the resource", func() {
+				recorder := serveRequest(eh, "PUT", "/things/1", tc.headers(etag)...)
+				Expect(recorder.Code).To(Equal(tc.status))
+				if tc.status == http.StatusPreconditionFailed {
+					Expect(calls).To(Equal(1))
+				} else {
+					Expect(calls).To(Equal(2))
+				}
+			})
+		}
+
+		It("should run requests without preconditions straight away", func() {
+			Expect(serveRequest(eh, "DELETE", "/things/1").Code).To(Equal(http.StatusNoContent))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should ask the validator func when there is one", func() {
+			validated, err := handler.NewETagHandler(next, handler.WithValidatorFunc(func(r *http.Request) handler.Validators {
+				return handler.Validators{LastModified: lastModified, Exists: true}
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(serveRequest(validated, "PATCH", "/things/1", "If-Unmodified-Since",
+				lastModified.Format(http.TimeFormat)).Code).To(Equal(http.StatusNoContent))
+			Expect(serveRequest(validated, "PATCH", "/things/1", "If-Unmodified-Since",
+				lastModified.Add(-time.Second).Format(http.TimeFormat)).Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	It("should leave the response uncommitted when Next panics", func() {
+		panicking, err := handler.NewETagHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("partial"))
+			panic("boom")
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		rh, err := handler.NewRecoveryHandler(panicking, handler.WithRecoveryFunc(
+			func(w http.ResponseWriter, r *http.Request, panicMessage interface{}, _ []handler.Stack) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		recorder := serveRequest(rh, "GET", "/things/1")
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(recorder.Body.String()).To(BeEmpty())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewETagHandler(nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		for _, opt := range []handler.ETagHandlerOption{
+			handler.WithMaxETagBytes(0),
+			handler.WithValidatorFunc(nil),
+			handler.WithETagResponder(nil),
+		} {
+			_, err = handler.ETagMiddleware(opt)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
@@ -1,6 +1,14 @@
 package handler
 
+import "errors"
+
 const (
 	RequestIDHeader   = "X-Request-Id"
 	RequestIDLogField = "request-id"
 )
+
+// StatusClientClosedRequest is nginx's non-standard status for requests the client abandoned before a response was
+// sent.
+const StatusClientClosedRequest = 499
+
+var ErrNilNext = errors.New("handler: next handler must not be nil")
@@ -1,6 +1,8 @@
 package handler_test
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -11,3 +13,14 @@ func TestHandler(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Handler Suite")
 }
+
+// serveRequest serves h a request without a body, with headers given as name, value pairs.
+func serveRequest(h http.Handler, method, target string, headers ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	h.ServeHTTP(recorder, request)
+	return recorder
+}
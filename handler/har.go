@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HAR is an HTTP Archive, version 1.2, as described at http://www.softwareishard.com/blog/har-12-spec/. Only the
+// parts RecordHandler writes are modelled. Fields starting with an underscore are extensions the spec allows.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total milliseconds the request took.
+	Time     float64     `json:"time"`
+	Request  HARRequest  `json:"request"`
+	Response HARResponse `json:"response"`
+	Cache    HARCache    `json:"cache"`
+	Timings  HARTimings  `json:"timings"`
+	Comment  string      `json:"comment,omitempty"`
+	// RequestID is the request's X-Request-Id, for finding it in the access log.
+	RequestID string `json:"_requestId,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// Encoding is "base64" for binary bodies. The spec only provides it for response content.
+	Encoding  string `json:"_encoding,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+type HARContent struct {
+	// Size is the full length of the body, including anything beyond the recorded Text.
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+// HARCache is always empty: nothing is known about the client's cache.
+type HARCache struct{}
+
+// HARTimings are in milliseconds. All of a request's time is counted as waiting on the server.
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HARWriter stores the entries RecordHandler records. Implementations must be safe for concurrent use.
+type HARWriter interface {
+	WriteEntry(entry HAREntry) error
+}
+
+// harTrailer closes the entries array and the document. Each entry is written over the previous trailer, so that a
+// file is a complete HAR document between entries.
+const harTrailer = "]}}\n"
+
+// HARArchive is a HARWriter that appends entries to HAR files in Dir, named Prefix-<time>-<n>.har. Once a file reaches
+// MaxFileBytes the next entry starts a new file, and the oldest files are removed to keep at most MaxFiles of them.
+type HARArchive struct {
+	Dir          string
+	Prefix       string
+	MaxFileBytes int64
+	MaxFiles     int
+	Creator      HARCreator
+	mu           sync.Mutex
+	file         *os.File
+	size         int64
+	entries      int
+	seq          int
+}
+
+type HARArchiveOption func(*HARArchive) error
+
+// NewHARArchive creates dir if need be and writes files of up to 16MiB named requests-*.har, keeping the last 10,
+// unless told otherwise. Close it to close the current file.
+func NewHARArchive(dir string, opts ...HARArchiveOption) (*HARArchive, error) {
+	if dir == "" {
+		return nil, errors.New("handler: HAR archive directory must not be empty")
+	}
+	a := &HARArchive{
+		Dir:          dir,
+		Prefix:       "requests",
+		MaxFileBytes: 16 << 20,
+		MaxFiles:     10,
+		Creator:      HARCreator{Name: "github.com/sahilm/handlers", Version: "1"},
+	}
+	for _, opt := range opts {
+		if optErr := opt(a); optErr != nil {
+			return nil, optErr
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// WithHARRotation sets the size at which a new file is started and how many files are kept. A single entry larger
+// than maxFileBytes still gets written, to a file of its own.
+func WithHARRotation(maxFileBytes int64, maxFiles int) HARArchiveOption {
+	return func(a *HARArchive) error {
+		if maxFileBytes <= 0 || maxFiles <= 0 {
+			return errors.New("handler: HAR rotation limits must be positive")
+		}
+		a.MaxFileBytes = maxFileBytes
+		a.MaxFiles = maxFiles
+		return nil
+	}
+}
+
+func WithHARPrefix(prefix string) HARArchiveOption {
+	return func(a *HARArchive) error {
+		if prefix == "" || strings.ContainsAny(prefix, `/\`) {
+			return fmt.Errorf("handler: invalid HAR file prefix %q", prefix)
+		}
+		a.Prefix = prefix
+		return nil
+	}
+}
+
+func (a *HARArchive) WriteEntry(entry HAREntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil && a.entries > 0 && a.size+int64(len(data)+1) > a.MaxFileBytes {
+		if err = a.closeFile(); err != nil {
+			return err
+		}
+	}
+	if a.file == nil {
+		if err = a.openFile(); err != nil {
+			return err
+		}
+	}
+	if a.entries > 0 {
+		data = append([]byte(","), data...)
+	}
+	data = append(data, harTrailer...)
+	if _, err = a.file.WriteAt(data, a.size-int64(len(harTrailer))); err != nil {
+		return err
+	}
+	a.size += int64(len(data) - len(harTrailer))
+	a.entries++
+	return nil
+}
+
+// Files lists the archive's files, oldest first.
+func (a *HARArchive) Files() ([]string, error) {
+	infos, err := ioutil.ReadDir(a.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), a.Prefix+"-") && strings.HasSuffix(info.Name(), ".har") {
+			files = append(files, filepath.Join(a.Dir, info.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Close closes the current file. The next entry written starts a new one.
+func (a *HARArchive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	return a.closeFile()
+}
+
+func (a *HARArchive) openFile() error {
+	a.seq++
+	name := fmt.Sprintf("%s-%s-%04d.har", a.Prefix, time.Now().UTC().Format("20060102T150405.000000000"), a.seq)
+	f, err := os.OpenFile(filepath.Join(a.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	creator, err := json.Marshal(a.Creator)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	header := `{"log":{"version":"1.2","creator":` + string(creator) + `,"entries":[` + harTrailer
+	if _, err = f.WriteString(header); err != nil {
+		_ = f.Close()
+		return err
+	}
+	a.file, a.size, a.entries = f, int64(len(header)), 0
+	return a.prune()
+}
+
+func (a *HARArchive) closeFile() error {
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// prune removes the oldest files beyond MaxFiles.
+func (a *HARArchive) prune() error {
+	files, err := a.Files()
+	if err != nil {
+		return err
+	}
+	for len(files) > a.MaxFiles {
+		if err = os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// volatileHeaders change from one response to the next, so they are never compared.
+var volatileHeaders = []string{"Age", "Date", "Expires", "Set-Cookie", RequestIDHeader}
+
+// ReplayResult is the response a replayed HAR entry got, and how it differs from the recorded one.
+type ReplayResult struct {
+	Entry  HAREntry
+	Status int
+	Header http.Header
+	Body   []byte
+	// Differences is empty when the response matches the recording.
+	Differences []string
+}
+
+// Matched reports whether the response matched the recording.
+func (rr ReplayResult) Matched() bool {
+	return len(rr.Differences) == 0
+}
+
+// Replayer sends recorded requests, such as those RecordHandler archives, to a handler and compares the
+// responses with the recorded ones: their statuses, the recorded headers and their bodies, JSON bodies by value.
+// Headers recorded as RedactedValue are neither sent nor compared. Query strings and bodies are sent as they
+// were recorded, redacted values and all, so requests that depended on a redacted token or password will not get the
+// response they got when recorded.
+type Replayer struct {
+	// Header is set on every request, for instance to put back the credentials the recording redacted.
+	Header http.Header
+	// IgnoreHeaders are not compared. Nor are Age, Date, Expires, Set-Cookie and X-Request-Id.
+	IgnoreHeaders []string
+}
+
+// ReadHARFile reads a HAR file, such as one written by HARArchive.
+func ReadHARFile(name string) (HAR, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return HAR{}, err
+	}
+	var har HAR
+	if err = json.Unmarshal(data, &har); err != nil {
+		return HAR{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return har, nil
+}
+
+// ReplayHAR replays every entry of har against target with a zero Replayer.
+func ReplayHAR(har HAR, target http.Handler) ([]ReplayResult, error) {
+	return Replayer{}.Replay(har, target)
+}
+
+// Replay replays the entries of har against target in order.
+func (rp Replayer) Replay(har HAR, target http.Handler) ([]ReplayResult, error) {
+	results := make([]ReplayResult, 0, len(har.Log.Entries))
+	for _, entry := range har.Log.Entries {
+		result, err := rp.ReplayEntry(entry, target)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// ReplayEntry replays a single entry against target. It fails only if the entry's request cannot be rebuilt.
+func (rp Replayer) ReplayEntry(entry HAREntry, target http.Handler) (ReplayResult, error) {
+	request, err := rp.request(entry.Request)
+	if err != nil {
+		return ReplayResult{}, fmt.Errorf("%s %s: %w", entry.Request.Method, entry.Request.URL, err)
+	}
+	bw := newBufferedWriter(nil, math.MaxInt64)
+	target.ServeHTTP(bw, request)
+	result := ReplayResult{
+		Entry:  entry,
+		Status: bw.status,
+		Header: bw.header,
+		Body:   bw.body.Bytes(),
+	}
+	if entry.Request.PostData != nil && entry.Request.PostData.Truncated {
+		result.Differences = append(result.Differences, "request body was truncated when recorded")
+	}
+	result.Differences = append(result.Differences, rp.compare(entry.Response, result)...)
+	return result, nil
+}
+
+func (rp Replayer) request(recorded HARRequest) (*http.Request, error) {
+	u, err := url.Parse(recorded.URL)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if recorded.PostData != nil {
+		if body, err = decodeBody(recorded.PostData.Text, recorded.PostData.Encoding); err != nil {
+			return nil, err
+		}
+	}
+	request, err := http.NewRequest(recorded.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.RequestURI = u.RequestURI()
+	request.RemoteAddr = "192.0.2.1:1234"
+	if recorded.PostData == nil {
+		request.Body = http.NoBody
+	}
+	for _, h := range recorded.Headers {
+		switch http.CanonicalHeaderKey(h.Name) {
+		case "Host", "Content-Length":
+			continue
+		}
+		if h.Value != RedactedValue {
+			request.Header.Add(h.Name, h.Value)
+		}
+	}
+	for name, values := range rp.Header {
+		request.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+	return request, nil
+}
+
+func (rp Replayer) compare(recorded HARResponse, result ReplayResult) []string {
+	var differences []string
+	if recorded.Status != result.Status {
+		differences = append(differences, fmt.Sprintf("status: recorded %d, replayed %d", recorded.Status,
+			result.Status))
+	}
+	differences = append(differences, rp.compareHeaders(recorded.Headers, result.Header)...)
+	if difference := compareBodies(recorded.Content, result.Body); difference != "" {
+		differences = append(differences, difference)
+	}
+	return differences
+}
+
+func (rp Replayer) compareHeaders(recorded []HARNameValue, replayed http.Header) []string {
+	want := make(http.Header)
+	for _, h := range recorded {
+		name := http.CanonicalHeaderKey(h.Name)
+		if h.Value == RedactedValue || containsHeader(volatileHeaders, name) ||
+			containsHeader(rp.IgnoreHeaders, name) {
+			continue
+		}
+		want[name] = append(want[name], h.Value)
+	}
+	var differences []string
+	for _, name := range sortedNames(want) {
+		w, got := strings.Join(want[name], ", "), strings.Join(replayed[name], ", ")
+		if w != got {
+			differences = append(differences, fmt.Sprintf("header %s: recorded %q, replayed %q", name, w, got))
+		}
+	}
+	return differences
+}
+
+// compareBodies compares a replayed body with the recorded content, only as far as the recording goes if it was
+// truncated.
+func compareBodies(recorded HARContent, replayed []byte) string {
+	want, err := decodeBody(recorded.Text, recorded.Encoding)
+	if err != nil {
+		return fmt.Sprintf("body: recorded content is invalid: %v", err)
+	}
+	got := replayed
+	if recorded.Truncated {
+		if int64(len(replayed)) != recorded.Size {
+			return fmt.Sprintf("body: recorded %d bytes, replayed %d", recorded.Size, len(replayed))
+		}
+		if len(got) > len(want) {
+			got = got[:len(want)]
+		}
+	} else if equalJSON(want, got) {
+		return ""
+	}
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	i := 0
+	for i < len(want) && i < len(got) && want[i] == got[i] {
+		i++
+	}
+	return fmt.Sprintf("body differs at byte %d: recorded %q, replayed %q", i, excerpt(want, i), excerpt(got, i))
+}
+
+func equalJSON(a, b []byte) bool {
+	if !json.Valid(a) || !json.Valid(b) {
+		return false
+	}
+	var av, bv interface{}
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func excerpt(b []byte, from int) string {
+	const max = 40
+	if len(b)-from > max {
+		return string(b[from:from+max]) + "..."
+	}
+	return string(b[from:])
+}
+
+func decodeBody(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+func sortedNames(h http.Header) []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,135 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+type harLog struct {
+	mu  sync.Mutex
+	har handler.HAR
+}
+
+func (hl *harLog) WriteEntry(entry handler.HAREntry) error {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	hl.har.Log.Entries = append(hl.har.Log.Entries, entry)
+	return nil
+}
+
+var _ = Describe("Replayer", func() {
+	var (
+		log      *harLog
+		greeting string
+		app      http.Handler
+	)
+
+	BeforeEach(func() {
+		log = &harLog{}
+		greeting = "hello"
+		app = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer letmein" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Date", "whenever")
+			w.Header().Set("X-Greeting", greeting)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"greeting": greeting,
+				"echo":     string(body),
+				"path":     r.URL.RequestURI(),
+			})
+		})
+	})
+
+	record := func(method, target, body string) {
+		rh, err := handler.NewRecordHandler(app, log)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer letmein")
+		rh.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	replayer := handler.Replayer{Header: http.Header{"Authorization": {"Bearer letmein"}}}
+
+	It("should match a handler that still behaves as recorded", func() {
+		record("POST", "/greet?name=sam", "hi")
+		record("GET", "/greet", "")
+		results, err := replayer.Replay(log.har, app)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(2))
+		for _, result := range results {
+			Expect(result.Differences).To(BeEmpty())
+			Expect(result.Matched()).To(BeTrue())
+		}
+		Expect(string(results[0].Body)).To(ContainSubstring(`"echo":"hi"`))
+		Expect(string(results[0].Body)).To(ContainSubstring(`"path":"/greet?name=sam"`))
+	})
+
+	It("should describe how responses differ", func() {
+		record("GET", "/greet", "")
+		greeting = "goodbye"
+		results, err := replayer.Replay(log.har, app)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results[0].Matched()).To(BeFalse())
+		Expect(results[0].Differences).To(ConsistOf(
+			`header X-Greeting: recorded "hello", replayed "goodbye"`,
+			MatchRegexp(`^body differs at byte \d+: recorded "hello.*", replayed "goodbye.*"$`),
+		))
+
+		results, err = handler.ReplayHAR(log.har, app)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results[0].Differences).To(ContainElement("status: recorded 200, replayed 401"))
+	})
+
+	It("should ignore the headers it is told to", func() {
+		record("GET", "/greet", "")
+		greeting = "hello!"
+		results, err := handler.Replayer{Header: replayer.Header, IgnoreHeaders: []string{"x-greeting"}}.
+			Replay(log.har, app)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results[0].Differences).To(HaveLen(1))
+		Expect(results[0].Differences[0]).To(HavePrefix("body differs"))
+	})
+
+	It("should fail on entries it cannot rebuild", func() {
+		_, err := handler.ReplayHAR(handler.HAR{Log: handler.HARLog{Entries: []handler.HAREntry{
+			{Request: handler.HARRequest{Method: "GET", URL: "http://[::1"}},
+		}}}, app)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read HAR files", func() {
+		dir, err := ioutil.TempDir("", "replay")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		archive, err := handler.NewHARArchive(dir)
+		Expect(err).ToNot(HaveOccurred())
+		rh, err := handler.NewRecordHandler(app, archive)
+		Expect(err).ToNot(HaveOccurred())
+		rh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/greet", nil))
+		Expect(archive.Close()).To(Succeed())
+		files, err := archive.Files()
+		Expect(err).ToNot(HaveOccurred())
+
+		har, err := handler.ReadHARFile(files[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(har.Log.Entries).To(HaveLen(1))
+		Expect(har.Log.Entries[0].Response.Status).To(Equal(http.StatusUnauthorized))
+
+		_, err = handler.ReadHARFile(filepath.Join(dir, "missing.har"))
+		Expect(err).To(HaveOccurred())
+	})
+})
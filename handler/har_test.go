@@ -0,0 +1,100 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("HARArchive", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "har")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	entry := func(path string) handler.HAREntry {
+		return handler.HAREntry{
+			StartedDateTime: time.Date(2019, 10, 13, 12, 0, 0, 0, time.UTC),
+			Request:         handler.HARRequest{Method: "GET", URL: "http://example.com" + path},
+			Response:        handler.HARResponse{Status: 200},
+		}
+	}
+
+	read := func(name string) handler.HAR {
+		data, err := ioutil.ReadFile(name)
+		Expect(err).ToNot(HaveOccurred())
+		var har handler.HAR
+		Expect(json.Unmarshal(data, &har)).To(Succeed())
+		return har
+	}
+
+	It("should keep the file a complete HAR document after every entry", func() {
+		archive, err := handler.NewHARArchive(filepath.Join(dir, "recordings"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(archive.WriteEntry(entry("/a"))).To(Succeed())
+
+		files, err := archive.Files()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		Expect(filepath.Base(files[0])).To(MatchRegexp(`^requests-\d{8}T\d{6}\.\d{9}-0001\.har$`))
+		har := read(files[0])
+		Expect(har.Log.Version).To(Equal("1.2"))
+		Expect(har.Log.Creator.Name).To(Equal("github.com/sahilm/handlers"))
+		Expect(har.Log.Entries).To(HaveLen(1))
+
+		Expect(archive.WriteEntry(entry("/b"))).To(Succeed())
+		Expect(archive.Close()).To(Succeed())
+		har = read(files[0])
+		Expect(har.Log.Entries).To(HaveLen(2))
+		Expect(har.Log.Entries[1].Request.URL).To(Equal("http://example.com/b"))
+		Expect(har.Log.Entries[1].StartedDateTime).To(Equal(entry("/b").StartedDateTime))
+	})
+
+	It("should rotate files and keep only the newest", func() {
+		archive, err := handler.NewHARArchive(dir, handler.WithHARRotation(600, 2), handler.WithHARPrefix("orders"))
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 6; i++ {
+			Expect(archive.WriteEntry(entry("/" + strings.Repeat("x", 200)))).To(Succeed())
+		}
+		Expect(archive.Close()).To(Succeed())
+
+		files, err := archive.Files()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		total := 0
+		for _, name := range files {
+			Expect(filepath.Base(name)).To(HavePrefix("orders-"))
+			total += len(read(name).Log.Entries)
+		}
+		Expect(total).To(BeNumerically("<", 6))
+		Expect(files[1]).To(HaveSuffix("-0006.har"))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewHARArchive("")
+		Expect(err).To(HaveOccurred())
+		for _, opt := range []handler.HARArchiveOption{
+			handler.WithHARRotation(0, 1),
+			handler.WithHARRotation(1, 0),
+			handler.WithHARPrefix(""),
+			handler.WithHARPrefix("a/b"),
+		} {
+			_, err = handler.NewHARArchive(dir, opt)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// IdempotencyScopeFunc namespaces idempotency keys, typically by client, so that one client cannot replay another's
+// responses by guessing their keys.
+type IdempotencyScopeFunc func(r *http.Request) string
+
+// UserOrClientIPScope scopes keys by the RequestMetadata User an authentication handler further out set, or failing
+// that by the client address resolver works out.
+func UserOrClientIPScope(resolver ClientIPResolver) IdempotencyScopeFunc {
+	return func(r *http.Request) string {
+		if user := pendingMetadata(r).User; user != "" {
+			return "user:" + user
+		}
+		return "ip:" + resolver(r)
+	}
+}
+
+// IdempotencyHandler runs requests carrying an Idempotency-Key header at most once per key. The first request with a
+// key runs and its response is recorded; retries within TTL get that response replayed, marked with an
+// Idempotent-Replayed header, without Next running again. Retries that arrive while the first request is still
+// running get a 409, and requests reusing a key with a different method, URI or body get a 422.
+//
+// Server errors are not recorded, so a retry after one runs afresh. Neither are requests that panic, nor responses
+// larger than MaxBytes.
+type IdempotencyHandler struct {
+	Store       IdempotencyStore
+	TTL         time.Duration
+	LockTimeout time.Duration
+	Methods     []string
+	MaxBytes    int64
+	ScopeFunc   IdempotencyScopeFunc
+	KeyRequired bool
+	Responder   Responder
+	Next        http.Handler
+	clock       Clock
+}
+
+type IdempotencyHandlerOption func(*IdempotencyHandler) error
+
+// NewIdempotencyHandler applies to POST and PATCH requests with bodies of up to 1MiB, keeps responses for 24 hours and
+// gives up on a first request that has not finished after a minute, all in a MemoryIdempotencyStore of 10000 keys
+// scoped by UserOrClientIPScope(RemoteAddrClientIP), unless told otherwise.
+func NewIdempotencyHandler(next http.Handler, opts ...IdempotencyHandlerOption) (IdempotencyHandler, error) {
+	if next == nil {
+		return IdempotencyHandler{}, ErrNilNext
+	}
+	store, err := NewMemoryIdempotencyStore(10000)
+	if err != nil {
+		return IdempotencyHandler{}, err
+	}
+	ih := IdempotencyHandler{
+		Store:       store,
+		TTL:         24 * time.Hour,
+		LockTimeout: time.Minute,
+		Methods:     []string{http.MethodPost, http.MethodPatch},
+		MaxBytes:    1 << 20,
+		ScopeFunc:   UserOrClientIPScope(RemoteAddrClientIP),
+		Responder:   NegotiatedResponder,
+		Next:        next,
+		clock:       time.Now,
+	}
+	for _, opt := range opts {
+		if optErr := opt(&ih); optErr != nil {
+			return IdempotencyHandler{}, optErr
+		}
+	}
+	return ih, nil
+}
+
+func WithIdempotencyStore(store IdempotencyStore) IdempotencyHandlerOption {
+	return func(ih *IdempotencyHandler) error {
+		if store == nil {
+			return errors.New("handler: idempotency store must not be nil")
+		}
+		ih.Store = store
+		return nil
+	}
+}
+
+// WithIdempotencyTTL sets how long responses are replayed for, and lockTimeout how long a first request may run
+// before retries are allowed to run too.
+func WithIdempotencyTTL(ttl, lockTimeout time.Duration) IdempotencyHandlerOption {
+	return func(ih *IdempotencyHandler) error {
+		if ttl <= 0 || lockTimeout <= 0 {
+			return errors.New("handler: idempotency TTL and lock timeout must be positive")
+		}
+		ih.TTL = ttl
+		ih.LockTimeout = lockTimeout
+		return nil
+	}
+}
+
+func WithIdempotentMethods(methods ...string) IdempotencyHandlerOption {
+	return func(ih *IdempotencyHandler) error {
+		if len(methods) == 0 {
+			return errors.New("handler: at least one method is required")
+		}
+		ih.Methods = methods
+		return nil
+	}
+}
+
+// WithIdempotencyMaxBytes limits the bodies that are read to fingerprint requests, and the responses that are recorded.
+// Larger requests get a 413; larger responses are passed on but not recorded, so a retry runs afresh.
+func WithIdempotencyMaxBytes(maxBytes int64) IdempotencyHandlerOption {
+	return func(ih *IdempotencyHandler) error {
+		if maxBytes <= 0 {
+			return errors.New("handler: max idempotency bytes must be positive")
+		}
+		ih.MaxBytes = maxBytes
+		return nil
+	}
+}
+
+func WithIdempotencyScope(scopeFunc IdempotencyScopeFunc) IdempotencyHandlerOption {
+	return func(ih *IdempotencyHandler) error {
+		if scopeFunc == nil {
+			return errors.New("handler: scope func must not be nil")
+		}
+		ih.ScopeFunc = scopeFunc
+		return nil
+	}
+}
+
+// WithIdempotencyKeyRequired answers requests without an Idempotency-Key with a 400 instead of just running them.
+func WithIdempotencyKeyRequired() IdempotencyHandlerOption {
+	return func(ih *IdempotencyHandler) error {
+		ih.KeyRequired = true
+		return nil
+	}
+}
+
+func WithIdempotencyResponder(responder Responder) IdempotencyHandlerOption {
+	return func(ih *IdempotencyHandler) error {
+		if responder == nil {
+			return errors.New("handler: responder must not be nil")
+		}
+		ih.Responder = responder
+		return nil
+	}
+}
+
+func WithIdempotencyClock(clock Clock) IdempotencyHandlerOption {
+	return func(ih *IdempotencyHandler) error {
+		if clock == nil {
+			return errors.New("handler: clock must not be nil")
+		}
+		ih.clock = clock
+		return nil
+	}
+}
+
+func (ih IdempotencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if !containsFold(ih.Methods, r.Method) || (key == "" && !ih.KeyRequired) {
+		ih.Next.ServeHTTP(w, r)
+		return
+	}
+	r, key, fingerprint, ok := ih.prepare(w, r, key)
+	if !ok {
+		return
+	}
+	ih.claim(w, r, key, fingerprint)
+}
+
+// prepare checks key and works out the store key and fingerprint for r, answering the request itself if it cannot. It
+// returns a copy of r for Next to read the buffered body from.
+func (ih IdempotencyHandler) prepare(w http.ResponseWriter, r *http.Request,
+	key string) (*http.Request, string, string, bool) {
+
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		ih.respond(w, r, http.StatusBadRequest, "an Idempotency-Key of 1 to 255 characters is required")
+		return nil, "", "", false
+	}
+	r2, fingerprint, ok := ih.fingerprint(w, r)
+	if !ok {
+		return nil, "", "", false
+	}
+	if ih.ScopeFunc != nil {
+		key = ih.ScopeFunc(r) + "\x00" + key
+	}
+	return r2, key, fingerprint, true
+}
+
+func (ih IdempotencyHandler) claim(w http.ResponseWriter, r *http.Request, key, fingerprint string) {
+	now := ih.now()
+	claim := IdempotencyRecord{Fingerprint: fingerprint, Expires: now.Add(ih.LockTimeout)}
+	existing, claimed, err := ih.Store.Claim(key, claim, now)
+	switch {
+	case err != nil:
+		ih.respond(w, r, http.StatusServiceUnavailable, "idempotency store unavailable")
+	case claimed:
+		ih.execute(w, r, key, claim)
+	case existing.Fingerprint != fingerprint:
+		ih.respond(w, r, http.StatusUnprocessableEntity, "Idempotency-Key reused for a different request")
+	case !existing.Complete:
+		w.Header().Set("Retry-After", "1")
+		ih.respond(w, r, http.StatusConflict, "a request with this Idempotency-Key is in progress")
+	default:
+		replay(w, r, existing)
+	}
+}
+
+// fingerprint hashes the method, URI and body. It returns a copy of r with the body buffered for Next to read again,
+// leaving r itself untouched.
+func (ih IdempotencyHandler) fingerprint(w http.ResponseWriter, r *http.Request) (*http.Request, string, bool) {
+	var body []byte
+	r2 := r
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(io.LimitReader(r.Body, ih.MaxBytes+1)); err != nil {
+			ih.respond(w, r, http.StatusBadRequest, "could not read request body")
+			return nil, "", false
+		}
+		if int64(len(body)) > ih.MaxBytes {
+			ih.respond(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+			return nil, "", false
+		}
+		r2 = new(http.Request)
+		*r2 = *r
+		r2.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	_, _ = h.Write(body)
+	return r2, hex.EncodeToString(h.Sum(nil)), true
+}
+
+func (ih IdempotencyHandler) execute(w http.ResponseWriter, r *http.Request, key string, claim IdempotencyRecord) {
+	rw := newRecordingWriter(w, ih.MaxBytes)
+	defer func() {
+		if p := recover(); p != nil {
+			_ = ih.Store.Delete(key, claim)
+			panic(p)
+		}
+		rw.finish()
+		if rw.status >= http.StatusInternalServerError || rw.overflowed {
+			_ = ih.Store.Delete(key, claim)
+			return
+		}
+		record := IdempotencyRecord{
+			Fingerprint: claim.Fingerprint,
+			Complete:    true,
+			Status:      rw.status,
+			Header:      rw.sent,
+			Body:        rw.body.Bytes(),
+			Expires:     ih.now().Add(ih.TTL),
+		}
+		if err := ih.Store.Put(key, claim, record); err != nil {
+			// free the key rather than leave retries locked out until the claim expires
+			_ = ih.Store.Delete(key, claim)
+		}
+	}()
+	ih.Next.ServeHTTP(rw, r)
+}
+
+func replay(w http.ResponseWriter, r *http.Request, record IdempotencyRecord) {
+	h := w.Header()
+	mergeHeader(h, record.Header, false)
+	h.Set(IdempotentReplayedHeader, "true")
+	UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+		metadata.IdempotentReplay = true
+	})
+	w.WriteHeader(record.Status)
+	_, _ = w.Write(record.Body)
+}
+
+func (ih IdempotencyHandler) respond(w http.ResponseWriter, r *http.Request, status int, message string) {
+	responder := ih.Responder
+	if responder == nil {
+		responder = NegotiatedResponder
+	}
+	responder(w, r, status, message)
+}
+
+func (ih IdempotencyHandler) now() time.Time {
+	if ih.clock == nil {
+		return time.Now()
+	}
+	return ih.clock()
+}
+
+// mergeHeader copies src, the headers one handler set on a map of its own, into dst, where handlers further out may
+// have set headers already. Vary values are combined; for anything else replace decides which side wins.
+func mergeHeader(dst, src http.Header, replace bool) {
+	for name, values := range src {
+		if name == "Vary" {
+			for _, value := range values {
+				if !containsFold(dst[name], value) {
+					dst[name] = append(dst[name], value)
+				}
+			}
+			continue
+		}
+		if replace || len(dst[name]) == 0 {
+			dst[name] = append([]string(nil), values...)
+		}
+	}
+}
+
+// recordingWriter keeps a copy of the response it passes through, giving up on the copy once it grows past maxBytes
+// unless that is zero. Next writes its headers to a map of its own, so that sent holds only those, not the ones
+// handlers further out set for this request alone.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	sent        http.Header
+	body        bytes.Buffer
+	maxBytes    int64
+	overflowed  bool
+	wroteHeader bool
+}
+
+func newRecordingWriter(w http.ResponseWriter, maxBytes int64) *recordingWriter {
+	return &recordingWriter{ResponseWriter: w, status: http.StatusOK, header: make(http.Header), maxBytes: maxBytes}
+}
+
+func (rw *recordingWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *recordingWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+	mergeHeader(rw.ResponseWriter.Header(), rw.header, true)
+	if statusCode >= http.StatusOK {
+		rw.wroteHeader = true
+		rw.status = statusCode
+		rw.sent = rw.header.Clone()
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// finish sends the headers of a response Next wrote nothing for.
+func (rw *recordingWriter) finish() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.maxBytes > 0 && int64(rw.body.Len()+len(p)) > rw.maxBytes {
+		rw.overflowed = true
+		rw.body = bytes.Buffer{}
+	}
+	if !rw.overflowed {
+		rw.body.Write(p)
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recordingWriter) Flush() {
+	rw.finish()
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,359 @@
+package handler_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+type failingIdempotencyStore struct{}
+
+func (failingIdempotencyStore) Claim(string, handler.IdempotencyRecord,
+	time.Time) (handler.IdempotencyRecord, bool, error) {
+
+	return handler.IdempotencyRecord{}, false, errors.New("unavailable")
+}
+
+func (failingIdempotencyStore) Put(string, handler.IdempotencyRecord, handler.IdempotencyRecord) error {
+	return errors.New("unavailable")
+}
+
+func (failingIdempotencyStore) Delete(string, handler.IdempotencyRecord) error {
+	return errors.New("unavailable")
+}
+
+// forgetfulIdempotencyStore loses every response, as if the server running the first request had died.
+type forgetfulIdempotencyStore struct {
+	*handler.MemoryIdempotencyStore
+}
+
+func (forgetfulIdempotencyStore) Put(string, handler.IdempotencyRecord, handler.IdempotencyRecord) error {
+	return nil
+}
+
+func (forgetfulIdempotencyStore) Delete(string, handler.IdempotencyRecord) error {
+	return nil
+}
+
+var _ = Describe("IdempotencyHandler", func() {
+	var (
+		now    time.Time
+		calls  int
+		status int
+		next   http.Handler
+		ih     handler.IdempotencyHandler
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1571000000, 0)
+		calls = 0
+		status = http.StatusCreated
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			w.Header().Set("Location", "/payments/1")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("charged " + string(body)))
+		})
+		var err error
+		ih, err = handler.NewIdempotencyHandler(next,
+			handler.WithIdempotencyClock(func() time.Time { return now }),
+			handler.WithIdempotencyTTL(time.Hour, time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	serve := func(h http.Handler, method, uri, key, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, uri, strings.NewReader(body))
+		if key != "" {
+			request.Header.Set(handler.IdempotencyKeyHeader, key)
+		}
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	It("should replay the recorded response to retries", func() {
+		first := serve(ih, "POST", "/payments", "k1", "10")
+		Expect(first.Code).To(Equal(http.StatusCreated))
+		Expect(first.Header()).ToNot(HaveKey(handler.IdempotentReplayedHeader))
+
+		retry := serve(ih, "POST", "/payments", "k1", "10")
+		Expect(calls).To(Equal(1))
+		Expect(retry.Code).To(Equal(http.StatusCreated))
+		Expect(retry.Body.String()).To(Equal("charged 10"))
+		Expect(retry.Header().Get("Location")).To(Equal("/payments/1"))
+		Expect(retry.Header().Get(handler.IdempotentReplayedHeader)).To(Equal("true"))
+	})
+
+	It("should only replay the headers Next set", func() {
+		outer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Set-Cookie", "session="+r.Header.Get("X-Session"))
+			ih.ServeHTTP(w, r)
+		})
+		request := httptest.NewRequest("POST", "/payments", strings.NewReader("10"))
+		request.Header.Set(handler.IdempotencyKeyHeader, "k1")
+		request.Header.Set("X-Session", "first")
+		first := httptest.NewRecorder()
+		outer.ServeHTTP(first, request)
+		Expect(first.Header().Get("Set-Cookie")).To(Equal("session=first"))
+		Expect(first.Header().Get("Location")).To(Equal("/payments/1"))
+
+		request = httptest.NewRequest("POST", "/payments", strings.NewReader("10"))
+		request.Header.Set(handler.IdempotencyKeyHeader, "k1")
+		request.Header.Set("X-Session", "second")
+		retry := httptest.NewRecorder()
+		outer.ServeHTTP(retry, request)
+		Expect(retry.Header().Get(handler.IdempotentReplayedHeader)).To(Equal("true"))
+		Expect(retry.Header().Get("Set-Cookie")).To(Equal("session=second"))
+		Expect(retry.Header().Get("Location")).To(Equal("/payments/1"))
+	})
+
+	It("should leave the caller's request body alone", func() {
+		request := httptest.NewRequest("POST", "/payments", strings.NewReader("10"))
+		request.Header.Set(handler.IdempotencyKeyHeader, "k1")
+		body := request.Body
+		ih.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(request.Body).To(BeIdenticalTo(body))
+	})
+
+	It("should mark replays in the request metadata", func() {
+		var replays []bool
+		rh, err := handler.NewRequestsHandlerWithOptions(ih, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, metadata handler.RequestMetadata) {
+				replays = append(replays, metadata.IdempotentReplay)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		serve(rh, "POST", "/payments", "k1", "10")
+		serve(rh, "POST", "/payments", "k1", "10")
+		Expect(replays).To(Equal([]bool{false, true}))
+	})
+
+	It("should run the request again once the record expires", func() {
+		serve(ih, "POST", "/payments", "k1", "10")
+		now = now.Add(time.Hour + time.Second)
+		retry := serve(ih, "POST", "/payments", "k1", "10")
+		Expect(calls).To(Equal(2))
+		Expect(retry.Header()).ToNot(HaveKey(handler.IdempotentReplayedHeader))
+	})
+
+	It("should reject keys reused for different requests", func() {
+		serve(ih, "POST", "/payments", "k1", "10")
+		for _, recorder := range []*httptest.ResponseRecorder{
+			serve(ih, "POST", "/payments", "k1", "20"),
+			serve(ih, "POST", "/refunds", "k1", "10"),
+			serve(ih, "PATCH", "/payments", "k1", "10"),
+		} {
+			Expect(recorder.Code).To(Equal(http.StatusUnprocessableEntity))
+		}
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should turn away duplicates while the first request is running", func() {
+		started, release := make(chan struct{}), make(chan struct{})
+		blocking, err := handler.NewIdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer GinkgoRecover()
+			Expect(serve(blocking, "POST", "/payments", "k1", "10").Code).To(Equal(http.StatusOK))
+		}()
+		<-started
+		duplicate := serve(blocking, "POST", "/payments", "k1", "10")
+		close(release)
+		wg.Wait()
+
+		Expect(duplicate.Code).To(Equal(http.StatusConflict))
+		Expect(duplicate.Header().Get("Retry-After")).To(Equal("1"))
+		Expect(serve(blocking, "POST", "/payments", "k1", "10").Header().Get(handler.IdempotentReplayedHeader)).
+			To(Equal("true"))
+	})
+
+	It("should let duplicates run once the first request has been running too long", func() {
+		store, err := handler.NewMemoryIdempotencyStore(10)
+		Expect(err).ToNot(HaveOccurred())
+		ih.Store = forgetfulIdempotencyStore{store}
+
+		Expect(serve(ih, "POST", "/payments", "k1", "10").Code).To(Equal(http.StatusCreated))
+		Expect(serve(ih, "POST", "/payments", "k1", "10").Code).To(Equal(http.StatusConflict))
+		now = now.Add(time.Minute)
+		Expect(serve(ih, "POST", "/payments", "k1", "10").Code).To(Equal(http.StatusCreated))
+		Expect(calls).To(Equal(2))
+	})
+
+	It("should not let a request that outran its claim overwrite a newer one", func() {
+		started, release := make(chan struct{}), make(chan struct{})
+		slow, err := handler.NewIdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				close(started)
+				<-release
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}),
+			handler.WithIdempotencyClock(func() time.Time { return now }),
+			handler.WithIdempotencyTTL(time.Hour, time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer GinkgoRecover()
+			Expect(serve(slow, "POST", "/payments", "k1", "10").Code).To(Equal(http.StatusBadGateway))
+		}()
+		<-started
+		now = now.Add(time.Minute)
+		Expect(serve(slow, "POST", "/payments", "k1", "10").Code).To(Equal(http.StatusCreated))
+		close(release)
+		<-done
+
+		retry := serve(slow, "POST", "/payments", "k1", "10")
+		Expect(retry.Code).To(Equal(http.StatusCreated))
+		Expect(retry.Header().Get(handler.IdempotentReplayedHeader)).To(Equal("true"))
+		Expect(calls).To(Equal(2))
+	})
+
+	It("should not record server errors", func() {
+		status = http.StatusBadGateway
+		serve(ih, "POST", "/payments", "k1", "10")
+		status = http.StatusCreated
+		retry := serve(ih, "POST", "/payments", "k1", "10")
+		Expect(calls).To(Equal(2))
+		Expect(retry.Code).To(Equal(http.StatusCreated))
+	})
+
+	It("should not record requests that panic", func() {
+		panicking, err := handler.NewIdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				panic("boom")
+			}
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(func() { serve(panicking, "POST", "/payments", "k1", "10") }).To(Panic())
+		Expect(serve(panicking, "POST", "/payments", "k1", "10").Header()).
+			ToNot(HaveKey(handler.IdempotentReplayedHeader))
+		Expect(calls).To(Equal(2))
+	})
+
+	It("should not record responses over the limit", func() {
+		small, err := handler.NewIdempotencyHandler(next, handler.WithIdempotencyMaxBytes(5))
+		Expect(err).ToNot(HaveOccurred())
+		serve(small, "POST", "/payments", "k1", "10")
+		retry := serve(small, "POST", "/payments", "k1", "10")
+		Expect(calls).To(Equal(2))
+		Expect(retry.Body.String()).To(Equal("charged 10"))
+		Expect(retry.Header()).ToNot(HaveKey(handler.IdempotentReplayedHeader))
+	})
+
+	It("should scope keys by user, or by client address for anonymous requests", func() {
+		rh, err := handler.NewRequestsHandlerWithOptions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user := r.Header.Get("X-User"); user != "" {
+				handler.UpdateRequestMetadata(r, func(metadata *handler.RequestMetadata) {
+					metadata.User = user
+				})
+			}
+			ih.ServeHTTP(w, r)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		send := func(user, remoteAddr string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("POST", "/payments", strings.NewReader("10"))
+			request.Header.Set(handler.IdempotencyKeyHeader, "k1")
+			request.Header.Set("X-User", user)
+			request.RemoteAddr = remoteAddr
+			rh.ServeHTTP(recorder, request)
+			return recorder
+		}
+		send("", "10.0.0.1:1234")
+		send("", "10.0.0.2:1234")
+		Expect(calls).To(Equal(2))
+		Expect(send("", "10.0.0.1:5678").Header().Get(handler.IdempotentReplayedHeader)).To(Equal("true"))
+
+		send("alice", "10.0.0.1:1234")
+		Expect(calls).To(Equal(3))
+		Expect(send("alice", "10.0.0.3:1234").Header().Get(handler.IdempotentReplayedHeader)).To(Equal("true"))
+		send("bob", "10.0.0.3:1234")
+		Expect(calls).To(Equal(4))
+	})
+
+	It("should scope keys", func() {
+		scoped, err := handler.NewIdempotencyHandler(next, handler.WithIdempotencyScope(func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		for _, client := range []string{"a", "b"} {
+			request := httptest.NewRequest("POST", "/payments", strings.NewReader("10"))
+			request.Header.Set(handler.IdempotencyKeyHeader, "k1")
+			request.Header.Set("X-Client", client)
+			scoped.ServeHTTP(httptest.NewRecorder(), request)
+		}
+		Expect(calls).To(Equal(2))
+	})
+
+	It("should leave other requests alone", func() {
+		serve(ih, "GET", "/payments", "k1", "")
+		serve(ih, "GET", "/payments", "k1", "")
+		serve(ih, "POST", "/payments", "", "10")
+		serve(ih, "POST", "/payments", "", "10")
+		Expect(calls).To(Equal(4))
+	})
+
+	It("should reject bad keys", func() {
+		required, err := handler.NewIdempotencyHandler(next, handler.WithIdempotencyKeyRequired(),
+			handler.WithIdempotentMethods("POST", "PUT"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serve(required, "PUT", "/payments/1", "", "10").Code).To(Equal(http.StatusBadRequest))
+		Expect(serve(required, "POST", "/payments", strings.Repeat("k", 256), "10").Code).
+			To(Equal(http.StatusBadRequest))
+		Expect(serve(required, "GET", "/payments", "", "").Code).To(Equal(http.StatusCreated))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should reject bodies over the limit", func() {
+		small, err := handler.NewIdempotencyHandler(next, handler.WithIdempotencyMaxBytes(2))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serve(small, "POST", "/payments", "k1", "100").Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(calls).To(BeZero())
+	})
+
+	It("should fail closed when the store is unavailable", func() {
+		ih.Store = failingIdempotencyStore{}
+		Expect(serve(ih, "POST", "/payments", "k1", "10").Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(calls).To(BeZero())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewIdempotencyHandler(nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		for _, opt := range []handler.IdempotencyHandlerOption{
+			handler.WithIdempotencyStore(nil),
+			handler.WithIdempotencyTTL(0, time.Minute),
+			handler.WithIdempotencyTTL(time.Hour, 0),
+			handler.WithIdempotentMethods(),
+			handler.WithIdempotencyMaxBytes(0),
+			handler.WithIdempotencyScope(nil),
+			handler.WithIdempotencyResponder(nil),
+			handler.WithIdempotencyClock(nil),
+		} {
+			_, err = handler.IdempotencyMiddleware(opt)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
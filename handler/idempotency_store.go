@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyRecord is what an IdempotencyHandler keeps for each key: the fingerprint of the request that first used
+// it and, once Complete, the response to replay to retries.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Complete    bool
+	Status      int
+	Header      http.Header
+	Body        []byte
+	Expires     time.Time
+}
+
+// IdempotencyStore holds IdempotencyRecords. Implementations shared between servers must make Claim atomic, or
+// duplicates racing to different servers will both run.
+type IdempotencyStore interface {
+	// Claim stores record under key, unless a record that has not expired by now is there already, in which case it
+	// returns that one and false.
+	Claim(key string, record IdempotencyRecord, now time.Time) (IdempotencyRecord, bool, error)
+	// Put replaces the record under key, provided it is still claim, the record the request was claimed with. A request
+	// that outran its claim finds it replaced by a retry's, and gets ErrIdempotencyClaimLost.
+	Put(key string, claim, record IdempotencyRecord) error
+	// Delete forgets key, so that the next request with it runs afresh, provided the record under it is still claim.
+	Delete(key string, claim IdempotencyRecord) error
+}
+
+var (
+	// ErrIdempotencyStoreFull is returned by a MemoryIdempotencyStore whose keys are all claimed by requests still
+	// running.
+	ErrIdempotencyStoreFull = errors.New("handler: idempotency store full")
+	// ErrIdempotencyClaimLost is returned by Put once another request has claimed the key.
+	ErrIdempotencyClaimLost = errors.New("handler: idempotency claim lost")
+)
+
+// sameClaim reports whether record is still the claim a request made, rather than a later request's claim or the
+// response one recorded.
+func sameClaim(record, claim IdempotencyRecord) bool {
+	return !record.Complete && record.Fingerprint == claim.Fingerprint && record.Expires.Equal(claim.Expires)
+}
+
+// MemoryIdempotencyStore keeps the records for up to maxKeys keys in memory. Beyond that it evicts the least recently
+// used complete or expired records, but never the claims of requests still running, as that would let their
+// duplicates run too. It refuses new claims with ErrIdempotencyStoreFull rather than evict those.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries *lru
+	maxKeys int
+}
+
+func NewMemoryIdempotencyStore(maxKeys int) (*MemoryIdempotencyStore, error) {
+	if maxKeys <= 0 {
+		return nil, errors.New("handler: idempotency store size must be positive")
+	}
+	return &MemoryIdempotencyStore{entries: newLRU(0), maxKeys: maxKeys}, nil
+}
+
+func (ms *MemoryIdempotencyStore) Claim(key string, record IdempotencyRecord,
+	now time.Time) (IdempotencyRecord, bool, error) {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if existing, ok := ms.entries.get(key); ok && existing.(IdempotencyRecord).Expires.After(now) {
+		return existing.(IdempotencyRecord), false, nil
+	}
+	if !ms.makeRoom(key, now) {
+		return IdempotencyRecord{}, false, ErrIdempotencyStoreFull
+	}
+	ms.entries.add(key, record)
+	return record, true, nil
+}
+
+func (ms *MemoryIdempotencyStore) Put(key string, claim, record IdempotencyRecord) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if !ms.claimed(key, claim) {
+		return ErrIdempotencyClaimLost
+	}
+	ms.entries.add(key, record)
+	return nil
+}
+
+// claimed reports whether the record under key is still claim.
+func (ms *MemoryIdempotencyStore) claimed(key string, claim IdempotencyRecord) bool {
+	existing, ok := ms.entries.peek(key)
+	return ok && sameClaim(existing.(IdempotencyRecord), claim)
+}
+
+// makeRoom evicts records until there is room to add key, and reports whether there is.
+func (ms *MemoryIdempotencyStore) makeRoom(key string, now time.Time) bool {
+	if _, ok := ms.entries.peek(key); ok {
+		return true
+	}
+	for ms.entries.len() >= ms.maxKeys {
+		evicted := ms.entries.removeOldestWhere(func(value interface{}) bool {
+			record := value.(IdempotencyRecord)
+			return record.Complete || !record.Expires.After(now)
+		})
+		if !evicted {
+			return false
+		}
+	}
+	return true
+}
+
+func (ms *MemoryIdempotencyStore) Delete(key string, claim IdempotencyRecord) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.claimed(key, claim) {
+		ms.entries.remove(key)
+	}
+	return nil
+}
+
+func (ms *MemoryIdempotencyStore) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.entries.len()
+}
@@ -0,0 +1,120 @@
+package handler_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("MemoryIdempotencyStore", func() {
+	now := time.Unix(1571000000, 0)
+
+	It("should only let one claim a key until it expires", func() {
+		store, err := handler.NewMemoryIdempotencyStore(10)
+		Expect(err).ToNot(HaveOccurred())
+		first := handler.IdempotencyRecord{Fingerprint: "a", Expires: now.Add(time.Minute)}
+		_, claimed, err := store.Claim("k", first, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeTrue())
+
+		existing, claimed, err := store.Claim("k", handler.IdempotencyRecord{Fingerprint: "b"}, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeFalse())
+		Expect(existing).To(Equal(first))
+
+		_, claimed, err = store.Claim("k", handler.IdempotencyRecord{Fingerprint: "b"}, now.Add(time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeTrue())
+	})
+
+	It("should replace and forget claims", func() {
+		store, err := handler.NewMemoryIdempotencyStore(10)
+		Expect(err).ToNot(HaveOccurred())
+		claim := handler.IdempotencyRecord{Fingerprint: "a", Expires: now.Add(time.Minute)}
+		_, _, err = store.Claim("k", claim, now)
+		Expect(err).ToNot(HaveOccurred())
+		complete := handler.IdempotencyRecord{Fingerprint: "a", Complete: true, Status: 201, Expires: now.Add(time.Hour)}
+		Expect(store.Put("k", claim, complete)).To(Succeed())
+		existing, claimed, err := store.Claim("k", handler.IdempotencyRecord{}, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeFalse())
+		Expect(existing).To(Equal(complete))
+		Expect(store.Delete("k", claim)).To(Succeed())
+		Expect(store.Len()).To(Equal(1))
+
+		_, _, err = store.Claim("other", claim, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.Delete("other", claim)).To(Succeed())
+		Expect(store.Len()).To(Equal(1))
+		Expect(store.Delete("other", claim)).To(Succeed())
+	})
+
+	It("should not let a stale claim replace or forget a newer one", func() {
+		store, err := handler.NewMemoryIdempotencyStore(10)
+		Expect(err).ToNot(HaveOccurred())
+		stale := handler.IdempotencyRecord{Fingerprint: "a", Expires: now.Add(time.Minute)}
+		_, _, err = store.Claim("k", stale, now)
+		Expect(err).ToNot(HaveOccurred())
+		newer := handler.IdempotencyRecord{Fingerprint: "a", Expires: now.Add(2 * time.Minute)}
+		_, claimed, err := store.Claim("k", newer, now.Add(time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeTrue())
+
+		complete := handler.IdempotencyRecord{Fingerprint: "a", Complete: true, Expires: now.Add(time.Hour)}
+		Expect(store.Put("k", stale, complete)).To(Equal(handler.ErrIdempotencyClaimLost))
+		Expect(store.Delete("k", stale)).To(Succeed())
+		existing, claimed, err := store.Claim("k", handler.IdempotencyRecord{}, now.Add(time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeFalse())
+		Expect(existing).To(Equal(newer))
+	})
+
+	It("should evict the least recently used keys", func() {
+		store, err := handler.NewMemoryIdempotencyStore(2)
+		Expect(err).ToNot(HaveOccurred())
+		for _, key := range []string{"a", "b", "c"} {
+			claim := handler.IdempotencyRecord{Expires: now.Add(time.Minute)}
+			_, _, claimErr := store.Claim(key, claim, now)
+			Expect(claimErr).ToNot(HaveOccurred())
+			complete := handler.IdempotencyRecord{Complete: true, Expires: now.Add(time.Hour)}
+			Expect(store.Put(key, claim, complete)).To(Succeed())
+		}
+		Expect(store.Len()).To(Equal(2))
+		_, claimed, err := store.Claim("a", handler.IdempotencyRecord{}, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeTrue())
+	})
+
+	It("should never evict the claims of requests still running", func() {
+		store, err := handler.NewMemoryIdempotencyStore(2)
+		Expect(err).ToNot(HaveOccurred())
+		claim := handler.IdempotencyRecord{Expires: now.Add(time.Minute)}
+		_, _, err = store.Claim("done", claim, now)
+		Expect(err).ToNot(HaveOccurred())
+		complete := handler.IdempotencyRecord{Complete: true, Expires: now.Add(time.Hour)}
+		Expect(store.Put("done", claim, complete)).To(Succeed())
+		for _, key := range []string{"a", "b"} {
+			_, claimed, claimErr := store.Claim(key, handler.IdempotencyRecord{Expires: now.Add(time.Minute)}, now)
+			Expect(claimErr).ToNot(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+		}
+		_, _, err = store.Claim("c", handler.IdempotencyRecord{Expires: now.Add(time.Minute)}, now)
+		Expect(err).To(Equal(handler.ErrIdempotencyStoreFull))
+		existing, claimed, err := store.Claim("a", handler.IdempotencyRecord{Fingerprint: "dup"}, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeFalse())
+		Expect(existing.Fingerprint).To(BeEmpty())
+
+		_, claimed, err = store.Claim("c", handler.IdempotencyRecord{Expires: now.Add(2 * time.Minute)},
+			now.Add(time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed).To(BeTrue())
+	})
+
+	It("should reject invalid sizes", func() {
+		_, err := handler.NewMemoryIdempotencyStore(0)
+		Expect(err).To(HaveOccurred())
+	})
+})
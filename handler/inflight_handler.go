@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+var inFlightTemplate = template.Must(template.New("inflight").Parse(`<!DOCTYPE html>
+<html>
+<head><title>In-flight requests</title></head>
+<body>
+<h1>{{len .Requests}} in-flight requests</h1>
+<table>
+<tr><th>ID</th><th>Request ID</th><th>Age</th><th>Method</th><th>Path</th><th>Remote address</th><th>Status</th>
+<th></th></tr>
+{{range .Requests}}<tr>
+<td>{{.ID}}</td><td>{{.RequestID}}</td><td>{{.Age}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.RemoteAddr}}</td>
+<td>{{if .Status}}{{.Status}}{{else}}pending{{end}}</td>
+<td><button data-id="{{.ID}}">Cancel</button></td>
+</tr>
+{{end}}</table>
+<script{{with .Nonce}} nonce="{{.}}"{{end}}>
+document.querySelectorAll("button[data-id]").forEach(function (button) {
+  button.addEventListener("click", function () {
+    fetch("?id=" + encodeURIComponent(button.dataset.id), {method: "DELETE"}).then(function () {
+      location.reload();
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// InFlightHandler is a debug endpoint for an InFlightRegistry. GET lists the in-flight requests, oldest first, as JSON
+// or as HTML for browsers. DELETE with an "id" query parameter cancels that request's context. Browsers do not send
+// cross-origin DELETEs without a CORS preflight, so other sites cannot cancel requests through a visitor's browser.
+type InFlightHandler struct {
+	Registry *InFlightRegistry
+}
+
+func NewInFlightHandler(registry *InFlightRegistry) (InFlightHandler, error) {
+	if registry == nil {
+		return InFlightHandler{}, errors.New("handler: in-flight registry must not be nil")
+	}
+	return InFlightHandler{Registry: registry}, nil
+}
+
+func (ih InFlightHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		ih.list(w, r)
+	case http.MethodDelete:
+		ih.cancel(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (ih InFlightHandler) list(w http.ResponseWriter, r *http.Request) {
+	requests := ih.Registry.Requests()
+	if r.URL.Query().Get("format") == "html" || strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page := struct {
+			Requests []InFlightRequest
+			Nonce    string
+		}{requests, CSPNonce(r)}
+		if err := inFlightTemplate.Execute(w, page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(requests); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (ih InFlightHandler) cancel(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	if !ih.Registry.Cancel(id) {
+		http.Error(w, "no in-flight request with id "+id, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("InFlightHandler", func() {
+	var (
+		registry    *handler.InFlightRegistry
+		debug       handler.InFlightHandler
+		unblock     chan struct{}
+		done        chan struct{}
+		ctxDone     chan struct{}
+		recorder    *httptest.ResponseRecorder
+		requestsSrv handler.RequestsHandler
+	)
+
+	BeforeEach(func() {
+		registry = handler.NewInFlightRegistry()
+		var err error
+		debug, err = handler.NewInFlightHandler(registry)
+		Expect(err).ToNot(HaveOccurred())
+		block := make(chan struct{})
+		unblock = block
+		started := make(chan struct{})
+		cancelled := make(chan struct{})
+		ctxDone = cancelled
+		requestsSrv, err = handler.NewRequestsHandlerWithOptions(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-r.Context().Done():
+					close(cancelled)
+				case <-block:
+				}
+			}),
+			handler.WithInFlightRegistry(registry),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		request := httptest.NewRequest("POST", "/upload", nil)
+		request.Header.Set(handler.RequestIDHeader, "abcd")
+		finished := make(chan struct{})
+		done = finished
+		go func() {
+			defer close(finished)
+			requestsSrv.ServeHTTP(httptest.NewRecorder(), request)
+		}()
+		Eventually(started).Should(BeClosed())
+		recorder = httptest.NewRecorder()
+	})
+
+	AfterEach(func() {
+		close(unblock)
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("should list in-flight requests as JSON", func() {
+		debug.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/requests", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		var requests []handler.InFlightRequest
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &requests)).To(Succeed())
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].ID).ToNot(BeEmpty())
+		Expect(requests[0].RequestID).To(Equal("abcd"))
+		Expect(requests[0].Path).To(Equal("/upload"))
+	})
+
+	It("should list in-flight requests as HTML for browsers", func() {
+		request := httptest.NewRequest("GET", "/debug/requests", nil)
+		request.Header.Set("Accept", "text/html,application/xhtml+xml")
+		debug.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/html"))
+		Expect(recorder.Body.String()).To(ContainSubstring("<td>abcd</td>"))
+		Expect(recorder.Body.String()).To(ContainSubstring("pending"))
+	})
+
+	It("should cancel a request by ID", func() {
+		id := registry.Requests()[0].ID
+		debug.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/debug/requests?id="+id, nil))
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Eventually(ctxDone).Should(BeClosed())
+	})
+
+	It("should refuse to cancel in response to a form post, which any site can make a browser send", func() {
+		request := httptest.NewRequest("POST", "/debug/requests",
+			strings.NewReader("id="+registry.Requests()[0].ID))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		debug.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(recorder.Header().Get("Allow")).To(Equal("GET, HEAD, DELETE"))
+		Consistently(ctxDone).ShouldNot(BeClosed())
+	})
+
+	It("should 404 for unknown IDs", func() {
+		debug.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/debug/requests?id=nope", nil))
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should reject a cancel without an ID", func() {
+		debug.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/debug/requests", nil))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should reject a nil registry", func() {
+		_, err := handler.NewInFlightHandler(nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type InFlightRequest struct {
+	// ID is the registry's own, random so that it can be neither guessed nor chosen by a client.
+	ID string `json:"id"`
+	// RequestID is the request's X-Request-Id, if it has one.
+	RequestID      string        `json:"requestId,omitempty"`
+	Method         string        `json:"method"`
+	Path           string        `json:"path"`
+	RemoteAddr     string        `json:"remoteAddr"`
+	StartTimestamp time.Time     `json:"startTimestamp"`
+	Age            time.Duration `json:"age"`
+	// Status is zero until the handler writes a header.
+	Status int `json:"status"`
+}
+
+// InFlightRegistry tracks the requests RequestsHandler is currently serving. It is safe for concurrent use.
+type InFlightRegistry struct {
+	mu       sync.Mutex
+	requests map[string]*inFlightEntry
+	clock    Clock
+}
+
+type inFlightEntry struct {
+	request InFlightRequest
+	status  int32
+	cancel  context.CancelFunc
+}
+
+func NewInFlightRegistry() *InFlightRegistry {
+	return &InFlightRegistry{
+		requests: make(map[string]*inFlightEntry),
+		clock:    time.Now,
+	}
+}
+
+// Requests returns a snapshot of the in-flight requests, oldest first.
+func (reg *InFlightRegistry) Requests() []InFlightRequest {
+	now := reg.clock()
+	reg.mu.Lock()
+	requests := make([]InFlightRequest, 0, len(reg.requests))
+	for _, entry := range reg.requests {
+		request := entry.request
+		request.Status = int(atomic.LoadInt32(&entry.status))
+		request.Age = now.Sub(request.StartTimestamp)
+		requests = append(requests, request)
+	}
+	reg.mu.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].StartTimestamp.Before(requests[j].StartTimestamp)
+	})
+	return requests
+}
+
+// Cancel cancels the context of the in-flight request with the given ID and reports whether there was one.
+func (reg *InFlightRegistry) Cancel(id string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	entry, ok := reg.requests[id]
+	if ok {
+		entry.cancel()
+	}
+	return ok
+}
+
+func (reg *InFlightRegistry) register(r *http.Request, metadata RequestMetadata) (*http.Request, *inFlightEntry, func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	entry := &inFlightEntry{
+		request: InFlightRequest{
+			ID:             inFlightID(),
+			RequestID:      r.Header.Get(RequestIDHeader),
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			RemoteAddr:     metadata.RemoteAddr,
+			StartTimestamp: metadata.StartTimestamp,
+		},
+		cancel: cancel,
+	}
+
+	id := entry.request.ID
+	reg.mu.Lock()
+	reg.requests[id] = entry
+	reg.mu.Unlock()
+
+	deregister := func() {
+		reg.mu.Lock()
+		delete(reg.requests, id)
+		reg.mu.Unlock()
+		cancel()
+	}
+	return r.WithContext(ctx), entry, deregister
+}
+
+func (e *inFlightEntry) setStatus(code int) {
+	if e != nil {
+		atomic.StoreInt32(&e.status, int32(code))
+	}
+}
+
+func inFlightID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic("handler: cannot generate an in-flight request ID: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,92 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("InFlightRegistry", func() {
+	var (
+		registry    *handler.InFlightRegistry
+		started     chan struct{}
+		unblock     chan struct{}
+		cancelled   chan error
+		rh          handler.RequestsHandler
+		serveInBack func(path, requestID string) chan struct{}
+	)
+
+	BeforeEach(func() {
+		registry = handler.NewInFlightRegistry()
+		block := make(chan struct{})
+		unblock = block
+		startedCh := make(chan struct{})
+		started = startedCh
+		cancelledCh := make(chan error, 2)
+		cancelled = cancelledCh
+		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			startedCh <- struct{}{}
+			select {
+			case <-r.Context().Done():
+				cancelledCh <- r.Context().Err()
+			case <-block:
+			}
+		})
+		var err error
+		rh, err = handler.NewRequestsHandlerWithOptions(nextHandler, handler.WithInFlightRegistry(registry))
+		Expect(err).ToNot(HaveOccurred())
+		serveInBack = func(path, requestID string) chan struct{} {
+			request := httptest.NewRequest("GET", path, nil)
+			request.RemoteAddr = "10.0.0.1:1234"
+			if requestID != "" {
+				request.Header.Set(handler.RequestIDHeader, requestID)
+			}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				rh.ServeHTTP(httptest.NewRecorder(), request)
+			}()
+			Eventually(started).Should(Receive())
+			return done
+		}
+	})
+
+	It("should list in-flight requests oldest first", func() {
+		first := serveInBack("/first", "abcd")
+		second := serveInBack("/second", "")
+
+		requests := registry.Requests()
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[0]).To(MatchFields(IgnoreExtras, Fields{
+			"ID":         Not(BeEmpty()),
+			"RequestID":  Equal("abcd"),
+			"Method":     Equal("GET"),
+			"Path":       Equal("/first"),
+			"RemoteAddr": Equal("10.0.0.1"),
+			"Status":     Equal(http.StatusAccepted),
+		}))
+		Expect(requests[1].Path).To(Equal("/second"))
+		Expect(requests[1].ID).ToNot(Equal(requests[0].ID))
+		Expect(requests[1].RequestID).To(BeEmpty())
+		Expect(requests[0].Age).To(BeNumerically(">=", requests[1].Age))
+
+		close(unblock)
+		Eventually(first).Should(BeClosed())
+		Eventually(second).Should(BeClosed())
+		Expect(registry.Requests()).To(BeEmpty())
+	})
+
+	It("should cancel a request's context by ID", func() {
+		done := serveInBack("/slow", "abcd")
+		Expect(registry.Cancel("unknown")).To(BeFalse())
+		Expect(registry.Cancel("abcd")).To(BeFalse())
+		Expect(registry.Cancel(registry.Requests()[0].ID)).To(BeTrue())
+		Eventually(cancelled).Should(Receive(MatchError("context canceled")))
+		Eventually(done).Should(BeClosed())
+	})
+})
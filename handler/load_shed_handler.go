@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Criticality orders requests when a LoadShedHandler has to choose between them.
+type Criticality int
+
+const (
+	// Sheddable requests are turned away as soon as the limit is reached rather than queued.
+	Sheddable Criticality = iota
+	DefaultCriticality
+	// Critical requests are queued ahead of everything else and may evict less critical ones from a full queue.
+	Critical
+)
+
+// CriticalityHeader is the header HeaderCriticality reads by default.
+const CriticalityHeader = "X-Request-Criticality"
+
+type CriticalityFunc func(r *http.Request) Criticality
+
+// HeaderCriticality reads "critical" or "sheddable" from header, treating anything else as DefaultCriticality. Strip
+// the header from requests arriving from outside, or clients will mark everything critical.
+func HeaderCriticality(header string) CriticalityFunc {
+	return func(r *http.Request) Criticality {
+		switch strings.ToLower(r.Header.Get(header)) {
+		case "critical":
+			return Critical
+		case "sheddable":
+			return Sheddable
+		default:
+			return DefaultCriticality
+		}
+	}
+}
+
+// LoadShedHandler caps how many requests run at once at the Limiter's limit. Requests beyond it wait in a bounded
+// queue, most critical first, for up to MaxWait, and are shed with a 503 and Retry-After when the queue is full or
+// the wait runs out. Each handler keeps its own count, so wrap the whole mux for a global limit or individual routes
+// for per-route limits.
+type LoadShedHandler struct {
+	Limiter         ConcurrencyLimiter
+	MaxQueue        int
+	MaxWait         time.Duration
+	CriticalityFunc CriticalityFunc
+	RetryAfter      time.Duration
+	Responder       Responder
+	Next            http.Handler
+	mu              sync.Mutex
+	inFlight        int
+	queue           waitQueue
+	seq             uint64
+}
+
+type LoadShedHandlerOption func(*LoadShedHandler) error
+
+func NewLoadShedHandler(next http.Handler, limiter ConcurrencyLimiter,
+	opts ...LoadShedHandlerOption) (*LoadShedHandler, error) {
+
+	if next == nil {
+		return nil, ErrNilNext
+	}
+	if limiter == nil || limiter.Limit() <= 0 {
+		return nil, errors.New("handler: concurrency limiter must allow at least one request")
+	}
+	lh := &LoadShedHandler{
+		Limiter:         limiter,
+		CriticalityFunc: HeaderCriticality(CriticalityHeader),
+		RetryAfter:      time.Second,
+		Responder:       NegotiatedResponder,
+		Next:            next,
+	}
+	for _, opt := range opts {
+		if err := opt(lh); err != nil {
+			return nil, err
+		}
+	}
+	return lh, nil
+}
+
+// WithQueue lets up to size requests wait up to maxWait for a slot instead of being shed straight away.
+func WithQueue(size int, maxWait time.Duration) LoadShedHandlerOption {
+	return func(lh *LoadShedHandler) error {
+		if size <= 0 || maxWait <= 0 {
+			return errors.New("handler: queue size and wait must be positive")
+		}
+		lh.MaxQueue = size
+		lh.MaxWait = maxWait
+		return nil
+	}
+}
+
+func WithCriticality(criticalityFunc CriticalityFunc) LoadShedHandlerOption {
+	return func(lh *LoadShedHandler) error {
+		if criticalityFunc == nil {
+			return errors.New("handler: criticality func must not be nil")
+		}
+		lh.CriticalityFunc = criticalityFunc
+		return nil
+	}
+}
+
+func WithRetryAfter(retryAfter time.Duration) LoadShedHandlerOption {
+	return func(lh *LoadShedHandler) error {
+		if retryAfter <= 0 {
+			return errors.New("handler: retry after must be positive")
+		}
+		lh.RetryAfter = retryAfter
+		return nil
+	}
+}
+
+func WithLoadShedResponder(responder Responder) LoadShedHandlerOption {
+	return func(lh *LoadShedHandler) error {
+		if responder == nil {
+			return errors.New("handler: responder must not be nil")
+		}
+		lh.Responder = responder
+		return nil
+	}
+}
+
+// InFlight is how many requests are currently being served.
+func (lh *LoadShedHandler) InFlight() int {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.inFlight
+}
+
+// Queued is how many requests are waiting for a slot.
+func (lh *LoadShedHandler) Queued() int {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.queue.Len()
+}
+
+func (lh *LoadShedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	criticality := DefaultCriticality
+	if lh.CriticalityFunc != nil {
+		criticality = lh.CriticalityFunc(r)
+	}
+	admitted, queueTime := lh.admit(r, criticality)
+	UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+		metadata.Shed = !admitted
+		metadata.QueueTime = queueTime
+	})
+	if !admitted {
+		lh.shed(w, r)
+		return
+	}
+
+	lw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	start := time.Now()
+	defer func() {
+		end := time.Now()
+		metadata := RequestMetadata{
+			StartTimestamp: start,
+			EndTimestamp:   end,
+			ExecutionTime:  end.Sub(start),
+			Status:         lw.statusCode,
+			WroteHeader:    lw.wroteHeader,
+		}
+		switch r.Context().Err() {
+		case context.Canceled:
+			metadata.Cancelled = true
+		case context.DeadlineExceeded:
+			metadata.DeadlineExceeded = true
+		}
+		lh.release(metadata)
+	}()
+	lh.Next.ServeHTTP(lw, r)
+}
+
+func (lh *LoadShedHandler) shed(w http.ResponseWriter, r *http.Request) {
+	retryAfter := lh.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	w.Header().Set("Retry-After", headerSeconds(retryAfter))
+	responder := lh.Responder
+	if responder == nil {
+		responder = NegotiatedResponder
+	}
+	responder(w, r, http.StatusServiceUnavailable, "server overloaded")
+}
+
+// admit reports whether the request may run and how long it was queued for.
+func (lh *LoadShedHandler) admit(r *http.Request, criticality Criticality) (bool, time.Duration) {
+	wt, admitted := lh.enqueue(criticality)
+	if wt == nil {
+		return admitted, 0
+	}
+	queued := time.Now()
+	timer := time.NewTimer(lh.MaxWait)
+	defer timer.Stop()
+	select {
+	case ok := <-wt.ready:
+		return ok, time.Since(queued)
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	if wt.index >= 0 {
+		heap.Remove(&lh.queue, wt.index)
+		return false, time.Since(queued)
+	}
+	// a slot was handed over just as the wait ran out
+	return <-wt.ready, time.Since(queued)
+}
+
+// enqueue admits the request straight away if there is room, or queues it. It returns a nil waiter when the decision
+// has already been made.
+func (lh *LoadShedHandler) enqueue(criticality Criticality) (*waiter, bool) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	if lh.inFlight < lh.Limiter.Limit() {
+		lh.inFlight++
+		return nil, true
+	}
+	if criticality <= Sheddable || lh.MaxQueue <= 0 {
+		return nil, false
+	}
+	if lh.queue.Len() >= lh.MaxQueue {
+		victim := lh.queue.lowest()
+		if victim.criticality >= criticality {
+			return nil, false
+		}
+		heap.Remove(&lh.queue, victim.index)
+		victim.ready <- false
+	}
+	wt := &waiter{criticality: criticality, seq: lh.seq, ready: make(chan bool, 1)}
+	lh.seq++
+	heap.Push(&lh.queue, wt)
+	return wt, false
+}
+
+func (lh *LoadShedHandler) release(metadata RequestMetadata) {
+	lh.mu.Lock()
+	inFlight := lh.inFlight
+	lh.inFlight--
+	lh.mu.Unlock()
+
+	lh.Limiter.Observe(metadata, inFlight)
+
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	for lh.queue.Len() > 0 && lh.inFlight < lh.Limiter.Limit() {
+		wt := heap.Pop(&lh.queue).(*waiter)
+		lh.inFlight++
+		wt.ready <- true
+	}
+}
+
+type waiter struct {
+	criticality Criticality
+	seq         uint64
+	ready       chan bool
+	index       int
+}
+
+// waitQueue is a heap of waiters, most critical first and oldest first within a criticality.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int {
+	return len(q)
+}
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].criticality != q[j].criticality {
+		return q[i].criticality > q[j].criticality
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	wt := x.(*waiter)
+	wt.index = len(*q)
+	*q = append(*q, wt)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	wt := old[len(old)-1]
+	old[len(old)-1] = nil
+	wt.index = -1
+	*q = old[:len(old)-1]
+	return wt
+}
+
+// lowest is the waiter that would be served last.
+func (q waitQueue) lowest() *waiter {
+	lowest := q[0]
+	for _, wt := range q[1:] {
+		if q.Less(lowest.index, wt.index) {
+			lowest = wt
+		}
+	}
+	return lowest
+}
@@ -0,0 +1,221 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+type recordingLimiter struct {
+	handler.FixedLimit
+	mu       sync.Mutex
+	observed []handler.RequestMetadata
+}
+
+func (rl *recordingLimiter) Observe(metadata handler.RequestMetadata, inFlight int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.observed = append(rl.observed, metadata)
+}
+
+func (rl *recordingLimiter) observations() []handler.RequestMetadata {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.observed
+}
+
+var _ = Describe("LoadShedHandler", func() {
+	var (
+		release chan struct{}
+		served  chan string
+		blocked http.Handler
+	)
+
+	BeforeEach(func() {
+		unblock := make(chan struct{})
+		release = unblock
+		started := make(chan string, 10)
+		served = started
+		blocked = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- r.URL.Path
+			<-unblock
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+
+	serveInBackground := func(h http.Handler, r *http.Request) (*httptest.ResponseRecorder, chan struct{}) {
+		recorder := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			h.ServeHTTP(recorder, r)
+		}()
+		return recorder, done
+	}
+
+	It("should shed requests beyond the limit with a 503 and Retry-After", func() {
+		lh, err := handler.NewLoadShedHandler(blocked, handler.FixedLimit(1), handler.WithRetryAfter(5*time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		first, firstDone := serveInBackground(lh, httptest.NewRequest("GET", "/first", nil))
+		Eventually(served).Should(Receive(Equal("/first")))
+		Expect(lh.InFlight()).To(Equal(1))
+
+		recorder := httptest.NewRecorder()
+		lh.ServeHTTP(recorder, httptest.NewRequest("GET", "/second", nil))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(recorder.Header().Get("Retry-After")).To(Equal("5"))
+
+		close(release)
+		Eventually(firstDone).Should(BeClosed())
+		Expect(first.Code).To(Equal(http.StatusNoContent))
+		Expect(lh.InFlight()).To(BeZero())
+	})
+
+	It("should queue requests until a slot frees up", func() {
+		lh, err := handler.NewLoadShedHandler(blocked, handler.FixedLimit(1), handler.WithQueue(1, time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		var metadata handler.RequestMetadata
+		rh, err := handler.NewRequestsHandlerWithOptions(lh, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, m handler.RequestMetadata) {
+				if r.URL.Path == "/second" {
+					metadata = m
+				}
+			}))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, firstDone := serveInBackground(rh, httptest.NewRequest("GET", "/first", nil))
+		Eventually(served).Should(Receive(Equal("/first")))
+		second, secondDone := serveInBackground(rh, httptest.NewRequest("GET", "/second", nil))
+		Eventually(lh.Queued).Should(Equal(1))
+		Consistently(served, 20*time.Millisecond).ShouldNot(Receive())
+
+		close(release)
+		Eventually(firstDone).Should(BeClosed())
+		Eventually(secondDone).Should(BeClosed())
+		Expect(second.Code).To(Equal(http.StatusNoContent))
+		Expect(metadata.Shed).To(BeFalse())
+		Expect(metadata.QueueTime).To(BeNumerically(">=", 20*time.Millisecond))
+	})
+
+	It("should shed queued requests that wait too long", func() {
+		lh, err := handler.NewLoadShedHandler(blocked, handler.FixedLimit(1), handler.WithQueue(1, 10*time.Millisecond))
+		Expect(err).ToNot(HaveOccurred())
+		var metadata handler.RequestMetadata
+		rh, err := handler.NewRequestsHandlerWithOptions(lh, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, m handler.RequestMetadata) {
+				metadata = m
+			}))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, firstDone := serveInBackground(lh, httptest.NewRequest("GET", "/first", nil))
+		Eventually(served).Should(Receive())
+		recorder := httptest.NewRecorder()
+		rh.ServeHTTP(recorder, httptest.NewRequest("GET", "/second", nil))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(metadata.Shed).To(BeTrue())
+		Expect(metadata.QueueTime).To(BeNumerically(">=", 10*time.Millisecond))
+		Expect(lh.Queued()).To(BeZero())
+
+		close(release)
+		Eventually(firstDone).Should(BeClosed())
+	})
+
+	It("should never queue sheddable requests", func() {
+		lh, err := handler.NewLoadShedHandler(blocked, handler.FixedLimit(1), handler.WithQueue(1, time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		_, firstDone := serveInBackground(lh, httptest.NewRequest("GET", "/first", nil))
+		Eventually(served).Should(Receive())
+
+		request := httptest.NewRequest("GET", "/batch", nil)
+		request.Header.Set(handler.CriticalityHeader, "sheddable")
+		recorder := httptest.NewRecorder()
+		lh.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+
+		close(release)
+		Eventually(firstDone).Should(BeClosed())
+	})
+
+	It("should serve critical requests first and let them displace others from a full queue", func() {
+		lh, err := handler.NewLoadShedHandler(blocked, handler.FixedLimit(1), handler.WithQueue(2, time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		critical := func(path string) *http.Request {
+			r := httptest.NewRequest("GET", path, nil)
+			r.Header.Set(handler.CriticalityHeader, "critical")
+			return r
+		}
+
+		_, firstDone := serveInBackground(lh, httptest.NewRequest("GET", "/first", nil))
+		Eventually(served).Should(Receive(Equal("/first")))
+		_, olderDone := serveInBackground(lh, httptest.NewRequest("GET", "/older", nil))
+		Eventually(lh.Queued).Should(Equal(1))
+		displaced, displacedDone := serveInBackground(lh, httptest.NewRequest("GET", "/displaced", nil))
+		Eventually(lh.Queued).Should(Equal(2))
+		_, criticalDone := serveInBackground(lh, critical("/critical"))
+
+		Eventually(displacedDone).Should(BeClosed())
+		Expect(displaced.Code).To(Equal(http.StatusServiceUnavailable))
+
+		close(release)
+		Eventually(served).Should(Receive(Equal("/critical")))
+		Eventually(served).Should(Receive(Equal("/older")))
+		for _, done := range []chan struct{}{firstDone, olderDone, criticalDone} {
+			Eventually(done).Should(BeClosed())
+		}
+	})
+
+	It("should report what it served to the limiter", func() {
+		limiter := &recordingLimiter{FixedLimit: 1}
+		lh, err := handler.NewLoadShedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}), limiter)
+		Expect(err).ToNot(HaveOccurred())
+		lh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		Expect(limiter.observations()).To(HaveLen(1))
+		Expect(limiter.observations()[0].Status).To(Equal(http.StatusAccepted))
+	})
+
+	It("should release its slot when the handler panics", func() {
+		lh, err := handler.NewLoadShedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}), handler.FixedLimit(1))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(func() {
+			lh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}).To(Panic())
+		Expect(lh.InFlight()).To(BeZero())
+	})
+
+	It("should give each route its own limit when used as middleware", func() {
+		middleware, err := handler.LoadShedMiddleware(func() handler.ConcurrencyLimiter { return handler.FixedLimit(1) })
+		Expect(err).ToNot(HaveOccurred())
+		a, b := middleware(blocked), middleware(blocked)
+		_, aDone := serveInBackground(a, httptest.NewRequest("GET", "/a", nil))
+		_, bDone := serveInBackground(b, httptest.NewRequest("GET", "/b", nil))
+		Eventually(served).Should(Receive())
+		Eventually(served).Should(Receive())
+		close(release)
+		Eventually(aDone).Should(BeClosed())
+		Eventually(bDone).Should(BeClosed())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewLoadShedHandler(nil, handler.FixedLimit(1))
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewLoadShedHandler(blocked, handler.FixedLimit(0))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewLoadShedHandler(blocked, handler.FixedLimit(1), handler.WithQueue(1, 0))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewLoadShedHandler(blocked, handler.FixedLimit(1), handler.WithCriticality(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewLoadShedHandler(blocked, handler.FixedLimit(1), handler.WithRetryAfter(0))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.LoadShedMiddleware(nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
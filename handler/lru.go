@@ -0,0 +1,87 @@
+package handler
+
+import "container/list"
+
+// lru is a least recently used map. It is not safe for concurrent use; callers hold their own locks.
+type lru struct {
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRU(maxEntries int) *lru {
+	return &lru{maxEntries: maxEntries, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lru) get(key string) (interface{}, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruEntry).value, true
+}
+
+// peek is get without counting as a use.
+func (c *lru) peek(key string) (interface{}, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*lruEntry).value, true
+}
+
+func (c *lru) add(key string, value interface{}) {
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*lruEntry).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key, value})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lru) remove(key string) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// removeOldest evicts the least recently used entry and returns it.
+func (c *lru) removeOldest() (string, interface{}, bool) {
+	el := c.ll.Back()
+	if el == nil {
+		return "", nil, false
+	}
+	c.removeElement(el)
+	entry := el.Value.(*lruEntry)
+	return entry.key, entry.value, true
+}
+
+// removeOldestWhere evicts the least recently used entry whose value evictable accepts, and reports whether there was
+// one.
+func (c *lru) removeOldestWhere(evictable func(value interface{}) bool) bool {
+	for el := c.ll.Back(); el != nil; el = el.Prev() {
+		if evictable(el.Value.(*lruEntry).value) {
+			c.removeElement(el)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *lru) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).key)
+}
+
+func (c *lru) len() int {
+	return c.ll.Len()
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("lru", func() {
+	It("should evict the least recently used entry", func() {
+		c := newLRU(2)
+		c.add("a", 1)
+		c.add("b", 2)
+		_, ok := c.get("a")
+		Expect(ok).To(BeTrue())
+		c.add("c", 3)
+
+		_, ok = c.get("b")
+		Expect(ok).To(BeFalse())
+		value, ok := c.get("a")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal(1))
+		Expect(c.len()).To(Equal(2))
+	})
+
+	It("should replace and remove entries", func() {
+		c := newLRU(2)
+		c.add("a", 1)
+		c.add("a", 2)
+		value, _ := c.get("a")
+		Expect(value).To(Equal(2))
+		c.remove("a")
+		Expect(c.len()).To(BeZero())
+	})
+
+	It("should hand back the least recently used entry", func() {
+		c := newLRU(0)
+		c.add("a", 1)
+		c.add("b", 2)
+		c.get("a")
+		key, value, ok := c.removeOldest()
+		Expect(ok).To(BeTrue())
+		Expect(key).To(Equal("b"))
+		Expect(value).To(Equal(2))
+		c.removeOldest()
+		_, _, ok = c.removeOldest()
+		Expect(ok).To(BeFalse())
+	})
+})
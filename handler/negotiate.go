@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type qualityValue struct {
+	value   string
+	quality float64
+}
+
+// parseQualityList parses headers such as Accept and Accept-Encoding into values ordered by descending quality.
+// Values with equal quality keep their header order.
+func parseQualityList(header string) []qualityValue {
+	var values []qualityValue
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		if value == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(param[2:], 64)
+			if err == nil && q >= 0 && q <= 1 {
+				quality = q
+			}
+		}
+		values = append(values, qualityValue{value, quality})
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].quality > values[j].quality
+	})
+	return values
+}
+
+// negotiateMediaType picks the offer the Accept header prefers, falling back to the first offer.
+func negotiateMediaType(accept string, offers ...string) string {
+	if accept == "" {
+		return offers[0]
+	}
+	for _, accepted := range parseQualityList(accept) {
+		if accepted.quality == 0 {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaTypeMatches(accepted.value, offer) {
+				return offer
+			}
+		}
+	}
+	return offers[0]
+}
+
+func mediaTypeMatches(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	return strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("negotiation", func() {
+	It("should order values by quality, keeping header order for ties", func() {
+		Expect(parseQualityList("gzip;q=0.5, br, identity;q=0, Deflate")).To(Equal([]qualityValue{
+			{"br", 1}, {"deflate", 1}, {"gzip", 0.5}, {"identity", 0},
+		}))
+	})
+
+	It("should ignore malformed quality values", func() {
+		Expect(parseQualityList("gzip;q=2, ,br;q=x")).To(Equal([]qualityValue{{"gzip", 1}, {"br", 1}}))
+	})
+
+	It("should pick the preferred media type", func() {
+		Expect(negotiateMediaType("", "text/plain", "application/json")).To(Equal("text/plain"))
+		Expect(negotiateMediaType("application/json", "text/plain", "application/json")).To(Equal("application/json"))
+		Expect(negotiateMediaType("text/*;q=0.5, application/json", "text/plain", "application/json")).
+			To(Equal("application/json"))
+		Expect(negotiateMediaType("*/*", "text/plain", "application/json")).To(Equal("text/plain"))
+		Expect(negotiateMediaType("application/json;q=0, image/png", "text/plain", "application/json")).
+			To(Equal("text/plain"))
+	})
+})
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitState is what a RateLimitAlgorithm keeps per key. Stores only need to persist it; its meaning is up to the
+// algorithm.
+type RateLimitState struct {
+	Tokens      float64   `json:"tokens,omitempty"`
+	Updated     time.Time `json:"updated,omitempty"`
+	WindowStart time.Time `json:"windowStart,omitempty"`
+	Count       int       `json:"count,omitempty"`
+	PrevCount   int       `json:"prevCount,omitempty"`
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the full quota is available again.
+	Reset time.Duration
+	// RetryAfter is how long a rejected client should wait before its next request can succeed.
+	RetryAfter time.Duration
+}
+
+type RateLimitAlgorithm interface {
+	Validate() error
+	Limit() int
+	// Take spends one request from state, updating it in place.
+	Take(state *RateLimitState, now time.Time) RateLimitResult
+}
+
+// TokenBucket allows bursts of up to Burst requests, refilled at Rate requests every Per.
+type TokenBucket struct {
+	Burst int
+	Rate  int
+	Per   time.Duration
+}
+
+func (tb TokenBucket) Validate() error {
+	if tb.Burst <= 0 || tb.Rate <= 0 || tb.Per <= 0 {
+		return errors.New("handler: token bucket burst, rate and period must be positive")
+	}
+	return nil
+}
+
+func (tb TokenBucket) Limit() int {
+	return tb.Burst
+}
+
+func (tb TokenBucket) Take(state *RateLimitState, now time.Time) RateLimitResult {
+	perSecond := float64(tb.Rate) / tb.Per.Seconds()
+	if state.Updated.IsZero() {
+		state.Tokens = float64(tb.Burst)
+	} else if elapsed := now.Sub(state.Updated); elapsed > 0 {
+		state.Tokens = math.Min(float64(tb.Burst), state.Tokens+elapsed.Seconds()*perSecond)
+	}
+	state.Updated = now
+
+	result := RateLimitResult{Limit: tb.Burst}
+	if state.Tokens >= 1 {
+		state.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - state.Tokens) / perSecond)
+	}
+	result.Remaining = int(state.Tokens)
+	result.Reset = seconds((float64(tb.Burst) - state.Tokens) / perSecond)
+	return result
+}
+
+// SlidingWindow allows Requests per Window, estimating the requests in the trailing window from the counts of the
+// current and previous fixed windows.
+type SlidingWindow struct {
+	Requests int
+	Window   time.Duration
+}
+
+func (sw SlidingWindow) Validate() error {
+	if sw.Requests <= 0 || sw.Window <= 0 {
+		return errors.New("handler: sliding window requests and window must be positive")
+	}
+	return nil
+}
+
+func (sw SlidingWindow) Limit() int {
+	return sw.Requests
+}
+
+func (sw SlidingWindow) Take(state *RateLimitState, now time.Time) RateLimitResult {
+	windowStart := now.Truncate(sw.Window)
+	switch {
+	case state.WindowStart.IsZero() || windowStart.Sub(state.WindowStart) >= 2*sw.Window:
+		state.PrevCount, state.Count = 0, 0
+	case windowStart.After(state.WindowStart):
+		state.PrevCount, state.Count = state.Count, 0
+	}
+	state.WindowStart = windowStart
+
+	elapsed := now.Sub(windowStart)
+	prevWeight := 1 - float64(elapsed)/float64(sw.Window)
+	estimate := float64(state.PrevCount)*prevWeight + float64(state.Count)
+	result := RateLimitResult{Limit: sw.Requests, Reset: sw.Window - elapsed}
+	if estimate+1 <= float64(sw.Requests) {
+		state.Count++
+		estimate++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = sw.retryAfter(state, elapsed)
+	}
+	result.Remaining = sw.Requests - int(math.Ceil(estimate))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	return result
+}
+
+// retryAfter works out when the estimate will have decayed enough to admit one more request.
+func (sw SlidingWindow) retryAfter(state *RateLimitState, elapsed time.Duration) time.Duration {
+	window := float64(sw.Window)
+	spare := float64(sw.Requests - 1)
+	if state.Count > sw.Requests-1 {
+		// nothing frees up until this window becomes the previous one
+		return sw.Window - elapsed + time.Duration(window*(1-spare/float64(state.Count)))
+	}
+	return time.Duration(window*(1-(spare-float64(state.Count))/float64(state.PrevCount))) - elapsed
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// RateLimitStore holds RateLimitState per key, so that limits can be shared between instances by backing it with an
+// external store. Update must apply update atomically with respect to other updates of the same key.
+type RateLimitStore interface {
+	Update(key string, update func(state *RateLimitState)) error
+}
+
+// MemoryRateLimitStore keeps state for the most recently seen MaxKeys keys in memory, evicting the least recently
+// used beyond that.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	entries *lru
+}
+
+func NewMemoryRateLimitStore(maxKeys int) (*MemoryRateLimitStore, error) {
+	if maxKeys <= 0 {
+		return nil, errors.New("handler: rate limit store size must be positive")
+	}
+	return &MemoryRateLimitStore{entries: newLRU(maxKeys)}, nil
+}
+
+func (ms *MemoryRateLimitStore) Update(key string, update func(state *RateLimitState)) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	state, ok := ms.entries.get(key)
+	if !ok {
+		state = &RateLimitState{}
+		ms.entries.add(key, state)
+	}
+	update(state.(*RateLimitState))
+	return nil
+}
+
+func (ms *MemoryRateLimitStore) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.entries.len()
+}
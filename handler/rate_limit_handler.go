@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitKeyFunc picks the bucket a request is counted against. Requests it returns an empty key for are not
+// limited.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// ClientIPKey limits each client address separately. It returns nil for a nil resolver, which WithRateLimitKey
+// rejects.
+func ClientIPKey(resolver ClientIPResolver) RateLimitKeyFunc {
+	if resolver == nil {
+		return nil
+	}
+	return func(r *http.Request) string {
+		return "ip:" + resolver(r)
+	}
+}
+
+// HeaderKey limits each value of header separately, such as an API key, and leaves requests without it alone.
+func HeaderKey(header string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		value := r.Header.Get(header)
+		if value == "" {
+			return ""
+		}
+		return "header:" + value
+	}
+}
+
+// RateLimitHandler rejects requests beyond Algorithm's limit with a 429 and advertises the client's remaining quota
+// in RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers. It fails open: requests are served unlimited
+// when the Store returns an error.
+type RateLimitHandler struct {
+	Algorithm RateLimitAlgorithm
+	Store     RateLimitStore
+	KeyFunc   RateLimitKeyFunc
+	Responder Responder
+	Next      http.Handler
+	clock     Clock
+}
+
+type RateLimitHandlerOption func(*RateLimitHandler) error
+
+// NewRateLimitHandler limits each client address, as seen by RemoteAddrClientIP, in a MemoryRateLimitStore of 10000
+// keys unless told otherwise.
+func NewRateLimitHandler(next http.Handler, algorithm RateLimitAlgorithm,
+	opts ...RateLimitHandlerOption) (RateLimitHandler, error) {
+
+	if next == nil {
+		return RateLimitHandler{}, ErrNilNext
+	}
+	if algorithm == nil {
+		return RateLimitHandler{}, errors.New("handler: rate limit algorithm must not be nil")
+	}
+	if err := algorithm.Validate(); err != nil {
+		return RateLimitHandler{}, err
+	}
+	rh := RateLimitHandler{
+		Algorithm: algorithm,
+		KeyFunc:   ClientIPKey(RemoteAddrClientIP),
+		Responder: NegotiatedResponder,
+		Next:      next,
+		clock:     time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(&rh); err != nil {
+			return RateLimitHandler{}, err
+		}
+	}
+	if rh.Store == nil {
+		rh.Store, _ = NewMemoryRateLimitStore(10000)
+	}
+	return rh, nil
+}
+
+func WithRateLimitStore(store RateLimitStore) RateLimitHandlerOption {
+	return func(rh *RateLimitHandler) error {
+		if store == nil {
+			return errors.New("handler: rate limit store must not be nil")
+		}
+		rh.Store = store
+		return nil
+	}
+}
+
+func WithRateLimitKey(keyFunc RateLimitKeyFunc) RateLimitHandlerOption {
+	return func(rh *RateLimitHandler) error {
+		if keyFunc == nil {
+			return errors.New("handler: rate limit key func must not be nil")
+		}
+		rh.KeyFunc = keyFunc
+		return nil
+	}
+}
+
+// WithRateLimitClientIPResolver limits each client address, as resolver works it out, separately. Use it with
+// ForwardedClientIP behind a trusted proxy.
+func WithRateLimitClientIPResolver(resolver ClientIPResolver) RateLimitHandlerOption {
+	return func(rh *RateLimitHandler) error {
+		if resolver == nil {
+			return errors.New("handler: client IP resolver must not be nil")
+		}
+		rh.KeyFunc = ClientIPKey(resolver)
+		return nil
+	}
+}
+
+func WithRateLimitResponder(responder Responder) RateLimitHandlerOption {
+	return func(rh *RateLimitHandler) error {
+		if responder == nil {
+			return errors.New("handler: responder must not be nil")
+		}
+		rh.Responder = responder
+		return nil
+	}
+}
+
+func WithRateLimitClock(clock Clock) RateLimitHandlerOption {
+	return func(rh *RateLimitHandler) error {
+		if clock == nil {
+			return errors.New("handler: clock must not be nil")
+		}
+		rh.clock = clock
+		return nil
+	}
+}
+
+func (rh RateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := ""
+	if rh.KeyFunc != nil {
+		key = rh.KeyFunc(r)
+	}
+	if key == "" || rh.Algorithm == nil || rh.Store == nil {
+		rh.Next.ServeHTTP(w, r)
+		return
+	}
+	if rh.clock == nil {
+		rh.clock = time.Now
+	}
+
+	now := rh.clock()
+	var result RateLimitResult
+	if err := rh.Store.Update(key, func(state *RateLimitState) {
+		result = rh.Algorithm.Take(state, now)
+	}); err != nil {
+		rh.Next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", headerSeconds(result.Reset))
+	if result.Allowed {
+		rh.Next.ServeHTTP(w, r)
+		return
+	}
+
+	UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+		metadata.RateLimited = true
+	})
+	w.Header().Set("Retry-After", headerSeconds(result.RetryAfter))
+	responder := rh.Responder
+	if responder == nil {
+		responder = NegotiatedResponder
+	}
+	responder(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+}
+
+// headerSeconds rounds up, so that clients honouring the header never retry early.
+func headerSeconds(d time.Duration) string {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 1 {
+		s = 1
+	}
+	return strconv.Itoa(s)
+}
@@ -0,0 +1,161 @@
+package handler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Update(string, func(*handler.RateLimitState)) error {
+	return errors.New("store unavailable")
+}
+
+var _ = Describe("RateLimitHandler", func() {
+	var (
+		now       time.Time
+		clock     handler.Clock
+		algorithm handler.TokenBucket
+		ok        http.Handler
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+		clock = func() time.Time { return now }
+		algorithm = handler.TokenBucket{Burst: 1, Rate: 1, Per: 10 * time.Second}
+		ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+
+	request := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+
+	It("should advertise the quota and reject requests over it", func() {
+		rh, err := handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+
+		recorder := httptest.NewRecorder()
+		rh.ServeHTTP(recorder, request("10.0.0.1:1234"))
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Expect(recorder.Header().Get("RateLimit-Limit")).To(Equal("1"))
+		Expect(recorder.Header().Get("RateLimit-Remaining")).To(Equal("0"))
+		Expect(recorder.Header().Get("RateLimit-Reset")).To(Equal("10"))
+		Expect(recorder.Header().Get("Retry-After")).To(BeEmpty())
+
+		recorder = httptest.NewRecorder()
+		rh.ServeHTTP(recorder, request("10.0.0.1:5678"))
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(recorder.Header().Get("Retry-After")).To(Equal("10"))
+		Expect(recorder.Body.String()).To(ContainSubstring("rate limit exceeded"))
+
+		recorder = httptest.NewRecorder()
+		rh.ServeHTTP(recorder, request("10.0.0.2:1234"))
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should record rejections in RequestMetadata", func() {
+		rl, err := handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+		var metadata handler.RequestMetadata
+		rh, err := handler.NewRequestsHandlerWithOptions(rl, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, m handler.RequestMetadata) {
+				metadata = m
+			}))
+		Expect(err).ToNot(HaveOccurred())
+
+		rh.ServeHTTP(httptest.NewRecorder(), request("10.0.0.1:1234"))
+		Expect(metadata.RateLimited).To(BeFalse())
+		rh.ServeHTTP(httptest.NewRecorder(), request("10.0.0.1:1234"))
+		Expect(metadata.RateLimited).To(BeTrue())
+		Expect(metadata.Status).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should key on a header and leave requests without it alone", func() {
+		rh, err := handler.NewRateLimitHandler(ok, algorithm,
+			handler.WithRateLimitClock(clock), handler.WithRateLimitKey(handler.HeaderKey("X-Api-Key")))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 3; i++ {
+			recorder := httptest.NewRecorder()
+			rh.ServeHTTP(recorder, request("10.0.0.1:1234"))
+			Expect(recorder.Code).To(Equal(http.StatusNoContent))
+			Expect(recorder.Header().Get("RateLimit-Limit")).To(BeEmpty())
+		}
+
+		codes := []int{}
+		for _, key := range []string{"a", "a", "b"} {
+			r := request("10.0.0.1:1234")
+			r.Header.Set("X-Api-Key", key)
+			recorder := httptest.NewRecorder()
+			rh.ServeHTTP(recorder, r)
+			codes = append(codes, recorder.Code)
+		}
+		Expect(codes).To(Equal([]int{http.StatusNoContent, http.StatusTooManyRequests, http.StatusNoContent}))
+	})
+
+	It("should key on the resolved client IP", func() {
+		rh, err := handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitClock(clock),
+			handler.WithRateLimitClientIPResolver(handler.ForwardedClientIP))
+		Expect(err).ToNot(HaveOccurred())
+
+		first := request("10.0.0.1:1234")
+		first.Header.Set("X-Forwarded-For", "192.0.2.1")
+		rh.ServeHTTP(httptest.NewRecorder(), first)
+		second := request("10.0.0.1:1234")
+		second.Header.Set("X-Forwarded-For", "192.0.2.2")
+		recorder := httptest.NewRecorder()
+		rh.ServeHTTP(recorder, second)
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should share limits through a store", func() {
+		store, err := handler.NewMemoryRateLimitStore(10)
+		Expect(err).ToNot(HaveOccurred())
+		middleware, err := handler.RateLimitMiddleware(algorithm,
+			handler.WithRateLimitClock(clock), handler.WithRateLimitStore(store))
+		Expect(err).ToNot(HaveOccurred())
+
+		middleware(ok).ServeHTTP(httptest.NewRecorder(), request("10.0.0.1:1234"))
+		recorder := httptest.NewRecorder()
+		middleware(ok).ServeHTTP(recorder, request("10.0.0.1:1234"))
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should fail open when the store errors", func() {
+		rh, err := handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitStore(failingRateLimitStore{}))
+		Expect(err).ToNot(HaveOccurred())
+		recorder := httptest.NewRecorder()
+		rh.ServeHTTP(recorder, request("10.0.0.1:1234"))
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Expect(recorder.Header().Get("RateLimit-Limit")).To(BeEmpty())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewRateLimitHandler(nil, algorithm)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewRateLimitHandler(ok, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewRateLimitHandler(ok, handler.SlidingWindow{})
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitStore(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitKey(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitKey(handler.ClientIPKey(nil)))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitClientIPResolver(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewRateLimitHandler(ok, algorithm, handler.WithRateLimitClock(nil))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,123 @@
+package handler_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("rate limit algorithms", func() {
+	var (
+		now   time.Time
+		state *handler.RateLimitState
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+		state = &handler.RateLimitState{}
+	})
+
+	Describe("TokenBucket", func() {
+		var tb handler.TokenBucket
+
+		BeforeEach(func() {
+			tb = handler.TokenBucket{Burst: 2, Rate: 1, Per: time.Second}
+		})
+
+		It("should allow bursts and then refill at the rate", func() {
+			first := tb.Take(state, now)
+			Expect(first.Allowed).To(BeTrue())
+			Expect(first.Remaining).To(Equal(1))
+			Expect(tb.Take(state, now).Allowed).To(BeTrue())
+
+			rejected := tb.Take(state, now)
+			Expect(rejected.Allowed).To(BeFalse())
+			Expect(rejected.Remaining).To(BeZero())
+			Expect(rejected.RetryAfter).To(Equal(time.Second))
+			Expect(rejected.Reset).To(Equal(2 * time.Second))
+
+			Expect(tb.Take(state, now.Add(500*time.Millisecond)).Allowed).To(BeFalse())
+			Expect(tb.Take(state, now.Add(time.Second)).Allowed).To(BeTrue())
+		})
+
+		It("should not refill beyond the burst", func() {
+			tb.Take(state, now)
+			result := tb.Take(state, now.Add(time.Hour))
+			Expect(result.Remaining).To(Equal(1))
+		})
+
+		It("should validate its settings", func() {
+			Expect(tb.Validate()).To(Succeed())
+			Expect(handler.TokenBucket{Burst: 1, Rate: 1}.Validate()).ToNot(Succeed())
+		})
+	})
+
+	Describe("SlidingWindow", func() {
+		var sw handler.SlidingWindow
+
+		BeforeEach(func() {
+			sw = handler.SlidingWindow{Requests: 2, Window: time.Minute}
+		})
+
+		It("should limit requests within a window", func() {
+			Expect(sw.Take(state, now).Allowed).To(BeTrue())
+			second := sw.Take(state, now.Add(10*time.Second))
+			Expect(second.Allowed).To(BeTrue())
+			Expect(second.Remaining).To(BeZero())
+			Expect(second.Reset).To(Equal(50 * time.Second))
+
+			rejected := sw.Take(state, now.Add(20*time.Second))
+			Expect(rejected.Allowed).To(BeFalse())
+			Expect(rejected.RetryAfter).To(Equal(70 * time.Second))
+		})
+
+		It("should weigh the previous window by how much of it still overlaps", func() {
+			sw.Take(state, now)
+			sw.Take(state, now)
+			Expect(sw.Take(state, now.Add(time.Minute+10*time.Second)).Allowed).To(BeFalse())
+
+			allowed := sw.Take(state, now.Add(time.Minute+30*time.Second))
+			Expect(allowed.Allowed).To(BeTrue())
+			Expect(allowed.Remaining).To(BeZero())
+		})
+
+		It("should forget windows that no longer overlap", func() {
+			sw.Take(state, now)
+			sw.Take(state, now)
+			result := sw.Take(state, now.Add(3*time.Minute))
+			Expect(result.Allowed).To(BeTrue())
+			Expect(result.Remaining).To(Equal(1))
+		})
+
+		It("should validate its settings", func() {
+			Expect(sw.Validate()).To(Succeed())
+			Expect(handler.SlidingWindow{Requests: 1}.Validate()).ToNot(Succeed())
+		})
+	})
+})
+
+var _ = Describe("MemoryRateLimitStore", func() {
+	It("should keep state per key and evict the least recently used", func() {
+		store, err := handler.NewMemoryRateLimitStore(2)
+		Expect(err).ToNot(HaveOccurred())
+		increment := func(state *handler.RateLimitState) { state.Count++ }
+		Expect(store.Update("a", increment)).To(Succeed())
+		Expect(store.Update("a", increment)).To(Succeed())
+		Expect(store.Update("b", increment)).To(Succeed())
+		Expect(store.Update("a", func(state *handler.RateLimitState) {
+			Expect(state.Count).To(Equal(2))
+		})).To(Succeed())
+		Expect(store.Update("c", increment)).To(Succeed())
+		Expect(store.Len()).To(Equal(2))
+		Expect(store.Update("b", func(state *handler.RateLimitState) {
+			Expect(state.Count).To(BeZero())
+		})).To(Succeed())
+	})
+
+	It("should reject invalid sizes", func() {
+		_, err := handler.NewMemoryRateLimitStore(0)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecordHandler records the requests it is told to, with their responses, to a HARWriter such as a HARArchive, so that
+// a customer's bug can be reproduced from exactly what they sent. Requests are picked by MatchFunc, typically by
+// route, then sampled at SampleRate, and their responses kept only if StatusFunc accepts the status. Headers, URIs and
+// bodies pass through Redactor first, so a recorded Authorization header or token needs putting back before replaying.
+//
+// Bodies are recorded as they stream through, up to MaxBodyBytes each; a request body only as far as Next read it.
+// Binary bodies are kept base64 encoded and are not redacted. A request that panics is recorded with a 500 unless it
+// had sent a status already, and the panic is passed on.
+type RecordHandler struct {
+	Writer       HARWriter
+	MatchFunc    func(r *http.Request) bool
+	StatusFunc   func(status int) bool
+	SampleRate   float64
+	Redactor     *Redactor
+	MaxBodyBytes int
+	// ErrorFunc is told about entries that could not be written. Recording never fails a request.
+	ErrorFunc func(r *http.Request, err error)
+	Next      http.Handler
+	clock     Clock
+}
+
+type RecordHandlerOption func(*RecordHandler) error
+
+// NewRecordHandler records every request, with bodies of up to 1MiB, redacted by NewDefaultRedactor, unless told
+// otherwise.
+func NewRecordHandler(next http.Handler, writer HARWriter, opts ...RecordHandlerOption) (RecordHandler, error) {
+	if next == nil {
+		return RecordHandler{}, ErrNilNext
+	}
+	if writer == nil {
+		return RecordHandler{}, errors.New("handler: HAR writer must not be nil")
+	}
+	rh := RecordHandler{
+		Writer:       writer,
+		SampleRate:   1,
+		Redactor:     NewDefaultRedactor(),
+		MaxBodyBytes: 1 << 20,
+		Next:         next,
+		clock:        time.Now,
+	}
+	for _, opt := range opts {
+		if optErr := opt(&rh); optErr != nil {
+			return RecordHandler{}, optErr
+		}
+	}
+	return rh, nil
+}
+
+// WithRecordMatch records only the requests match accepts, such as those for a route under investigation.
+func WithRecordMatch(match func(r *http.Request) bool) RecordHandlerOption {
+	return func(rh *RecordHandler) error {
+		if match == nil {
+			return errors.New("handler: record match func must not be nil")
+		}
+		rh.MatchFunc = match
+		return nil
+	}
+}
+
+// WithRecordStatus keeps only the responses whose status keep accepts, such as server errors.
+func WithRecordStatus(keep func(status int) bool) RecordHandlerOption {
+	return func(rh *RecordHandler) error {
+		if keep == nil {
+			return errors.New("handler: record status func must not be nil")
+		}
+		rh.StatusFunc = keep
+		return nil
+	}
+}
+
+// WithRecordSampling records the given fraction of matching requests.
+func WithRecordSampling(rate float64) RecordHandlerOption {
+	return func(rh *RecordHandler) error {
+		if rate <= 0 || rate > 1 {
+			return fmt.Errorf("handler: record sample rate %v is not in (0, 1]", rate)
+		}
+		rh.SampleRate = rate
+		return nil
+	}
+}
+
+// WithRecordRedactor replaces the default redactor. Pass &Redactor{} to record everything as it was sent.
+func WithRecordRedactor(redactor *Redactor) RecordHandlerOption {
+	return func(rh *RecordHandler) error {
+		if redactor == nil {
+			return errors.New("handler: redactor must not be nil")
+		}
+		if err := redactor.Validate(); err != nil {
+			return err
+		}
+		rh.Redactor = redactor
+		return nil
+	}
+}
+
+func WithRecordMaxBodyBytes(maxBytes int) RecordHandlerOption {
+	return func(rh *RecordHandler) error {
+		if maxBytes <= 0 {
+			return errors.New("handler: record body limit must be positive")
+		}
+		rh.MaxBodyBytes = maxBytes
+		return nil
+	}
+}
+
+func WithRecordErrorFunc(fn func(r *http.Request, err error)) RecordHandlerOption {
+	return func(rh *RecordHandler) error {
+		if fn == nil {
+			return errors.New("handler: record error func must not be nil")
+		}
+		rh.ErrorFunc = fn
+		return nil
+	}
+}
+
+func WithRecordClock(clock Clock) RecordHandlerOption {
+	return func(rh *RecordHandler) error {
+		if clock == nil {
+			return errors.New("handler: clock must not be nil")
+		}
+		rh.clock = clock
+		return nil
+	}
+}
+
+func (rh RecordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !rh.selects(r) {
+		rh.Next.ServeHTTP(w, r)
+		return
+	}
+	start := rh.now()
+	config := &BodyCaptureConfig{MaxBytes: rh.MaxBodyBytes}
+	var requestCapture *bodyCapturer
+	if r.Body != nil && r.Body != http.NoBody {
+		requestCapture = &bodyCapturer{config: config, contentType: r.Header.Get("Content-Type")}
+		r = r.WithContext(r.Context())
+		r.Body = &capturingReadCloser{r.Body, requestCapture}
+	}
+	rw := &harResponseWriter{ResponseWriter: w, status: http.StatusOK, capture: &bodyCapturer{config: config}}
+	defer func() {
+		p := recover()
+		comment := ""
+		if p != nil {
+			comment = fmt.Sprintf("panic: %v", p)
+			if !rw.wroteHeader {
+				rw.status = http.StatusInternalServerError
+			}
+		}
+		rh.record(r, requestCapture, rw, start, comment)
+		if p != nil {
+			panic(p)
+		}
+	}()
+	rh.Next.ServeHTTP(rw, r)
+}
+
+func (rh RecordHandler) selects(r *http.Request) bool {
+	if rh.MatchFunc != nil && !rh.MatchFunc(r) {
+		return false
+	}
+	return rh.SampleRate >= 1 || rand.Float64() < rh.SampleRate
+}
+
+func (rh RecordHandler) record(r *http.Request, requestCapture *bodyCapturer, rw *harResponseWriter, start time.Time,
+	comment string) {
+
+	if rh.StatusFunc != nil && !rh.StatusFunc(rw.status) {
+		return
+	}
+	elapsed := float64(rh.now().Sub(start)) / float64(time.Millisecond)
+	entry := HAREntry{
+		StartedDateTime: start,
+		Time:            elapsed,
+		Request:         rh.harRequest(r, requestCapture),
+		Response:        rh.harResponse(r, rw),
+		Timings:         HARTimings{Wait: elapsed},
+		Comment:         comment,
+		RequestID:       r.Header.Get(RequestIDHeader),
+	}
+	if err := rh.Writer.WriteEntry(entry); err != nil && rh.ErrorFunc != nil {
+		rh.ErrorFunc(r, err)
+	}
+}
+
+func (rh RecordHandler) harRequest(r *http.Request, capture *bodyCapturer) HARRequest {
+	header := rh.Redactor.Header(r.Header)
+	uri := rh.Redactor.URI(r.URL.RequestURI())
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if host == "" {
+		host = r.URL.Host
+	}
+	request := HARRequest{
+		Method:      r.Method,
+		URL:         scheme + "://" + host + uri,
+		HTTPVersion: r.Proto,
+		Cookies:     harCookies((&http.Request{Header: header}).Cookies()),
+		Headers:     harHeaders(header),
+		QueryString: harQuery(uri),
+		HeadersSize: -1,
+	}
+	if capture != nil {
+		text, encoding := rh.harBody(capture)
+		request.PostData = &HARPostData{
+			MimeType:  capture.contentType,
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: capture.size > int64(capture.buf.Len()),
+		}
+		request.BodySize = capture.size
+	}
+	return request
+}
+
+func (rh RecordHandler) harResponse(r *http.Request, rw *harResponseWriter) HARResponse {
+	if rw.header == nil {
+		rw.header = rw.Header().Clone()
+	}
+	header := rh.Redactor.Header(rw.header)
+	text, encoding := rh.harBody(rw.capture)
+	return HARResponse{
+		Status:      rw.status,
+		StatusText:  http.StatusText(rw.status),
+		HTTPVersion: r.Proto,
+		Cookies:     harCookies((&http.Response{Header: header}).Cookies()),
+		Headers:     harHeaders(header),
+		Content: HARContent{
+			Size:      rw.capture.size,
+			MimeType:  rw.capture.contentType,
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: rw.capture.size > int64(rw.capture.buf.Len()),
+		},
+		RedirectURL: rh.Redactor.URI(rw.header.Get("Location")),
+		HeadersSize: -1,
+		BodySize:    rw.capture.size,
+	}
+}
+
+// harBody redacts a text body, or base64 encodes a binary one.
+func (rh RecordHandler) harBody(c *bodyCapturer) (string, string) {
+	data := c.buf.Bytes()
+	if isBinary(data) {
+		return base64.StdEncoding.EncodeToString(data), "base64"
+	}
+	return string(rh.Redactor.Body(c.contentType, data)), ""
+}
+
+func (rh RecordHandler) now() time.Time {
+	if rh.clock == nil {
+		return time.Now()
+	}
+	return rh.clock()
+}
+
+func harHeaders(h http.Header) []HARNameValue {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := []HARNameValue{}
+	for _, name := range names {
+		for _, v := range h[name] {
+			headers = append(headers, HARNameValue{Name: name, Value: v})
+		}
+	}
+	return headers
+}
+
+func harCookies(cookies []*http.Cookie) []HARCookie {
+	harCookies := []HARCookie{}
+	for _, c := range cookies {
+		harCookies = append(harCookies, HARCookie{Name: c.Name, Value: c.Value})
+	}
+	return harCookies
+}
+
+// harQuery lists the query parameters of uri in order, which url.ParseQuery would lose.
+func harQuery(uri string) []HARNameValue {
+	params := []HARNameValue{}
+	q := strings.Index(uri, "?")
+	if q == -1 {
+		return params
+	}
+	for _, param := range strings.Split(uri[q+1:], "&") {
+		if param == "" {
+			continue
+		}
+		name, value := param, ""
+		if eq := strings.Index(param, "="); eq != -1 {
+			name, value = param[:eq], param[eq+1:]
+		}
+		params = append(params, HARNameValue{Name: unescapeQuery(name), Value: unescapeQuery(value)})
+	}
+	return params
+}
+
+func unescapeQuery(s string) string {
+	if unescaped, err := url.QueryUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+// harResponseWriter keeps the status, headers and the start of the body of the response it passes through. Like
+// net/http, it sniffs the Content-Type of a body written without one.
+type harResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	capture     *bodyCapturer
+	wroteHeader bool
+}
+
+func (hw *harResponseWriter) WriteHeader(statusCode int) {
+	if hw.wroteHeader {
+		return
+	}
+	if statusCode >= http.StatusOK {
+		hw.wroteHeader = true
+		hw.status = statusCode
+		hw.header = hw.Header().Clone()
+		hw.capture.contentType = hw.header.Get("Content-Type")
+	}
+	hw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (hw *harResponseWriter) Write(p []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	n, err := hw.ResponseWriter.Write(p)
+	if hw.capture.size == 0 && n > 0 {
+		hw.sniff(p[:n])
+	}
+	hw.capture.write(p[:n])
+	return n, err
+}
+
+func (hw *harResponseWriter) sniff(p []byte) {
+	if _, ok := hw.header["Content-Type"]; ok || hw.header.Get("Content-Encoding") != "" {
+		return
+	}
+	hw.capture.contentType = http.DetectContentType(p)
+	hw.header.Set("Content-Type", hw.capture.contentType)
+}
+
+func (hw *harResponseWriter) Flush() {
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (hw *harResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := hw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("handler: ResponseWriter does not implement http.Hijacker")
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,251 @@
+package handler_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+// memoryHARWriter keeps the entries written to it.
+type memoryHARWriter struct {
+	mu      sync.Mutex
+	entries []handler.HAREntry
+	err     error
+}
+
+func (mw *memoryHARWriter) WriteEntry(entry handler.HAREntry) error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if mw.err != nil {
+		return mw.err
+	}
+	mw.entries = append(mw.entries, entry)
+	return nil
+}
+
+func (mw *memoryHARWriter) Entries() []handler.HAREntry {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	return append([]handler.HAREntry(nil), mw.entries...)
+}
+
+var _ = Describe("RecordHandler", func() {
+	start := time.Date(2019, 10, 13, 12, 0, 0, 0, time.UTC)
+
+	var (
+		writer *memoryHARWriter
+		next   http.Handler
+		clock  handler.Clock
+	)
+
+	BeforeEach(func() {
+		writer = &memoryHARWriter{}
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body strings.Builder
+			if r.Body != nil {
+				buf := make([]byte, 1024)
+				for {
+					n, err := r.Body.Read(buf)
+					body.Write(buf[:n])
+					if err != nil {
+						break
+					}
+				}
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"got":"` + body.String() + `"}`))
+		})
+		now := start
+		clock = func() time.Time {
+			t := now
+			now = now.Add(250 * time.Millisecond)
+			return t
+		}
+	})
+
+	serve := func(h http.Handler, request *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	It("should record the request and response as a HAR entry", func() {
+		rh, err := handler.NewRecordHandler(next, writer, handler.WithRecordClock(clock))
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("POST", "/things?page=2&token=abc&q=a%20b", strings.NewReader("hi"))
+		request.Header.Set("Content-Type", "text/plain")
+		request.Header.Set("Authorization", "Bearer abc")
+		request.Header.Set("Cookie", "theme=dark")
+		request.Header.Set(handler.RequestIDHeader, "req-1")
+		recorder := serve(rh, request)
+		Expect(recorder.Code).To(Equal(http.StatusCreated))
+		Expect(recorder.Body.String()).To(Equal(`{"got":"hi"}`))
+
+		Expect(writer.Entries()).To(HaveLen(1))
+		entry := writer.Entries()[0]
+		Expect(entry.StartedDateTime).To(Equal(start))
+		Expect(entry.Time).To(Equal(250.0))
+		Expect(entry.Timings).To(Equal(handler.HARTimings{Wait: 250}))
+		Expect(entry.RequestID).To(Equal("req-1"))
+
+		req := entry.Request
+		Expect(req.Method).To(Equal("POST"))
+		Expect(req.URL).To(Equal("http://example.com/things?page=2&token=[REDACTED]&q=a%20b"))
+		Expect(req.HTTPVersion).To(Equal("HTTP/1.1"))
+		Expect(req.QueryString).To(Equal([]handler.HARNameValue{
+			{Name: "page", Value: "2"}, {Name: "token", Value: "[REDACTED]"}, {Name: "q", Value: "a b"},
+		}))
+		Expect(req.Headers).To(ContainElement(handler.HARNameValue{Name: "Authorization", Value: "[REDACTED]"}))
+		Expect(req.Cookies).To(Equal([]handler.HARCookie{{Name: "theme", Value: "[REDACTED]"}}))
+		Expect(req.PostData).To(Equal(&handler.HARPostData{MimeType: "text/plain", Text: "hi"}))
+		Expect(req.BodySize).To(Equal(int64(2)))
+		Expect(req.HeadersSize).To(Equal(int64(-1)))
+
+		resp := entry.Response
+		Expect(resp.Status).To(Equal(http.StatusCreated))
+		Expect(resp.StatusText).To(Equal("Created"))
+		Expect(resp.Headers).To(ContainElement(handler.HARNameValue{Name: "Set-Cookie", Value: "[REDACTED]"}))
+		Expect(resp.Cookies).To(BeEmpty())
+		Expect(resp.Content).To(Equal(handler.HARContent{Size: 12, MimeType: "application/json", Text: `{"got":"hi"}`}))
+		Expect(resp.BodySize).To(Equal(int64(12)))
+	})
+
+	It("should record only matching requests and statuses", func() {
+		rh, err := handler.NewRecordHandler(next, writer,
+			handler.WithRecordMatch(func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/things") }),
+			handler.WithRecordStatus(func(status int) bool { return status >= http.StatusInternalServerError }))
+		Expect(err).ToNot(HaveOccurred())
+		serve(rh, httptest.NewRequest("GET", "/other", nil))
+		serve(rh, httptest.NewRequest("GET", "/things", nil))
+		Expect(writer.Entries()).To(BeEmpty())
+
+		failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		})
+		rh.Next = failing
+		serve(rh, httptest.NewRequest("GET", "/other", nil))
+		serve(rh, httptest.NewRequest("GET", "/things", nil))
+		Expect(writer.Entries()).To(HaveLen(1))
+		Expect(writer.Entries()[0].Response.Status).To(Equal(http.StatusBadGateway))
+		Expect(writer.Entries()[0].Request.PostData).To(BeNil())
+	})
+
+	It("should sample requests", func() {
+		rh, err := handler.NewRecordHandler(next, writer, handler.WithRecordSampling(0.5))
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 400; i++ {
+			serve(rh, httptest.NewRequest("GET", "/things", nil))
+		}
+		Expect(len(writer.Entries())).To(BeNumerically("~", 200, 80))
+	})
+
+	It("should truncate large bodies and base64 encode binary ones", func() {
+		binary := []byte{0, 1, 2, 3}
+		rh, err := handler.NewRecordHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(binary)
+		}), writer, handler.WithRecordMaxBodyBytes(5))
+		Expect(err).ToNot(HaveOccurred())
+		serve(rh, httptest.NewRequest("PUT", "/things", strings.NewReader("0123456789")))
+
+		entry := writer.Entries()[0]
+		Expect(entry.Request.PostData.Text).To(BeEmpty())
+		Expect(entry.Request.BodySize).To(Equal(int64(0)))
+
+		rh.Next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf := make([]byte, 10)
+			_, _ = r.Body.Read(buf)
+			_, _ = w.Write([]byte("abcdefgh"))
+		})
+		serve(rh, httptest.NewRequest("PUT", "/things", strings.NewReader("0123456789")))
+		entry = writer.Entries()[1]
+		Expect(entry.Request.PostData.Text).To(Equal("01234"))
+		Expect(entry.Request.PostData.Truncated).To(BeTrue())
+		Expect(entry.Request.BodySize).To(Equal(int64(10)))
+		Expect(entry.Response.Content.Text).To(Equal("abcde"))
+		Expect(entry.Response.Content.Size).To(Equal(int64(8)))
+		Expect(entry.Response.Content.Truncated).To(BeTrue())
+
+		Expect(writer.Entries()[0].Response.Content.Encoding).To(Equal("base64"))
+		Expect(writer.Entries()[0].Response.Content.Text).To(Equal(base64.StdEncoding.EncodeToString(binary)))
+	})
+
+	It("should record the Content-Type sniffed for bodies written without one", func() {
+		rh, err := handler.NewRecordHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("<!DOCTYPE html><p>hi</p>"))
+		}), writer)
+		Expect(err).ToNot(HaveOccurred())
+		serve(rh, httptest.NewRequest("GET", "/things", nil))
+		resp := writer.Entries()[0].Response
+		Expect(resp.Content.MimeType).To(Equal("text/html; charset=utf-8"))
+		Expect(resp.Headers).To(ContainElement(handler.HARNameValue{Name: "Content-Type",
+			Value: "text/html; charset=utf-8"}))
+	})
+
+	It("should let the handler hijack the connection", func() {
+		rh, err := handler.NewRecordHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _, hijackErr := w.(http.Hijacker).Hijack()
+			Expect(hijackErr).ToNot(HaveOccurred())
+		}), writer)
+		Expect(err).ToNot(HaveOccurred())
+		hr := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+		rh.ServeHTTP(hr, httptest.NewRequest("GET", "/things", nil))
+		Expect(hr.hijacked).To(BeTrue())
+	})
+
+	It("should record panicking requests and pass the panic on", func() {
+		rh, err := handler.NewRecordHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}), writer)
+		Expect(err).ToNot(HaveOccurred())
+		var recovered interface{}
+		func() {
+			defer func() { recovered = recover() }()
+			serve(rh, httptest.NewRequest("GET", "/things", nil))
+		}()
+		Expect(recovered).To(Equal("boom"))
+		Expect(writer.Entries()).To(HaveLen(1))
+		Expect(writer.Entries()[0].Response.Status).To(Equal(http.StatusInternalServerError))
+		Expect(writer.Entries()[0].Comment).To(Equal("panic: boom"))
+	})
+
+	It("should report entries it could not write without failing the request", func() {
+		writer.err = errors.New("disk full")
+		var reported error
+		rh, err := handler.NewRecordHandler(next, writer,
+			handler.WithRecordErrorFunc(func(r *http.Request, err error) { reported = err }))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serve(rh, httptest.NewRequest("GET", "/things", nil)).Code).To(Equal(http.StatusCreated))
+		Expect(reported).To(MatchError("disk full"))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewRecordHandler(nil, writer)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewRecordHandler(next, nil)
+		Expect(err).To(HaveOccurred())
+		for _, opt := range []handler.RecordHandlerOption{
+			handler.WithRecordMatch(nil),
+			handler.WithRecordStatus(nil),
+			handler.WithRecordSampling(0),
+			handler.WithRecordSampling(1.5),
+			handler.WithRecordRedactor(nil),
+			handler.WithRecordMaxBodyBytes(0),
+			handler.WithRecordErrorFunc(nil),
+			handler.WithRecordClock(nil),
+		} {
+			_, err = handler.RecordMiddleware(writer, opt)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
@@ -1,6 +1,10 @@
 package handler
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"runtime"
 )
@@ -11,6 +15,18 @@ type Stack struct {
 	FuncName   string
 }
 
+// RecoveredPanic carries a panic recovered on another goroutine, such as the one TimeoutHandler runs Next on, along
+// with the stack it was raised on. RecoveryHandler unwraps it, so that it reports where the panic happened rather than
+// where it was raised again.
+type RecoveredPanic struct {
+	Value interface{}
+	Stack []Stack
+}
+
+func (rp *RecoveredPanic) Error() string {
+	return fmt.Sprint(rp.Value)
+}
+
 type RecoveryFunc func(w http.ResponseWriter, req *http.Request, panicMessage interface{}, stackTrace []Stack)
 
 type RecoveryHandler struct {
@@ -18,15 +34,51 @@ type RecoveryHandler struct {
 	Next           http.Handler
 }
 
+type RecoveryHandlerOption func(*RecoveryHandler) error
+
+func NewRecoveryHandler(next http.Handler, opts ...RecoveryHandlerOption) (RecoveryHandler, error) {
+	if next == nil {
+		return RecoveryHandler{}, ErrNilNext
+	}
+	rh := RecoveryHandler{Next: next}
+	for _, opt := range opts {
+		if err := opt(&rh); err != nil {
+			return RecoveryHandler{}, err
+		}
+	}
+	return rh, nil
+}
+
+func WithRecoveryFunc(recoveryFunc RecoveryFunc) RecoveryHandlerOption {
+	return func(rh *RecoveryHandler) error {
+		rh.OnRecoveryFunc = recoveryFunc
+		return nil
+	}
+}
+
 func (rh RecoveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw := &recoveryWriter{ResponseWriter: w}
 	defer func() {
 		if err := recover(); err != nil {
-			if rh.OnRecoveryFunc != nil {
-				rh.OnRecoveryFunc(w, r, err, stackTrace())
+			if rh.OnRecoveryFunc == nil {
+				return
+			}
+			stack := stackTrace()
+			if rp, ok := err.(*RecoveredPanic); ok {
+				err, stack = rp.Value, rp.Stack
 			}
+			rh.OnRecoveryFunc(rw, r, err, stack)
 		}
 	}()
-	rh.Next.ServeHTTP(w, r)
+	rh.Next.ServeHTTP(rw, r)
+}
+
+// ResponseStarted reports whether the panicking handler had already sent a status, or hijacked the connection, before
+// it panicked, in which case a RecoveryFunc can no longer send an error response of its own. w must be the
+// ResponseWriter passed to the RecoveryFunc; for any other it reports false.
+func ResponseStarted(w http.ResponseWriter) bool {
+	rw, ok := w.(*recoveryWriter)
+	return ok && rw.started
 }
 
 func stackTrace() []Stack {
@@ -39,7 +91,7 @@ func stackTrace() []Stack {
 		traces = append(traces, Stack{
 			frame.File,
 			frame.Line,
-			frame.Func.Name(),
+			frame.Function,
 		})
 		if !more {
 			break
@@ -47,3 +99,40 @@ func stackTrace() []Stack {
 	}
 	return traces
 }
+
+// recoveryWriter notes whether the response has been started.
+type recoveryWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (rw *recoveryWriter) WriteHeader(statusCode int) {
+	if statusCode >= http.StatusOK {
+		rw.started = true
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recoveryWriter) Write(p []byte) (int, error) {
+	rw.started = true
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recoveryWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		rw.started = true
+		f.Flush()
+	}
+}
+
+func (rw *recoveryWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("handler: ResponseWriter does not implement http.Hijacker")
+	}
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		rw.started = true
+	}
+	return conn, brw, err
+}
@@ -73,6 +73,35 @@ var _ = Describe("RecoveryHandler", func() {
 		Expect(string(bytes)).To(ContainSubstring("runtime.gopanic()"))
 	})
 
+	It("should be constructible from options", func() {
+		recoveryHandler, err := handler.NewRecoveryHandler(panickingNextHandler, handler.WithRecoveryFunc(recoveryFunc))
+		Expect(err).ToNot(HaveOccurred())
+		recoveryHandler.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+	})
+
+	It("should tell the recoveryFunc whether the response had started", func() {
+		var started []bool
+		recoveryHandler, err := handler.NewRecoveryHandler(panickingNextHandler, handler.WithRecoveryFunc(
+			func(w http.ResponseWriter, _ *http.Request, _ interface{}, _ []handler.Stack) {
+				started = append(started, handler.ResponseStarted(w))
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		recoveryHandler.ServeHTTP(recorder, request)
+		recoveryHandler.Next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic(panicMessage)
+		})
+		recoveryHandler.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(started).To(Equal([]bool{false, true}))
+		Expect(handler.ResponseStarted(recorder)).To(BeFalse())
+	})
+
+	It("should reject a nil next handler", func() {
+		_, err := handler.NewRecoveryHandler(nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+	})
+
 	It("should not bomb if there is no recoveryFunc", func() {
 		recoveryHandler := handler.RecoveryHandler{
 			Next: panickingNextHandler,
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const RedactedValue = "[REDACTED]"
+
+var (
+	JWTPattern        = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	CardNumberPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	EmailPattern      = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+	jsonStringField = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)"((?:[^"\\]|\\.)*)("|$)`)
+)
+
+type RedactionMode int
+
+const (
+	// MaskRedaction replaces sensitive values with RedactedValue.
+	MaskRedaction RedactionMode = iota
+	// HashRedaction replaces sensitive values with a keyed hash so that equal values stay correlatable.
+	HashRedaction
+)
+
+// Redactor scrubs sensitive data from headers, request URIs and free-form values before they are logged.
+// A nil *Redactor returns its input unchanged.
+type Redactor struct {
+	// AllowedHeaders, when non-empty, is the only set of headers whose values are kept.
+	AllowedHeaders []string
+	// DeniedHeaders are always redacted.
+	DeniedHeaders []string
+	// Cookies lists the cookie names whose values are redacted from the Cookie header. "*" matches every cookie.
+	Cookies []string
+	// QueryParams lists the query parameters whose values are redacted. "*" matches every parameter.
+	QueryParams []string
+	// ValuePatterns are masked wherever they occur in a header or query value.
+	ValuePatterns []*regexp.Regexp
+	Mode          RedactionMode
+	// HashKey keys the HMAC used by HashRedaction and must not be empty, since an unkeyed hash of a short secret can be
+	// brute-forced. Values are masked instead while it is.
+	HashKey []byte
+}
+
+// Validate reports a configuration that would leak what it should redact. Options that take a Redactor call it.
+func (rd *Redactor) Validate() error {
+	if rd != nil && rd.Mode == HashRedaction && len(rd.HashKey) == 0 {
+		return errors.New("handler: hash redaction needs a HashKey")
+	}
+	return nil
+}
+
+func NewDefaultRedactor() *Redactor {
+	return &Redactor{
+		DeniedHeaders: []string{"Authorization", "Proxy-Authorization", "Set-Cookie", "X-Api-Key"},
+		Cookies:       []string{"*"},
+		QueryParams: []string{"access_token", "api_key", "apikey", "code", "password", "secret", "session",
+			"sig", "signature", "token"},
+		ValuePatterns: []*regexp.Regexp{JWTPattern, CardNumberPattern, EmailPattern},
+	}
+}
+
+// Header returns a copy of h with sensitive values redacted.
+func (rd *Redactor) Header(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for name, values := range h {
+		copied := make([]string, len(values))
+		for i, v := range values {
+			copied[i] = rd.headerValue(name, v)
+		}
+		redacted[name] = copied
+	}
+	return redacted
+}
+
+func (rd *Redactor) headerValue(name, value string) string {
+	if rd == nil {
+		return value
+	}
+	name = http.CanonicalHeaderKey(name)
+	if len(rd.AllowedHeaders) > 0 && !containsHeader(rd.AllowedHeaders, name) {
+		return rd.redact(value)
+	}
+	if containsHeader(rd.DeniedHeaders, name) {
+		return rd.redact(value)
+	}
+	if name == "Cookie" {
+		return rd.Cookie(value)
+	}
+	return rd.Value(value)
+}
+
+// Cookie redacts the values of the configured cookies in a Cookie header value.
+func (rd *Redactor) Cookie(value string) string {
+	if rd == nil || value == "" {
+		return value
+	}
+	pairs := strings.Split(value, ";")
+	for i, pair := range pairs {
+		trimmed := strings.TrimSpace(pair)
+		eq := strings.Index(trimmed, "=")
+		if eq == -1 {
+			continue
+		}
+		name, v := trimmed[:eq], trimmed[eq+1:]
+		if containsName(rd.Cookies, name) {
+			v = rd.redact(v)
+		} else {
+			v = rd.Value(v)
+		}
+		pairs[i] = name + "=" + v
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// URI redacts query parameter values in a request URI or an absolute URL such as a Referer.
+// Everything outside the query string is kept byte for byte.
+func (rd *Redactor) URI(uri string) string {
+	if rd == nil {
+		return uri
+	}
+	q := strings.Index(uri, "?")
+	if q == -1 {
+		return rd.Value(uri)
+	}
+	base, query, fragment := uri[:q], uri[q+1:], ""
+	if f := strings.Index(query, "#"); f != -1 {
+		query, fragment = query[:f], query[f:]
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, value := param, ""
+		eq := strings.Index(param, "=")
+		if eq != -1 {
+			name, value = param[:eq], param[eq+1:]
+		}
+		if eq == -1 || value == "" {
+			continue
+		}
+		if containsName(rd.QueryParams, queryName(name)) {
+			value = rd.redact(value)
+		} else {
+			value = rd.Value(value)
+		}
+		params[i] = name + "=" + value
+	}
+	return rd.Value(base) + "?" + strings.Join(params, "&") + fragment
+}
+
+// queryName decodes an escaped parameter name, such as access%5Ftoken, so that it matches its configured name.
+func queryName(name string) string {
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// Body redacts a captured body. Form bodies are treated like query strings, and string fields of JSON bodies named
+// like a redacted query parameter are redacted. Anything else is pattern masked. Its signature matches
+// BodyCaptureConfig.Redact.
+func (rd *Redactor) Body(contentType string, body []byte) []byte {
+	if rd == nil {
+		return body
+	}
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return []byte(strings.TrimPrefix(rd.URI("?"+string(body)), "?"))
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return []byte(rd.Value(rd.jsonFields(string(body))))
+	}
+	return []byte(rd.Value(string(body)))
+}
+
+// jsonFields works on the text rather than a decoded value so that truncated bodies are redacted too.
+func (rd *Redactor) jsonFields(body string) string {
+	return jsonStringField.ReplaceAllStringFunc(body, func(field string) string {
+		parts := jsonStringField.FindStringSubmatch(field)
+		if !containsName(rd.QueryParams, parts[1]) {
+			return field
+		}
+		return `"` + parts[1] + `"` + parts[2] + `"` + rd.redact(parts[3]) + parts[4]
+	})
+}
+
+// Value masks every match of the configured ValuePatterns in s.
+func (rd *Redactor) Value(s string) string {
+	if rd == nil {
+		return s
+	}
+	for _, p := range rd.ValuePatterns {
+		s = p.ReplaceAllStringFunc(s, rd.redact)
+	}
+	return s
+}
+
+func (rd *Redactor) redact(value string) string {
+	if rd.Mode != HashRedaction || len(rd.HashKey) == 0 {
+		return RedactedValue
+	}
+	mac := hmac.New(sha256.New, rd.HashKey)
+	_, _ = mac.Write([]byte(value))
+	return "sha256:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func containsHeader(names []string, name string) bool {
+	for _, n := range names {
+		if http.CanonicalHeaderKey(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == "*" || strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
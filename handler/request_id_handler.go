@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -13,19 +14,50 @@ type RequestIDHandler struct {
 	Next        http.Handler
 }
 
+type RequestIDHandlerOption func(*RequestIDHandler) error
+
 func (ri RequestIDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get(RequestIDHeader) == "" {
-		r.Header.Set(RequestIDHeader, ri.IDGenerator())
+		idGenerator := ri.IDGenerator
+		if idGenerator == nil {
+			idGenerator = UUIDGenerator
+		}
+		r.Header.Set(RequestIDHeader, idGenerator())
 	}
 	ri.Next.ServeHTTP(w, r)
 }
 
-func NewUUIDRequestIDHandler(next http.Handler) RequestIDHandler {
-	idGenfn := func() string {
-		return uuid.New().String()
+func UUIDGenerator() string {
+	return uuid.New().String()
+}
+
+// NewRequestIDHandler generates UUIDs unless WithIDGenerator says otherwise.
+func NewRequestIDHandler(next http.Handler, opts ...RequestIDHandlerOption) (RequestIDHandler, error) {
+	if next == nil {
+		return RequestIDHandler{}, ErrNilNext
+	}
+	ri := RequestIDHandler{IDGenerator: UUIDGenerator, Next: next}
+	for _, opt := range opts {
+		if err := opt(&ri); err != nil {
+			return RequestIDHandler{}, err
+		}
 	}
+	return ri, nil
+}
+
+func WithIDGenerator(idGenerator IDGenerator) RequestIDHandlerOption {
+	return func(ri *RequestIDHandler) error {
+		if idGenerator == nil {
+			return errors.New("handler: ID generator must not be nil")
+		}
+		ri.IDGenerator = idGenerator
+		return nil
+	}
+}
+
+func NewUUIDRequestIDHandler(next http.Handler) RequestIDHandler {
 	return RequestIDHandler{
-		IDGenerator: idGenfn,
+		IDGenerator: UUIDGenerator,
 		Next:        next,
 	}
 }
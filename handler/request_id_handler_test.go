@@ -37,6 +37,24 @@ var _ = Describe("UUIDRequestIdHandler", func() {
 		})
 	})
 
+	When("constructed with options", func() {
+		It("should use the configured ID generator", func() {
+			idHandler, err := handler.NewRequestIDHandler(nextHandler,
+				handler.WithIDGenerator(func() string { return "fixed" }))
+			Expect(err).ToNot(HaveOccurred())
+			recorder := httptest.NewRecorder()
+			idHandler.ServeHTTP(recorder, httptest.NewRequest("GET", "/test", nil))
+			Expect(recorder.Header().Get(handler.RequestIDHeader)).To(Equal("fixed"))
+		})
+
+		It("should reject invalid setups", func() {
+			_, err := handler.NewRequestIDHandler(nil)
+			Expect(err).To(Equal(handler.ErrNilNext))
+			_, err = handler.NewRequestIDHandler(nextHandler, handler.WithIDGenerator(nil))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	When("there is an existing request ID header", func() {
 		It("should not change it", func() {
 			recorder := httptest.NewRecorder()
@@ -1,8 +1,13 @@
 package handler
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,67 +17,349 @@ type RequestMetadata struct {
 	RemoteAddr     string
 	ExecutionTime  time.Duration
 	Status         int
+	// WroteHeader is whether the handler sent a status at all. Status is 200 when it did not.
+	WroteHeader  bool
+	RequestBody  *CapturedBody
+	ResponseBody *CapturedBody
+	// Cancelled and DeadlineExceeded report the state of the request context when the handler returned.
+	// A cancelled context usually means the client went away.
+	Cancelled        bool
+	DeadlineExceeded bool
+	// WriteError is the first error returned while writing the response.
+	WriteError error
+	// TLS is nil for plain HTTP requests.
+	TLS *TLSMetadata
+	// Timeout is the deadline a TimeoutHandler applied to the request, and TimedOut whether it fired before the
+	// handler finished.
+	Timeout  time.Duration
+	TimedOut bool
+	// BodyBytesRead is how much of the request body the handler read through a BodyLimitHandler, and
+	// BodyLimitExceeded and BodyTooSlow record which of its limits, if any, the body broke.
+	BodyBytesRead     int64
+	BodyLimitExceeded bool
+	BodyTooSlow       bool
+	// RateLimited is set when a RateLimitHandler turned the request away.
+	RateLimited bool
+	// Shed is set when a LoadShedHandler turned the request away, and QueueTime is how long it waited for a slot.
+	Shed      bool
+	QueueTime time.Duration
+	// BytesWritten is the size of the response body as sent, and UncompressedBytes its size before a
+	// CompressHandler compressed it.
+	BytesWritten      int64
+	UncompressedBytes int64
+	// BytesSaved is the size of the body an ETagHandler did not send because the client's copy was current.
+	BytesSaved int64
+	// IdempotentReplay is set when an IdempotencyHandler answered the request with a recorded response.
+	IdempotentReplay bool
+	// Cache is whether a CacheHandler answered the request from its cache: CacheHit, CacheMiss or CacheStale.
+	Cache string
+	// User identifies whoever an authentication handler, such as those in the auth package, authenticated the request
+	// as.
+	User string
 }
 
 type RequestStartFunc func(r *http.Request, metadata RequestMetadata)
 
 type RequestEndFunc func(w http.ResponseWriter, r *http.Request, metadata RequestMetadata)
 
-type clock func() time.Time
+type Clock func() time.Time
+
+// ClientIPResolver works out the address of the client that made a request.
+type ClientIPResolver func(r *http.Request) string
 
 type RequestsHandler struct {
 	OnRequestStartFunc RequestStartFunc
 	OnRequestEndFunc   RequestEndFunc
+	ClientIPResolver   ClientIPResolver
+	BodyCapture        *BodyCaptureConfig
+	Registry           *InFlightRegistry
 	Next               http.Handler
-	clock              clock
+	clock              Clock
+}
+
+type RequestsHandlerOption func(*RequestsHandler) error
+
+type metadataCtxKey struct{}
+
+type metadataUpdates struct {
+	mu      sync.Mutex
+	updates []func(*RequestMetadata)
+	applied bool
 }
 
 type loggingResponseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode      int
+	wroteHeader     bool
+	bodyCapture     *BodyCaptureConfig
+	responseCapture *bodyCapturer
+	inFlight        *inFlightEntry
+	writeErr        error
+	bytesWritten    int64
+}
+
+func NewRequestsHandlerWithOptions(next http.Handler, opts ...RequestsHandlerOption) (RequestsHandler, error) {
+	if next == nil {
+		return RequestsHandler{}, ErrNilNext
+	}
+	rh := RequestsHandler{
+		Next:             next,
+		ClientIPResolver: ForwardedClientIP,
+		clock:            time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(&rh); err != nil {
+			return RequestsHandler{}, err
+		}
+	}
+	return rh, nil
 }
 
+// NewRequestsHandler is the constructor from before RequestsHandler took options. Nil callbacks are no-ops.
+//
+// Deprecated: use NewRequestsHandlerWithOptions, which also rejects a nil next handler.
 func NewRequestsHandler(requestStartFunc RequestStartFunc, requestEndFunc RequestEndFunc, next http.Handler) RequestsHandler {
 	return RequestsHandler{
 		OnRequestStartFunc: requestStartFunc,
 		OnRequestEndFunc:   requestEndFunc,
+		ClientIPResolver:   ForwardedClientIP,
 		Next:               next,
 		clock:              time.Now,
 	}
 }
 
+func WithStartFunc(requestStartFunc RequestStartFunc) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		rh.OnRequestStartFunc = requestStartFunc
+		return nil
+	}
+}
+
+func WithEndFunc(requestEndFunc RequestEndFunc) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		rh.OnRequestEndFunc = requestEndFunc
+		return nil
+	}
+}
+
+// WithClock replaces time.Now, mostly so that tests can control timestamps and execution times.
+func WithClock(clock Clock) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		if clock == nil {
+			return errors.New("handler: clock must not be nil")
+		}
+		rh.clock = clock
+		return nil
+	}
+}
+
+func WithClientIPResolver(resolver ClientIPResolver) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		if resolver == nil {
+			return errors.New("handler: client IP resolver must not be nil")
+		}
+		rh.ClientIPResolver = resolver
+		return nil
+	}
+}
+
+func WithBodyCapture(config *BodyCaptureConfig) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		if config == nil || config.MaxBytes <= 0 {
+			return errors.New("handler: body capture needs a positive MaxBytes")
+		}
+		rh.BodyCapture = config
+		return nil
+	}
+}
+
+func WithInFlightRegistry(registry *InFlightRegistry) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		if registry == nil {
+			return errors.New("handler: in-flight registry must not be nil")
+		}
+		rh.Registry = registry
+		return nil
+	}
+}
+
 func (rh RequestsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rh.clock == nil {
+		rh.clock = time.Now
+	}
+	if rh.ClientIPResolver == nil {
+		rh.ClientIPResolver = ForwardedClientIP
+	}
 	start := rh.clock()
 
 	metadata := RequestMetadata{
 		StartTimestamp: start,
-		RemoteAddr:     remoteAddr(r),
+		RemoteAddr:     rh.ClientIPResolver(r),
+		TLS:            newTLSMetadata(r.TLS),
 	}
 
-	rh.OnRequestStartFunc(r, metadata)
+	if rh.OnRequestStartFunc != nil {
+		rh.OnRequestStartFunc(r, metadata)
+	}
 
-	lw := &loggingResponseWriter{w, http.StatusOK}
+	lw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	if rh.Registry != nil {
+		var deregister func()
+		r, lw.inFlight, deregister = rh.Registry.register(r, metadata)
+		defer deregister()
+	}
+	updates := &metadataUpdates{}
+	r = r.WithContext(context.WithValue(r.Context(), metadataCtxKey{}, updates))
+	var requestCapture *bodyCapturer
+	if rh.BodyCapture.enabledFor(r) {
+		lw.bodyCapture = rh.BodyCapture
+		r, requestCapture = captureRequestBody(r, rh.BodyCapture)
+	}
 	rh.Next.ServeHTTP(lw, r)
 
 	end := rh.clock()
 	metadata.EndTimestamp = end
 	metadata.ExecutionTime = end.Sub(start)
 	metadata.Status = lw.statusCode
-	rh.OnRequestEndFunc(w, r, metadata)
+	metadata.WroteHeader = lw.wroteHeader
+	metadata.WriteError = lw.writeErr
+	metadata.BytesWritten = lw.bytesWritten
+	switch r.Context().Err() {
+	case context.Canceled:
+		metadata.Cancelled = true
+	case context.DeadlineExceeded:
+		metadata.DeadlineExceeded = true
+	}
+	if requestCapture != nil {
+		metadata.RequestBody = requestCapture.captured()
+	}
+	if lw.responseCapture != nil {
+		metadata.ResponseBody = lw.responseCapture.captured()
+	}
+	updates.apply(&metadata)
+	if rh.OnRequestEndFunc != nil {
+		rh.OnRequestEndFunc(w, r, metadata)
+	}
+}
+
+// UpdateRequestMetadata lets middleware running inside a RequestsHandler contribute to the RequestMetadata passed to
+// its RequestEndFunc. Updates are applied in order once the request has been served, and it is safe to call from any
+// goroutine. It reports false, and does nothing, when r is not being served by a RequestsHandler or the request has
+// already been reported.
+func UpdateRequestMetadata(r *http.Request, update func(metadata *RequestMetadata)) bool {
+	updates, ok := r.Context().Value(metadataCtxKey{}).(*metadataUpdates)
+	if !ok {
+		return false
+	}
+	updates.mu.Lock()
+	defer updates.mu.Unlock()
+	if updates.applied {
+		return false
+	}
+	updates.updates = append(updates.updates, update)
+	return true
+}
+
+// pendingMetadata is the RequestMetadata contributed to r so far, such as the User an authentication handler set, for
+// middleware that depends on it. Only fields set through UpdateRequestMetadata are filled in.
+func pendingMetadata(r *http.Request) RequestMetadata {
+	var metadata RequestMetadata
+	updates, ok := r.Context().Value(metadataCtxKey{}).(*metadataUpdates)
+	if !ok {
+		return metadata
+	}
+	updates.mu.Lock()
+	defer updates.mu.Unlock()
+	for _, update := range updates.updates {
+		update(&metadata)
+	}
+	return metadata
+}
+
+func (mu *metadataUpdates) apply(metadata *RequestMetadata) {
+	mu.mu.Lock()
+	defer mu.mu.Unlock()
+	for _, update := range mu.updates {
+		update(metadata)
+	}
+	mu.applied = true
+}
+
+func captureRequestBody(r *http.Request, config *BodyCaptureConfig) (*http.Request, *bodyCapturer) {
+	contentType := r.Header.Get("Content-Type")
+	if r.Body == nil || r.Body == http.NoBody || !config.allows(contentType) {
+		return r, nil
+	}
+	capturer := &bodyCapturer{config: config, contentType: contentType}
+	r = r.WithContext(r.Context())
+	r.Body = &capturingReadCloser{r.Body, capturer}
+	return r, capturer
+}
+
+// ForwardedClientIP is the default ClientIPResolver. It trusts X-Forwarded-For when present and otherwise uses the
+// host part of the connection's remote address.
+func ForwardedClientIP(r *http.Request) string {
+	if s := r.Header.Get("X-Forwarded-For"); s != "" {
+		return s
+	}
+	return RemoteAddrClientIP(r)
 }
 
-func remoteAddr(r *http.Request) string {
+// RemoteAddrClientIP ignores forwarding headers, for servers that are not behind a trusted proxy.
+func RemoteAddrClientIP(r *http.Request) string {
 	remoteAddr := r.RemoteAddr
 	if index := strings.LastIndex(remoteAddr, ":"); index != -1 {
 		remoteAddr = remoteAddr[:index]
 	}
-	if s := r.Header.Get("X-Forwarded-For"); s != "" {
-		remoteAddr = s
-	}
 	return remoteAddr
 }
 
 func (lw *loggingResponseWriter) WriteHeader(code int) {
-	lw.statusCode = code
+	if !lw.wroteHeader {
+		lw.statusCode = code
+		lw.wroteHeader = true
+		lw.inFlight.setStatus(code)
+		lw.startResponseCapture()
+	}
 	lw.ResponseWriter.WriteHeader(code)
 }
+
+func (lw *loggingResponseWriter) Write(p []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.wroteHeader = true
+		lw.inFlight.setStatus(lw.statusCode)
+		if lw.Header().Get("Content-Type") == "" && lw.bodyCapture != nil {
+			lw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		lw.startResponseCapture()
+	}
+	n, err := lw.ResponseWriter.Write(p)
+	lw.bytesWritten += int64(n)
+	if err != nil && lw.writeErr == nil {
+		lw.writeErr = err
+	}
+	if lw.responseCapture != nil {
+		lw.responseCapture.write(p[:n])
+	}
+	return n, err
+}
+
+func (lw *loggingResponseWriter) startResponseCapture() {
+	contentType := lw.Header().Get("Content-Type")
+	if lw.bodyCapture != nil && lw.bodyCapture.allows(contentType) {
+		lw.responseCapture = &bodyCapturer{config: lw.bodyCapture, contentType: contentType}
+	}
+}
+
+func (lw *loggingResponseWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (lw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := lw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("handler: ResponseWriter does not implement http.Hijacker")
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -57,11 +59,32 @@ var _ = Describe("RequestsHandler", func() {
 		endFunc = func(w http.ResponseWriter, r *http.Request, metadata RequestMetadata) {
 			endFuncCalled++
 			Expect(metadata).To(MatchAllFields(Fields{
-				"StartTimestamp": Equal(times[0]),
-				"EndTimestamp":   Equal(times[1]),
-				"RemoteAddr":     Equal("127.0.0.1"),
-				"ExecutionTime":  Equal(100 * time.Millisecond),
-				"Status":         Equal(http.StatusFound),
+				"StartTimestamp":    Equal(times[0]),
+				"EndTimestamp":      Equal(times[1]),
+				"RemoteAddr":        Equal("127.0.0.1"),
+				"ExecutionTime":     Equal(100 * time.Millisecond),
+				"Status":            Equal(http.StatusFound),
+				"WroteHeader":       BeTrue(),
+				"RequestBody":       BeNil(),
+				"ResponseBody":      BeNil(),
+				"Cancelled":         BeFalse(),
+				"DeadlineExceeded":  BeFalse(),
+				"WriteError":        BeNil(),
+				"TLS":               BeNil(),
+				"Timeout":           BeZero(),
+				"TimedOut":          BeFalse(),
+				"BodyBytesRead":     BeZero(),
+				"BodyLimitExceeded": BeFalse(),
+				"BodyTooSlow":       BeFalse(),
+				"RateLimited":       BeFalse(),
+				"Shed":              BeFalse(),
+				"QueueTime":         BeZero(),
+				"BytesWritten":      Equal(int64(3)),
+				"UncompressedBytes": BeZero(),
+				"BytesSaved":        BeZero(),
+				"IdempotentReplay":  BeFalse(),
+				"Cache":             BeEmpty(),
+				"User":              BeEmpty(),
 			}))
 		}
 
@@ -114,9 +137,132 @@ var _ = Describe("RequestsHandler", func() {
 		request.Header.Add("X-Forwarded-For", "192.168.0.1:443")
 		handler.ServeHTTP(recorder, request)
 	})
+
+	Describe("NewRequestsHandler", func() {
+		It("should configure the handler from options", func() {
+			var captured RequestMetadata
+			rh, err := NewRequestsHandlerWithOptions(nextHandler,
+				WithClock(fakeClock(times)),
+				WithClientIPResolver(func(r *http.Request) string { return "10.1.1.1" }),
+				WithEndFunc(func(w http.ResponseWriter, r *http.Request, metadata RequestMetadata) {
+					captured = metadata
+				}),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			rh.ServeHTTP(recorder, httptest.NewRequest("GET", "/test", nil))
+			Expect(captured.ExecutionTime).To(Equal(100 * time.Millisecond))
+			Expect(captured.RemoteAddr).To(Equal("10.1.1.1"))
+		})
+
+		It("should treat missing callbacks as no-ops", func() {
+			rh, err := NewRequestsHandlerWithOptions(nextHandler)
+			Expect(err).ToNot(HaveOccurred())
+			rh.ServeHTTP(recorder, httptest.NewRequest("GET", "/test", nil))
+			Expect(recorder.Code).To(Equal(http.StatusFound))
+		})
+
+		It("should reject invalid setups", func() {
+			_, err := NewRequestsHandlerWithOptions(nil)
+			Expect(err).To(Equal(ErrNilNext))
+			_, err = NewRequestsHandlerWithOptions(nextHandler, WithClock(nil))
+			Expect(err).To(HaveOccurred())
+			_, err = NewRequestsHandlerWithOptions(nextHandler, WithClientIPResolver(nil))
+			Expect(err).To(HaveOccurred())
+			_, err = NewRequestsHandlerWithOptions(nextHandler, WithBodyCapture(&BodyCaptureConfig{}))
+			Expect(err).To(HaveOccurred())
+			_, err = NewRequestsHandlerWithOptions(nextHandler, WithInFlightRegistry(nil))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("UpdateRequestMetadata", func() {
+		It("should apply updates from inner handlers to the reported metadata", func() {
+			var captured RequestMetadata
+			rh, err := NewRequestsHandlerWithOptions(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					Expect(UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+						metadata.TimedOut = true
+					})).To(BeTrue())
+				}),
+				WithEndFunc(func(w http.ResponseWriter, r *http.Request, metadata RequestMetadata) {
+					captured = metadata
+					Expect(UpdateRequestMetadata(r, func(metadata *RequestMetadata) {})).To(BeFalse())
+				}),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			rh.ServeHTTP(recorder, httptest.NewRequest("GET", "/test", nil))
+			Expect(captured.TimedOut).To(BeTrue())
+		})
+
+		It("should report false outside a RequestsHandler", func() {
+			Expect(UpdateRequestMetadata(httptest.NewRequest("GET", "/", nil), func(*RequestMetadata) {})).To(BeFalse())
+		})
+	})
+
+	Describe("request abandonment", func() {
+		var captured RequestMetadata
+
+		BeforeEach(func() {
+			endFunc = func(w http.ResponseWriter, r *http.Request, metadata RequestMetadata) {
+				captured = metadata
+			}
+		})
+
+		It("should record a cancelled request context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			handler = RequestsHandler{
+				OnRequestStartFunc: func(r *http.Request, metadata RequestMetadata) {},
+				OnRequestEndFunc:   endFunc,
+				Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					cancel()
+				}),
+				clock: fakeClock(times),
+			}
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/test", nil).WithContext(ctx))
+			Expect(captured.Cancelled).To(BeTrue())
+			Expect(captured.DeadlineExceeded).To(BeFalse())
+		})
+
+		It("should record an expired request deadline", func() {
+			ctx, cancel := context.WithDeadline(context.Background(), times[0])
+			defer cancel()
+			handler = RequestsHandler{
+				OnRequestStartFunc: func(r *http.Request, metadata RequestMetadata) {},
+				OnRequestEndFunc:   endFunc,
+				Next:               nextHandler,
+				clock:              fakeClock(times),
+			}
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/test", nil).WithContext(ctx))
+			Expect(captured.Cancelled).To(BeFalse())
+			Expect(captured.DeadlineExceeded).To(BeTrue())
+		})
+
+		It("should record failed writes", func() {
+			handler = RequestsHandler{
+				OnRequestStartFunc: func(r *http.Request, metadata RequestMetadata) {},
+				OnRequestEndFunc:   endFunc,
+				Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusFound)
+					_, _ = fmt.Fprint(w, responseString)
+				}),
+				clock: fakeClock(times),
+			}
+			handler.ServeHTTP(failingWriter{recorder}, httptest.NewRequest("GET", "/test", nil))
+			Expect(captured.WriteError).To(MatchError("broken pipe"))
+			Expect(captured.Status).To(Equal(http.StatusFound))
+		})
+	})
 })
 
-func fakeClock(times []time.Time) clock {
+type failingWriter struct {
+	http.ResponseWriter
+}
+
+func (fw failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func fakeClock(times []time.Time) Clock {
 	return func() time.Time {
 		t := times[0]
 		times = times[1:]
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// Responder writes the error responses that middleware in this module produces on its own behalf, such as a timeout
+// or a recovered panic.
+type Responder func(w http.ResponseWriter, r *http.Request, status int, message string)
+
+type ErrorResponse struct {
+	Status    int    `json:"status"`
+	Error     string `json:"error"`
+	Message   string `json:"message,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+var errorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Status}} {{.Error}}</title></head>
+<body>
+<h1>{{.Status}} {{.Error}}</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .RequestID}}<p>Request ID: <code>{{.RequestID}}</code></p>{{end}}
+</body>
+</html>
+`))
+
+// NegotiatedResponder renders an ErrorResponse as JSON, HTML or plain text, whichever the request's Accept header
+// prefers.
+func NegotiatedResponder(w http.ResponseWriter, r *http.Request, status int, message string) {
+	body := ErrorResponse{
+		Status:    status,
+		Error:     http.StatusText(status),
+		Message:   message,
+		RequestID: r.Header.Get(RequestIDHeader),
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	switch negotiateMediaType(r.Header.Get("Accept"), "text/plain", "application/json", "text/html") {
+	case "application/json":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		_ = errorTemplate.Execute(w, body)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		text := fmt.Sprintf("%d %s", body.Status, body.Error)
+		if body.Message != "" {
+			text += ": " + body.Message
+		}
+		if body.RequestID != "" {
+			text += " (request ID " + body.RequestID + ")"
+		}
+		_, _ = fmt.Fprintln(w, text)
+	}
+}
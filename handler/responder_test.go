@@ -0,0 +1,46 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("NegotiatedResponder", func() {
+	var (
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("GET", "/", nil)
+		request.Header.Set(handler.RequestIDHeader, "abcd")
+	})
+
+	It("should default to plain text", func() {
+		handler.NegotiatedResponder(recorder, request, http.StatusServiceUnavailable, "request timed out")
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+		Expect(recorder.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(recorder.Body.String()).To(Equal("503 Service Unavailable: request timed out (request ID abcd)\n"))
+	})
+
+	It("should render JSON", func() {
+		request.Header.Set("Accept", "application/json")
+		handler.NegotiatedResponder(recorder, request, http.StatusInternalServerError, "")
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"status":500,"error":"Internal Server Error","requestId":"abcd"}`))
+	})
+
+	It("should render HTML for browsers", func() {
+		request.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+		handler.NegotiatedResponder(recorder, request, http.StatusInternalServerError, "<oops>")
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+		Expect(recorder.Body.String()).To(ContainSubstring("<h1>500 Internal Server Error</h1>"))
+		Expect(recorder.Body.String()).To(ContainSubstring("&lt;oops&gt;"))
+	})
+})
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachedResponse is a response held by a CacheHandler, along with the request header values it was selected by.
+type cachedResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	varyNames []string
+	varyKey   string
+	stored    time.Time
+	policy    cachePolicy
+	byteSize  int64
+}
+
+func (cr *cachedResponse) age(now time.Time) time.Duration {
+	return now.Sub(cr.stored)
+}
+
+func (cr *cachedResponse) fresh(now time.Time) bool {
+	return cr.age(now) < cr.policy.lifetime
+}
+
+// staleWithin reports whether the response is no more than window past its freshness lifetime, and so may still be
+// served stale under a stale-while-revalidate or stale-if-error of window.
+func (cr *cachedResponse) staleWithin(now time.Time, window time.Duration) bool {
+	return !cr.policy.mustRevalidate && cr.age(now) < cr.policy.lifetime+window
+}
+
+// usable reports whether the response is still of any use, fresh or stale.
+func (cr *cachedResponse) usable(now time.Time) bool {
+	window := cr.policy.staleWhileRevalidate
+	if cr.policy.staleIfError > window {
+		window = cr.policy.staleIfError
+	}
+	return cr.fresh(now) || cr.staleWithin(now, window)
+}
+
+// varyMarker takes the place of responses that vary on request headers, sending lookups on to the variant for the
+// request's values of names. It keeps the keys of its variants so that they go when it does.
+type varyMarker struct {
+	names    []string
+	variants map[string]bool
+}
+
+// varyKey joins the values r has for names, so that requests with the same values share a variant.
+func varyKey(names []string, r *http.Request) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ":" + strings.Join(r.Header[name], ",")
+	}
+	return strings.Join(parts, "\n")
+}
+
+// responseCache keeps responses in an LRU, evicting the least recently used once they add up to more than maxBytes.
+type responseCache struct {
+	mu       sync.Mutex
+	entries  *lru
+	bytes    int64
+	maxBytes int64
+}
+
+func newResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{entries: newLRU(0), maxBytes: maxBytes}
+}
+
+func (rc *responseCache) get(key string, r *http.Request) *cachedResponse {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	value, ok := rc.entries.get(key)
+	if !ok {
+		return nil
+	}
+	if marker, isMarker := value.(*varyMarker); isMarker {
+		if value, ok = rc.entries.get(key + "\x00" + varyKey(marker.names, r)); !ok {
+			return nil
+		}
+	}
+	return value.(*cachedResponse)
+}
+
+// put stores cr for requests to key carrying the header values cr varies on.
+func (rc *responseCache) put(key string, names []string, cr *cachedResponse) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(names) > 0 {
+		variant := key + "\x00" + cr.varyKey
+		rc.marker(key, names).variants[variant] = true
+		key = variant
+	}
+	rc.set(key, cr)
+	for rc.bytes > rc.maxBytes {
+		evicted, value, _ := rc.entries.removeOldest()
+		rc.bytes -= entrySize(value)
+		rc.forget(evicted, value)
+	}
+}
+
+// marker returns the marker for key, replacing whatever key held unless it was a marker for the same names.
+func (rc *responseCache) marker(key string, names []string) *varyMarker {
+	if value, ok := rc.entries.get(key); ok {
+		if marker, isMarker := value.(*varyMarker); isMarker && sameNames(marker.names, names) {
+			return marker
+		}
+		rc.delete(key)
+	}
+	marker := &varyMarker{names: names, variants: make(map[string]bool)}
+	rc.set(key, marker)
+	return marker
+}
+
+func (rc *responseCache) set(key string, value interface{}) {
+	if old, ok := rc.entries.get(key); ok {
+		rc.bytes -= entrySize(old)
+	}
+	rc.entries.add(key, value)
+	rc.bytes += entrySize(value)
+}
+
+// remove forgets key, along with any variants stored under it.
+func (rc *responseCache) remove(key string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.delete(key)
+}
+
+func (rc *responseCache) delete(key string) {
+	value, ok := rc.entries.peek(key)
+	if !ok {
+		return
+	}
+	rc.entries.remove(key)
+	rc.bytes -= entrySize(value)
+	rc.forget(key, value)
+}
+
+// forget tidies up after key has gone: a marker's variants go with it, and a variant is struck off its marker.
+func (rc *responseCache) forget(key string, value interface{}) {
+	switch v := value.(type) {
+	case *varyMarker:
+		for variant := range v.variants {
+			rc.delete(variant)
+		}
+	case *cachedResponse:
+		if len(v.varyNames) == 0 {
+			return
+		}
+		if marker, ok := rc.entries.peek(strings.TrimSuffix(key, "\x00"+v.varyKey)); ok {
+			if m, isMarker := marker.(*varyMarker); isMarker {
+				delete(m.variants, key)
+			}
+		}
+	}
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (rc *responseCache) len() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.entries.len()
+}
+
+func entrySize(value interface{}) int64 {
+	switch v := value.(type) {
+	case *cachedResponse:
+		return v.byteSize
+	case *varyMarker:
+		return int64(len(strings.Join(v.names, ",")))
+	}
+	return 0
+}
+
+func responseSize(header http.Header, body []byte) int64 {
+	size := int64(len(body))
+	for name, values := range header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SecurityHeadersHandler sets security related response headers before calling Next, which may still override them.
+// Strict-Transport-Security is only sent on requests that arrived over TLS, directly or through a proxy that says so
+// in X-Forwarded-Proto, as browsers ignore it otherwise.
+type SecurityHeadersHandler struct {
+	HSTSMaxAge                time.Duration
+	HSTSIncludeSubdomains     bool
+	HSTSPreload               bool
+	ContentTypeNosniff        bool
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CSP                       *CSP
+	CSPReportOnly             bool
+	Next                      http.Handler
+}
+
+type SecurityHeadersHandlerOption func(*SecurityHeadersHandler) error
+
+// NewSecurityHeadersHandler sends X-Content-Type-Options: nosniff and Referrer-Policy:
+// strict-origin-when-cross-origin unless told otherwise. Everything else is opt in.
+func NewSecurityHeadersHandler(next http.Handler, opts ...SecurityHeadersHandlerOption) (SecurityHeadersHandler, error) {
+	if next == nil {
+		return SecurityHeadersHandler{}, ErrNilNext
+	}
+	sh := SecurityHeadersHandler{
+		ContentTypeNosniff: true,
+		ReferrerPolicy:     "strict-origin-when-cross-origin",
+		Next:               next,
+	}
+	for _, opt := range opts {
+		if err := opt(&sh); err != nil {
+			return SecurityHeadersHandler{}, err
+		}
+	}
+	return sh, nil
+}
+
+func WithHSTS(maxAge time.Duration, includeSubdomains, preload bool) SecurityHeadersHandlerOption {
+	return func(sh *SecurityHeadersHandler) error {
+		if maxAge <= 0 {
+			return errors.New("handler: HSTS max age must be positive")
+		}
+		sh.HSTSMaxAge = maxAge
+		sh.HSTSIncludeSubdomains = includeSubdomains
+		sh.HSTSPreload = preload
+		return nil
+	}
+}
+
+// WithoutNosniff stops the handler sending X-Content-Type-Options.
+func WithoutNosniff() SecurityHeadersHandlerOption {
+	return func(sh *SecurityHeadersHandler) error {
+		sh.ContentTypeNosniff = false
+		return nil
+	}
+}
+
+// WithReferrerPolicy replaces the default policy. An empty policy stops the handler sending the header.
+func WithReferrerPolicy(policy string) SecurityHeadersHandlerOption {
+	return func(sh *SecurityHeadersHandler) error {
+		sh.ReferrerPolicy = policy
+		return nil
+	}
+}
+
+func WithPermissionsPolicy(policy string) SecurityHeadersHandlerOption {
+	return func(sh *SecurityHeadersHandler) error {
+		sh.PermissionsPolicy = policy
+		return nil
+	}
+}
+
+func WithCrossOriginOpenerPolicy(policy string) SecurityHeadersHandlerOption {
+	return func(sh *SecurityHeadersHandler) error {
+		sh.CrossOriginOpenerPolicy = policy
+		return nil
+	}
+}
+
+func WithCrossOriginEmbedderPolicy(policy string) SecurityHeadersHandlerOption {
+	return func(sh *SecurityHeadersHandler) error {
+		sh.CrossOriginEmbedderPolicy = policy
+		return nil
+	}
+}
+
+func WithCSP(csp *CSP) SecurityHeadersHandlerOption {
+	return func(sh *SecurityHeadersHandler) error {
+		if csp == nil {
+			return errors.New("handler: CSP must not be nil")
+		}
+		sh.CSP = csp
+		sh.CSPReportOnly = false
+		return nil
+	}
+}
+
+// WithCSPReportOnly sends the policy as Content-Security-Policy-Report-Only, which reports violations without
+// blocking anything, for trying a policy out.
+func WithCSPReportOnly(csp *CSP) SecurityHeadersHandlerOption {
+	return func(sh *SecurityHeadersHandler) error {
+		if csp == nil {
+			return errors.New("handler: CSP must not be nil")
+		}
+		sh.CSP = csp
+		sh.CSPReportOnly = true
+		return nil
+	}
+}
+
+func (sh SecurityHeadersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	if sh.HSTSMaxAge > 0 && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+		h.Set("Strict-Transport-Security", sh.hsts())
+	}
+	if sh.ContentTypeNosniff {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+	setIfNotEmpty(h, "Referrer-Policy", sh.ReferrerPolicy)
+	setIfNotEmpty(h, "Permissions-Policy", sh.PermissionsPolicy)
+	setIfNotEmpty(h, "Cross-Origin-Opener-Policy", sh.CrossOriginOpenerPolicy)
+	setIfNotEmpty(h, "Cross-Origin-Embedder-Policy", sh.CrossOriginEmbedderPolicy)
+
+	if sh.CSP != nil {
+		var err error
+		if r, err = sh.setCSP(h, r); err != nil {
+			// without a nonce the policy would block every nonced script, so fail loudly instead
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	sh.Next.ServeHTTP(w, r)
+}
+
+func (sh SecurityHeadersHandler) setCSP(h http.Header, r *http.Request) (*http.Request, error) {
+	var nonce string
+	if sh.CSP.usesNonce() {
+		var err error
+		if r, nonce, err = withCSPNonce(r); err != nil {
+			return r, err
+		}
+	}
+	header := "Content-Security-Policy"
+	if sh.CSPReportOnly {
+		header = "Content-Security-Policy-Report-Only"
+	}
+	h.Set(header, sh.CSP.String(nonce, r.Header.Get(RequestIDHeader)))
+	return r, nil
+}
+
+func (sh SecurityHeadersHandler) hsts() string {
+	value := "max-age=" + strconv.FormatInt(int64(sh.HSTSMaxAge/time.Second), 10)
+	if sh.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if sh.HSTSPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+func setIfNotEmpty(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
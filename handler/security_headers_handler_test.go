@@ -0,0 +1,119 @@
+package handler_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("SecurityHeadersHandler", func() {
+	var (
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+		nonce    string
+		next     http.Handler
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("GET", "/", nil)
+		nonce = ""
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce = handler.CSPNonce(r)
+		})
+	})
+
+	It("should send safe defaults", func() {
+		sh, err := handler.NewSecurityHeadersHandler(next)
+		Expect(err).ToNot(HaveOccurred())
+		sh.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(recorder.Header().Get("Referrer-Policy")).To(Equal("strict-origin-when-cross-origin"))
+		Expect(recorder.Header()).To(HaveLen(2))
+		Expect(nonce).To(BeEmpty())
+	})
+
+	It("should send the configured headers", func() {
+		sh, err := handler.NewSecurityHeadersHandler(next,
+			handler.WithoutNosniff(),
+			handler.WithReferrerPolicy("no-referrer"),
+			handler.WithPermissionsPolicy("camera=(), geolocation=(self)"),
+			handler.WithCrossOriginOpenerPolicy("same-origin"),
+			handler.WithCrossOriginEmbedderPolicy("require-corp"))
+		Expect(err).ToNot(HaveOccurred())
+		sh.ServeHTTP(recorder, request)
+		Expect(recorder.Header()).ToNot(HaveKey("X-Content-Type-Options"))
+		Expect(recorder.Header().Get("Referrer-Policy")).To(Equal("no-referrer"))
+		Expect(recorder.Header().Get("Permissions-Policy")).To(Equal("camera=(), geolocation=(self)"))
+		Expect(recorder.Header().Get("Cross-Origin-Opener-Policy")).To(Equal("same-origin"))
+		Expect(recorder.Header().Get("Cross-Origin-Embedder-Policy")).To(Equal("require-corp"))
+	})
+
+	It("should only send HSTS over TLS", func() {
+		sh, err := handler.NewSecurityHeadersHandler(next, handler.WithHSTS(365*24*time.Hour, true, true))
+		Expect(err).ToNot(HaveOccurred())
+		sh.ServeHTTP(recorder, request)
+		Expect(recorder.Header()).ToNot(HaveKey("Strict-Transport-Security"))
+
+		request.TLS = &tls.ConnectionState{}
+		sh.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("Strict-Transport-Security")).To(Equal("max-age=31536000; includeSubDomains; preload"))
+
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("X-Forwarded-Proto", "https")
+		sh.ServeHTTP(recorder, request)
+		Expect(recorder.Header()).To(HaveKey("Strict-Transport-Security"))
+	})
+
+	It("should give each request its own nonce", func() {
+		csp := handler.NewCSP().DefaultSrc(handler.SourceSelf).ScriptSrc(handler.SourceNonce).ReportURI("/csp")
+		sh, err := handler.NewSecurityHeadersHandler(next, handler.WithCSP(csp))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set(handler.RequestIDHeader, "page-1")
+		sh.ServeHTTP(recorder, request)
+		first := nonce
+		Expect(first).To(HaveLen(24))
+		Expect(recorder.Header().Get("Content-Security-Policy")).To(Equal(
+			"default-src 'self'; script-src 'nonce-" + first + "'; report-uri /csp?request-id=page-1"))
+
+		sh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		Expect(nonce).ToNot(BeEmpty())
+		Expect(nonce).ToNot(Equal(first))
+	})
+
+	It("should send report only policies", func() {
+		sh, err := handler.NewSecurityHeadersHandler(next,
+			handler.WithCSPReportOnly(handler.NewCSP().DefaultSrc(handler.SourceSelf)))
+		Expect(err).ToNot(HaveOccurred())
+		sh.ServeHTTP(recorder, request)
+		Expect(recorder.Header()).ToNot(HaveKey("Content-Security-Policy"))
+		Expect(recorder.Header().Get("Content-Security-Policy-Report-Only")).To(Equal("default-src 'self'"))
+		Expect(nonce).To(BeEmpty())
+	})
+
+	It("should let the next handler override headers", func() {
+		sh, err := handler.NewSecurityHeadersHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Referrer-Policy", "origin")
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		sh.ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("Referrer-Policy")).To(Equal("origin"))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewSecurityHeadersHandler(nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewSecurityHeadersHandler(next, handler.WithHSTS(0, false, false))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewSecurityHeadersHandler(next, handler.WithCSP(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.SecurityHeadersMiddleware(handler.WithCSPReportOnly(nil))
+		Expect(err).To(HaveOccurred())
+	})
+})
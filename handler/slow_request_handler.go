@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// SlowRequestFunc is called while a request is still in flight once it has run for longer than the threshold.
+// stack holds the serving goroutine's current stack when stack capture is enabled.
+type SlowRequestFunc func(r *http.Request, elapsed time.Duration, stack []byte)
+
+type SlowRequestHandler struct {
+	Threshold         time.Duration
+	CaptureStack      bool
+	OnSlowRequestFunc SlowRequestFunc
+	Next              http.Handler
+}
+
+type SlowRequestHandlerOption func(*SlowRequestHandler) error
+
+func NewSlowRequestHandler(next http.Handler, threshold time.Duration,
+	opts ...SlowRequestHandlerOption) (SlowRequestHandler, error) {
+
+	if next == nil {
+		return SlowRequestHandler{}, ErrNilNext
+	}
+	if threshold <= 0 {
+		return SlowRequestHandler{}, errors.New("handler: slow request threshold must be positive")
+	}
+	sh := SlowRequestHandler{Threshold: threshold, Next: next}
+	for _, opt := range opts {
+		if err := opt(&sh); err != nil {
+			return SlowRequestHandler{}, err
+		}
+	}
+	return sh, nil
+}
+
+func WithSlowRequestFunc(slowRequestFunc SlowRequestFunc) SlowRequestHandlerOption {
+	return func(sh *SlowRequestHandler) error {
+		sh.OnSlowRequestFunc = slowRequestFunc
+		return nil
+	}
+}
+
+// WithStackCapture includes the serving goroutine's stack in slow request reports. Collecting it stops the world
+// briefly, so leave it off for thresholds that fire often.
+func WithStackCapture() SlowRequestHandlerOption {
+	return func(sh *SlowRequestHandler) error {
+		sh.CaptureStack = true
+		return nil
+	}
+}
+
+func (sh SlowRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if sh.Threshold <= 0 || sh.OnSlowRequestFunc == nil {
+		sh.Next.ServeHTTP(w, r)
+		return
+	}
+
+	start := time.Now()
+	// the watchdog runs on its own goroutine, so it gets a copy that Next cannot mutate underneath it
+	snapshot := r.Clone(r.Context())
+	var goroutineID []byte
+	if sh.CaptureStack {
+		goroutineID = currentGoroutineID()
+	}
+	watchdog := time.AfterFunc(sh.Threshold, func() {
+		var stack []byte
+		if goroutineID != nil {
+			stack = goroutineStack(goroutineID)
+		}
+		sh.OnSlowRequestFunc(snapshot, time.Since(start), stack)
+	})
+	defer watchdog.Stop()
+
+	sh.Next.ServeHTTP(w, r)
+}
+
+func currentGoroutineID() []byte {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// "goroutine 42 [running]:"
+	fields := bytes.Fields(buf)
+	if len(fields) < 2 {
+		return nil
+	}
+	if _, err := strconv.ParseUint(string(fields[1]), 10, 64); err != nil {
+		return nil
+	}
+	return fields[1]
+}
+
+func goroutineStack(id []byte) []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := append(append([]byte("goroutine "), id...), " ["...)
+	start := bytes.Index(buf, header)
+	if start == -1 {
+		return nil
+	}
+	stack := buf[start:]
+	if end := bytes.Index(stack, []byte("\n\n")); end != -1 {
+		stack = stack[:end]
+	}
+	return append([]byte(nil), stack...)
+}
@@ -0,0 +1,98 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+type slowRequest struct {
+	request *http.Request
+	elapsed time.Duration
+	stack   []byte
+}
+
+var _ = Describe("SlowRequestHandler", func() {
+	var (
+		slowRequests chan slowRequest
+		slowFunc     handler.SlowRequestFunc
+		release      chan struct{}
+		stuckHandler http.Handler
+	)
+
+	BeforeEach(func() {
+		slowRequests = make(chan slowRequest, 1)
+		slowFunc = func(r *http.Request, elapsed time.Duration, stack []byte) {
+			slowRequests <- slowRequest{r, elapsed, stack}
+		}
+		unblock := make(chan struct{})
+		release = unblock
+		stuckHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-unblock
+			w.WriteHeader(http.StatusAccepted)
+		})
+	})
+
+	It("should report requests that exceed the threshold while they are running", func() {
+		sh, err := handler.NewSlowRequestHandler(stuckHandler, 10*time.Millisecond, handler.WithSlowRequestFunc(slowFunc))
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("GET", "/stuck", nil)
+		request.Header.Set(handler.RequestIDHeader, "abcd")
+		recorder := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			sh.ServeHTTP(recorder, request)
+		}()
+
+		var slow slowRequest
+		Eventually(slowRequests).Should(Receive(&slow))
+		Consistently(done).ShouldNot(BeClosed())
+		Expect(slow.elapsed).To(BeNumerically(">=", 10*time.Millisecond))
+		Expect(slow.request.URL.Path).To(Equal("/stuck"))
+		Expect(slow.request.Header.Get(handler.RequestIDHeader)).To(Equal("abcd"))
+		Expect(slow.stack).To(BeNil())
+
+		close(release)
+		Eventually(done).Should(BeClosed())
+		Expect(recorder.Code).To(Equal(http.StatusAccepted))
+	})
+
+	It("should capture the serving goroutine's stack", func() {
+		sh, err := handler.NewSlowRequestHandler(stuckHandler, 10*time.Millisecond,
+			handler.WithSlowRequestFunc(slowFunc), handler.WithStackCapture())
+		Expect(err).ToNot(HaveOccurred())
+		go sh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/stuck", nil))
+
+		var slow slowRequest
+		Eventually(slowRequests).Should(Receive(&slow))
+		close(release)
+		Expect(string(slow.stack)).To(HavePrefix("goroutine "))
+		Expect(string(slow.stack)).To(ContainSubstring("handler.SlowRequestHandler.ServeHTTP"))
+	})
+
+	It("should not report fast requests", func() {
+		sh, err := handler.NewSlowRequestHandler(http.NotFoundHandler(), 50*time.Millisecond,
+			handler.WithSlowRequestFunc(slowFunc))
+		Expect(err).ToNot(HaveOccurred())
+		sh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		Consistently(slowRequests, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should reject a non-positive threshold", func() {
+		_, err := handler.NewSlowRequestHandler(http.NotFoundHandler(), 0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should not bomb if there is no slowRequestFunc", func() {
+		sh, err := handler.NewSlowRequestHandler(http.NotFoundHandler(), time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		recorder := httptest.NewRecorder()
+		sh.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeoutFunc picks the deadline for a request, which lets routes carry their own timeouts. Returning zero or less
+// disables the timeout, which is how streaming routes opt out.
+type TimeoutFunc func(r *http.Request) time.Duration
+
+// TimeoutHandler bounds how long Next may take. Unlike http.TimeoutHandler it does not buffer the response: the
+// deadline is propagated through the request context, and if it fires before Next has written its headers the
+// Responder writes a 503 in its place. Once headers have been written the response can no longer be replaced, so
+// TimeoutHandler waits for Next to notice the cancelled context, failing its further writes with
+// http.ErrHandlerTimeout.
+//
+// Next runs on a goroutine of its own, so its panics are raised again on the serving goroutine as a RecoveredPanic
+// carrying the stack they happened on.
+type TimeoutHandler struct {
+	Timeout     time.Duration
+	TimeoutFunc TimeoutFunc
+	Responder   Responder
+	Message     string
+	// RecoveryFunc handles panics in Next that come after the timeout response was sent, when there is no request
+	// left to raise them on. Its ResponseWriter discards writes. Nil means logging them with the standard log package.
+	RecoveryFunc RecoveryFunc
+	Next         http.Handler
+}
+
+type TimeoutHandlerOption func(*TimeoutHandler) error
+
+func NewTimeoutHandler(next http.Handler, timeout time.Duration, opts ...TimeoutHandlerOption) (TimeoutHandler, error) {
+	if next == nil {
+		return TimeoutHandler{}, ErrNilNext
+	}
+	if timeout <= 0 {
+		return TimeoutHandler{}, errors.New("handler: timeout must be positive")
+	}
+	th := TimeoutHandler{
+		Timeout:   timeout,
+		Responder: NegotiatedResponder,
+		Message:   "request timed out",
+		Next:      next,
+	}
+	for _, opt := range opts {
+		if err := opt(&th); err != nil {
+			return TimeoutHandler{}, err
+		}
+	}
+	return th, nil
+}
+
+// WithTimeoutFunc overrides the handler's timeout per request.
+func WithTimeoutFunc(timeoutFunc TimeoutFunc) TimeoutHandlerOption {
+	return func(th *TimeoutHandler) error {
+		if timeoutFunc == nil {
+			return errors.New("handler: timeout func must not be nil")
+		}
+		th.TimeoutFunc = timeoutFunc
+		return nil
+	}
+}
+
+func WithTimeoutResponder(responder Responder) TimeoutHandlerOption {
+	return func(th *TimeoutHandler) error {
+		if responder == nil {
+			return errors.New("handler: responder must not be nil")
+		}
+		th.Responder = responder
+		return nil
+	}
+}
+
+func WithTimeoutMessage(message string) TimeoutHandlerOption {
+	return func(th *TimeoutHandler) error {
+		th.Message = message
+		return nil
+	}
+}
+
+// WithTimeoutRecoveryFunc handles the panics that come after the timeout response was sent.
+func WithTimeoutRecoveryFunc(recoveryFunc RecoveryFunc) TimeoutHandlerOption {
+	return func(th *TimeoutHandler) error {
+		if recoveryFunc == nil {
+			return errors.New("handler: recovery func must not be nil")
+		}
+		th.RecoveryFunc = recoveryFunc
+		return nil
+	}
+}
+
+func (th TimeoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	timeout := th.Timeout
+	if th.TimeoutFunc != nil {
+		timeout = th.TimeoutFunc(r)
+	}
+	if timeout <= 0 {
+		th.Next.ServeHTTP(w, r)
+		return
+	}
+	UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+		metadata.Timeout = timeout
+	})
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	tw := &timeoutWriter{w: w, header: make(http.Header)}
+	run := th.start(tw, r.WithContext(ctx))
+	defer close(run.abandoned)
+
+	select {
+	case <-run.done:
+		return
+	case rp := <-run.panicked:
+		raise(rp)
+	case <-ctx.Done():
+	}
+	// a cancelled parent means the client has gone, so there is nobody to send a 503 to
+	if r.Context().Err() == nil && th.deadlineExceeded(w, r, tw) {
+		// Next keeps running until it notices the cancelled context; anything it does from here on is discarded
+		return
+	}
+	select {
+	case <-run.done:
+	case rp := <-run.panicked:
+		raise(rp)
+	}
+}
+
+// timeoutRun tracks Next running on its own goroutine.
+type timeoutRun struct {
+	done     chan struct{}
+	panicked chan *RecoveredPanic
+	// closed once ServeHTTP has returned, after which panics can only be handed to RecoveryFunc
+	abandoned chan struct{}
+}
+
+func (th TimeoutHandler) start(tw *timeoutWriter, r *http.Request) *timeoutRun {
+	run := &timeoutRun{
+		done:      make(chan struct{}),
+		panicked:  make(chan *RecoveredPanic),
+		abandoned: make(chan struct{}),
+	}
+	go func() {
+		defer th.finish(run, tw, r)
+		th.Next.ServeHTTP(tw, r)
+	}()
+	return run
+}
+
+// finish hands a panic in Next, with the stack it happened on, to ServeHTTP, or to RecoveryFunc if ServeHTTP has
+// already returned.
+func (th TimeoutHandler) finish(run *timeoutRun, tw *timeoutWriter, r *http.Request) {
+	p := recover()
+	if p == nil {
+		close(run.done)
+		return
+	}
+	rp := &RecoveredPanic{Value: p, Stack: stackTrace()}
+	select {
+	case run.panicked <- rp:
+	case <-run.abandoned:
+		th.recoverLate(tw, r, rp)
+	}
+}
+
+// raise panics again with a panic recovered from Next. http.ErrAbortHandler is raised as it is, so that the server
+// still recognises it.
+func raise(rp *RecoveredPanic) {
+	if rp.Value == http.ErrAbortHandler {
+		panic(rp.Value)
+	}
+	panic(rp)
+}
+
+func (th TimeoutHandler) recoverLate(w http.ResponseWriter, r *http.Request, rp *RecoveredPanic) {
+	if th.RecoveryFunc != nil {
+		th.RecoveryFunc(w, r, rp.Value, rp.Stack)
+		return
+	}
+	if rp.Value == http.ErrAbortHandler {
+		return
+	}
+	var sb strings.Builder
+	for _, s := range rp.Stack {
+		_, _ = fmt.Fprintf(&sb, "%s:%d %s()\n", s.File, s.LineNumber, s.FuncName)
+	}
+	log.Printf("handler: panic serving %s %s after it timed out: %v\n%s", r.Method, r.URL, rp.Value, sb.String())
+}
+
+// deadlineExceeded stops Next's writes from reaching w and reports whether the timeout response replaced Next's.
+func (th TimeoutHandler) deadlineExceeded(w http.ResponseWriter, r *http.Request, tw *timeoutWriter) bool {
+	UpdateRequestMetadata(r, func(metadata *RequestMetadata) {
+		metadata.TimedOut = true
+	})
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+	if tw.wroteHeader {
+		return false
+	}
+	responder := th.Responder
+	if responder == nil {
+		responder = NegotiatedResponder
+	}
+	responder(w, r, http.StatusServiceUnavailable, th.Message)
+	return true
+}
+
+type timeoutWriter struct {
+	mu          sync.Mutex
+	w           http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeader(statusCode)
+}
+
+func (tw *timeoutWriter) writeHeader(statusCode int) {
+	tw.wroteHeader = true
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = append([]string(nil), v...)
+	}
+	tw.w.WriteHeader(statusCode)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,249 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/sahilm/handlers/handler"
+)
+
+var _ = Describe("TimeoutHandler", func() {
+	var (
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+		writeErr chan error
+		release  chan struct{}
+		stuck    http.Handler
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("GET", "/slow", nil)
+		errs := make(chan error, 1)
+		writeErr = errs
+		unblock := make(chan struct{})
+		release = unblock
+		stuck = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			<-unblock
+			w.Header().Set("X-Late", "true")
+			_, err := fmt.Fprint(w, "too late")
+			errs <- err
+		})
+	})
+
+	It("should pass responses through untouched when they finish in time", func() {
+		th, err := handler.NewTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, hasDeadline := r.Context().Deadline()
+			Expect(hasDeadline).To(BeTrue())
+			w.Header().Set("X-Fast", "true")
+			w.WriteHeader(http.StatusCreated)
+			_, writeErr := fmt.Fprint(w, "done")
+			Expect(writeErr).ToNot(HaveOccurred())
+		}), time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		th.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusCreated))
+		Expect(recorder.Header().Get("X-Fast")).To(Equal("true"))
+		Expect(recorder.Body.String()).To(Equal("done"))
+	})
+
+	It("should respond with 503 when the deadline fires before the handler responds", func() {
+		th, err := handler.NewTimeoutHandler(stuck, 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept", "application/json")
+		request.Header.Set(handler.RequestIDHeader, "abcd")
+		th.ServeHTTP(recorder, request)
+		close(release)
+
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		var body handler.ErrorResponse
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(Succeed())
+		Expect(body).To(Equal(handler.ErrorResponse{
+			Status:    http.StatusServiceUnavailable,
+			Error:     "Service Unavailable",
+			Message:   "request timed out",
+			RequestID: "abcd",
+		}))
+		Eventually(writeErr).Should(Receive(Equal(http.ErrHandlerTimeout)))
+		Expect(recorder.Header().Get("X-Late")).To(BeEmpty())
+	})
+
+	It("should record the timeout in RequestMetadata", func() {
+		th, err := handler.NewTimeoutHandler(stuck, 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		var metadata handler.RequestMetadata
+		rh, err := handler.NewRequestsHandlerWithOptions(th, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, m handler.RequestMetadata) {
+				metadata = m
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		rh.ServeHTTP(recorder, request)
+		close(release)
+		Expect(metadata.Status).To(Equal(http.StatusServiceUnavailable))
+		Expect(metadata.Timeout).To(Equal(10 * time.Millisecond))
+		Expect(metadata.TimedOut).To(BeTrue())
+		Expect(metadata.DeadlineExceeded).To(BeFalse())
+	})
+
+	It("should report a captured body while the abandoned handler is still reading it", func() {
+		body, feed := io.Pipe()
+		finished := make(chan struct{})
+		th, err := handler.NewTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(finished)
+			_, _ = ioutil.ReadAll(r.Body)
+		}), 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		var captured *handler.CapturedBody
+		rh, err := handler.NewRequestsHandlerWithOptions(th,
+			handler.WithBodyCapture(&handler.BodyCaptureConfig{
+				MaxBytes: 1024,
+				Enabled:  func(r *http.Request) bool { return true },
+			}),
+			handler.WithEndFunc(func(w http.ResponseWriter, r *http.Request, metadata handler.RequestMetadata) {
+				captured = metadata.RequestBody
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			defer feed.Close()
+			for i := 0; i < 50; i++ {
+				_, _ = feed.Write([]byte("chunk"))
+				time.Sleep(time.Millisecond)
+			}
+		}()
+
+		rh.ServeHTTP(recorder, httptest.NewRequest("POST", "/upload", body))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(captured).ToNot(BeNil())
+		Eventually(finished).Should(BeClosed())
+	})
+
+	It("should use per-route timeouts and let routes opt out", func() {
+		th, err := handler.NewTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, hasDeadline := r.Context().Deadline()
+			Expect(hasDeadline).To(BeFalse())
+			w.WriteHeader(http.StatusNoContent)
+		}), time.Second, handler.WithTimeoutFunc(func(r *http.Request) time.Duration {
+			if r.URL.Path == "/stream" {
+				return 0
+			}
+			return time.Second
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		th.ServeHTTP(recorder, httptest.NewRequest("GET", "/stream", nil))
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should let streaming handlers finish once they have started responding", func() {
+		th, err := handler.NewTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, writeErr := fmt.Fprint(w, "chunk 1\n")
+			Expect(writeErr).ToNot(HaveOccurred())
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			// keep-alives start failing once the handler has been cut off
+			for {
+				if _, writeErr = fmt.Fprint(w, ""); writeErr != nil {
+					Expect(writeErr).To(Equal(http.ErrHandlerTimeout))
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}), 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		var metadata handler.RequestMetadata
+		rh, err := handler.NewRequestsHandlerWithOptions(th, handler.WithEndFunc(
+			func(w http.ResponseWriter, r *http.Request, m handler.RequestMetadata) {
+				metadata = m
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		rh.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Flushed).To(BeTrue())
+		Expect(recorder.Body.String()).To(Equal("chunk 1\n"))
+		Expect(metadata.TimedOut).To(BeTrue())
+	})
+
+	It("should not respond when the client has gone away", func() {
+		th, err := handler.NewTimeoutHandler(stuck, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithCancel(request.Context())
+		cancel()
+		close(release)
+		th.ServeHTTP(recorder, request.WithContext(ctx))
+		Expect(recorder.Body.String()).To(Equal("too late"))
+	})
+
+	It("should re-raise panics for an outer RecoveryHandler", func() {
+		th, err := handler.NewTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}), time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		var (
+			recovered interface{}
+			stack     []handler.Stack
+		)
+		rh, err := handler.NewRecoveryHandler(th, handler.WithRecoveryFunc(
+			func(w http.ResponseWriter, r *http.Request, panicMessage interface{}, stackTrace []handler.Stack) {
+				recovered, stack = panicMessage, stackTrace
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		rh.ServeHTTP(recorder, request)
+		Expect(recovered).To(Equal("boom"))
+		Expect(stack).To(ContainElement(MatchFields(IgnoreExtras, Fields{
+			"File":     HaveSuffix("timeout_handler_test.go"),
+			"FuncName": ContainSubstring("handler_test"),
+		})))
+	})
+
+	It("should hand panics after the timeout response to the recovery func", func() {
+		recovered := make(chan interface{}, 1)
+		th, err := handler.NewTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			panic("late")
+		}), 10*time.Millisecond, handler.WithTimeoutRecoveryFunc(
+			func(w http.ResponseWriter, r *http.Request, panicMessage interface{}, _ []handler.Stack) {
+				recovered <- panicMessage
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		th.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Eventually(recovered).Should(Receive(Equal("late")))
+	})
+
+	It("should use a custom responder and message", func() {
+		th, err := handler.NewTimeoutHandler(stuck, 10*time.Millisecond,
+			handler.WithTimeoutMessage("try again later"),
+			handler.WithTimeoutResponder(func(w http.ResponseWriter, r *http.Request, status int, message string) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				_, writeErr := fmt.Fprint(w, message)
+				Expect(writeErr).ToNot(HaveOccurred())
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		th.ServeHTTP(recorder, request)
+		close(release)
+		Expect(recorder.Code).To(Equal(http.StatusGatewayTimeout))
+		Expect(recorder.Body.String()).To(Equal("try again later"))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewTimeoutHandler(nil, time.Second)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewTimeoutHandler(stuck, 0)
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewTimeoutHandler(stuck, time.Second, handler.WithTimeoutFunc(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewTimeoutHandler(stuck, time.Second, handler.WithTimeoutResponder(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewTimeoutHandler(stuck, time.Second, handler.WithTimeoutRecoveryFunc(nil))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,116 @@
+package handler_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/sahilm/handlers/handler"
+)
+
+func clientCertificate() *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	spiffe, err := url.Parse("spiffe://example.org/billing")
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(0xBEEF),
+		Subject:        pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		DNSNames:       []string{"billing.internal"},
+		EmailAddresses: []string{"ops@example.org"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.7")},
+		URIs:           []*url.URL{spiffe},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return cert
+}
+
+var _ = Describe("TLS metadata", func() {
+	var (
+		captured handler.RequestMetadata
+		rh       handler.RequestsHandler
+	)
+
+	BeforeEach(func() {
+		var err error
+		rh, err = handler.NewRequestsHandlerWithOptions(http.NotFoundHandler(),
+			handler.WithEndFunc(func(w http.ResponseWriter, r *http.Request, metadata handler.RequestMetadata) {
+				captured = metadata
+			}),
+		)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should be nil for plain HTTP requests", func() {
+		rh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		Expect(captured.TLS).To(BeNil())
+	})
+
+	It("should describe the TLS connection", func() {
+		request := httptest.NewRequest("GET", "https://api.example.org/", nil)
+		request.TLS = &tls.ConnectionState{
+			Version:            tls.VersionTLS13,
+			CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+			ServerName:         "api.example.org",
+			NegotiatedProtocol: "h2",
+			DidResume:          true,
+		}
+		rh.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(captured.TLS).To(PointTo(MatchAllFields(Fields{
+			"Version":            Equal("TLS 1.3"),
+			"CipherSuite":        Equal("TLS_AES_128_GCM_SHA256"),
+			"ServerName":         Equal("api.example.org"),
+			"NegotiatedProtocol": Equal("h2"),
+			"Resumed":            BeTrue(),
+			"ClientCertificate":  BeNil(),
+		})))
+	})
+
+	It("should describe a verified client certificate", func() {
+		cert := clientCertificate()
+		request := httptest.NewRequest("GET", "https://api.example.org/", nil)
+		request.TLS = &tls.ConnectionState{
+			Version:          tls.VersionTLS12,
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+		rh.ServeHTTP(httptest.NewRecorder(), request)
+		clientCert := captured.TLS.ClientCertificate
+		Expect(clientCert).To(PointTo(MatchAllFields(Fields{
+			"Subject":        Equal("CN=billing,O=Example"),
+			"SerialNumber":   Equal("BEEF"),
+			"DNSNames":       ConsistOf("billing.internal"),
+			"EmailAddresses": ConsistOf("ops@example.org"),
+			"IPAddresses":    ConsistOf("10.0.0.7"),
+			"URIs":           ConsistOf("spiffe://example.org/billing"),
+		})))
+		Expect(clientCert.SANs()).To(HaveLen(4))
+	})
+
+	It("should ignore unverified peer certificates", func() {
+		request := httptest.NewRequest("GET", "https://api.example.org/", nil)
+		request.TLS = &tls.ConnectionState{
+			Version:          tls.VersionTLS12,
+			PeerCertificates: []*x509.Certificate{clientCertificate()},
+		}
+		rh.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(captured.TLS.ClientCertificate).To(BeNil())
+	})
+})
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+var (
+	errMissingSignature = errors.New("missing signature")
+	errMissingTimestamp = errors.New("missing timestamp")
+)
+
+// WebhookScheme describes how a webhook provider signs its requests.
+type WebhookScheme struct {
+	Hash func() hash.Hash
+	// Extract reads the candidate signatures, and the timestamp if the scheme has one, from the request headers.
+	Extract func(h http.Header) (timestamp string, signatures [][]byte, err error)
+	// Payload builds the bytes that were signed from the timestamp and the body.
+	Payload func(timestamp string, body []byte) []byte
+	// Timestamped schemes sign the time the request was sent, which is checked against a tolerance to stop replays.
+	Timestamped bool
+}
+
+// HeaderSignatureScheme reads a single hex encoded signature of the body from header, after prefix.
+func HeaderSignatureScheme(header, prefix string, hash func() hash.Hash) WebhookScheme {
+	return WebhookScheme{
+		Hash: hash,
+		Extract: func(h http.Header) (string, [][]byte, error) {
+			signature, err := decodeHexSignature(h.Get(header), prefix)
+			if err != nil {
+				return "", nil, err
+			}
+			return "", [][]byte{signature}, nil
+		},
+		Payload: func(timestamp string, body []byte) []byte {
+			return body
+		},
+	}
+}
+
+// GitHubWebhooks verifies the X-Hub-Signature-256 header.
+func GitHubWebhooks() WebhookScheme {
+	return HeaderSignatureScheme("X-Hub-Signature-256", "sha256=", sha256.New)
+}
+
+// GitHubSHA1Webhooks verifies the legacy X-Hub-Signature header, for integrations that do not send the SHA-256 one.
+func GitHubSHA1Webhooks() WebhookScheme {
+	return HeaderSignatureScheme("X-Hub-Signature", "sha1=", sha1.New)
+}
+
+// StripeWebhooks verifies Stripe-Signature headers such as "t=1492774577,v1=5257a869...", which may carry several v1
+// signatures while secrets are being rolled.
+func StripeWebhooks() WebhookScheme {
+	return WebhookScheme{
+		Hash:    sha256.New,
+		Extract: extractStripeSignature,
+		Payload: func(timestamp string, body []byte) []byte {
+			return append([]byte(timestamp+"."), body...)
+		},
+		Timestamped: true,
+	}
+}
+
+func extractStripeSignature(h http.Header) (string, [][]byte, error) {
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(h.Get("Stripe-Signature"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signature, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return "", nil, errors.New("malformed signature")
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+	if len(signatures) == 0 {
+		return "", nil, errMissingSignature
+	}
+	if timestamp == "" {
+		return "", nil, errMissingTimestamp
+	}
+	return timestamp, signatures, nil
+}
+
+// SlackWebhooks verifies Slack's v0 signatures, sent in X-Slack-Signature with the time in
+// X-Slack-Request-Timestamp.
+func SlackWebhooks() WebhookScheme {
+	return WebhookScheme{
+		Hash: sha256.New,
+		Extract: func(h http.Header) (string, [][]byte, error) {
+			signature, err := decodeHexSignature(h.Get("X-Slack-Signature"), "v0=")
+			if err != nil {
+				return "", nil, err
+			}
+			timestamp := h.Get("X-Slack-Request-Timestamp")
+			if timestamp == "" {
+				return "", nil, errMissingTimestamp
+			}
+			return timestamp, [][]byte{signature}, nil
+		},
+		Payload: func(timestamp string, body []byte) []byte {
+			return append([]byte("v0:"+timestamp+":"), body...)
+		},
+		Timestamped: true,
+	}
+}
+
+func decodeHexSignature(value, prefix string) ([]byte, error) {
+	if value == "" {
+		return nil, errMissingSignature
+	}
+	if !strings.HasPrefix(value, prefix) {
+		return nil, errors.New("signature does not start with " + prefix)
+	}
+	signature, err := hex.DecodeString(value[len(prefix):])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	return signature, nil
+}
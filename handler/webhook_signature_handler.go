@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WebhookFailureFunc is called with the reason a request failed signature verification.
+type WebhookFailureFunc func(r *http.Request, reason string)
+
+// WebhookSignatureHandler buffers request bodies and only passes on those signed with one of Secrets according to
+// Scheme, answering the rest with a 401. Timestamped schemes must also have been sent within Tolerance of now. Next
+// gets the buffered body, so it can read it as usual.
+type WebhookSignatureHandler struct {
+	Scheme        WebhookScheme
+	Secrets       [][]byte
+	Tolerance     time.Duration
+	MaxBytes      int64
+	Responder     Responder
+	OnFailureFunc WebhookFailureFunc
+	Next          http.Handler
+	clock         Clock
+}
+
+type WebhookSignatureHandlerOption func(*WebhookSignatureHandler) error
+
+// NewWebhookSignatureHandler allows timestamps 5 minutes either side of now and bodies of up to 1MiB unless told
+// otherwise.
+func NewWebhookSignatureHandler(next http.Handler, scheme WebhookScheme, secret []byte,
+	opts ...WebhookSignatureHandlerOption) (WebhookSignatureHandler, error) {
+
+	if next == nil {
+		return WebhookSignatureHandler{}, ErrNilNext
+	}
+	if scheme.Hash == nil || scheme.Extract == nil || scheme.Payload == nil {
+		return WebhookSignatureHandler{}, errors.New("handler: webhook scheme is incomplete")
+	}
+	if len(secret) == 0 {
+		return WebhookSignatureHandler{}, errors.New("handler: webhook secret must not be empty")
+	}
+	wh := WebhookSignatureHandler{
+		Scheme:    scheme,
+		Secrets:   [][]byte{secret},
+		Tolerance: 5 * time.Minute,
+		MaxBytes:  1 << 20,
+		Responder: NegotiatedResponder,
+		Next:      next,
+		clock:     time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(&wh); err != nil {
+			return WebhookSignatureHandler{}, err
+		}
+	}
+	return wh, nil
+}
+
+// WithPreviousSecrets keeps accepting signatures made with secrets that are being rotated out.
+func WithPreviousSecrets(secrets ...[]byte) WebhookSignatureHandlerOption {
+	return func(wh *WebhookSignatureHandler) error {
+		for _, secret := range secrets {
+			if len(secret) == 0 {
+				return errors.New("handler: webhook secret must not be empty")
+			}
+		}
+		wh.Secrets = append(wh.Secrets, secrets...)
+		return nil
+	}
+}
+
+func WithTolerance(tolerance time.Duration) WebhookSignatureHandlerOption {
+	return func(wh *WebhookSignatureHandler) error {
+		if tolerance <= 0 {
+			return errors.New("handler: tolerance must be positive")
+		}
+		wh.Tolerance = tolerance
+		return nil
+	}
+}
+
+func WithMaxWebhookBytes(maxBytes int64) WebhookSignatureHandlerOption {
+	return func(wh *WebhookSignatureHandler) error {
+		if maxBytes <= 0 {
+			return errors.New("handler: max webhook bytes must be positive")
+		}
+		wh.MaxBytes = maxBytes
+		return nil
+	}
+}
+
+func WithWebhookResponder(responder Responder) WebhookSignatureHandlerOption {
+	return func(wh *WebhookSignatureHandler) error {
+		if responder == nil {
+			return errors.New("handler: responder must not be nil")
+		}
+		wh.Responder = responder
+		return nil
+	}
+}
+
+func WithWebhookFailureFunc(failureFunc WebhookFailureFunc) WebhookSignatureHandlerOption {
+	return func(wh *WebhookSignatureHandler) error {
+		if failureFunc == nil {
+			return errors.New("handler: failure func must not be nil")
+		}
+		wh.OnFailureFunc = failureFunc
+		return nil
+	}
+}
+
+func WithWebhookClock(clock Clock) WebhookSignatureHandlerOption {
+	return func(wh *WebhookSignatureHandler) error {
+		if clock == nil {
+			return errors.New("handler: clock must not be nil")
+		}
+		wh.clock = clock
+		return nil
+	}
+}
+
+func (wh WebhookSignatureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, wh.MaxBytes+1))
+	if err != nil {
+		wh.fail(w, r, http.StatusBadRequest, "could not read webhook body", "reading body: "+err.Error())
+		return
+	}
+	if int64(len(body)) > wh.MaxBytes {
+		wh.fail(w, r, http.StatusRequestEntityTooLarge, "webhook body too large",
+			"body larger than "+strconv.FormatInt(wh.MaxBytes, 10)+" bytes")
+		return
+	}
+	if reason := wh.verify(r.Header, body); reason != "" {
+		wh.fail(w, r, http.StatusUnauthorized, "invalid webhook signature", reason)
+		return
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Body = ioutil.NopCloser(bytes.NewReader(body))
+	wh.Next.ServeHTTP(w, r2)
+}
+
+// verify explains why the signature is unacceptable, or returns "" if it is fine.
+func (wh WebhookSignatureHandler) verify(h http.Header, body []byte) string {
+	timestamp, signatures, err := wh.Scheme.Extract(h)
+	if err != nil {
+		return err.Error()
+	}
+	if !wh.signatureMatches(wh.Scheme.Payload(timestamp, body), signatures) {
+		return "signature mismatch"
+	}
+	if wh.Scheme.Timestamped {
+		return wh.checkTimestamp(timestamp)
+	}
+	return ""
+}
+
+func (wh WebhookSignatureHandler) signatureMatches(payload []byte, signatures [][]byte) bool {
+	for _, secret := range wh.Secrets {
+		mac := hmac.New(wh.Scheme.Hash, secret)
+		_, _ = mac.Write(payload)
+		expected := mac.Sum(nil)
+		for _, signature := range signatures {
+			if hmac.Equal(expected, signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (wh WebhookSignatureHandler) checkTimestamp(timestamp string) string {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "malformed timestamp"
+	}
+	clock := wh.clock
+	if clock == nil {
+		clock = time.Now
+	}
+	age := clock().Sub(time.Unix(seconds, 0))
+	if age > wh.Tolerance || age < -wh.Tolerance {
+		return "timestamp " + age.Round(time.Second).String() + " outside tolerance"
+	}
+	return ""
+}
+
+// fail tells the client message but only tells OnFailureFunc the reason, which would help an attacker.
+func (wh WebhookSignatureHandler) fail(w http.ResponseWriter, r *http.Request, status int, message, reason string) {
+	if wh.OnFailureFunc != nil {
+		wh.OnFailureFunc(r, reason)
+	}
+	responder := wh.Responder
+	if responder == nil {
+		responder = NegotiatedResponder
+	}
+	responder(w, r, status, message)
+}
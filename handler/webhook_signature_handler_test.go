@@ -0,0 +1,207 @@
+package handler_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+)
+
+func hmacHex(hash func() hash.Hash, secret, payload string) string {
+	mac := hmac.New(hash, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var _ = Describe("WebhookSignatureHandler", func() {
+	const body = `{"event":"push"}`
+	now := time.Unix(1571000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	var (
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+		received string
+		reason   string
+		next     http.Handler
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("POST", "/hooks", strings.NewReader(body))
+		received = ""
+		reason = ""
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			received = string(b)
+		})
+	})
+
+	newHandler := func(scheme handler.WebhookScheme, opts ...handler.WebhookSignatureHandlerOption) http.Handler {
+		opts = append([]handler.WebhookSignatureHandlerOption{
+			handler.WithWebhookClock(func() time.Time { return now }),
+			handler.WithWebhookFailureFunc(func(r *http.Request, why string) { reason = why }),
+		}, opts...)
+		wh, err := handler.NewWebhookSignatureHandler(next, scheme, []byte("secret"), opts...)
+		Expect(err).ToNot(HaveOccurred())
+		return wh
+	}
+
+	expectRejected := func(expectedReason string) {
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(recorder.Body.String()).To(HavePrefix("401 Unauthorized: invalid webhook signature"))
+		Expect(received).To(BeEmpty())
+		Expect(reason).To(Equal(expectedReason))
+	}
+
+	Describe("GitHub", func() {
+		It("should accept signed bodies and pass them on", func() {
+			request.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex(sha256.New, "secret", body))
+			newHandler(handler.GitHubWebhooks()).ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(received).To(Equal(body))
+		})
+
+		It("should leave the caller's request untouched", func() {
+			request.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex(sha256.New, "secret", body))
+			original := request.Body
+			newHandler(handler.GitHubWebhooks()).ServeHTTP(recorder, request)
+			Expect(received).To(Equal(body))
+			Expect(request.Body).To(BeIdenticalTo(original))
+		})
+
+		It("should accept legacy SHA-1 signatures", func() {
+			request.Header.Set("X-Hub-Signature", "sha1="+hmacHex(sha1.New, "secret", body))
+			newHandler(handler.GitHubSHA1Webhooks()).ServeHTTP(recorder, request)
+			Expect(received).To(Equal(body))
+		})
+
+		for _, tc := range []struct {
+			description, signature, reason string
+		}{
+			{"missing signatures", "", "missing signature"},
+			{"signatures with the wrong prefix", "sha1=" + hmacHex(sha256.New, "secret", body),
+				"signature does not start with sha256="},
+			{"malformed signatures", "sha256=zz", "malformed signature"},
+			{"signatures made with another secret", "sha256=" + hmacHex(sha256.New, "other", body), "signature mismatch"},
+		} {
+			tc := tc
+			It("should reject "+tc.description, func() {
+				if tc.signature != "" {
+					request.Header.Set("X-Hub-Signature-256", tc.signature)
+				}
+				newHandler(handler.GitHubWebhooks()).ServeHTTP(recorder, request)
+				expectRejected(tc.reason)
+			})
+		}
+
+		It("should accept secrets that are being rotated out", func() {
+			request.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex(sha256.New, "old", body))
+			newHandler(handler.GitHubWebhooks(), handler.WithPreviousSecrets([]byte("old"))).ServeHTTP(recorder, request)
+			Expect(received).To(Equal(body))
+		})
+	})
+
+	Describe("Stripe", func() {
+		stripeSignature := func(ts, secret string) string {
+			return hmacHex(sha256.New, secret, ts+"."+body)
+		}
+
+		It("should accept any of several v1 signatures", func() {
+			request.Header.Set("Stripe-Signature", "t="+timestamp+",v1="+stripeSignature(timestamp, "other")+
+				",v1="+stripeSignature(timestamp, "secret")+",v0=abc")
+			newHandler(handler.StripeWebhooks()).ServeHTTP(recorder, request)
+			Expect(received).To(Equal(body))
+		})
+
+		It("should reject replays outside the tolerance", func() {
+			old := strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10)
+			request.Header.Set("Stripe-Signature", "t="+old+",v1="+stripeSignature(old, "secret"))
+			newHandler(handler.StripeWebhooks()).ServeHTTP(recorder, request)
+			expectRejected("timestamp 6m0s outside tolerance")
+		})
+
+		It("should honour a configured tolerance", func() {
+			old := strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10)
+			request.Header.Set("Stripe-Signature", "t="+old+",v1="+stripeSignature(old, "secret"))
+			newHandler(handler.StripeWebhooks(), handler.WithTolerance(10*time.Minute)).ServeHTTP(recorder, request)
+			Expect(received).To(Equal(body))
+		})
+
+		It("should reject signatures that do not cover the timestamp", func() {
+			request.Header.Set("Stripe-Signature", "t="+timestamp+",v1="+hmacHex(sha256.New, "secret", body))
+			newHandler(handler.StripeWebhooks()).ServeHTTP(recorder, request)
+			expectRejected("signature mismatch")
+		})
+
+		It("should reject headers without a timestamp", func() {
+			request.Header.Set("Stripe-Signature", "v1="+stripeSignature(timestamp, "secret"))
+			newHandler(handler.StripeWebhooks()).ServeHTTP(recorder, request)
+			expectRejected("missing timestamp")
+		})
+	})
+
+	Describe("Slack", func() {
+		It("should accept v0 signatures", func() {
+			request.Header.Set("X-Slack-Request-Timestamp", timestamp)
+			request.Header.Set("X-Slack-Signature", "v0="+hmacHex(sha256.New, "secret", "v0:"+timestamp+":"+body))
+			newHandler(handler.SlackWebhooks()).ServeHTTP(recorder, request)
+			Expect(received).To(Equal(body))
+		})
+
+		It("should reject timestamps from the future", func() {
+			future := strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10)
+			request.Header.Set("X-Slack-Request-Timestamp", future)
+			request.Header.Set("X-Slack-Signature", "v0="+hmacHex(sha256.New, "secret", "v0:"+future+":"+body))
+			newHandler(handler.SlackWebhooks()).ServeHTTP(recorder, request)
+			expectRejected("timestamp -10m0s outside tolerance")
+		})
+
+		It("should reject malformed timestamps", func() {
+			request.Header.Set("X-Slack-Request-Timestamp", "yesterday")
+			request.Header.Set("X-Slack-Signature", "v0="+hmacHex(sha256.New, "secret", "v0:yesterday:"+body))
+			newHandler(handler.SlackWebhooks()).ServeHTTP(recorder, request)
+			expectRejected("malformed timestamp")
+		})
+	})
+
+	It("should reject bodies over the limit", func() {
+		request.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex(sha256.New, "secret", body))
+		newHandler(handler.GitHubWebhooks(), handler.WithMaxWebhookBytes(4)).ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(reason).To(Equal("body larger than 4 bytes"))
+		Expect(received).To(BeEmpty())
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := handler.NewWebhookSignatureHandler(nil, handler.GitHubWebhooks(), []byte("secret"))
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = handler.NewWebhookSignatureHandler(next, handler.WebhookScheme{}, []byte("secret"))
+		Expect(err).To(HaveOccurred())
+		_, err = handler.NewWebhookSignatureHandler(next, handler.GitHubWebhooks(), nil)
+		Expect(err).To(HaveOccurred())
+		for _, opt := range []handler.WebhookSignatureHandlerOption{
+			handler.WithPreviousSecrets(nil),
+			handler.WithTolerance(0),
+			handler.WithMaxWebhookBytes(0),
+			handler.WithWebhookResponder(nil),
+			handler.WithWebhookFailureFunc(nil),
+			handler.WithWebhookClock(nil),
+		} {
+			_, err = handler.WebhookSignatureMiddleware(handler.GitHubWebhooks(), []byte("secret"), opt)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
@@ -0,0 +1,53 @@
+// Package handlertest helps test code built on the handler and logrushandler packages: a fake clock, a recorder for
+// the callbacks handlers make, Gomega matchers for handler.RequestMetadata, a capturing logrus logger, and handlers
+// that panic, dawdle or stream on demand.
+package handlertest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a fake clock that only moves when told to. Pass its Now method wherever a handler.Clock is wanted, for
+// instance to handler.WithClock. It is safe for concurrent use.
+type Clock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+// NewClock starts a Clock at start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current time and then moves the clock on by its step, if it has one.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now
+}
+
+// Advance moves the clock on by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t, backwards if need be.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Step makes every call to Now move the clock on by d, so that a handler reading the clock at the start and end of a
+// request sees it take d.
+func (c *Clock) Step(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.step = d
+}
@@ -0,0 +1,30 @@
+package handlertest_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handlertest"
+)
+
+var _ = Describe("Clock", func() {
+	start := time.Unix(1571000000, 0)
+
+	It("should only move when told to", func() {
+		clock := handlertest.NewClock(start)
+		Expect(clock.Now()).To(Equal(start))
+		Expect(clock.Now()).To(Equal(start))
+		clock.Advance(time.Minute)
+		Expect(clock.Now()).To(Equal(start.Add(time.Minute)))
+		clock.Set(start)
+		Expect(clock.Now()).To(Equal(start))
+	})
+
+	It("should step on every reading", func() {
+		clock := handlertest.NewClock(start)
+		clock.Step(time.Second)
+		Expect(clock.Now()).To(Equal(start))
+		Expect(clock.Now()).To(Equal(start.Add(time.Second)))
+	})
+})
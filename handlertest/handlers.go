@@ -0,0 +1,70 @@
+package handlertest
+
+import (
+	"net/http"
+	"time"
+)
+
+// PanickingHandler panics with panicMessage before writing anything.
+func PanickingHandler(panicMessage interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(panicMessage)
+	})
+}
+
+// PanicAfterWriteHandler sends status and body, then panics with panicMessage, as a handler that fails half way
+// through a response does.
+func PanicAfterWriteHandler(status int, body string, panicMessage interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		panic(panicMessage)
+	})
+}
+
+// SlowHandler waits d before passing the request to next, or gives up without calling next if the request's context
+// ends first.
+func SlowHandler(d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			next.ServeHTTP(w, r)
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// SlowHandler moves the clock on by d before passing the request to next, so that it appears to take d without the
+// test having to wait.
+func (c *Clock) SlowHandler(d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Advance(d)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StreamingHandler writes each chunk in turn, flushing after each one.
+func StreamingHandler(chunks ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _ := w.(http.Flusher)
+		for _, chunk := range chunks {
+			_, _ = w.Write([]byte(chunk))
+			if f != nil {
+				f.Flush()
+			}
+		}
+	})
+}
+
+// StatusHandler answers with status and body.
+func StatusHandler(status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	})
+}
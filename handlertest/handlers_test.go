@@ -0,0 +1,59 @@
+package handlertest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handlertest"
+)
+
+var _ = Describe("handlers", func() {
+	var (
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("GET", "/test", nil)
+	})
+
+	It("should panic before writing", func() {
+		Expect(func() { handlertest.PanickingHandler("boom").ServeHTTP(recorder, request) }).To(Panic())
+		Expect(recorder.Flushed).To(BeFalse())
+	})
+
+	It("should panic after writing", func() {
+		h := handlertest.PanicAfterWriteHandler(http.StatusAccepted, "partial", "boom")
+		Expect(func() { h.ServeHTTP(recorder, request) }).To(Panic())
+		Expect(recorder.Code).To(Equal(http.StatusAccepted))
+		Expect(recorder.Body.String()).To(Equal("partial"))
+		Expect(recorder.Flushed).To(BeTrue())
+	})
+
+	It("should stream chunks", func() {
+		handlertest.StreamingHandler("a", "b").ServeHTTP(recorder, request)
+		Expect(recorder.Body.String()).To(Equal("ab"))
+		Expect(recorder.Flushed).To(BeTrue())
+	})
+
+	It("should wait before calling next", func() {
+		start := time.Now()
+		handlertest.SlowHandler(10*time.Millisecond, handlertest.StatusHandler(http.StatusTeapot, "")).
+			ServeHTTP(recorder, request)
+		Expect(time.Since(start)).To(BeNumerically(">=", 10*time.Millisecond))
+		Expect(recorder.Code).To(Equal(http.StatusTeapot))
+	})
+
+	It("should give up waiting when the request ends", func() {
+		ctx, cancel := context.WithCancel(request.Context())
+		cancel()
+		handlertest.SlowHandler(time.Hour, handlertest.StatusHandler(http.StatusTeapot, "")).
+			ServeHTTP(recorder, request.WithContext(ctx))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+})
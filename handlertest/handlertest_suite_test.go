@@ -0,0 +1,13 @@
+package handlertest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHandlertest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Handlertest Suite")
+}
@@ -0,0 +1,71 @@
+package handlertest
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogEntry is what a logrus logger emitted: the level, message and fields of one entry.
+type LogEntry struct {
+	Level   logrus.Level
+	Message string
+	Fields  logrus.Fields
+}
+
+// LogCapture keeps the entries logged through the logger NewLogCapture made, so that tests of logrushandler can check
+// what was emitted without wiring up a hook of their own. It is safe for concurrent use.
+type LogCapture struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+// NewLogCapture returns an entry to hand to logrushandler constructors, logging at every level to the capture and
+// nowhere else.
+func NewLogCapture() (*logrus.Entry, *LogCapture) {
+	lc := &LogCapture{}
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Level = logrus.TraceLevel
+	logger.AddHook(lc)
+	return logrus.NewEntry(logger), lc
+}
+
+func (lc *LogCapture) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (lc *LogCapture) Fire(entry *logrus.Entry) error {
+	fields := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		fields[k] = v
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.entries = append(lc.entries, LogEntry{Level: entry.Level, Message: entry.Message, Fields: fields})
+	return nil
+}
+
+func (lc *LogCapture) Entries() []LogEntry {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return append([]LogEntry(nil), lc.entries...)
+}
+
+// LastEntry is the entry logged last, and false if there has been none.
+func (lc *LogCapture) LastEntry() (LogEntry, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if len(lc.entries) == 0 {
+		return LogEntry{}, false
+	}
+	return lc.entries[len(lc.entries)-1], true
+}
+
+// Reset forgets every entry so far.
+func (lc *LogCapture) Reset() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.entries = nil
+}
@@ -0,0 +1,34 @@
+package handlertest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/handlertest"
+	"github.com/sahilm/handlers/logrushandler"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("LogCapture", func() {
+	It("should capture what logrushandler emits", func() {
+		entry, capture := handlertest.NewLogCapture()
+		rh, err := logrushandler.NewRequestsHandlerWithOptions(entry, handlertest.StatusHandler(http.StatusCreated, "made"))
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("POST", "/things", nil)
+		request.Header.Set(handler.RequestIDHeader, "abc")
+		rh.ServeHTTP(httptest.NewRecorder(), request)
+
+		last, ok := capture.LastEntry()
+		Expect(ok).To(BeTrue())
+		Expect(last.Level).To(Equal(logrus.InfoLevel))
+		Expect(last.Message).To(Equal("POST /things"))
+		Expect(last.Fields).To(HaveKeyWithValue("status", http.StatusCreated))
+		Expect(last.Fields).To(HaveKeyWithValue(handler.RequestIDLogField, "abc"))
+
+		capture.Reset()
+		Expect(capture.Entries()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,52 @@
+package handlertest
+
+import (
+	"time"
+
+	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/gstruct"
+	"github.com/onsi/gomega/types"
+	"github.com/sahilm/handlers/handler"
+)
+
+// MatchMetadata matches a handler.RequestMetadata whose named fields match fields, ignoring the rest.
+func MatchMetadata(fields gstruct.Fields) types.GomegaMatcher {
+	return gstruct.MatchFields(gstruct.IgnoreExtras, fields)
+}
+
+// HaveStatus matches a handler.RequestMetadata with the given status.
+func HaveStatus(status int) types.GomegaMatcher {
+	return gomega.WithTransform(func(m handler.RequestMetadata) int { return m.Status }, gomega.Equal(status))
+}
+
+// HaveExecutionTime matches a handler.RequestMetadata whose ExecutionTime matches matcher, such as
+// Equal(time.Second) with a stepping Clock or BeNumerically(">=", time.Second) with a real one.
+func HaveExecutionTime(matcher types.GomegaMatcher) types.GomegaMatcher {
+	return gomega.WithTransform(func(m handler.RequestMetadata) time.Duration { return m.ExecutionTime }, matcher)
+}
+
+// HaveBytesWritten matches a handler.RequestMetadata whose response body was n bytes.
+func HaveBytesWritten(n int64) types.GomegaMatcher {
+	return gomega.WithTransform(func(m handler.RequestMetadata) int64 { return m.BytesWritten }, gomega.Equal(n))
+}
+
+// HaveBeenCancelled matches a handler.RequestMetadata for a request whose context was cancelled, usually because the
+// client went away.
+func HaveBeenCancelled() types.GomegaMatcher {
+	return gomega.WithTransform(func(m handler.RequestMetadata) bool { return m.Cancelled }, gomega.BeTrue())
+}
+
+// HaveTimedOut matches a handler.RequestMetadata for a request a handler.TimeoutHandler cut short.
+func HaveTimedOut() types.GomegaMatcher {
+	return gomega.WithTransform(func(m handler.RequestMetadata) bool { return m.TimedOut }, gomega.BeTrue())
+}
+
+// HaveWriteError matches a handler.RequestMetadata whose WriteError matches matcher.
+func HaveWriteError(matcher types.GomegaMatcher) types.GomegaMatcher {
+	return gomega.WithTransform(func(m handler.RequestMetadata) error { return m.WriteError }, matcher)
+}
+
+// HaveUser matches a handler.RequestMetadata for a request authenticated as user.
+func HaveUser(user string) types.GomegaMatcher {
+	return gomega.WithTransform(func(m handler.RequestMetadata) string { return m.User }, gomega.Equal(user))
+}
@@ -0,0 +1,121 @@
+package handlertest
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/sahilm/handlers/handler"
+)
+
+// StartCall is a call to a handler.RequestStartFunc.
+type StartCall struct {
+	Request  *http.Request
+	Metadata handler.RequestMetadata
+}
+
+// EndCall is a call to a handler.RequestEndFunc. Header is a copy of the response header as it stood.
+type EndCall struct {
+	Request  *http.Request
+	Header   http.Header
+	Metadata handler.RequestMetadata
+}
+
+// RecoveryCall is a call to a handler.RecoveryFunc.
+type RecoveryCall struct {
+	Request      *http.Request
+	PanicMessage interface{}
+	StackTrace   []handler.Stack
+}
+
+// Recorder makes callbacks for handlers to call and keeps every call they get. It is safe for concurrent use.
+type Recorder struct {
+	mu         sync.Mutex
+	starts     []StartCall
+	ends       []EndCall
+	recoveries []RecoveryCall
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (rec *Recorder) StartFunc() handler.RequestStartFunc {
+	return func(r *http.Request, metadata handler.RequestMetadata) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.starts = append(rec.starts, StartCall{Request: r, Metadata: metadata})
+	}
+}
+
+func (rec *Recorder) EndFunc() handler.RequestEndFunc {
+	return func(w http.ResponseWriter, r *http.Request, metadata handler.RequestMetadata) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.ends = append(rec.ends, EndCall{Request: r, Header: w.Header().Clone(), Metadata: metadata})
+	}
+}
+
+// RecoveryFunc records the panic and answers with a 500, as logrushandler.RecoveryHandler does.
+func (rec *Recorder) RecoveryFunc() handler.RecoveryFunc {
+	return func(w http.ResponseWriter, r *http.Request, panicMessage interface{}, stackTrace []handler.Stack) {
+		rec.mu.Lock()
+		rec.recoveries = append(rec.recoveries, RecoveryCall{
+			Request:      r,
+			PanicMessage: panicMessage,
+			StackTrace:   stackTrace,
+		})
+		rec.mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// RequestsHandlerOptions wires the recorder into a handler.RequestsHandler.
+func (rec *Recorder) RequestsHandlerOptions() []handler.RequestsHandlerOption {
+	return []handler.RequestsHandlerOption{handler.WithStartFunc(rec.StartFunc()), handler.WithEndFunc(rec.EndFunc())}
+}
+
+func (rec *Recorder) Starts() []StartCall {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]StartCall(nil), rec.starts...)
+}
+
+func (rec *Recorder) Ends() []EndCall {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]EndCall(nil), rec.ends...)
+}
+
+func (rec *Recorder) Recoveries() []RecoveryCall {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]RecoveryCall(nil), rec.recoveries...)
+}
+
+// Metadata lists the metadata of every request that has ended, in the order they ended.
+func (rec *Recorder) Metadata() []handler.RequestMetadata {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	metadata := make([]handler.RequestMetadata, len(rec.ends))
+	for i, end := range rec.ends {
+		metadata[i] = end.Metadata
+	}
+	return metadata
+}
+
+// LastMetadata is the metadata of the request that ended last, and false if none has.
+func (rec *Recorder) LastMetadata() (handler.RequestMetadata, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.ends) == 0 {
+		return handler.RequestMetadata{}, false
+	}
+	return rec.ends[len(rec.ends)-1].Metadata, true
+}
+
+// Reset forgets every call so far.
+func (rec *Recorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.starts, rec.ends, rec.recoveries = nil, nil, nil
+}
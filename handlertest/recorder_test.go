@@ -0,0 +1,91 @@
+package handlertest_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/handlertest"
+)
+
+var _ = Describe("Recorder", func() {
+	var (
+		clock    *handlertest.Clock
+		recorder *handlertest.Recorder
+	)
+
+	BeforeEach(func() {
+		clock = handlertest.NewClock(time.Unix(1571000000, 0))
+		recorder = handlertest.NewRecorder()
+	})
+
+	serve := func(next http.Handler) *httptest.ResponseRecorder {
+		opts := append(recorder.RequestsHandlerOptions(), handler.WithClock(clock.Now))
+		rh, err := handler.NewRequestsHandlerWithOptions(next, opts...)
+		Expect(err).ToNot(HaveOccurred())
+		response := httptest.NewRecorder()
+		rh.ServeHTTP(response, httptest.NewRequest("GET", "/test", nil))
+		return response
+	}
+
+	It("should record the start and end of requests", func() {
+		serve(clock.SlowHandler(time.Second, handlertest.StatusHandler(http.StatusCreated, "made")))
+
+		Expect(recorder.Starts()).To(HaveLen(1))
+		Expect(recorder.Starts()[0].Request.URL.Path).To(Equal("/test"))
+		Expect(recorder.Ends()).To(HaveLen(1))
+		Expect(recorder.Metadata()).To(ConsistOf(SatisfyAll(
+			handlertest.HaveStatus(http.StatusCreated),
+			handlertest.HaveExecutionTime(Equal(time.Second)),
+			handlertest.HaveBytesWritten(4),
+			handlertest.MatchMetadata(Fields{"RemoteAddr": Equal("192.0.2.1")}),
+		)))
+		Expect(recorder.Metadata()[0]).ToNot(Or(
+			handlertest.HaveBeenCancelled(),
+			handlertest.HaveTimedOut(),
+			handlertest.HaveWriteError(HaveOccurred()),
+			handlertest.HaveUser("alice"),
+		))
+	})
+
+	It("should record panics and answer them with a 500", func() {
+		rh, err := handler.NewRecoveryHandler(handlertest.PanickingHandler("boom"),
+			handler.WithRecoveryFunc(recorder.RecoveryFunc()))
+		Expect(err).ToNot(HaveOccurred())
+		response := serve(rh)
+
+		Expect(response.Code).To(Equal(http.StatusInternalServerError))
+		Expect(recorder.Recoveries()).To(HaveLen(1))
+		Expect(recorder.Recoveries()[0].PanicMessage).To(Equal("boom"))
+		Expect(recorder.Recoveries()[0].StackTrace).ToNot(BeEmpty())
+		metadata, ok := recorder.LastMetadata()
+		Expect(ok).To(BeTrue())
+		Expect(metadata).To(handlertest.HaveStatus(http.StatusInternalServerError))
+	})
+
+	It("should keep a copy of the response header", func() {
+		serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test", "yes")
+		}))
+		Expect(recorder.Ends()[0].Header.Get("X-Test")).To(Equal("yes"))
+	})
+
+	It("should forget calls when reset", func() {
+		serve(handlertest.StatusHandler(http.StatusOK, ""))
+		recorder.Reset()
+		Expect(recorder.Starts()).To(BeEmpty())
+		Expect(recorder.Ends()).To(BeEmpty())
+		_, ok := recorder.LastMetadata()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should match write errors", func() {
+		Expect(handler.RequestMetadata{WriteError: errors.New("broken pipe")}).
+			To(handlertest.HaveWriteError(MatchError("broken pipe")))
+	})
+})
@@ -0,0 +1,65 @@
+package logrushandler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRequestLoggerCtxKey is the context key DefaultStack stores the request scoped logger under.
+const DefaultRequestLoggerCtxKey = "logger"
+
+func RequestsMiddleware(logEntry *logrus.Entry, opts ...RequestsHandlerOption) (handler.Middleware, error) {
+	return handler.NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewRequestsHandlerWithOptions(logEntry, next, opts...)
+	})
+}
+
+func RecoveryMiddleware(logger *logrus.Entry, opts ...RecoveryHandlerOption) (handler.Middleware, error) {
+	return handler.NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewRecoveryHandlerWithOptions(logger, next, opts...)
+	})
+}
+
+func SlowRequestMiddleware(logger *logrus.Entry, threshold time.Duration,
+	opts ...SlowRequestHandlerOption) (handler.Middleware, error) {
+
+	return handler.NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewSlowRequestHandler(logger, next, threshold, opts...)
+	})
+}
+
+func CORSMiddleware(logger *logrus.Entry, opts ...handler.CORSHandlerOption) (handler.Middleware, error) {
+	return handler.NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewCORSHandler(logger, next, opts...)
+	})
+}
+
+func WebhookSignatureMiddleware(logger *logrus.Entry, scheme handler.WebhookScheme, secret []byte,
+	opts ...WebhookSignatureHandlerOption) (handler.Middleware, error) {
+
+	return handler.NewMiddleware(func(next http.Handler) (http.Handler, error) {
+		return NewWebhookSignatureHandler(logger, next, scheme, secret, opts...)
+	})
+}
+
+// DefaultStack is the recommended ordering of the handlers in this module. Request IDs are assigned first so that
+// every log line carries one, the access log wraps recovery so that it records the 500 recovery writes, and recovery
+// sits closest to the application.
+func DefaultStack(logger *logrus.Logger) (handler.Chain, error) {
+	if logger == nil {
+		return handler.Chain{}, ErrNilLogger
+	}
+	logEntry := logrus.NewEntry(logger)
+	requests, err := RequestsMiddleware(logEntry, WithRequestLogger(DefaultRequestLoggerCtxKey, logger))
+	if err != nil {
+		return handler.Chain{}, err
+	}
+	recovery, err := RecoveryMiddleware(logEntry)
+	if err != nil {
+		return handler.Chain{}, err
+	}
+	return handler.NewChain(handler.UUIDRequestIDMiddleware(), requests, recovery), nil
+}
@@ -0,0 +1,74 @@
+package logrushandler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/logrushandler"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("DefaultStack", func() {
+	var (
+		logger   *logrus.Logger
+		hook     *logrustest.Hook
+		recorder *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		logger = logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		hook = logrustest.NewLocal(logger)
+		recorder = httptest.NewRecorder()
+	})
+
+	It("should log panics and the resulting 500 with the same request ID", func() {
+		stack, err := logrushandler.DefaultStack(logger)
+		Expect(err).ToNot(HaveOccurred())
+		h := stack.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		entries := hook.AllEntries()
+		Expect(entries).To(HaveLen(2))
+		panicEntry, accessEntry := entries[0], entries[1]
+		Expect(panicEntry.Level).To(Equal(logrus.ErrorLevel))
+		Expect(accessEntry.Data["status"]).To(Equal(http.StatusInternalServerError))
+		Expect(panicEntry.Data[handler.RequestIDLogField]).ToNot(BeEmpty())
+		Expect(accessEntry.Data[handler.RequestIDLogField]).To(Equal(panicEntry.Data[handler.RequestIDLogField]))
+	})
+
+	It("should provide a request scoped logger", func() {
+		stack, err := logrushandler.DefaultStack(logger)
+		Expect(err).ToNot(HaveOccurred())
+		h := stack.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestLogger := r.Context().Value(logrushandler.DefaultRequestLoggerCtxKey).(*logrus.Entry)
+			Expect(requestLogger.Data[handler.RequestIDLogField]).ToNot(BeEmpty())
+		})
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should reject a nil logger", func() {
+		_, err := logrushandler.DefaultStack(nil)
+		Expect(err).To(Equal(logrushandler.ErrNilLogger))
+	})
+
+	It("should allow per-route additions", func() {
+		stack, err := logrushandler.DefaultStack(logger)
+		Expect(err).ToNot(HaveOccurred())
+		requestID, err := handler.RequestIDMiddleware(handler.WithIDGenerator(func() string { return "ignored" }))
+		Expect(err).ToNot(HaveOccurred())
+		h := stack.Append(requestID).Then(http.NotFoundHandler())
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set(handler.RequestIDHeader, "abcd")
+		h.ServeHTTP(recorder, request)
+		Expect(hook.LastEntry().Data[handler.RequestIDLogField]).To(Equal("abcd"))
+	})
+})
@@ -0,0 +1,40 @@
+package logrushandler
+
+import (
+	"net/http"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sirupsen/logrus"
+)
+
+// CORSHandler logs rejected preflights as warnings through the request scoped logger stored under
+// RequestLoggerCtxKey, falling back to Logger when there is none.
+type CORSHandler struct {
+	Logger              *logrus.Entry
+	RequestLoggerCtxKey string
+	hch                 handler.CORSHandler
+}
+
+func NewCORSHandler(logger *logrus.Entry, next http.Handler, opts ...handler.CORSHandlerOption) (CORSHandler, error) {
+	if logger == nil {
+		return CORSHandler{}, ErrNilLogger
+	}
+	hch, err := handler.NewCORSHandler(next, opts...)
+	if err != nil {
+		return CORSHandler{}, err
+	}
+	return CORSHandler{Logger: logger, RequestLoggerCtxKey: DefaultRequestLoggerCtxKey, hch: hch}, nil
+}
+
+func (ch CORSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ch.hch.OnRejectedFunc = ch.onRejected
+	ch.hch.ServeHTTP(w, r)
+}
+
+func (ch CORSHandler) onRejected(r *http.Request, reason string) {
+	RequestLogger(r, ch.RequestLoggerCtxKey, ch.Logger).WithFields(logrus.Fields{
+		"origin":         r.Header.Get("Origin"),
+		"requestMethod":  r.Header.Get("Access-Control-Request-Method"),
+		"requestHeaders": r.Header.Get("Access-Control-Request-Headers"),
+	}).Warn("CORS preflight rejected: ", reason)
+}
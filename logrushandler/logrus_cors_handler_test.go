@@ -0,0 +1,64 @@
+package logrushandler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/logrushandler"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("LogrusCORSHandler", func() {
+	var (
+		logger   *logrus.Logger
+		hook     *logrustest.Hook
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+	)
+
+	BeforeEach(func() {
+		logger = logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		hook = logrustest.NewLocal(logger)
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("OPTIONS", "/", nil)
+		request.Header.Set("Origin", "https://evil.example")
+		request.Header.Set("Access-Control-Request-Method", "GET")
+	})
+
+	It("should log rejected preflights through the request scoped logger", func() {
+		stack, err := logrushandler.DefaultStack(logger)
+		Expect(err).ToNot(HaveOccurred())
+		cors, err := logrushandler.CORSMiddleware(logrus.NewEntry(logger),
+			handler.WithAllowedOrigins("https://app.example.org"))
+		Expect(err).ToNot(HaveOccurred())
+		stack.Append(cors).Then(http.NotFoundHandler()).ServeHTTP(recorder, request)
+
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		warning := hook.AllEntries()[0]
+		Expect(warning.Level).To(Equal(logrus.WarnLevel))
+		Expect(warning.Message).To(Equal(`CORS preflight rejected: origin "https://evil.example" not allowed`))
+		Expect(warning.Data["origin"]).To(Equal("https://evil.example"))
+		Expect(warning.Data[handler.RequestIDLogField]).ToNot(BeEmpty())
+		Expect(warning.Data[handler.RequestIDLogField]).To(Equal(hook.LastEntry().Data[handler.RequestIDLogField]))
+	})
+
+	It("should fall back to its own logger", func() {
+		h, err := logrushandler.NewCORSHandler(logrus.NewEntry(logger), http.NotFoundHandler())
+		Expect(err).ToNot(HaveOccurred())
+		h.ServeHTTP(recorder, request)
+		Expect(hook.Entries).To(HaveLen(1))
+		Expect(hook.LastEntry().Data).ToNot(HaveKey(handler.RequestIDLogField))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := logrushandler.NewCORSHandler(nil, http.NotFoundHandler())
+		Expect(err).To(Equal(logrushandler.ErrNilLogger))
+		_, err = logrushandler.NewCORSHandler(logrus.NewEntry(logger), nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+	})
+})
@@ -0,0 +1,58 @@
+package logrushandler
+
+import (
+	"net/http"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sirupsen/logrus"
+)
+
+// CSPReportHandler logs violation reports as warnings, tagged with the request ID of the page that triggered them so
+// that they line up with that page's access log entry. The ID of the report request itself is logged as
+// reportRequestId.
+type CSPReportHandler struct {
+	Logger *logrus.Entry
+	hrh    handler.CSPReportHandler
+}
+
+func NewCSPReportHandler(logger *logrus.Entry, opts ...handler.CSPReportHandlerOption) (CSPReportHandler, error) {
+	if logger == nil {
+		return CSPReportHandler{}, ErrNilLogger
+	}
+	hrh, err := handler.NewCSPReportHandler(func(*http.Request, handler.CSPReport, string) {}, opts...)
+	if err != nil {
+		return CSPReportHandler{}, err
+	}
+	return CSPReportHandler{Logger: logger, hrh: hrh}, nil
+}
+
+func (rh CSPReportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.hrh.OnReportFunc = rh.onReport
+	rh.hrh.ServeHTTP(w, r)
+}
+
+func (rh CSPReportHandler) onReport(r *http.Request, report handler.CSPReport, pageRequestID string) {
+	fields := logrus.Fields{
+		"documentUri":        report.DocumentURI,
+		"blockedUri":         report.BlockedURI,
+		"violatedDirective":  report.ViolatedDirective,
+		"effectiveDirective": report.EffectiveDirective,
+		"disposition":        report.Disposition,
+		"userAgent":          r.UserAgent(),
+	}
+	if report.SourceFile != "" {
+		fields["sourceFile"] = report.SourceFile
+		fields["lineNumber"] = report.LineNumber
+		fields["columnNumber"] = report.ColumnNumber
+	}
+	if report.Sample != "" {
+		fields["sample"] = report.Sample
+	}
+	if pageRequestID != "" {
+		fields[handler.RequestIDLogField] = pageRequestID
+	}
+	if requestID := r.Header.Get(handler.RequestIDHeader); requestID != "" {
+		fields["reportRequestId"] = requestID
+	}
+	rh.Logger.WithFields(fields).Warn("CSP violation: ", report.EffectiveDirective, " blocked ", report.BlockedURI)
+}
@@ -0,0 +1,80 @@
+package logrushandler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/logrushandler"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("LogrusCSPReportHandler", func() {
+	var (
+		logger *logrus.Logger
+		hook   *logrustest.Hook
+	)
+
+	BeforeEach(func() {
+		logger = logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		hook = logrustest.NewLocal(logger)
+	})
+
+	It("should log violations with the request ID of the page that caused them", func() {
+		stack, err := logrushandler.DefaultStack(logger)
+		Expect(err).ToNot(HaveOccurred())
+		csp := handler.NewCSP().ScriptSrc(handler.SourceSelf).ReportURI("/csp")
+		security, err := handler.SecurityHeadersMiddleware(handler.WithCSP(csp))
+		Expect(err).ToNot(HaveOccurred())
+		reporter, err := logrushandler.NewCSPReportHandler(logrus.NewEntry(logger))
+		Expect(err).ToNot(HaveOccurred())
+
+		mux := http.NewServeMux()
+		mux.Handle("/csp", reporter)
+		mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		app := stack.Append(security).Then(mux)
+
+		page := httptest.NewRecorder()
+		pageRequest := httptest.NewRequest("GET", "/", nil)
+		pageRequest.Header.Set(handler.RequestIDHeader, "page-1")
+		app.ServeHTTP(page, pageRequest)
+		reportURI := strings.TrimPrefix(page.Header().Get("Content-Security-Policy"), "script-src 'self'; report-uri ")
+
+		request := httptest.NewRequest("POST", reportURI, strings.NewReader(`{"csp-report": {
+			"document-uri": "https://example.com/",
+			"blocked-uri": "https://evil.example/x.js",
+			"effective-directive": "script-src-elem",
+			"source-file": "https://example.com/",
+			"line-number": 4
+		}}`))
+		request.Header.Set("Content-Type", "application/csp-report")
+		request.Header.Set(handler.RequestIDHeader, "report-1")
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+
+		var warning *logrus.Entry
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				warning = entry
+			}
+		}
+		Expect(warning).ToNot(BeNil())
+		Expect(warning.Message).To(Equal("CSP violation: script-src-elem blocked https://evil.example/x.js"))
+		Expect(warning.Data[handler.RequestIDLogField]).To(Equal("page-1"))
+		Expect(warning.Data["reportRequestId"]).To(Equal("report-1"))
+		Expect(warning.Data["lineNumber"]).To(Equal(4))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := logrushandler.NewCSPReportHandler(nil)
+		Expect(err).To(Equal(logrushandler.ErrNilLogger))
+		_, err = logrushandler.NewCSPReportHandler(logrus.NewEntry(logger), handler.WithMaxReportBytes(-1))
+		Expect(err).To(HaveOccurred())
+	})
+})
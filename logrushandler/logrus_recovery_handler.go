@@ -1,6 +1,7 @@
 package logrushandler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -11,26 +12,98 @@ import (
 
 type RecoveryHandler struct {
 	Logger *logrus.Entry
-	hrh    handler.RecoveryHandler
+	// Responder writes the 500 sent in place of the panicking handler's response.
+	Responder handler.Responder
+	// StructuredStack logs the stack trace as a "stack" field of StackFrames instead of as the message.
+	StructuredStack bool
+	hrh             handler.RecoveryHandler
 }
 
+// StackFrame is one frame of a stack trace logged with WithStructuredStack.
+type StackFrame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+type RecoveryHandlerOption func(*RecoveryHandler) error
+
+func NewRecoveryHandlerWithOptions(logger *logrus.Entry, next http.Handler, opts ...RecoveryHandlerOption) (RecoveryHandler, error) {
+	if logger == nil {
+		return RecoveryHandler{}, ErrNilLogger
+	}
+	hrh, err := handler.NewRecoveryHandler(next)
+	if err != nil {
+		return RecoveryHandler{}, err
+	}
+	rh := RecoveryHandler{Logger: logger, Responder: handler.NegotiatedResponder, hrh: hrh}
+	for _, opt := range opts {
+		if optErr := opt(&rh); optErr != nil {
+			return RecoveryHandler{}, optErr
+		}
+	}
+	return rh, nil
+}
+
+// NewRecoveryHandler is the constructor from before RecoveryHandler took options. It responds with an empty 500, as it
+// always has.
+//
+// Deprecated: use NewRecoveryHandlerWithOptions, which rejects a nil logger or next handler and negotiates the 500.
 func NewRecoveryHandler(logger *logrus.Entry, next http.Handler) RecoveryHandler {
-	rh := RecoveryHandler{Logger: logger}
-	rh.hrh = handler.RecoveryHandler{
-		OnRecoveryFunc: rh.recoveryFunc,
-		Next:           next,
+	return RecoveryHandler{Logger: logger, hrh: handler.RecoveryHandler{Next: next}}
+}
+
+func WithResponder(responder handler.Responder) RecoveryHandlerOption {
+	return func(rh *RecoveryHandler) error {
+		if responder == nil {
+			return errors.New("logrushandler: responder must not be nil")
+		}
+		rh.Responder = responder
+		return nil
+	}
+}
+
+// WithStructuredStack logs the stack trace as a "stack" field, which is easier to aggregate than the multi-line message
+// logged by default. The message becomes "panic recovered".
+func WithStructuredStack() RecoveryHandlerOption {
+	return func(rh *RecoveryHandler) error {
+		rh.StructuredStack = true
+		return nil
 	}
-	return rh
 }
 
 func (rh RecoveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.hrh.OnRecoveryFunc = rh.recoveryFunc
 	rh.hrh.ServeHTTP(w, r)
 }
 
 func (rh RecoveryHandler) recoveryFunc(w http.ResponseWriter, req *http.Request, panicMessage interface{},
 	stackTrace []handler.Stack) {
 
-	w.WriteHeader(http.StatusInternalServerError)
+	// once the handler has sent its status the 500 can no longer replace it, and writing it would corrupt the body
+	switch {
+	case handler.ResponseStarted(w):
+	case rh.Responder != nil:
+		rh.Responder(w, req, http.StatusInternalServerError, "")
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	logEntry := rh.Logger.WithFields(logrus.Fields{
+		"panic": panicMessage,
+	})
+	if requestID := req.Header.Get(handler.RequestIDHeader); requestID != "" {
+		logEntry = logEntry.WithField(handler.RequestIDLogField, requestID)
+	}
+
+	if rh.StructuredStack {
+		frames := make([]StackFrame, len(stackTrace))
+		for i, s := range stackTrace {
+			frames[i] = StackFrame{Func: s.FuncName, File: s.File, Line: s.LineNumber}
+		}
+		logEntry.WithField("stack", frames).Error("panic recovered")
+		return
+	}
 
 	var sb strings.Builder
 	for _, s := range stackTrace {
@@ -42,12 +115,5 @@ func (rh RecoveryHandler) recoveryFunc(w http.ResponseWriter, req *http.Request,
 			return
 		}
 	}
-
-	logEntry := rh.Logger.WithFields(logrus.Fields{
-		"panic": panicMessage,
-	})
-	if requestID := req.Header.Get(handler.RequestIDHeader); requestID != "" {
-		logEntry = logEntry.WithField(handler.RequestIDLogField, requestID)
-	}
 	logEntry.Error(sb.String())
 }
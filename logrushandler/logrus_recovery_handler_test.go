@@ -10,6 +10,7 @@ import (
 	nested "github.com/antonfisher/nested-logrus-formatter"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
 	"github.com/sahilm/handlers/handler"
 	"github.com/sahilm/handlers/logrushandler"
 	"github.com/sirupsen/logrus"
@@ -56,19 +57,75 @@ var _ = Describe("LogrusRecoveryHandler", func() {
 		handler := logrushandler.NewRecoveryHandler(logger.WithFields(logrus.Fields{}), panickingNextHandler)
 		handler.ServeHTTP(recorder, request)
 		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(recorder.Body.String()).To(BeEmpty())
 		Expect(hook.Entries).To(HaveLen(1))
 		Expect(hook.LastEntry().Level).To(Equal(logrus.ErrorLevel))
 		Expect(hook.LastEntry().Message).To(ContainSubstring("panic"))
 	})
 
+	It("should negotiate the error response", func() {
+		h, err := logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}), panickingNextHandler)
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept", "application/json")
+		h.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(recorder.Body.String()).To(MatchJSON(`{"status":500,"error":"Internal Server Error"}`))
+	})
+
+	It("should use a custom responder", func() {
+		h, err := logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}), panickingNextHandler,
+			logrushandler.WithResponder(func(w http.ResponseWriter, r *http.Request, status int, message string) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		h.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		Expect(hook.Entries).To(HaveLen(1))
+	})
+
+	It("should log the stack trace as a field when asked", func() {
+		h, err := logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}), panickingNextHandler,
+			logrushandler.WithStructuredStack())
+		Expect(err).ToNot(HaveOccurred())
+		h.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(hook.Entries).To(HaveLen(1))
+		Expect(hook.LastEntry().Message).To(Equal("panic recovered"))
+		Expect(hook.LastEntry().Data["panic"]).To(Equal(panicMessage))
+		frames, ok := hook.LastEntry().Data["stack"].([]logrushandler.StackFrame)
+		Expect(ok).To(BeTrue())
+		Expect(frames).To(ContainElement(MatchFields(IgnoreExtras, Fields{
+			"Func": ContainSubstring("logrushandler_test"),
+			"File": HaveSuffix("logrus_recovery_handler_test.go"),
+			"Line": BeNumerically(">", 0),
+		})))
+	})
+
+	It("should leave a response the handler had started alone", func() {
+		h, err := logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}),
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				_, _ = w.Write([]byte("partial"))
+				panic(panicMessage)
+			}))
+		Expect(err).ToNot(HaveOccurred())
+		h.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusAccepted))
+		Expect(recorder.Body.String()).To(Equal("partial"))
+		Expect(hook.Entries).To(HaveLen(1))
+		Expect(hook.LastEntry().Data["panic"]).To(Equal(panicMessage))
+	})
+
 	It("should log nothing if there are no panics", func() {
-		handler := logrushandler.NewRecoveryHandler(logger.WithFields(logrus.Fields{}), nextHandler)
+		handler, err := logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}), nextHandler)
+		Expect(err).ToNot(HaveOccurred())
 		handler.ServeHTTP(recorder, request)
 		Expect(hook.Entries).To(HaveLen(0))
 	})
 
 	It("should delegate to next handler", func() {
-		handler := logrushandler.NewRecoveryHandler(logger.WithFields(logrus.Fields{}), nextHandler)
+		handler, err := logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}), nextHandler)
+		Expect(err).ToNot(HaveOccurred())
 		handler.ServeHTTP(recorder, request)
 		Expect(recorder.Code).To(Equal(http.StatusOK))
 		bytes, err := ioutil.ReadAll(recorder.Body)
@@ -76,9 +133,20 @@ var _ = Describe("LogrusRecoveryHandler", func() {
 		Expect(bytes).To(Equal([]byte(responseString)))
 	})
 
+	It("should reject invalid setups", func() {
+		_, err := logrushandler.NewRecoveryHandlerWithOptions(nil, nextHandler)
+		Expect(err).To(Equal(logrushandler.ErrNilLogger))
+		_, err = logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}), nil)
+		Expect(err).To(Equal(handler.ErrNilNext))
+		_, err = logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}), nextHandler,
+			logrushandler.WithResponder(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
 	When("request ID header is provided", func() {
 		It("should add a logger field to the panic trace", func() {
-			h := logrushandler.NewRecoveryHandler(logger.WithFields(logrus.Fields{}), panickingNextHandler)
+			h, err := logrushandler.NewRecoveryHandlerWithOptions(logger.WithFields(logrus.Fields{}), panickingNextHandler)
+			Expect(err).ToNot(HaveOccurred())
 			request.Header.Set(handler.RequestIDHeader, "foo")
 			h.ServeHTTP(recorder, request)
 			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
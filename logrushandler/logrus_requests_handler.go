@@ -2,7 +2,9 @@ package logrushandler
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/sahilm/handlers/handler"
 	"github.com/sirupsen/logrus"
@@ -10,17 +12,104 @@ import (
 
 const ISO8601Format = "2006-01-02T15:04:05Z0700"
 
+var ErrNilLogger = errors.New("logrushandler: logger must not be nil")
+
 type RequestsHandler struct {
 	LogEntry            *logrus.Entry
 	Logger              *logrus.Logger
 	RequestLoggerCtxKey string
+	Redactor            *handler.Redactor
+	LogHeaders          bool
 	hrh                 handler.RequestsHandler
 }
 
-func NewRequestsHandler(logEntry *logrus.Entry, next http.Handler, requestLoggerCtxKey string, logger *logrus.Logger) RequestsHandler {
-	rh := RequestsHandler{LogEntry: logEntry, RequestLoggerCtxKey: requestLoggerCtxKey, Logger: logger}
-	rh.hrh = handler.NewRequestsHandler(rh.onRequestStart, rh.onRequestEnd, next)
-	return rh
+type RequestsHandlerOption func(*RequestsHandler) error
+
+func NewRequestsHandlerWithOptions(logEntry *logrus.Entry, next http.Handler, opts ...RequestsHandlerOption) (RequestsHandler, error) {
+	if logEntry == nil {
+		return RequestsHandler{}, ErrNilLogger
+	}
+	hrh, err := handler.NewRequestsHandlerWithOptions(next)
+	if err != nil {
+		return RequestsHandler{}, err
+	}
+	rh := RequestsHandler{LogEntry: logEntry, hrh: hrh}
+	for _, opt := range opts {
+		if optErr := opt(&rh); optErr != nil {
+			return RequestsHandler{}, optErr
+		}
+	}
+	return rh, nil
+}
+
+// NewRequestsHandler is the constructor from before RequestsHandler took options. An empty requestLoggerCtxKey
+// disables the request logger.
+//
+// Deprecated: use NewRequestsHandlerWithOptions with WithRequestLogger, which rejects a nil logEntry or next handler.
+func NewRequestsHandler(logEntry *logrus.Entry, next http.Handler, requestLoggerCtxKey string,
+	logger *logrus.Logger) RequestsHandler {
+
+	return RequestsHandler{
+		LogEntry:            logEntry,
+		Logger:              logger,
+		RequestLoggerCtxKey: requestLoggerCtxKey,
+		hrh:                 handler.RequestsHandler{Next: next},
+	}
+}
+
+// WithRequestLogger stores a logger tagged with the request ID in each request's context under ctxKey. A nil logger
+// means the LogEntry's logger.
+func WithRequestLogger(ctxKey string, logger *logrus.Logger) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		if ctxKey == "" {
+			return errors.New("logrushandler: request logger context key must not be empty")
+		}
+		rh.RequestLoggerCtxKey = ctxKey
+		rh.Logger = logger
+		return nil
+	}
+}
+
+func WithRedactor(redactor *handler.Redactor) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		if redactor == nil {
+			return errors.New("logrushandler: redactor must not be nil")
+		}
+		if err := redactor.Validate(); err != nil {
+			return err
+		}
+		rh.Redactor = redactor
+		return nil
+	}
+}
+
+// WithHeaders logs request headers, passed through the redactor if there is one.
+func WithHeaders() RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		rh.LogHeaders = true
+		return nil
+	}
+}
+
+// WithHandlerOptions configures the underlying handler.RequestsHandler, e.g. with handler.WithClock,
+// handler.WithClientIPResolver, handler.WithBodyCapture or handler.WithInFlightRegistry.
+func WithHandlerOptions(opts ...handler.RequestsHandlerOption) RequestsHandlerOption {
+	return func(rh *RequestsHandler) error {
+		for _, opt := range opts {
+			if err := opt(&rh.hrh); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// RequestLogger returns the request scoped logger a RequestsHandler stored under ctxKey, or fallback if there is none.
+func RequestLogger(r *http.Request, ctxKey string, fallback *logrus.Entry) *logrus.Entry {
+	if logger, ok := r.Context().Value(ctxKey).(*logrus.Entry); ok && logger != nil {
+		return logger
+	}
+	return fallback
 }
 
 func (rh RequestsHandler) onRequestStart(r *http.Request, metadata handler.RequestMetadata) {
@@ -28,9 +117,13 @@ func (rh RequestsHandler) onRequestStart(r *http.Request, metadata handler.Reque
 		return
 	}
 
-	logEntry := logrus.NewEntry(rh.Logger)
+	logger := rh.Logger
+	if logger == nil {
+		logger = rh.LogEntry.Logger
+	}
+	logEntry := logrus.NewEntry(logger)
 	if requestID := r.Header.Get("X-Request-Id"); requestID != "" {
-		logEntry = rh.Logger.WithField(handler.RequestIDLogField, requestID)
+		logEntry = logger.WithField(handler.RequestIDLogField, requestID)
 	}
 	ctx := context.WithValue(r.Context(), rh.RequestLoggerCtxKey, logEntry)
 	*r = *r.Clone(ctx)
@@ -42,19 +135,125 @@ func (rh RequestsHandler) onRequestEnd(w http.ResponseWriter, r *http.Request, m
 		"endTimestamp":   metadata.EndTimestamp.Format(ISO8601Format),
 		"runtime":        metadata.ExecutionTime,
 		"remoteAddr":     metadata.RemoteAddr,
-		"status":         metadata.Status,
+		"status":         status(metadata),
 		"proto":          r.Proto,
-		"referer":        r.Referer(),
+		"referer":        rh.Redactor.URI(r.Referer()),
 		"userAgent":      r.UserAgent(),
 		"method":         r.Method,
+		"bytes":          metadata.BytesWritten,
+	}
+	if rh.LogHeaders {
+		fields["headers"] = flattenHeader(rh.Redactor.Header(r.Header))
+	}
+	if metadata.Cancelled {
+		fields["cancelled"] = true
+	}
+	if metadata.DeadlineExceeded {
+		fields["deadlineExceeded"] = true
+	}
+	if metadata.Timeout > 0 {
+		fields["timeout"] = metadata.Timeout
+	}
+	if metadata.TimedOut {
+		fields["timedOut"] = true
+	}
+	if metadata.BodyBytesRead > 0 {
+		fields["bodyBytesRead"] = metadata.BodyBytesRead
+	}
+	if metadata.BodyLimitExceeded {
+		fields["bodyLimitExceeded"] = true
+	}
+	if metadata.BodyTooSlow {
+		fields["bodyTooSlow"] = true
+	}
+	if metadata.RateLimited {
+		fields["rateLimited"] = true
+	}
+	if metadata.Shed {
+		fields["shed"] = true
+	}
+	if metadata.QueueTime > 0 {
+		fields["queueTime"] = metadata.QueueTime
+	}
+	if metadata.UncompressedBytes > 0 {
+		fields["uncompressedBytes"] = metadata.UncompressedBytes
+	}
+	if metadata.BytesSaved > 0 {
+		fields["bytesSaved"] = metadata.BytesSaved
+	}
+	if metadata.IdempotentReplay {
+		fields["idempotentReplay"] = true
+	}
+	if metadata.Cache != "" {
+		fields["cache"] = metadata.Cache
+	}
+	if metadata.User != "" {
+		fields["user"] = metadata.User
+	}
+	if metadata.WriteError != nil {
+		fields["writeError"] = metadata.WriteError.Error()
+	}
+	if metadata.TLS != nil {
+		fields["tls"] = tlsFields(metadata.TLS)
+	}
+	if metadata.RequestBody != nil {
+		fields["requestBody"] = rh.body(metadata.RequestBody)
+	}
+	if metadata.ResponseBody != nil {
+		fields["responseBody"] = rh.body(metadata.ResponseBody)
 	}
 	entry := rh.LogEntry.WithFields(fields)
 	if requestID := r.Header.Get("X-Request-Id"); requestID != "" {
 		entry = entry.WithField(handler.RequestIDLogField, requestID)
 	}
-	entry.Info(r.Method, " ", r.RequestURI)
+	entry.Info(r.Method, " ", rh.Redactor.URI(r.RequestURI))
+}
+
+// body passes a captured body through the redactor, as the URI and headers are.
+func (rh RequestsHandler) body(captured *handler.CapturedBody) string {
+	redacted := *captured
+	if !redacted.Binary {
+		redacted.Data = rh.Redactor.Body(redacted.ContentType, redacted.Data)
+	}
+	return redacted.String()
 }
 
 func (rh RequestsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// rebind so that fields changed after construction are honoured
+	rh.hrh.OnRequestStartFunc = rh.onRequestStart
+	rh.hrh.OnRequestEndFunc = rh.onRequestEnd
 	rh.hrh.ServeHTTP(w, r)
 }
+
+func tlsFields(metadata *handler.TLSMetadata) logrus.Fields {
+	fields := logrus.Fields{
+		"version":     metadata.Version,
+		"cipherSuite": metadata.CipherSuite,
+		"serverName":  metadata.ServerName,
+		"alpn":        metadata.NegotiatedProtocol,
+		"resumed":     metadata.Resumed,
+	}
+	if cert := metadata.ClientCertificate; cert != nil {
+		fields["clientSubject"] = cert.Subject
+		fields["clientSANs"] = cert.SANs()
+		fields["clientSerial"] = cert.SerialNumber
+	}
+	return fields
+}
+
+// status reports requests abandoned by the client before the handler sent anything as 499, like nginx, rather than the
+// status the handler never got to send.
+func status(metadata handler.RequestMetadata) int {
+	if metadata.Cancelled && !metadata.WroteHeader && metadata.BytesWritten == 0 {
+		return handler.StatusClientClosedRequest
+	}
+	return metadata.Status
+}
+
+func flattenHeader(h http.Header) map[string]string {
+	flat := make(map[string]string, len(h))
+	for name, values := range h {
+		flat[name] = strings.Join(values, ", ")
+	}
+	return flat
+}
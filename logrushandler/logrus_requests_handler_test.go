@@ -1,14 +1,19 @@
 package logrushandler_test
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
+	"github.com/sahilm/handlers/auth"
 	"github.com/sahilm/handlers/handler"
 	"github.com/sahilm/handlers/logrushandler"
 	"github.com/sirupsen/logrus"
@@ -44,7 +49,8 @@ var _ = Describe("RequestsHandler", func() {
 
 	It("should log requests and delegate to the next handler", func() {
 		loggerEntry := logger.WithFields(logrus.Fields{})
-		handler := logrushandler.NewRequestsHandler(loggerEntry, nextHandler, "", nil)
+		handler, err := logrushandler.NewRequestsHandlerWithOptions(loggerEntry, nextHandler)
+		Expect(err).ToNot(HaveOccurred())
 		handler.ServeHTTP(recorder, request)
 
 		Expect(hook.Entries).To(HaveLen(1))
@@ -60,6 +66,7 @@ var _ = Describe("RequestsHandler", func() {
 			"referer":        Equal("test-referer"),
 			"userAgent":      Equal("007"),
 			"method":         Equal(request.Method),
+			"bytes":          Equal(int64(len(responseString))),
 		}))
 		Expect(logEntry.Message).To(Equal("GET /something/1/else"))
 
@@ -71,7 +78,8 @@ var _ = Describe("RequestsHandler", func() {
 
 	It("should log request IDs if present", func() {
 		loggerEntry := logger.WithFields(logrus.Fields{})
-		h := logrushandler.NewRequestsHandler(loggerEntry, nextHandler, "", nil)
+		h, err := logrushandler.NewRequestsHandlerWithOptions(loggerEntry, nextHandler)
+		Expect(err).ToNot(HaveOccurred())
 		request.Header.Add("X-Request-Id", "abcd")
 		h.ServeHTTP(recorder, request)
 
@@ -79,6 +87,319 @@ var _ = Describe("RequestsHandler", func() {
 		Expect(hook.LastEntry().Data[handler.RequestIDLogField]).To(Equal("abcd"))
 	})
 
+	When("a redactor is configured", func() {
+		It("should redact the request URI, referer and headers", func() {
+			loggerEntry := logger.WithFields(logrus.Fields{})
+			h, err := logrushandler.NewRequestsHandlerWithOptions(loggerEntry, nextHandler,
+				logrushandler.WithRedactor(handler.NewDefaultRedactor()), logrushandler.WithHeaders())
+			Expect(err).ToNot(HaveOccurred())
+			request = httptest.NewRequest("GET", "/login?user=bob&password=hunter2", nil)
+			request.Header.Add("Referer", "https://example.com/?token=abc")
+			request.Header.Add("Authorization", "Bearer abc")
+			request.Header.Add("Accept", "text/plain")
+			h.ServeHTTP(recorder, request)
+
+			Expect(hook.Entries).To(HaveLen(1))
+			logEntry := hook.LastEntry()
+			Expect(logEntry.Message).To(Equal("GET /login?user=bob&password=[REDACTED]"))
+			Expect(logEntry.Data["referer"]).To(Equal("https://example.com/?token=[REDACTED]"))
+			Expect(logEntry.Data["headers"]).To(MatchKeys(IgnoreExtras, Keys{
+				"Authorization": Equal(handler.RedactedValue),
+				"Accept":        Equal("text/plain"),
+			}))
+		})
+	})
+
+	When("body capture is enabled", func() {
+		It("should log the captured bodies", func() {
+			loggerEntry := logger.WithFields(logrus.Fields{})
+			h, err := logrushandler.NewRequestsHandlerWithOptions(loggerEntry, nextHandler,
+				logrushandler.WithHandlerOptions(handler.WithBodyCapture(&handler.BodyCaptureConfig{
+					MaxBytes: 64,
+					Enabled:  func(r *http.Request) bool { return true },
+				})))
+			Expect(err).ToNot(HaveOccurred())
+			request = httptest.NewRequest("POST", "/things", strings.NewReader("payload"))
+			h.ServeHTTP(recorder, request)
+
+			Expect(hook.Entries).To(HaveLen(1))
+			Expect(hook.LastEntry().Data["responseBody"]).To(Equal(responseString))
+		})
+
+		It("should pass the captured bodies through the redactor", func() {
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}),
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, readErr := ioutil.ReadAll(r.Body)
+					Expect(readErr).ToNot(HaveOccurred())
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = fmt.Fprint(w, `{"password":"hunter2","user":"a"}`)
+				}),
+				logrushandler.WithRedactor(handler.NewDefaultRedactor()),
+				logrushandler.WithHandlerOptions(handler.WithBodyCapture(&handler.BodyCaptureConfig{
+					MaxBytes: 64,
+					Enabled:  func(r *http.Request) bool { return true },
+				})))
+			Expect(err).ToNot(HaveOccurred())
+			request = httptest.NewRequest("POST", "/login?password=hunter2",
+				strings.NewReader("password=hunter2&user=a"))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			h.ServeHTTP(recorder, request)
+
+			Expect(hook.Entries).To(HaveLen(1))
+			Expect(hook.LastEntry().Message).To(Equal("POST /login?password=[REDACTED]"))
+			Expect(hook.LastEntry().Data).To(MatchKeys(IgnoreExtras, Keys{
+				"requestBody":  Equal("password=[REDACTED]&user=a"),
+				"responseBody": Equal(`{"password":"[REDACTED]","user":"a"}`),
+			}))
+		})
+	})
+
+	When("the client goes away before the handler returns", func() {
+		It("should log a 499 and flag the request as cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			abandoned := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cancel()
+			})
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), abandoned)
+			Expect(err).ToNot(HaveOccurred())
+			h.ServeHTTP(recorder, request.WithContext(ctx))
+
+			Expect(hook.Entries).To(HaveLen(1))
+			Expect(hook.LastEntry().Data).To(MatchKeys(IgnoreExtras, Keys{
+				"status":    Equal(handler.StatusClientClosedRequest),
+				"cancelled": BeTrue(),
+			}))
+			Expect(hook.LastEntry().Data).ToNot(HaveKey("deadlineExceeded"))
+		})
+
+		It("should log the status the handler sent if it responded in full", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			answered := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(responseString))
+				cancel()
+			})
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), answered)
+			Expect(err).ToNot(HaveOccurred())
+			h.ServeHTTP(recorder, request.WithContext(ctx))
+
+			Expect(hook.Entries).To(HaveLen(1))
+			Expect(hook.LastEntry().Data).To(MatchKeys(IgnoreExtras, Keys{
+				"status":    Equal(http.StatusOK),
+				"cancelled": BeTrue(),
+			}))
+		})
+	})
+
+	When("the request times out", func() {
+		It("should log the 503 and the timeout", func() {
+			th, err := handler.NewTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			}), 10*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), th)
+			Expect(err).ToNot(HaveOccurred())
+			h.ServeHTTP(recorder, request)
+
+			Expect(hook.Entries).To(HaveLen(1))
+			Expect(hook.LastEntry().Data).To(MatchKeys(IgnoreExtras, Keys{
+				"status":   Equal(http.StatusServiceUnavailable),
+				"timeout":  Equal(10 * time.Millisecond),
+				"timedOut": BeTrue(),
+			}))
+		})
+	})
+
+	When("the request body is over the limit", func() {
+		It("should log the 413 and the bytes read", func() {
+			bh, err := handler.NewBodyLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, readErr := ioutil.ReadAll(r.Body)
+				Expect(readErr).To(Equal(handler.ErrBodyTooLarge))
+			}), 4)
+			Expect(err).ToNot(HaveOccurred())
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), bh)
+			Expect(err).ToNot(HaveOccurred())
+			request = httptest.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader("payload")))
+			h.ServeHTTP(recorder, request)
+
+			Expect(hook.Entries).To(HaveLen(1))
+			Expect(hook.LastEntry().Data).To(MatchKeys(IgnoreExtras, Keys{
+				"status":            Equal(http.StatusRequestEntityTooLarge),
+				"bodyBytesRead":     Equal(int64(4)),
+				"bodyLimitExceeded": BeTrue(),
+			}))
+		})
+	})
+
+	When("the request is rate limited", func() {
+		It("should flag the rejection", func() {
+			rl, err := handler.NewRateLimitHandler(nextHandler, handler.TokenBucket{Burst: 1, Rate: 1, Per: time.Minute})
+			Expect(err).ToNot(HaveOccurred())
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), rl)
+			Expect(err).ToNot(HaveOccurred())
+			h.ServeHTTP(httptest.NewRecorder(), request)
+			Expect(hook.LastEntry().Data).ToNot(HaveKey("rateLimited"))
+			h.ServeHTTP(recorder, request)
+
+			Expect(hook.LastEntry().Data).To(MatchKeys(IgnoreExtras, Keys{
+				"status":      Equal(http.StatusTooManyRequests),
+				"rateLimited": BeTrue(),
+			}))
+		})
+	})
+
+	When("the response is an idempotent replay", func() {
+		It("should flag the replay", func() {
+			ih, err := handler.NewIdempotencyHandler(nextHandler)
+			Expect(err).ToNot(HaveOccurred())
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), ih)
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 2; i++ {
+				request := httptest.NewRequest("POST", "/payments", nil)
+				request.Header.Set(handler.IdempotencyKeyHeader, "k1")
+				h.ServeHTTP(httptest.NewRecorder(), request)
+			}
+			Expect(hook.AllEntries()[0].Data).ToNot(HaveKey("idempotentReplay"))
+			Expect(hook.LastEntry().Data["idempotentReplay"]).To(BeTrue())
+		})
+	})
+
+	When("the response came from a cache", func() {
+		It("should log whether it was a hit", func() {
+			ch, err := handler.NewCacheHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), ch)
+			Expect(err).ToNot(HaveOccurred())
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+			Expect(hook.LastEntry().Data["cache"]).To(Equal(handler.CacheMiss))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+			Expect(hook.LastEntry().Data["cache"]).To(Equal(handler.CacheHit))
+		})
+	})
+
+	When("the client's copy was current", func() {
+		It("should log how many bytes the 304 saved", func() {
+			eh, err := handler.NewETagHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("hello"))
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), eh)
+			Expect(err).ToNot(HaveOccurred())
+			first := httptest.NewRecorder()
+			h.ServeHTTP(first, httptest.NewRequest("GET", "/test", nil))
+			Expect(hook.LastEntry().Data).ToNot(HaveKey("bytesSaved"))
+
+			conditional := httptest.NewRequest("GET", "/test", nil)
+			conditional.Header.Set("If-None-Match", first.Header().Get("ETag"))
+			h.ServeHTTP(httptest.NewRecorder(), conditional)
+			Expect(hook.LastEntry().Data["bytesSaved"]).To(Equal(int64(first.Body.Len())))
+		})
+	})
+
+	When("the request is authenticated", func() {
+		It("should log who made it", func() {
+			basic, err := auth.NewBasicAuthenticator("test", auth.StaticCredentials{"alice": "secret"})
+			Expect(err).ToNot(HaveOccurred())
+			ah, err := auth.NewHandler(nextHandler, []auth.Authenticator{basic}, auth.WithOptional())
+			Expect(err).ToNot(HaveOccurred())
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), ah)
+			Expect(err).ToNot(HaveOccurred())
+			h.ServeHTTP(httptest.NewRecorder(), request)
+			Expect(hook.LastEntry().Data).ToNot(HaveKey("user"))
+
+			request.SetBasicAuth("alice", "secret")
+			h.ServeHTTP(recorder, request)
+			Expect(hook.LastEntry().Data["user"]).To(Equal("alice"))
+		})
+	})
+
+	When("the request arrived over TLS", func() {
+		It("should log the connection details under a tls group", func() {
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), nextHandler)
+			Expect(err).ToNot(HaveOccurred())
+			request.TLS = &tls.ConnectionState{
+				Version:            tls.VersionTLS13,
+				CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+				ServerName:         "example.org",
+				NegotiatedProtocol: "h2",
+			}
+			h.ServeHTTP(recorder, request)
+
+			Expect(hook.Entries).To(HaveLen(1))
+			Expect(hook.LastEntry().Data["tls"]).To(MatchAllKeys(Keys{
+				"version":     Equal("TLS 1.3"),
+				"cipherSuite": Equal("TLS_AES_128_GCM_SHA256"),
+				"serverName":  Equal("example.org"),
+				"alpn":        Equal("h2"),
+				"resumed":     BeFalse(),
+			}))
+		})
+	})
+
+	Describe("NewRequestsHandlerWithOptions", func() {
+		It("should reject invalid setups", func() {
+			_, err := logrushandler.NewRequestsHandlerWithOptions(nil, nextHandler)
+			Expect(err).To(Equal(logrushandler.ErrNilLogger))
+			_, err = logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), nil)
+			Expect(err).To(Equal(handler.ErrNilNext))
+			_, err = logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), nextHandler,
+				logrushandler.WithRequestLogger("", logger))
+			Expect(err).To(HaveOccurred())
+			_, err = logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), nextHandler,
+				logrushandler.WithHandlerOptions(handler.WithClock(nil)))
+			Expect(err).To(HaveOccurred())
+			_, err = logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), nextHandler,
+				logrushandler.WithRedactor(&handler.Redactor{Mode: handler.HashRedaction}))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should pass handler options through", func() {
+			start := time.Date(2019, 10, 5, 21, 4, 5, 0, time.UTC)
+			now := start
+			clock := func() time.Time {
+				t := now
+				now = now.Add(250 * time.Millisecond)
+				return t
+			}
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}), nextHandler,
+				logrushandler.WithHandlerOptions(handler.WithClock(clock)))
+			Expect(err).ToNot(HaveOccurred())
+			h.ServeHTTP(recorder, request)
+			Expect(hook.LastEntry().Data).To(MatchKeys(IgnoreExtras, Keys{
+				"startTimestamp": Equal("2019-10-05T21:04:05Z"),
+				"runtime":        Equal(250 * time.Millisecond),
+			}))
+		})
+
+		It("should fall back to the log entry's logger for request loggers", func() {
+			var requestLogger *logrus.Entry
+			h, err := logrushandler.NewRequestsHandlerWithOptions(logger.WithFields(logrus.Fields{}),
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requestLogger = r.Context().Value("logger").(*logrus.Entry)
+				}),
+				logrushandler.WithRequestLogger("logger", nil))
+			Expect(err).ToNot(HaveOccurred())
+			h.ServeHTTP(recorder, request)
+			Expect(requestLogger.Logger).To(BeIdenticalTo(logger))
+		})
+	})
+
+	Describe("NewRequestsHandler", func() {
+		It("should keep working for callers of the constructor from before options", func() {
+			var requestLogger *logrus.Entry
+			h := logrushandler.NewRequestsHandler(logger.WithFields(logrus.Fields{}),
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requestLogger = r.Context().Value("logger").(*logrus.Entry)
+					w.WriteHeader(http.StatusTeapot)
+				}), "logger", logger)
+			h.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusTeapot))
+			Expect(requestLogger.Logger).To(BeIdenticalTo(logger))
+			Expect(hook.LastEntry().Data["status"]).To(Equal(http.StatusTeapot))
+		})
+	})
+
 	When("request logger ctx key is provided", func() {
 		It("should set a logger with a request id in the request context", func() {
 			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -90,7 +411,9 @@ var _ = Describe("RequestsHandler", func() {
 
 			})
 			loggerEntry := logger.WithFields(logrus.Fields{})
-			handler := logrushandler.NewRequestsHandler(loggerEntry, h, "logger", logger)
+			handler, err := logrushandler.NewRequestsHandlerWithOptions(loggerEntry, h,
+				logrushandler.WithRequestLogger("logger", logger))
+			Expect(err).ToNot(HaveOccurred())
 			request.Header.Add("X-Request-Id", "abcd")
 			handler.ServeHTTP(recorder, request)
 			bytes, err := ioutil.ReadAll(recorder.Body)
@@ -0,0 +1,85 @@
+package logrushandler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sirupsen/logrus"
+)
+
+type SlowRequestHandler struct {
+	Logger *logrus.Entry
+	// ClientIPResolver works out the remoteAddr logged, handler.ForwardedClientIP by default as for RequestsHandler.
+	ClientIPResolver handler.ClientIPResolver
+	hsh              handler.SlowRequestHandler
+}
+
+type SlowRequestHandlerOption func(*SlowRequestHandler) error
+
+func NewSlowRequestHandler(logger *logrus.Entry, next http.Handler, threshold time.Duration,
+	opts ...SlowRequestHandlerOption) (SlowRequestHandler, error) {
+
+	if logger == nil {
+		return SlowRequestHandler{}, ErrNilLogger
+	}
+	hsh, err := handler.NewSlowRequestHandler(next, threshold)
+	if err != nil {
+		return SlowRequestHandler{}, err
+	}
+	sh := SlowRequestHandler{Logger: logger, ClientIPResolver: handler.ForwardedClientIP, hsh: hsh}
+	for _, opt := range opts {
+		if optErr := opt(&sh); optErr != nil {
+			return SlowRequestHandler{}, optErr
+		}
+	}
+	return sh, nil
+}
+
+func WithSlowRequestClientIPResolver(resolver handler.ClientIPResolver) SlowRequestHandlerOption {
+	return func(sh *SlowRequestHandler) error {
+		if resolver == nil {
+			return errors.New("logrushandler: client IP resolver must not be nil")
+		}
+		sh.ClientIPResolver = resolver
+		return nil
+	}
+}
+
+// WithSlowRequestHandlerOptions configures the underlying handler.SlowRequestHandler, e.g. with
+// handler.WithStackCapture to include the stuck goroutine's stack in the warning.
+func WithSlowRequestHandlerOptions(opts ...handler.SlowRequestHandlerOption) SlowRequestHandlerOption {
+	return func(sh *SlowRequestHandler) error {
+		for _, opt := range opts {
+			if err := opt(&sh.hsh); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (sh SlowRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sh.hsh.OnSlowRequestFunc = sh.onSlowRequest
+	sh.hsh.ServeHTTP(w, r)
+}
+
+func (sh SlowRequestHandler) onSlowRequest(r *http.Request, elapsed time.Duration, stack []byte) {
+	resolver := sh.ClientIPResolver
+	if resolver == nil {
+		resolver = handler.ForwardedClientIP
+	}
+	logEntry := sh.Logger.WithFields(logrus.Fields{
+		"elapsed":    elapsed,
+		"method":     r.Method,
+		"remoteAddr": resolver(r),
+	})
+	if requestID := r.Header.Get(handler.RequestIDHeader); requestID != "" {
+		logEntry = logEntry.WithField(handler.RequestIDLogField, requestID)
+	}
+	if stack != nil {
+		logEntry = logEntry.WithField("stack", string(stack))
+	}
+	logEntry.Warn("slow request still running: ", r.Method, " ", r.RequestURI)
+}
@@ -0,0 +1,88 @@
+package logrushandler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/logrushandler"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("SlowRequestHandler", func() {
+	var (
+		logger       *logrus.Logger
+		hook         *logrustest.Hook
+		release      chan struct{}
+		stuckHandler http.Handler
+	)
+
+	BeforeEach(func() {
+		logger = logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		hook = logrustest.NewLocal(logger)
+		unblock := make(chan struct{})
+		release = unblock
+		stuckHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-unblock
+		})
+	})
+
+	It("should log a warning with the request ID while the request is running", func() {
+		h, err := logrushandler.NewSlowRequestHandler(logger.WithFields(logrus.Fields{}), stuckHandler,
+			10*time.Millisecond, logrushandler.WithSlowRequestHandlerOptions(handler.WithStackCapture()))
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("GET", "/stuck", nil)
+		request.Header.Set(handler.RequestIDHeader, "abcd")
+		go h.ServeHTTP(httptest.NewRecorder(), request)
+		defer close(release)
+
+		Eventually(func() int { return len(hook.AllEntries()) }).Should(Equal(1))
+		entry := hook.AllEntries()[0]
+		Expect(entry.Level).To(Equal(logrus.WarnLevel))
+		Expect(entry.Message).To(Equal("slow request still running: GET /stuck"))
+		Expect(entry.Data[handler.RequestIDLogField]).To(Equal("abcd"))
+		Expect(entry.Data["elapsed"]).To(BeNumerically(">=", 10*time.Millisecond))
+		Expect(entry.Data["stack"]).To(HavePrefix("goroutine "))
+	})
+
+	It("should log the client IP the resolver works out", func() {
+		h, err := logrushandler.NewSlowRequestHandler(logger.WithFields(logrus.Fields{}), stuckHandler,
+			10*time.Millisecond, logrushandler.WithSlowRequestClientIPResolver(handler.RemoteAddrClientIP))
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest("GET", "/stuck", nil)
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.Header.Set("X-Forwarded-For", "203.0.113.9")
+		go h.ServeHTTP(httptest.NewRecorder(), request)
+		defer close(release)
+
+		Eventually(func() int { return len(hook.AllEntries()) }).Should(Equal(1))
+		Expect(hook.AllEntries()[0].Data["remoteAddr"]).To(Equal("10.0.0.1"))
+	})
+
+	It("should log nothing for fast requests", func() {
+		h, err := logrushandler.NewSlowRequestHandler(logger.WithFields(logrus.Fields{}), http.NotFoundHandler(), time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		Expect(hook.AllEntries()).To(BeEmpty())
+	})
+
+	It("should reject invalid setups", func() {
+		entry := logger.WithFields(logrus.Fields{})
+		_, err := logrushandler.NewSlowRequestHandler(nil, stuckHandler, time.Second)
+		Expect(err).To(Equal(logrushandler.ErrNilLogger))
+		_, err = logrushandler.NewSlowRequestHandler(entry, stuckHandler, time.Second,
+			logrushandler.WithSlowRequestClientIPResolver(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = logrushandler.NewSlowRequestHandler(entry, stuckHandler, time.Second,
+			logrushandler.WithSlowRequestHandlerOptions(func(*handler.SlowRequestHandler) error {
+				return errors.New("bad option")
+			}))
+		Expect(err).To(MatchError("bad option"))
+	})
+})
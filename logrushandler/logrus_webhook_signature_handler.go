@@ -0,0 +1,88 @@
+package logrushandler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sahilm/handlers/handler"
+	"github.com/sirupsen/logrus"
+)
+
+// WebhookSignatureHandler logs requests that fail verification as warnings, tagged with the request ID, through the
+// request scoped logger stored under RequestLoggerCtxKey, falling back to Logger when there is none.
+type WebhookSignatureHandler struct {
+	Logger              *logrus.Entry
+	RequestLoggerCtxKey string
+	// ClientIPResolver works out the remoteAddr logged, handler.ForwardedClientIP by default as for RequestsHandler.
+	ClientIPResolver handler.ClientIPResolver
+	hwh              handler.WebhookSignatureHandler
+}
+
+type WebhookSignatureHandlerOption func(*WebhookSignatureHandler) error
+
+func NewWebhookSignatureHandler(logger *logrus.Entry, next http.Handler, scheme handler.WebhookScheme, secret []byte,
+	opts ...WebhookSignatureHandlerOption) (WebhookSignatureHandler, error) {
+
+	if logger == nil {
+		return WebhookSignatureHandler{}, ErrNilLogger
+	}
+	hwh, err := handler.NewWebhookSignatureHandler(next, scheme, secret)
+	if err != nil {
+		return WebhookSignatureHandler{}, err
+	}
+	wh := WebhookSignatureHandler{
+		Logger:              logger,
+		RequestLoggerCtxKey: DefaultRequestLoggerCtxKey,
+		ClientIPResolver:    handler.ForwardedClientIP,
+		hwh:                 hwh,
+	}
+	for _, opt := range opts {
+		if optErr := opt(&wh); optErr != nil {
+			return WebhookSignatureHandler{}, optErr
+		}
+	}
+	return wh, nil
+}
+
+func WithWebhookClientIPResolver(resolver handler.ClientIPResolver) WebhookSignatureHandlerOption {
+	return func(wh *WebhookSignatureHandler) error {
+		if resolver == nil {
+			return errors.New("logrushandler: client IP resolver must not be nil")
+		}
+		wh.ClientIPResolver = resolver
+		return nil
+	}
+}
+
+// WithWebhookHandlerOptions configures the underlying handler.WebhookSignatureHandler, e.g. with handler.WithTolerance
+// or handler.WithPreviousSecrets. A failure func passed this way is replaced by the logging one.
+func WithWebhookHandlerOptions(opts ...handler.WebhookSignatureHandlerOption) WebhookSignatureHandlerOption {
+	return func(wh *WebhookSignatureHandler) error {
+		for _, opt := range opts {
+			if err := opt(&wh.hwh); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (wh WebhookSignatureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wh.hwh.OnFailureFunc = wh.onFailure
+	wh.hwh.ServeHTTP(w, r)
+}
+
+func (wh WebhookSignatureHandler) onFailure(r *http.Request, reason string) {
+	resolver := wh.ClientIPResolver
+	if resolver == nil {
+		resolver = handler.ForwardedClientIP
+	}
+	entry := RequestLogger(r, wh.RequestLoggerCtxKey, wh.Logger).WithFields(logrus.Fields{
+		"remoteAddr": resolver(r),
+		"userAgent":  r.UserAgent(),
+	})
+	if requestID := r.Header.Get(handler.RequestIDHeader); requestID != "" {
+		entry = entry.WithField(handler.RequestIDLogField, requestID)
+	}
+	entry.Warn("webhook signature verification failed: ", reason)
+}
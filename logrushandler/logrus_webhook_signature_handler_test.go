@@ -0,0 +1,85 @@
+package logrushandler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sahilm/handlers/handler"
+	"github.com/sahilm/handlers/logrushandler"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("LogrusWebhookSignatureHandler", func() {
+	var (
+		logger   *logrus.Logger
+		hook     *logrustest.Hook
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+	)
+
+	BeforeEach(func() {
+		logger = logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		hook = logrustest.NewLocal(logger)
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequest("POST", "/hooks", strings.NewReader("{}"))
+		request.Header.Set("X-Hub-Signature-256", "sha256=00")
+	})
+
+	It("should log failures with the request ID", func() {
+		stack, err := logrushandler.DefaultStack(logger)
+		Expect(err).ToNot(HaveOccurred())
+		webhooks, err := logrushandler.WebhookSignatureMiddleware(logrus.NewEntry(logger), handler.GitHubWebhooks(),
+			[]byte("secret"))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set(handler.RequestIDHeader, "hook-1")
+		stack.Append(webhooks).Then(http.NotFoundHandler()).ServeHTTP(recorder, request)
+
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		warning := hook.AllEntries()[0]
+		Expect(warning.Level).To(Equal(logrus.WarnLevel))
+		Expect(warning.Message).To(Equal("webhook signature verification failed: signature mismatch"))
+		Expect(warning.Data[handler.RequestIDLogField]).To(Equal("hook-1"))
+		Expect(hook.LastEntry().Data["status"]).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should tag its own logger with the request ID", func() {
+		h, err := logrushandler.NewWebhookSignatureHandler(logrus.NewEntry(logger), http.NotFoundHandler(),
+			handler.GitHubWebhooks(), []byte("secret"))
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set(handler.RequestIDHeader, "hook-2")
+		h.ServeHTTP(recorder, request)
+		Expect(hook.Entries).To(HaveLen(1))
+		Expect(hook.LastEntry().Data[handler.RequestIDLogField]).To(Equal("hook-2"))
+	})
+
+	It("should log the client IP the resolver works out", func() {
+		h, err := logrushandler.NewWebhookSignatureHandler(logrus.NewEntry(logger), http.NotFoundHandler(),
+			handler.GitHubWebhooks(), []byte("secret"),
+			logrushandler.WithWebhookClientIPResolver(handler.RemoteAddrClientIP))
+		Expect(err).ToNot(HaveOccurred())
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.Header.Set("X-Forwarded-For", "203.0.113.9")
+		h.ServeHTTP(recorder, request)
+		Expect(hook.LastEntry().Data["remoteAddr"]).To(Equal("10.0.0.1"))
+	})
+
+	It("should reject invalid setups", func() {
+		_, err := logrushandler.NewWebhookSignatureHandler(nil, http.NotFoundHandler(), handler.GitHubWebhooks(),
+			[]byte("secret"))
+		Expect(err).To(Equal(logrushandler.ErrNilLogger))
+		_, err = logrushandler.WebhookSignatureMiddleware(logrus.NewEntry(logger), handler.GitHubWebhooks(), nil)
+		Expect(err).To(HaveOccurred())
+		_, err = logrushandler.NewWebhookSignatureHandler(logrus.NewEntry(logger), http.NotFoundHandler(),
+			handler.GitHubWebhooks(), []byte("secret"), logrushandler.WithWebhookClientIPResolver(nil))
+		Expect(err).To(HaveOccurred())
+		_, err = logrushandler.NewWebhookSignatureHandler(logrus.NewEntry(logger), http.NotFoundHandler(),
+			handler.GitHubWebhooks(), []byte("secret"),
+			logrushandler.WithWebhookHandlerOptions(handler.WithWebhookFailureFunc(nil)))
+		Expect(err).To(HaveOccurred())
+	})
+})